@@ -0,0 +1,235 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	miniogo "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/auth"
+)
+
+// Admin APIs of a gateway are served below /minio/admin/v3/<gateway name>.
+// They are signed with AWS signature V4 by the root credentials, like the
+// admin APIs of MinIO, and answer JSON.
+const gatewayAdminPathPrefix = "/minio/admin/v3/"
+
+// AdminRouter is implemented by gateways serving admin APIs of their own.
+type AdminRouter interface {
+	// RegisterAdminRouter registers the admin APIs of the gateway on the
+	// router of its path prefix, their handlers wrapped with AdminHandler.
+	RegisterAdminRouter(router *mux.Router)
+}
+
+// Maximum size of the body of admin requests.
+const maxAdminRequestSize = 1 << 20
+
+// Maximum difference between the time of a signature and the server time.
+const maxAdminRequestSkew = 15 * time.Minute
+
+var errAdminSignature = errors.New("admin request signature does not match")
+
+// signV4Key returns the signing key of the date, region and service.
+func signV4Key(secretKey, date, region, service string) []byte {
+	key := []byte("AWS4" + secretKey)
+	for _, s := range []string{date, region, service, "aws4_request"} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(s))
+		key = mac.Sum(nil)
+	}
+	return key
+}
+
+// verifyAdminSignature verifies the AWS signature V4 of the request with
+// the credentials, restoring its body after reading it.
+func verifyAdminSignature(r *http.Request, cred auth.Credentials, now time.Time) error {
+	const algorithm = "AWS4-HMAC-SHA256"
+	authz := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authz, algorithm+" ") {
+		return errAdminSignature
+	}
+	fields := make(map[string]string)
+	for _, kv := range strings.Split(strings.TrimPrefix(authz, algorithm+" "), ",") {
+		if i := strings.Index(kv, "="); i > 0 {
+			fields[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+		}
+	}
+	scope := strings.SplitN(fields["Credential"], "/", 2)
+	if len(scope) != 2 || scope[0] != cred.AccessKey {
+		return errAdminSignature
+	}
+	scopeParts := strings.Split(scope[1], "/")
+	if len(scopeParts) != 4 || scopeParts[3] != "aws4_request" {
+		return errAdminSignature
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	t, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, scopeParts[0]) {
+		return errAdminSignature
+	}
+	if skew := now.Sub(t); skew > maxAdminRequestSkew || skew < -maxAdminRequestSkew {
+		return errAdminSignature
+	}
+
+	// Clients which do not send the hash of the payload sign it anyway.
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != "UNSIGNED-PAYLOAD" {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxAdminRequestSize))
+		if err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		if payloadHash == "" {
+			payloadHash = hex.EncodeToString(sum[:])
+		} else if payloadHash != hex.EncodeToString(sum[:]) {
+			return errAdminSignature
+		}
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	sort.Strings(signedHeaders)
+	var headers strings.Builder
+	for _, h := range signedHeaders {
+		var v string
+		switch h {
+		case "host":
+			v = r.Host
+		case "content-length":
+			v = strconv.FormatInt(r.ContentLength, 10)
+		default:
+			var values []string
+			for _, value := range r.Header[http.CanonicalHeaderKey(h)] {
+				values = append(values, strings.Join(strings.Fields(value), " "))
+			}
+			v = strings.Join(values, ",")
+		}
+		headers.WriteString(h + ":" + v + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		s3utils.EncodePath(r.URL.Path),
+		strings.Replace(r.URL.Query().Encode(), "+", "%20", -1),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{algorithm, amzDate, scope[1], hex.EncodeToString(hash[:])}, "\n")
+
+	mac := hmac.New(sha256.New, signV4Key(cred.SecretKey, scopeParts[0], scopeParts[1], scopeParts[2]))
+	mac.Write([]byte(stringToSign))
+	signature, err := hex.DecodeString(fields["Signature"])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return errAdminSignature
+	}
+	return nil
+}
+
+// adminErrorResponse is the JSON body of failed admin requests.
+type adminErrorResponse struct {
+	Code    string `json:"Code"`
+	Message string `json:"Message"`
+}
+
+// adminErrorStatus returns the HTTP status and the error code of errors
+// of the object layer.
+func adminErrorStatus(err error) (int, string) {
+	switch e := err.(type) {
+	case minio.BucketNotFound:
+		return http.StatusNotFound, "NoSuchBucket"
+	case minio.VersionNotFound:
+		return http.StatusNotFound, "NoSuchVersion"
+	case minio.BucketNameInvalid:
+		return http.StatusBadRequest, "InvalidBucketName"
+	case minio.InvalidArgument:
+		return http.StatusBadRequest, "InvalidArgument"
+	case minio.BucketExists, minio.ObjectAlreadyExists:
+		return http.StatusConflict, "AlreadyExists"
+	case minio.PrefixAccessDenied:
+		return http.StatusForbidden, "AccessDenied"
+	case minio.BucketQuotaConfigNotFound:
+		return http.StatusNotFound, "XMinioAdminNoSuchQuotaConfiguration"
+	case minio.BucketLifecycleNotFound:
+		return http.StatusNotFound, "NoSuchLifecycleConfiguration"
+	case minio.BucketObjectLockConfigNotFound:
+		return http.StatusNotFound, "ObjectLockConfigurationNotFoundError"
+	case minio.NotImplemented:
+		return http.StatusNotImplemented, "NotImplemented"
+	case miniogo.ErrorResponse:
+		return e.StatusCode, e.Code
+	}
+	return http.StatusInternalServerError, "InternalError"
+}
+
+// writeAdminResponse writes the JSON response of an admin request.
+func writeAdminResponse(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(adminErrorResponse{Code: "InternalError", Message: err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// AdminHandler returns the handler of an admin API of a gateway. Requests
+// are served by f once their signature is verified, with their body
+// limited to maxAdminRequestSize, its result is written as JSON, empty if
+// nil.
+func AdminHandler(f func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxAdminRequestSize)
+		if err := verifyAdminSignature(r, *minio.GlobalActiveCred, time.Now().UTC()); err != nil {
+			writeAdminResponse(w, http.StatusForbidden, adminErrorResponse{Code: "AccessDenied", Message: err.Error()})
+			return
+		}
+		v, err := f(r)
+		if err != nil {
+			status, code := adminErrorStatus(err)
+			if status == http.StatusInternalServerError {
+				logger.LogIf(r.Context(), err)
+			}
+			writeAdminResponse(w, status, adminErrorResponse{Code: code, Message: err.Error()})
+			return
+		}
+		if v == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeAdminResponse(w, http.StatusOK, v)
+	}
+}
@@ -0,0 +1,81 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/signer"
+	"github.com/minio/minio/pkg/auth"
+)
+
+func TestVerifyAdminSignature(t *testing.T) {
+	cred := auth.Credentials{AccessKey: "minio", SecretKey: "minio123"}
+	newSignedRequest := func(secretKey, body, payloadHash string) *http.Request {
+		req, err := http.NewRequest(http.MethodPut, "http://localhost:9000/minio/admin/v3/hdfs/snapshots?bucket=photos&name=daily%201", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+		return signer.SignV4(*req, cred.AccessKey, secretKey, "", "us-east-1")
+	}
+	newRequest := func(secretKey, body string) *http.Request {
+		return newSignedRequest(secretKey, body, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	}
+	// newVanillaRequest returns the get-vanilla request of the AWS
+	// signature V4 test suite, which signs the hash of its payload
+	// without sending it.
+	vanilla := auth.Credentials{AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	vanillaTime := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	newVanillaRequest := func(body string) *http.Request {
+		req, err := http.NewRequest(http.MethodGet, "http://example.amazonaws.com/", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Amz-Date", "20150830T123600Z")
+		req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+			"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31")
+		return req
+	}
+
+	testCases := []struct {
+		req  *http.Request
+		cred auth.Credentials
+		now  time.Time
+		pass bool
+	}{
+		{newRequest(cred.SecretKey, ""), cred, time.Now().UTC(), true},
+		{newRequest("wrong", ""), cred, time.Now().UTC(), false},
+		{newRequest(cred.SecretKey, "tampered"), cred, time.Now().UTC(), false},
+		{newRequest(cred.SecretKey, ""), cred, time.Now().UTC().Add(time.Hour), false},
+		// The payload is not signed, its body is not checked.
+		{newSignedRequest(cred.SecretKey, "any body", "UNSIGNED-PAYLOAD"), cred, time.Now().UTC(), true},
+		{newSignedRequest("wrong", "any body", "UNSIGNED-PAYLOAD"), cred, time.Now().UTC(), false},
+		// Without X-Amz-Content-Sha256 the hash of the body is signed.
+		{newVanillaRequest(""), vanilla, vanillaTime, true},
+		{newVanillaRequest("tampered"), vanilla, vanillaTime, false},
+	}
+	for i, testCase := range testCases {
+		err := verifyAdminSignature(testCase.req, testCase.cred, testCase.now)
+		if testCase.pass != (err == nil) {
+			t.Errorf("Test %d: expected pass %v, got %v", i+1, testCase.pass, err)
+		}
+	}
+}
@@ -17,8 +17,10 @@
 package cmd
 
 import (
+	"net/http"
 	"strings"
 
+	miniogo "github.com/minio/minio-go/v7"
 	minio "github.com/minio/minio/cmd"
 	"github.com/minio/minio/cmd/config"
 	"github.com/minio/minio/cmd/logger"
@@ -65,3 +67,16 @@ func gatewayHandleEnvVars() {
 		}
 	}
 }
+
+// ObjectLocked returns the error of gateways refusing to delete or
+// overwrite an object under retention or legal hold. It is answered as
+// AccessDenied, as S3 does for objects protected by object lock.
+func ObjectLocked(bucket, object string) error {
+	return miniogo.ErrorResponse{
+		Code:       "AccessDenied",
+		Message:    "Access Denied because object protected by object lock.",
+		BucketName: bucket,
+		Key:        object,
+		StatusCode: http.StatusForbidden,
+	}
+}
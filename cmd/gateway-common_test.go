@@ -19,13 +19,15 @@ package cmd
 import (
 	"reflect"
 	"testing"
+
+	minio "github.com/minio/minio/cmd"
 )
 
 // Tests cache exclude parsing.
 func TestParseGatewaySSE(t *testing.T) {
 	testCases := []struct {
 		gwSSEStr string
-		expected gatewaySSE
+		expected minio.GatewaySSE
 		success  bool
 	}{
 		// valid input
@@ -0,0 +1,112 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/crypto"
+	xhttp "github.com/minio/minio/cmd/http"
+)
+
+// Gateways reporting IsEncryptionSupported have their objects encrypted
+// by the MinIO handlers, SSE-C and SSE-S3 alike, and store the resulting
+// DARE stream as is. The handlers reject SSE-KMS requests, and never hand
+// the keys of a request to the object layer, so no gateway can pass them
+// through to the encryption of its backend.
+//
+// Besides the internal metadata of the handlers, holding the sealed keys,
+// a gateway keeps the layout of encrypted multipart objects: parts are
+// sealed with keys derived from their part number, and reading a range
+// of the object needs the stored size of each part. The layout is kept as
+// runs of consecutive parts of the same sizes, see EncodePartLayout.
+
+// IsKeyRotation returns whether copying the object of the stored metadata
+// onto itself with the metadata only seals its key again. As in the
+// handlers this is the case for SSE-C to SSE-C and SSE-S3 to SSE-S3
+// copies keeping the storage class.
+func IsKeyRotation(stored, metadata map[string]string) bool {
+	if _, ok := metadata[xhttp.AmzStorageClass]; ok {
+		return false
+	}
+	return (crypto.SSEC.IsEncrypted(stored) && crypto.SSEC.IsEncrypted(metadata)) ||
+		(crypto.S3.IsEncrypted(stored) && crypto.S3.IsEncrypted(metadata))
+}
+
+// CopyRewrites returns whether a copy of the object of the stored
+// metadata with the metadata has to write the stream the handlers
+// encrypted again, rather than being copied by the backend or replacing
+// the metadata of the object.
+func CopyRewrites(stored, metadata map[string]string, sameObject bool) bool {
+	_, srcEncrypted := crypto.IsEncrypted(stored)
+	_, dstEncrypted := crypto.IsEncrypted(metadata)
+	if !srcEncrypted && !dstEncrypted {
+		return false
+	}
+	return !sameObject || !IsKeyRotation(stored, metadata)
+}
+
+// EncodePartLayout encodes the stored and actual sizes of the parts as
+// comma separated runs "number:count:size:actualSize" of consecutive parts
+// of the same sizes. Parts of a uniform size take a few bytes.
+func EncodePartLayout(parts []minio.ObjectPartInfo) string {
+	var runs []string
+	for i := 0; i < len(parts); {
+		j := i + 1
+		for j < len(parts) && parts[j].Number == parts[j-1].Number+1 &&
+			parts[j].Size == parts[i].Size && parts[j].ActualSize == parts[i].ActualSize {
+			j++
+		}
+		runs = append(runs, fmt.Sprintf("%d:%d:%d:%d", parts[i].Number, j-i, parts[i].Size, parts[i].ActualSize))
+		i = j
+	}
+	return strings.Join(runs, ",")
+}
+
+// DecodePartLayout decodes the layout of at most maxParts parts encoded
+// by EncodePartLayout.
+func DecodePartLayout(s string, maxParts int) ([]minio.ObjectPartInfo, error) {
+	var parts []minio.ObjectPartInfo
+	for _, run := range strings.Split(s, ",") {
+		fields := strings.Split(run, ":")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid part layout %q", run)
+		}
+		var values [4]int64
+		for i, f := range fields {
+			v, err := strconv.ParseInt(f, 10, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("invalid part layout %q", run)
+			}
+			values[i] = v
+		}
+		if values[0] < 1 || int64(len(parts))+values[1] > int64(maxParts) {
+			return nil, fmt.Errorf("invalid part layout %q", run)
+		}
+		for k := int64(0); k < values[1]; k++ {
+			parts = append(parts, minio.ObjectPartInfo{
+				Number:     int(values[0] + k),
+				Size:       values[2],
+				ActualSize: values[3],
+			})
+		}
+	}
+	return parts, nil
+}
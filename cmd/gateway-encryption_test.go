@@ -0,0 +1,116 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/crypto"
+	xhttp "github.com/minio/minio/cmd/http"
+)
+
+func TestCopyRewrites(t *testing.T) {
+	plain := map[string]string{"X-Amz-Meta-A": "b"}
+	compressed := map[string]string{minio.ReservedMetadataPrefix + "compression": "klauspost/compress/s2"}
+	ssec := map[string]string{crypto.MetaSealedKeySSEC: "key"}
+	sses3 := map[string]string{crypto.MetaSealedKeyS3: "key"}
+	sses3Class := map[string]string{crypto.MetaSealedKeyS3: "key", xhttp.AmzStorageClass: "STANDARD"}
+
+	testCases := []struct {
+		stored, metadata map[string]string
+		sameObject       bool
+		rotation         bool
+		rewrites         bool
+	}{
+		{plain, plain, false, false, false},
+		{plain, plain, true, false, false},
+		{compressed, compressed, true, false, false},
+		{plain, ssec, true, false, true},
+		{ssec, plain, true, false, true},
+		{ssec, ssec, true, true, false},
+		{ssec, ssec, false, true, true},
+		{sses3, sses3, true, true, false},
+		{sses3, sses3Class, true, false, true},
+		{ssec, sses3, true, false, true},
+		{sses3, ssec, true, false, true},
+	}
+	for i, testCase := range testCases {
+		if got := IsKeyRotation(testCase.stored, testCase.metadata); got != testCase.rotation {
+			t.Errorf("Test %d: Expected key rotation %v, got %v", i+1, testCase.rotation, got)
+		}
+		if got := CopyRewrites(testCase.stored, testCase.metadata, testCase.sameObject); got != testCase.rewrites {
+			t.Errorf("Test %d: Expected rewrite %v, got %v", i+1, testCase.rewrites, got)
+		}
+	}
+}
+
+func TestPartLayout(t *testing.T) {
+	testCases := []struct {
+		parts   []minio.ObjectPartInfo
+		encoded string
+	}{
+		{
+			[]minio.ObjectPartInfo{{Number: 1, Size: 10, ActualSize: 8}},
+			"1:1:10:8",
+		},
+		{
+			[]minio.ObjectPartInfo{
+				{Number: 1, Size: 10, ActualSize: 8},
+				{Number: 2, Size: 10, ActualSize: 8},
+				{Number: 3, Size: 4, ActualSize: 2},
+			},
+			"1:2:10:8,3:1:4:2",
+		},
+		{
+			[]minio.ObjectPartInfo{
+				{Number: 1, Size: 10, ActualSize: 8},
+				{Number: 2, Size: 10, ActualSize: 9},
+				{Number: 3, Size: 10, ActualSize: 9},
+			},
+			"1:1:10:8,2:2:10:9",
+		},
+		{
+			[]minio.ObjectPartInfo{
+				{Number: 1, Size: 10},
+				{Number: 3, Size: 10},
+				{Number: 4, Size: 10},
+			},
+			"1:1:10:0,3:2:10:0",
+		},
+	}
+	for i, testCase := range testCases {
+		encoded := EncodePartLayout(testCase.parts)
+		if encoded != testCase.encoded {
+			t.Errorf("Test %d: Expected %q, got %q", i+1, testCase.encoded, encoded)
+		}
+		parts, err := DecodePartLayout(encoded, 10000)
+		if err != nil {
+			t.Fatalf("Test %d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(parts, testCase.parts) {
+			t.Errorf("Test %d: Expected %v, got %v", i+1, testCase.parts, parts)
+		}
+	}
+
+	for _, encoded := range []string{"", "1:1:10", "0:1:10:8", "1:-1:10:8", "1:1:x:8", "1:1:10:8,", "1:10001:1:1"} {
+		if _, err := DecodePartLayout(encoded, 10000); err == nil {
+			t.Errorf("Expected %q to be rejected", encoded)
+		}
+	}
+}
@@ -250,6 +250,12 @@ func StartGateway(ctx *cli.Context, gw Gateway) {
 
 	enableIAMOps := minio.GlobalEtcdClient != nil
 
+	// Admin APIs of the gateway are registered first, the admin router
+	// answers all other paths below its prefix.
+	if adminRouter, ok := gw.(AdminRouter); ok {
+		adminRouter.RegisterAdminRouter(router.PathPrefix(gatewayAdminPathPrefix + gatewayName).Subrouter())
+	}
+
 	// Enable IAM admin APIs if etcd is enabled, if not just enable basic
 	// operations such as profiling, server info etc.
 	minio.RegisterAdminRouter(router, enableConfigOps, enableIAMOps)
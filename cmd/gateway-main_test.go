@@ -22,6 +22,7 @@ import (
 	"testing"
 
 	"github.com/minio/cli"
+	minio "github.com/minio/minio/cmd"
 )
 
 // Test RegisterGatewayCommand
@@ -60,7 +61,7 @@ func TestRunRegisteredGatewayCommand(t *testing.T) {
 	}
 
 	if err = newApp("minio").Run(
-		[]string{"minio", "gateway", cmd.Name, fmt.Sprintf("--%s", flagName), flagValue}); err != nil {
+		[]string{"minio", cmd.Name, fmt.Sprintf("--%s", flagName), flagValue}); err != nil {
 		t.Errorf("running registered gateway command got unexpected error: %s", err)
 	}
 }
@@ -0,0 +1,79 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package azure
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	minio "github.com/minio/minio/cmd"
+)
+
+// Objects are encrypted by the MinIO handlers, see ming.EncodePartLayout.
+// The internal metadata of the handlers is kept in the azureInternalMetaKey
+// blob metadata and the layout of multipart objects in azurePartsKey.
+// Copies which encrypt again are streamed through the gateway.
+//
+// Both names are reserved: user metadata under the internal prefix is
+// rejected by s3MetaToAzureProperties.
+
+const (
+	// Blob metadata holding the internal metadata of the handlers.
+	// Azure metadata names are case insensitive, the keys are kept
+	// in a single value to preserve their case.
+	azureInternalMetaKey = "x_minio_internal"
+
+	// Blob metadata holding the part layout of encrypted multipart objects.
+	azurePartsKey = azureInternalMetaKey + "_parts"
+)
+
+// isAzureInternalMetaKey returns whether the Azure metadata name is
+// reserved for the gateway.
+func isAzureInternalMetaKey(key string) bool {
+	return strings.HasPrefix(strings.ToLower(key), azureInternalMetaKey)
+}
+
+// encodeAzureInternalMeta encodes the internal metadata of the handlers
+// as a blob metadata value.
+func encodeAzureInternalMeta(meta map[string]string) (string, error) {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decodeAzureInternalMeta decodes the internal metadata encoded by
+// encodeAzureInternalMeta.
+func decodeAzureInternalMeta(s string) (map[string]string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	meta := make(map[string]string)
+	if err = json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	for k := range meta {
+		if !strings.HasPrefix(k, minio.ReservedMetadataPrefix) {
+			return nil, fmt.Errorf("invalid internal metadata %q", k)
+		}
+	}
+	return meta, nil
+}
@@ -0,0 +1,205 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package azure
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/crypto"
+	"github.com/minio/minio/pkg/hash"
+)
+
+func TestAzureInternalMeta(t *testing.T) {
+	internal := map[string]string{
+		crypto.MetaSealedKeySSEC:                     "c2VhbGVk",
+		crypto.MetaIV:                                "aXY=",
+		crypto.MetaAlgorithm:                         crypto.SealAlgorithm,
+		minio.ReservedMetadataPrefix + "actual-size": "24",
+	}
+	encoded, err := encodeAzureInternalMeta(internal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeAzureInternalMeta(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, internal) {
+		t.Errorf("Expected %v, got %v", internal, decoded)
+	}
+
+	userMeta, _ := encodeAzureInternalMeta(map[string]string{"X-Amz-Meta-A": "b"})
+	for _, encoded := range []string{"", "e30", "bm90IGpzb24=", userMeta} {
+		if _, err := decodeAzureInternalMeta(encoded); err == nil {
+			t.Errorf("Expected %q to be rejected", encoded)
+		}
+	}
+}
+
+// newAzureBlobTestServer returns a server storing a single blob, enough
+// for the requests of PutObject and GetObjectInfo.
+func newAzureBlobTestServer(t *testing.T) (*httptest.Server, *azureObjects) {
+	var (
+		mu   sync.Mutex
+		meta http.Header
+		size int64
+	)
+	setMeta := func(h http.Header) {
+		meta = make(http.Header)
+		for k, v := range h {
+			if strings.HasPrefix(strings.ToLower(k), "x-ms-meta-") {
+				meta[k] = v
+			}
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/bucket/object" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "block":
+			n, _ := io.Copy(ioutil.Discard, r.Body)
+			size += n
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "blocklist":
+			setMeta(r.Header)
+			w.Header().Set("ETag", `"0x8D9"`)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "metadata":
+			setMeta(r.Header)
+		case r.Method == http.MethodHead && meta != nil:
+			for k, v := range meta {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+			w.Header().Set("ETag", `"0x8D9"`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	a := &azureObjects{
+		client: azblob.NewServiceURL(*u, azureVersionPipeline{azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{})}),
+	}
+	a.containerLocks.Store("bucket", azureContainerLock{})
+	return server, a
+}
+
+func TestAzurePutObjectInternalMeta(t *testing.T) {
+	server, a := newAzureBlobTestServer(t)
+	defer server.Close()
+
+	userDefined := map[string]string{
+		"X-Amz-Meta-A":           "b",
+		crypto.MetaSealedKeySSEC: "c2VhbGVk",
+		crypto.MetaIV:            "aXY=",
+		crypto.MetaAlgorithm:     crypto.SealAlgorithm,
+		minio.ReservedMetadataPrefix + "actual-size": "4",
+	}
+	data := []byte("data")
+	hashReader, err := hash.NewReader(bytes.NewReader(data), int64(len(data)), "", "", int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.PutObject(context.Background(), "bucket", "object", minio.NewPutObjReader(hashReader), minio.ObjectOptions{
+		UserDefined: userDefined,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objInfo, err := a.GetObjectInfo(context.Background(), "bucket", "object", minio.ObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range userDefined {
+		if objInfo.UserDefined[k] != v {
+			t.Errorf("Expected %s to be %q, got %q", k, v, objInfo.UserDefined[k])
+		}
+	}
+	for k := range objInfo.UserDefined {
+		if strings.HasPrefix(k, "X-Amz-Meta-X-Minio-Internal") {
+			t.Errorf("Unexpected internal metadata in the user metadata %v", objInfo.UserDefined)
+		}
+	}
+}
+
+func TestAzureGetObjectInfoEncryption(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || r.URL.Path != "/bucket/object" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", "24")
+		w.Header().Set("ETag", `"0x8D9"`)
+		w.Header().Set("x-ms-meta-md5sum", "etag-2")
+		w.Header().Set("x-ms-meta-"+azurePartsKey, "1:1:16:0,2:1:8:0")
+		// Written by a client, the user metadata is not parsed as parts.
+		w.Header().Set("x-ms-meta-minioparts", "invalid")
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &azureObjects{
+		client: azblob.NewServiceURL(*u, azureVersionPipeline{azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{})}),
+	}
+	a.containerLocks.Store("bucket", azureContainerLock{})
+
+	objInfo, err := a.GetObjectInfo(context.Background(), "bucket", "object", minio.ObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedParts := []minio.ObjectPartInfo{{Number: 1, Size: 16}, {Number: 2, Size: 8}}
+	if !reflect.DeepEqual(objInfo.Parts, expectedParts) {
+		t.Errorf("Expected parts %v, got %v", expectedParts, objInfo.Parts)
+	}
+	if objInfo.ETag != "etag-2" {
+		t.Errorf("Expected ETag etag-2, got %s", objInfo.ETag)
+	}
+	if v := objInfo.UserDefined["X-Amz-Meta-Minioparts"]; v != "invalid" {
+		t.Errorf("Expected the user metadata minioparts, got %q", v)
+	}
+	for k := range objInfo.UserDefined {
+		if strings.HasPrefix(k, "X-Amz-Meta-X-Minio-Internal") {
+			t.Errorf("Unexpected part sizes in the user metadata %v", objInfo.UserDefined)
+		}
+	}
+}
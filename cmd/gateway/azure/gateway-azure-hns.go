@@ -0,0 +1,302 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	ming "github.com/minio/ming/cmd"
+	minio "github.com/minio/minio/cmd"
+)
+
+// Azure storage accounts with hierarchical namespace (HNS) enabled, also
+// known as ADLS Gen2, expose real directories through the DFS endpoint.
+// The blob endpoint keeps working on such accounts, so the gateway uses
+// it for all object I/O and only talks to the DFS endpoint for the
+// operations that the blob API cannot express: creating and removing
+// directories, atomic renames and POSIX access control lists.
+
+// Metadata key set by Azure on the blob view of a directory.
+const azureHNSFolderMetaKey = "hdi_isfolder"
+
+// S3 user metadata which is passed through to the POSIX access control
+// headers of the DFS endpoint instead of being stored as blob metadata.
+var azureHNSAccessControlMeta = map[string]string{
+	"X-Amz-Meta-X-Ms-Owner":       "x-ms-owner",
+	"X-Amz-Meta-X-Ms-Group":       "x-ms-group",
+	"X-Amz-Meta-X-Ms-Permissions": "x-ms-permissions",
+	"X-Amz-Meta-X-Ms-Acl":         "x-ms-acl",
+}
+
+// parseDFSEndpoint derives the DFS endpoint of a storage account from
+// its blob endpoint, https://account.blob.core.windows.net becomes
+// https://account.dfs.core.windows.net. Endpoints which do not follow
+// the Azure naming scheme, such as emulators, are returned as-is.
+func parseDFSEndpoint(blobEndpoint *url.URL) *url.URL {
+	dfsEndpoint := *blobEndpoint
+	dfsEndpoint.Host = strings.Replace(blobEndpoint.Host, ".blob.", ".dfs.", 1)
+	return &dfsEndpoint
+}
+
+// extractHNSAccessControl removes the POSIX access control entries from
+// the S3 metadata and returns them as DFS request headers, along with
+// the remaining metadata which is meant to be stored on the blob.
+func extractHNSAccessControl(s3Metadata map[string]string) (http.Header, map[string]string) {
+	acl := make(http.Header)
+	meta := make(map[string]string, len(s3Metadata))
+	for k, v := range s3Metadata {
+		if h, ok := azureHNSAccessControlMeta[http.CanonicalHeaderKey(k)]; ok {
+			acl.Set(h, v)
+			continue
+		}
+		meta[k] = v
+	}
+	return acl, meta
+}
+
+// isAzureHNSFolder returns true if the blob metadata marks the blob as a
+// directory of a hierarchical namespace.
+func isAzureHNSFolder(meta azblob.Metadata) bool {
+	for k, v := range meta {
+		if strings.EqualFold(k, azureHNSFolderMetaKey) {
+			return strings.EqualFold(v, "true")
+		}
+	}
+	return false
+}
+
+// azureRequest sends a request without body for the given path of the
+// container to one of the storage account endpoints, for the operations
+// the SDK does not cover. The request is signed and retried by the same
+// pipeline which is used by the SDK.
+func (a *azureObjects) azureRequest(ctx context.Context, endpoint *url.URL, method, bucket, object string, query url.Values, header http.Header) (http.Header, error) {
+	u := *endpoint
+	u.Path = path.Join(u.Path, bucket, object)
+	u.RawQuery = query.Encode()
+
+	req, err := pipeline.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if req.Header.Get("x-ms-version") == "" {
+		req.Header.Set("x-ms-version", azblob.ServiceVersion)
+	}
+
+	resp, err := a.httpPipeline.Do(ctx, nil, req)
+	if err != nil {
+		return nil, err
+	}
+	httpResp := resp.Response()
+	io.Copy(ioutil.Discard, httpResp.Body)
+	httpResp.Body.Close()
+
+	if httpResp.StatusCode >= http.StatusBadRequest {
+		serviceCode := httpResp.Header.Get("x-ms-error-code")
+		return nil, azureCodesToObjectError(errors.New(httpResp.Status), serviceCode, httpResp.StatusCode, bucket, object)
+	}
+	return httpResp.Header, nil
+}
+
+// dfsRequest sends a request to the DFS endpoint for the given path of
+// the container.
+func (a *azureObjects) dfsRequest(ctx context.Context, method, bucket, object string, query url.Values, header http.Header) (http.Header, error) {
+	return a.azureRequest(ctx, a.dfsEndpoint, method, bucket, object, query, header)
+}
+
+// dfsCreateDirectory creates the directory and all its missing parents.
+func (a *azureObjects) dfsCreateDirectory(ctx context.Context, bucket, dir string) error {
+	_, err := a.dfsRequest(ctx, http.MethodPut, bucket, dir, url.Values{"resource": []string{"directory"}}, nil)
+	return err
+}
+
+// dfsDeleteDirectory removes an empty directory.
+func (a *azureObjects) dfsDeleteDirectory(ctx context.Context, bucket, dir string) error {
+	_, err := a.dfsRequest(ctx, http.MethodDelete, bucket, dir, url.Values{"recursive": []string{"false"}}, nil)
+	return err
+}
+
+// dfsRename atomically moves srcObject onto dstObject within the
+// container, replacing dstObject if it exists.
+func (a *azureObjects) dfsRename(ctx context.Context, bucket, srcObject, dstObject string) error {
+	src := url.URL{Path: path.Join(minio.SlashSeparator, bucket, srcObject)}
+	header := make(http.Header)
+	header.Set("x-ms-rename-source", src.EscapedPath())
+	_, err := a.dfsRequest(ctx, http.MethodPut, bucket, dstObject, url.Values{}, header)
+	return err
+}
+
+// dfsSetAccessControl applies owner, group, permissions and ACL headers
+// to the path, empty headers are left unchanged by Azure.
+func (a *azureObjects) dfsSetAccessControl(ctx context.Context, bucket, object string, acl http.Header) error {
+	if len(acl) == 0 {
+		return nil
+	}
+	_, err := a.dfsRequest(ctx, http.MethodPatch, bucket, object, url.Values{"action": []string{"setAccessControl"}}, acl)
+	return err
+}
+
+// dfsGetAccessControl returns the POSIX access control of the path as
+// S3 user metadata.
+func (a *azureObjects) dfsGetAccessControl(ctx context.Context, bucket, object string) (map[string]string, error) {
+	header, err := a.dfsRequest(ctx, http.MethodHead, bucket, object, url.Values{"action": []string{"getAccessControl"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	meta := make(map[string]string)
+	for k, h := range azureHNSAccessControlMeta {
+		if v := header.Get(h); v != "" {
+			meta[k] = v
+		}
+	}
+	return meta, nil
+}
+
+// hnsDeleteEmptyDirs removes dir and its parents as long as they are
+// empty, which mirrors how the NAS backend cleans up after deleting a
+// file.
+func (a *azureObjects) hnsDeleteEmptyDirs(ctx context.Context, bucket, dir string) {
+	for ; dir != "." && dir != minio.SlashSeparator; dir = path.Dir(dir) {
+		if strings.HasPrefix(dir+minio.SlashSeparator, ming.GatewayMinioSysTmp) {
+			return
+		}
+		if err := a.dfsDeleteDirectory(ctx, bucket, dir); err != nil {
+			// Directory is not empty or is already gone,
+			// either way there is nothing left to prune.
+			return
+		}
+	}
+}
+
+// hnsGetDirectoryInfo returns the object info of a directory, which is
+// what S3 clients see as an object with a trailing slash.
+func (a *azureObjects) hnsGetDirectoryInfo(ctx context.Context, bucket, object string) (objInfo minio.ObjectInfo, err error) {
+	blobURL := a.client.NewContainerURL(bucket).NewBlobURL(strings.TrimSuffix(object, minio.SlashSeparator))
+	blob, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		return objInfo, azureToObjectError(err, bucket, object)
+	}
+	if !isAzureHNSFolder(blob.NewMetadata()) {
+		return objInfo, minio.ObjectNotFound{Bucket: bucket, Object: object}
+	}
+	return minio.ObjectInfo{
+		Bucket:  bucket,
+		Name:    object,
+		ModTime: blob.LastModified(),
+		ETag:    minio.ToS3ETag(string(blob.ETag())),
+		IsDir:   true,
+	}, nil
+}
+
+// hnsListBlobsSegment lists a segment as ListBlobsHierarchySegment and
+// replaces the blob entries which represent directories. Whether a
+// directory, marked by its hdi_isfolder metadata, has children is only
+// known once the listing reaches "dir/", which sorts after "dir" and
+// names such as "dir-a", so the following segments are read until every
+// directory of the segment is decided.
+func hnsListBlobsSegment(ctx context.Context, containerURL azblob.ContainerURL, marker azblob.Marker, delimiter string, o azblob.ListBlobsSegmentOptions) (*azblob.ListBlobsHierarchySegmentResponse, error) {
+	o.Details.Metadata = true
+	resp, err := containerURL.ListBlobsHierarchySegment(ctx, marker, delimiter, o)
+	if err != nil {
+		return nil, err
+	}
+	for resp.NextMarker.NotDone() && hnsUndecidedFolders(resp.Segment) {
+		next, err := containerURL.ListBlobsHierarchySegment(ctx, resp.NextMarker, delimiter, o)
+		if err != nil {
+			return nil, err
+		}
+		resp.Segment.BlobItems = append(resp.Segment.BlobItems, next.Segment.BlobItems...)
+		resp.Segment.BlobPrefixes = append(resp.Segment.BlobPrefixes, next.Segment.BlobPrefixes...)
+		resp.NextMarker = next.NextMarker
+	}
+	resp.Segment.BlobItems = hnsFilterFolders(resp.Segment.BlobItems, resp.Segment.BlobPrefixes)
+	return resp, nil
+}
+
+// hnsUndecidedFolders returns whether the segment has a directory whose
+// children, if any, are listed after the end of the segment.
+func hnsUndecidedFolders(segment azblob.BlobHierarchyListSegment) bool {
+	var last string
+	for _, blob := range segment.BlobItems {
+		if blob.Name > last {
+			last = blob.Name
+		}
+	}
+	for _, p := range segment.BlobPrefixes {
+		if p.Name > last {
+			last = p.Name
+		}
+	}
+	for _, blob := range segment.BlobItems {
+		if isAzureHNSFolder(blob.Metadata) && blob.Name+minio.SlashSeparator > last {
+			return true
+		}
+	}
+	return false
+}
+
+// hnsFilterFolders replaces the blob entries which represent directories
+// in a listing segment which reaches past every directory, as listed by
+// hnsListBlobsSegment. Directories with children are already reported
+// through their blobs or prefixes, so only empty directories are kept
+// and they are listed as objects with a trailing slash, the same way
+// the NAS backend lists empty directories. Renamed directories are moved
+// to their sorted position, "a/" sorts after "a-b".
+func hnsFilterFolders(blobs []azblob.BlobItem, prefixes []azblob.BlobPrefix) []azblob.BlobItem {
+	seen := make(map[string]struct{}, len(prefixes))
+	for _, p := range prefixes {
+		seen[p.Name] = struct{}{}
+	}
+	for _, blob := range blobs {
+		// Every parent directory of a blob of the segment has children.
+		for i := range blob.Name {
+			if blob.Name[i] == '/' {
+				seen[blob.Name[:i+1]] = struct{}{}
+			}
+		}
+	}
+
+	filtered := make([]azblob.BlobItem, 0, len(blobs))
+	for _, blob := range blobs {
+		if !isAzureHNSFolder(blob.Metadata) {
+			filtered = append(filtered, blob)
+			continue
+		}
+		dir := blob.Name + minio.SlashSeparator
+		if _, ok := seen[dir]; ok {
+			continue
+		}
+		blob.Name = dir
+		filtered = append(filtered, blob)
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].Name < filtered[j].Name
+	})
+	return filtered
+}
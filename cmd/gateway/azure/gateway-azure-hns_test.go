@@ -0,0 +1,173 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+func TestParseDFSEndpoint(t *testing.T) {
+	testCases := []struct {
+		blobEndpoint string
+		expectedURL  string
+	}{
+		{"https://myaccount.blob.core.windows.net", "https://myaccount.dfs.core.windows.net"},
+		{"https://myaccount.blob.core.usgovcloudapi.net", "https://myaccount.dfs.core.usgovcloudapi.net"},
+		{"http://localhost:10000/myaccount", "http://localhost:10000/myaccount"},
+	}
+	for i, testCase := range testCases {
+		u, err := url.Parse(testCase.blobEndpoint)
+		if err != nil {
+			t.Fatalf("Test %d: %s", i+1, err)
+		}
+		if got := parseDFSEndpoint(u).String(); got != testCase.expectedURL {
+			t.Errorf("Test %d: Expected URL %s, got %s", i+1, testCase.expectedURL, got)
+		}
+		if u.String() != testCase.blobEndpoint {
+			t.Errorf("Test %d: blob endpoint was modified to %s", i+1, u)
+		}
+	}
+}
+
+func TestExtractHNSAccessControl(t *testing.T) {
+	acl, meta := extractHNSAccessControl(map[string]string{
+		"x-amz-meta-x-ms-owner":       "alice",
+		"X-Amz-Meta-X-Ms-Permissions": "rwxr-x---",
+		"X-Amz-Meta-X-Ms-Acl":         "user::rwx,group::r-x,other::---",
+		"X-Amz-Meta-Hdr":              "value",
+		"Content-Type":                "text/plain",
+	})
+
+	expectedACL := http.Header{}
+	expectedACL.Set("x-ms-owner", "alice")
+	expectedACL.Set("x-ms-permissions", "rwxr-x---")
+	expectedACL.Set("x-ms-acl", "user::rwx,group::r-x,other::---")
+	if !reflect.DeepEqual(acl, expectedACL) {
+		t.Errorf("Expected ACL %#v, got %#v", expectedACL, acl)
+	}
+
+	expectedMeta := map[string]string{
+		"X-Amz-Meta-Hdr": "value",
+		"Content-Type":   "text/plain",
+	}
+	if !reflect.DeepEqual(meta, expectedMeta) {
+		t.Errorf("Expected metadata %#v, got %#v", expectedMeta, meta)
+	}
+}
+
+func TestHNSFilterFolders(t *testing.T) {
+	folder := azblob.Metadata{azureHNSFolderMetaKey: "true"}
+	blobs := []azblob.BlobItem{
+		{Name: "a", Metadata: folder},
+		{Name: "a/b", Metadata: azblob.Metadata{}},
+		{Name: "c", Metadata: folder},
+		{Name: "d", Metadata: folder},
+		{Name: "e"},
+		{Name: "f", Metadata: folder},
+		{Name: "f-g"},
+		{Name: "h", Metadata: folder},
+		{Name: "h-i"},
+		{Name: "h/j/k"},
+	}
+	prefixes := []azblob.BlobPrefix{
+		{Name: "d/"},
+	}
+
+	var names []string
+	for _, blob := range hnsFilterFolders(blobs, prefixes) {
+		names = append(names, blob.Name)
+	}
+
+	// "a" and "h" have children and "d" is listed as a prefix, only the
+	// empty directories "c" and "f" remain and are listed with a trailing
+	// slash, "f/" after "f-g".
+	expected := []string{"a/b", "c/", "e", "f-g", "f/", "h-i", "h/j/k"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+}
+
+func TestHNSListObjectsFolderPages(t *testing.T) {
+	// The listing of the container root in the order of Azure, two
+	// entries per page: directory "a" has children on the next page
+	// and directory "c" is empty, its position "c/" after "c-d".
+	entries := []string{
+		`<Blob><Name>a</Name><Properties><Content-Length>0</Content-Length></Properties><Metadata><hdi_isfolder>true</hdi_isfolder></Metadata></Blob>`,
+		`<Blob><Name>a-b</Name><Properties><Content-Length>1</Content-Length><Content-Type>text/plain</Content-Type><Content-Encoding></Content-Encoding></Properties><Metadata/></Blob>`,
+		`<BlobPrefix><Name>a/</Name></BlobPrefix>`,
+		`<Blob><Name>c</Name><Properties><Content-Length>0</Content-Length></Properties><Metadata><hdi_isfolder>true</hdi_isfolder></Metadata></Blob>`,
+		`<Blob><Name>c-d</Name><Properties><Content-Length>1</Content-Length><Content-Type>text/plain</Content-Type><Content-Encoding></Content-Encoding></Properties><Metadata/></Blob>`,
+	}
+	const pageSize = 2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/bucket" || query.Get("comp") != "list" || !strings.Contains(query.Get("include"), "metadata") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		start, _ := strconv.Atoi(query.Get("marker"))
+		end := start + pageSize
+		var next string
+		if end < len(entries) {
+			next = strconv.Itoa(end)
+		} else {
+			end = len(entries)
+		}
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="bucket"><Delimiter>/</Delimiter><Blobs>%s</Blobs><NextMarker>%s</NextMarker></EnumerationResults>`,
+			strings.Join(entries[start:end], ""), next)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &azureObjects{
+		client: azblob.NewServiceURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{})),
+		hns:    true,
+	}
+
+	result, err := a.ListObjects(context.Background(), "bucket", "", "", "/", pageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, obj := range result.Objects {
+		names = append(names, obj.Name)
+	}
+	expectedNames := []string{"a-b", "c-d", "c/"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Expected objects %v, got %v", expectedNames, names)
+	}
+	expectedPrefixes := []string{"a/"}
+	if !reflect.DeepEqual(result.Prefixes, expectedPrefixes) {
+		t.Errorf("Expected prefixes %v, got %v", expectedPrefixes, result.Prefixes)
+	}
+	if result.IsTruncated {
+		t.Errorf("Expected the listing to be complete, got marker %s", result.NextMarker)
+	}
+}
@@ -0,0 +1,548 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/gorilla/mux"
+	ming "github.com/minio/ming/cmd"
+	minio "github.com/minio/minio/cmd"
+	xhttp "github.com/minio/minio/cmd/http"
+	objectlock "github.com/minio/minio/pkg/bucket/object/lock"
+)
+
+// S3 Object Lock maps onto Azure version-level immutability: buckets
+// created with object lock enabled are containers with immutable storage
+// with versioning, retention of an object is the immutability policy of
+// its blob, GOVERNANCE being an unlocked and COMPLIANCE a locked policy,
+// and legal holds are blob legal holds. Time-based retention policies set
+// on the container apply to all its blobs and are enforced by Azure.
+//
+// Azure versions the blobs of such containers, so deleting or
+// overwriting a locked blob would merely hide its data from the gateway.
+// Deletes and overwrites of locked blobs are refused by the gateway
+// instead, the same way S3 refuses them on objects without versions.
+
+const (
+	// First service version which reports and sets blob immutability
+	// policies and legal holds.
+	azureObjectLockVersion = "2020-10-02"
+
+	// First service version which creates containers with version-level
+	// immutability.
+	azureImmutableContainerVersion = "2021-04-10"
+
+	azureImmutableContainerHeader = "x-ms-immutable-storage-with-versioning-enabled"
+	azureImmutabilityUntilHeader  = "x-ms-immutability-policy-until-date"
+	azureImmutabilityModeHeader   = "x-ms-immutability-policy-mode"
+	azureLegalHoldHeader          = "x-ms-legal-hold"
+)
+
+type azureVersionKey struct{}
+
+// withAzureVersion returns a context which makes requests sent with it
+// use at least the given service version.
+func withAzureVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, azureVersionKey{}, version)
+}
+
+// azureVersionPipeline raises the service version of the requests whose
+// context asks for features newer than the SDK.
+type azureVersionPipeline struct {
+	pipeline.Pipeline
+}
+
+func (p azureVersionPipeline) Do(ctx context.Context, methodFactory pipeline.Factory, request pipeline.Request) (pipeline.Response, error) {
+	if version, ok := ctx.Value(azureVersionKey{}).(string); ok && request.Header.Get("x-ms-version") < version {
+		request.Header.Set("x-ms-version", version)
+	}
+	return p.Pipeline.Do(ctx, methodFactory, request)
+}
+
+// azureImmutabilityModeToRetMode translates the mode of a blob
+// immutability policy to the S3 retention mode.
+func azureImmutabilityModeToRetMode(mode string) objectlock.RetMode {
+	switch strings.ToLower(mode) {
+	case "unlocked":
+		return objectlock.RetGovernance
+	case "locked":
+		return objectlock.RetCompliance
+	}
+	return ""
+}
+
+// azureObjectLockToS3Meta returns the S3 object lock metadata of a blob
+// from its properties.
+func azureObjectLockToS3Meta(header http.Header) map[string]string {
+	meta := make(map[string]string)
+	if mode := azureImmutabilityModeToRetMode(header.Get(azureImmutabilityModeHeader)); mode != "" {
+		if until, err := http.ParseTime(header.Get(azureImmutabilityUntilHeader)); err == nil {
+			meta[strings.ToLower(xhttp.AmzObjectLockMode)] = string(mode)
+			meta[strings.ToLower(xhttp.AmzObjectLockRetainUntilDate)] = until.UTC().Format(time.RFC3339)
+		}
+	}
+	if v := header.Get(azureLegalHoldHeader); v != "" {
+		status := objectlock.LegalHoldOff
+		if strings.EqualFold(v, "true") {
+			status = objectlock.LegalHoldOn
+		}
+		meta[strings.ToLower(xhttp.AmzObjectLockLegalHold)] = string(status)
+	}
+	return meta
+}
+
+// isAzureObjectLocked returns true if the object lock metadata forbids
+// the object from being deleted or overwritten.
+func isAzureObjectLocked(meta map[string]string, now time.Time) bool {
+	if objectlock.GetObjectLegalHoldMeta(meta).Status == objectlock.LegalHoldOn {
+		return true
+	}
+	retention := objectlock.GetObjectRetentionMeta(meta)
+	return retention.Mode.Valid() && retention.RetainUntilDate.After(now)
+}
+
+// createImmutableContainer creates a container with version-level
+// immutability support, the storage account must have blob versioning
+// enabled.
+func (a *azureObjects) createImmutableContainer(ctx context.Context, bucket string) error {
+	header := make(http.Header)
+	header.Set(azureImmutableContainerHeader, "true")
+	header.Set("x-ms-version", azureImmutableContainerVersion)
+	_, err := a.azureRequest(ctx, a.endpoint, http.MethodPut, bucket, "", url.Values{"restype": []string{"container"}}, header)
+	return err
+}
+
+// Time the object lock state of a container with version-level
+// immutability is cached for, its default retention may be changed by
+// other gateways.
+const azureContainerLockTTL = time.Minute
+
+// azureContainerLock is the cached object lock state of a container.
+type azureContainerLock struct {
+	enabled          bool
+	defaultRetention *azureDefaultRetention
+	expires          time.Time
+}
+
+// containerLock returns the object lock state of the container, from the
+// cache when it is recent enough. Version-level immutability never changes
+// once a container is created, so containers without it stay cached.
+func (a *azureObjects) containerLock(ctx context.Context, bucket string) (azureContainerLock, error) {
+	if v, ok := a.containerLocks.Load(bucket); ok {
+		if lock := v.(azureContainerLock); !lock.enabled || time.Now().Before(lock.expires) {
+			return lock, nil
+		}
+	}
+	containerURL := a.client.NewContainerURL(bucket)
+	resp, err := containerURL.GetProperties(withAzureVersion(ctx, azureImmutableContainerVersion), azblob.LeaseAccessConditions{})
+	if err != nil {
+		return azureContainerLock{}, azureToObjectError(err, bucket)
+	}
+	lock := azureContainerLock{
+		enabled: strings.EqualFold(resp.Response().Header.Get(azureImmutableContainerHeader), "true"),
+		expires: time.Now().Add(azureContainerLockTTL),
+	}
+	if v, ok := resp.NewMetadata()[azureDefaultRetentionMetaKey]; ok && lock.enabled {
+		r, err := parseAzureDefaultRetention(v)
+		if err != nil {
+			return azureContainerLock{}, err
+		}
+		lock.defaultRetention = &r
+	}
+	a.containerLocks.Store(bucket, lock)
+	return lock, nil
+}
+
+// isObjectLockEnabled returns true if the container supports version-level
+// immutability.
+func (a *azureObjects) isObjectLockEnabled(ctx context.Context, bucket string) (bool, error) {
+	lock, err := a.containerLock(ctx, bucket)
+	return lock.enabled, err
+}
+
+// checkObjectLockRequest returns an error if the metadata of an object to
+// be written asks for a retention or legal hold in a bucket without object
+// lock enabled.
+func (a *azureObjects) checkObjectLockRequest(ctx context.Context, bucket, object string, meta map[string]string) error {
+	if !objectlock.GetObjectRetentionMeta(meta).Mode.Valid() && !objectlock.GetObjectLegalHoldMeta(meta).Status.Valid() {
+		return nil
+	}
+	enabled, err := a.isObjectLockEnabled(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return minio.InvalidArgument{Bucket: bucket, Object: object, Err: errors.New("bucket does not have object lock enabled")}
+	}
+	return nil
+}
+
+// checkObjectNotLocked returns an object locked error if the object
+// exists and is under retention or legal hold.
+func (a *azureObjects) checkObjectNotLocked(ctx context.Context, bucket, object string) error {
+	enabled, err := a.isObjectLockEnabled(ctx, bucket)
+	if err != nil || !enabled {
+		return err
+	}
+
+	current, err := a.getObjectLock(ctx, bucket, object)
+	if err != nil {
+		if _, ok := err.(minio.ObjectNotFound); ok {
+			return nil
+		}
+		return err
+	}
+	if isAzureObjectLocked(current, time.Now().UTC()) {
+		return ming.ObjectLocked(bucket, object)
+	}
+	return nil
+}
+
+// getObjectLock returns the S3 object lock metadata of the blob.
+func (a *azureObjects) getObjectLock(ctx context.Context, bucket, object string) (map[string]string, error) {
+	blobURL := a.client.NewContainerURL(bucket).NewBlobURL(object)
+	blob, err := blobURL.GetProperties(withAzureVersion(ctx, azureObjectLockVersion), azblob.BlobAccessConditions{})
+	if err != nil {
+		return nil, azureToObjectError(err, bucket, object)
+	}
+	return azureObjectLockToS3Meta(blob.Response().Header), nil
+}
+
+// setObjectRetention sets the immutability policy of the blob, an empty
+// retention removes an unlocked policy.
+func (a *azureObjects) setObjectRetention(ctx context.Context, bucket, object string, retention objectlock.ObjectRetention) error {
+	query := url.Values{"comp": []string{"immutabilityPolicies"}}
+	header := make(http.Header)
+	header.Set("x-ms-version", azureObjectLockVersion)
+	if !retention.Mode.Valid() {
+		_, err := a.azureRequest(ctx, a.endpoint, http.MethodDelete, bucket, object, query, header)
+		return err
+	}
+
+	mode := "Unlocked"
+	if retention.Mode == objectlock.RetCompliance {
+		mode = "Locked"
+	}
+	header.Set(azureImmutabilityModeHeader, mode)
+	header.Set(azureImmutabilityUntilHeader, retention.RetainUntilDate.UTC().Format(http.TimeFormat))
+	_, err := a.azureRequest(ctx, a.endpoint, http.MethodPut, bucket, object, query, header)
+	return err
+}
+
+// setObjectLegalHold sets or clears the legal hold of the blob.
+func (a *azureObjects) setObjectLegalHold(ctx context.Context, bucket, object string, legalHold objectlock.ObjectLegalHold) error {
+	header := make(http.Header)
+	header.Set("x-ms-version", azureObjectLockVersion)
+	if legalHold.Status == objectlock.LegalHoldOn {
+		header.Set(azureLegalHoldHeader, "true")
+	} else {
+		header.Set(azureLegalHoldHeader, "false")
+	}
+	_, err := a.azureRequest(ctx, a.endpoint, http.MethodPut, bucket, object, url.Values{"comp": []string{"legalhold"}}, header)
+	return err
+}
+
+// Default retention of new blobs of a bucket, kept in the metadata of its
+// container as "<mode>:<days>d" or "<mode>:<years>y".
+const azureDefaultRetentionMetaKey = "miniodefaultretention"
+
+// azureDefaultRetention is the default retention of the blobs of a bucket,
+// a period in either days or years.
+type azureDefaultRetention struct {
+	Mode  objectlock.RetMode `json:"mode"`
+	Days  int                `json:"days,omitempty"`
+	Years int                `json:"years,omitempty"`
+}
+
+// retainUntil returns the end of the default retention of a blob written
+// at now.
+func (r azureDefaultRetention) retainUntil(now time.Time) time.Time {
+	return now.AddDate(r.Years, 0, r.Days)
+}
+
+// encodeAzureDefaultRetention encodes the default retention as kept in the
+// container metadata.
+func encodeAzureDefaultRetention(r azureDefaultRetention) string {
+	if r.Years > 0 {
+		return fmt.Sprintf("%s:%dy", r.Mode, r.Years)
+	}
+	return fmt.Sprintf("%s:%dd", r.Mode, r.Days)
+}
+
+// parseAzureDefaultRetention parses a default retention encoded by
+// encodeAzureDefaultRetention.
+func parseAzureDefaultRetention(s string) (r azureDefaultRetention, err error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 || len(s) < i+3 {
+		return r, fmt.Errorf("invalid default retention %q", s)
+	}
+	r.Mode = objectlock.RetMode(s[:i])
+	period, err := strconv.Atoi(s[i+1 : len(s)-1])
+	if err != nil || period <= 0 || !r.Mode.Valid() {
+		return r, fmt.Errorf("invalid default retention %q", s)
+	}
+	switch s[len(s)-1] {
+	case 'd':
+		r.Days = period
+	case 'y':
+		r.Years = period
+	default:
+		return r, fmt.Errorf("invalid default retention %q", s)
+	}
+	return r, nil
+}
+
+// getDefaultRetention returns the default retention of the bucket, nil
+// if it has none.
+func (a *azureObjects) getDefaultRetention(ctx context.Context, bucket string) (*azureDefaultRetention, error) {
+	lock, err := a.containerLock(ctx, bucket)
+	return lock.defaultRetention, err
+}
+
+// setDefaultRetention sets the default retention of the bucket, or removes
+// it if nil, keeping the other metadata of the container.
+func (a *azureObjects) setDefaultRetention(ctx context.Context, bucket string, r *azureDefaultRetention) error {
+	containerURL := a.client.NewContainerURL(bucket)
+	props, err := containerURL.GetProperties(ctx, azblob.LeaseAccessConditions{})
+	if err != nil {
+		return azureToObjectError(err, bucket)
+	}
+	metadata := props.NewMetadata()
+	if r != nil {
+		metadata[azureDefaultRetentionMetaKey] = encodeAzureDefaultRetention(*r)
+	} else {
+		delete(metadata, azureDefaultRetentionMetaKey)
+	}
+	_, err = containerURL.SetMetadata(ctx, metadata, azblob.ContainerAccessConditions{})
+	a.containerLocks.Delete(bucket)
+	return azureToObjectError(err, bucket)
+}
+
+// applyObjectLock sets the retention and legal hold which the metadata
+// of the blob which was just written asks for, or else the default
+// retention of the bucket, if any.
+func (a *azureObjects) applyObjectLock(ctx context.Context, bucket, object string, meta map[string]string) error {
+	lock, err := a.containerLock(ctx, bucket)
+	if err != nil || !lock.enabled {
+		return err
+	}
+	retention := objectlock.GetObjectRetentionMeta(meta)
+	if !retention.Mode.Valid() && lock.defaultRetention != nil {
+		retention.Mode = lock.defaultRetention.Mode
+		retention.RetainUntilDate.Time = lock.defaultRetention.retainUntil(time.Now().UTC())
+	}
+	if retention.Mode.Valid() {
+		if err = a.setObjectRetention(ctx, bucket, object, retention); err != nil {
+			return err
+		}
+	}
+	if legalHold := objectlock.GetObjectLegalHoldMeta(meta); legalHold.Status == objectlock.LegalHoldOn {
+		return a.setObjectLegalHold(ctx, bucket, object, legalHold)
+	}
+	return nil
+}
+
+// azureObjectLockInfo is the retention and legal hold of an object as
+// answered by the object lock admin API.
+type azureObjectLockInfo struct {
+	Mode            objectlock.RetMode         `json:"mode,omitempty"`
+	RetainUntilDate *time.Time                 `json:"retainUntilDate,omitempty"`
+	LegalHold       objectlock.LegalHoldStatus `json:"legalHold"`
+}
+
+// newAzureObjectLockInfo returns the retention and legal hold of the S3
+// object lock metadata.
+func newAzureObjectLockInfo(meta map[string]string) azureObjectLockInfo {
+	info := azureObjectLockInfo{LegalHold: objectlock.LegalHoldOff}
+	if retention := objectlock.GetObjectRetentionMeta(meta); retention.Mode.Valid() {
+		until := retention.RetainUntilDate.UTC()
+		info.Mode, info.RetainUntilDate = retention.Mode, &until
+	}
+	if legalHold := objectlock.GetObjectLegalHoldMeta(meta); legalHold.Status.Valid() {
+		info.LegalHold = legalHold.Status
+	}
+	return info
+}
+
+// checkRetentionChange returns an object locked error if the retention of
+// the object cannot change from current to retention at now. As on S3
+// requests signed by the root credentials bypass governance mode, while
+// compliance mode retention may only be extended.
+func checkRetentionChange(bucket, object string, current map[string]string, retention objectlock.ObjectRetention, now time.Time) error {
+	currentRetention := objectlock.GetObjectRetentionMeta(current)
+	if currentRetention.Mode != objectlock.RetCompliance || !currentRetention.RetainUntilDate.After(now) {
+		return nil
+	}
+	if retention.Mode != objectlock.RetCompliance || retention.RetainUntilDate.Before(currentRetention.RetainUntilDate.Time) {
+		return ming.ObjectLocked(bucket, object)
+	}
+	return nil
+}
+
+// parseRetentionQuery parses the retention of an object from the mode and
+// until parameters, both empty removing the retention.
+func parseRetentionQuery(query url.Values, now time.Time) (retention objectlock.ObjectRetention, err error) {
+	mode, until := query.Get("mode"), query.Get("until")
+	if mode == "" && until == "" {
+		return retention, nil
+	}
+	retention.Mode = objectlock.RetMode(strings.ToUpper(mode))
+	if !retention.Mode.Valid() {
+		return retention, minio.InvalidArgument{Err: objectlock.ErrUnknownWORMModeDirective}
+	}
+	t, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		return retention, minio.InvalidArgument{Err: objectlock.ErrInvalidRetentionDate}
+	}
+	if !t.After(now) {
+		return retention, minio.InvalidArgument{Err: objectlock.ErrPastObjectLockRetainDate}
+	}
+	retention.RetainUntilDate.Time = t.UTC()
+	return retention, nil
+}
+
+// parseDefaultRetentionQuery parses the default retention of a bucket from
+// the mode and days or years parameters.
+func parseDefaultRetentionQuery(query url.Values) (r azureDefaultRetention, err error) {
+	r.Mode = objectlock.RetMode(strings.ToUpper(query.Get("mode")))
+	if !r.Mode.Valid() {
+		return r, minio.InvalidArgument{Err: objectlock.ErrUnknownWORMModeDirective}
+	}
+	days, years := query.Get("days"), query.Get("years")
+	if (days == "") == (years == "") {
+		return r, minio.InvalidArgument{Err: errors.New("default retention needs either days or years")}
+	}
+	if days != "" {
+		r.Days, err = strconv.Atoi(days)
+	} else {
+		r.Years, err = strconv.Atoi(years)
+	}
+	if err != nil || r.Days < 0 || r.Years < 0 || r.Days+r.Years == 0 {
+		return r, minio.InvalidArgument{Err: errors.New("default retention period must be a positive integer")}
+	}
+	return r, nil
+}
+
+// registerObjectLockRouter registers the object lock admin API, which
+// reports and sets the retention and legal hold of objects and the
+// default retention of buckets with object lock enabled:
+//
+//	GET    /minio/admin/v3/azure/retention?bucket=<bucket>&object=<object>
+//	PUT    /minio/admin/v3/azure/retention?bucket=<bucket>&object=<object>[&mode=<mode>&until=<date>]
+//	PUT    /minio/admin/v3/azure/legal-hold?bucket=<bucket>&object=<object>&status=<ON|OFF>
+//	GET    /minio/admin/v3/azure/default-retention?bucket=<bucket>
+//	PUT    /minio/admin/v3/azure/default-retention?bucket=<bucket>&mode=<mode>&(days=<days>|years=<years>)
+//	DELETE /minio/admin/v3/azure/default-retention?bucket=<bucket>
+func (g *Azure) registerObjectLockRouter(router *mux.Router) {
+	// handler returns the handler of the bucket of the request, which
+	// must have object lock enabled.
+	handler := func(f func(ctx context.Context, a *azureObjects, bucket string, query url.Values) (interface{}, error)) http.HandlerFunc {
+		return ming.AdminHandler(func(r *http.Request) (interface{}, error) {
+			a, _ := g.layer.Load().(*azureObjects)
+			if a == nil {
+				return nil, errors.New("azure gateway not initialized")
+			}
+			query := r.URL.Query()
+			bucket := query.Get("bucket")
+			if !minio.IsValidBucketName(bucket) {
+				return nil, minio.BucketNameInvalid{Bucket: bucket}
+			}
+			enabled, err := a.isObjectLockEnabled(r.Context(), bucket)
+			if err != nil {
+				return nil, err
+			}
+			if !enabled {
+				return nil, minio.InvalidArgument{Bucket: bucket, Err: errors.New("bucket does not have object lock enabled")}
+			}
+			return f(r.Context(), a, bucket, query)
+		})
+	}
+
+	router.Methods(http.MethodGet).Path("/retention").HandlerFunc(handler(
+		func(ctx context.Context, a *azureObjects, bucket string, query url.Values) (interface{}, error) {
+			current, err := a.getObjectLock(ctx, bucket, query.Get("object"))
+			if err != nil {
+				return nil, err
+			}
+			return newAzureObjectLockInfo(current), nil
+		}))
+	router.Methods(http.MethodPut).Path("/retention").HandlerFunc(handler(
+		func(ctx context.Context, a *azureObjects, bucket string, query url.Values) (interface{}, error) {
+			object := query.Get("object")
+			now := time.Now().UTC()
+			retention, err := parseRetentionQuery(query, now)
+			if err != nil {
+				return nil, err
+			}
+			current, err := a.getObjectLock(ctx, bucket, object)
+			if err != nil {
+				return nil, err
+			}
+			if err = checkRetentionChange(bucket, object, current, retention, now); err != nil {
+				return nil, err
+			}
+			if !retention.Mode.Valid() && !objectlock.GetObjectRetentionMeta(current).Mode.Valid() {
+				return nil, nil
+			}
+			return nil, a.setObjectRetention(ctx, bucket, object, retention)
+		}))
+	router.Methods(http.MethodPut).Path("/legal-hold").HandlerFunc(handler(
+		func(ctx context.Context, a *azureObjects, bucket string, query url.Values) (interface{}, error) {
+			object := query.Get("object")
+			legalHold := objectlock.ObjectLegalHold{Status: objectlock.LegalHoldStatus(strings.ToUpper(query.Get("status")))}
+			if !legalHold.Status.Valid() {
+				return nil, minio.InvalidArgument{Bucket: bucket, Object: object, Err: objectlock.ErrMalformedXML}
+			}
+			if _, err := a.getObjectLock(ctx, bucket, object); err != nil {
+				return nil, err
+			}
+			return nil, a.setObjectLegalHold(ctx, bucket, object, legalHold)
+		}))
+	router.Methods(http.MethodGet).Path("/default-retention").HandlerFunc(handler(
+		func(ctx context.Context, a *azureObjects, bucket string, query url.Values) (interface{}, error) {
+			r, err := a.getDefaultRetention(ctx, bucket)
+			if err != nil {
+				return nil, err
+			}
+			if r == nil {
+				return nil, minio.BucketObjectLockConfigNotFound{Bucket: bucket}
+			}
+			return r, nil
+		}))
+	router.Methods(http.MethodPut).Path("/default-retention").HandlerFunc(handler(
+		func(ctx context.Context, a *azureObjects, bucket string, query url.Values) (interface{}, error) {
+			r, err := parseDefaultRetentionQuery(query)
+			if err != nil {
+				return nil, err
+			}
+			return nil, a.setDefaultRetention(ctx, bucket, &r)
+		}))
+	router.Methods(http.MethodDelete).Path("/default-retention").HandlerFunc(handler(
+		func(ctx context.Context, a *azureObjects, bucket string, query url.Values) (interface{}, error) {
+			return nil, a.setDefaultRetention(ctx, bucket, nil)
+		}))
+}
@@ -0,0 +1,282 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package azure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	miniogo "github.com/minio/minio-go/v7"
+	minio "github.com/minio/minio/cmd"
+	objectlock "github.com/minio/minio/pkg/bucket/object/lock"
+)
+
+func TestAzureObjectLockToS3Meta(t *testing.T) {
+	testCases := []struct {
+		header   map[string]string
+		expected map[string]string
+	}{
+		{map[string]string{}, map[string]string{}},
+		{
+			map[string]string{
+				azureImmutabilityModeHeader:  "locked",
+				azureImmutabilityUntilHeader: "Mon, 01 Feb 2021 00:00:00 GMT",
+				azureLegalHoldHeader:         "false",
+			},
+			map[string]string{
+				"x-amz-object-lock-mode":              "COMPLIANCE",
+				"x-amz-object-lock-retain-until-date": "2021-02-01T00:00:00Z",
+				"x-amz-object-lock-legal-hold":        "OFF",
+			},
+		},
+		{
+			map[string]string{
+				azureImmutabilityModeHeader:  "unlocked",
+				azureImmutabilityUntilHeader: "Mon, 01 Feb 2021 00:00:00 GMT",
+				azureLegalHoldHeader:         "true",
+			},
+			map[string]string{
+				"x-amz-object-lock-mode":              "GOVERNANCE",
+				"x-amz-object-lock-retain-until-date": "2021-02-01T00:00:00Z",
+				"x-amz-object-lock-legal-hold":        "ON",
+			},
+		},
+		// Mutable blobs have no retention.
+		{map[string]string{azureImmutabilityModeHeader: "mutable"}, map[string]string{}},
+	}
+	for i, testCase := range testCases {
+		header := make(http.Header)
+		for k, v := range testCase.header {
+			header.Set(k, v)
+		}
+		if meta := azureObjectLockToS3Meta(header); !reflect.DeepEqual(meta, testCase.expected) {
+			t.Errorf("Test %d: Expected %v, got %v", i+1, testCase.expected, meta)
+		}
+	}
+}
+
+func TestIsAzureObjectLocked(t *testing.T) {
+	now := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		meta   map[string]string
+		locked bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"x-amz-object-lock-legal-hold": "ON"}, true},
+		{map[string]string{"x-amz-object-lock-legal-hold": "OFF"}, false},
+		{map[string]string{"x-amz-object-lock-mode": "GOVERNANCE", "x-amz-object-lock-retain-until-date": "2021-02-01T00:00:00Z"}, true},
+		{map[string]string{"x-amz-object-lock-mode": "COMPLIANCE", "x-amz-object-lock-retain-until-date": "2021-01-01T00:00:00Z"}, false},
+	}
+	for i, testCase := range testCases {
+		if locked := isAzureObjectLocked(testCase.meta, now); locked != testCase.locked {
+			t.Errorf("Test %d: Expected locked %v, got %v", i+1, testCase.locked, locked)
+		}
+	}
+}
+
+func TestAzureDefaultRetention(t *testing.T) {
+	now := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		encoded   string
+		retention azureDefaultRetention
+		until     time.Time
+	}{
+		{"GOVERNANCE:30d", azureDefaultRetention{Mode: objectlock.RetGovernance, Days: 30}, time.Date(2021, 2, 14, 0, 0, 0, 0, time.UTC)},
+		{"COMPLIANCE:2y", azureDefaultRetention{Mode: objectlock.RetCompliance, Years: 2}, time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC)},
+	}
+	for i, testCase := range testCases {
+		if encoded := encodeAzureDefaultRetention(testCase.retention); encoded != testCase.encoded {
+			t.Errorf("Test %d: Expected %q, got %q", i+1, testCase.encoded, encoded)
+		}
+		r, err := parseAzureDefaultRetention(testCase.encoded)
+		if err != nil {
+			t.Fatalf("Test %d: %v", i+1, err)
+		}
+		if r != testCase.retention {
+			t.Errorf("Test %d: Expected %v, got %v", i+1, testCase.retention, r)
+		}
+		if until := r.retainUntil(now); !until.Equal(testCase.until) {
+			t.Errorf("Test %d: Expected retention until %v, got %v", i+1, testCase.until, until)
+		}
+	}
+
+	for _, encoded := range []string{"", "GOVERNANCE", "GOVERNANCE:", "GOVERNANCE:30", "GOVERNANCE:0d", "GOVERNANCE:-1d", "GOVERNANCE:30m", "OTHER:30d"} {
+		if _, err := parseAzureDefaultRetention(encoded); err == nil {
+			t.Errorf("Expected %q to be rejected", encoded)
+		}
+	}
+}
+
+func TestParseDefaultRetentionQuery(t *testing.T) {
+	testCases := []struct {
+		query     string
+		retention azureDefaultRetention
+		success   bool
+	}{
+		{"mode=GOVERNANCE&days=30", azureDefaultRetention{Mode: objectlock.RetGovernance, Days: 30}, true},
+		{"mode=compliance&years=1", azureDefaultRetention{Mode: objectlock.RetCompliance, Years: 1}, true},
+		{"mode=GOVERNANCE", azureDefaultRetention{}, false},
+		{"mode=GOVERNANCE&days=1&years=1", azureDefaultRetention{}, false},
+		{"mode=GOVERNANCE&days=0", azureDefaultRetention{}, false},
+		{"mode=GOVERNANCE&days=x", azureDefaultRetention{}, false},
+		{"days=30", azureDefaultRetention{}, false},
+	}
+	for i, testCase := range testCases {
+		query, err := url.ParseQuery(testCase.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := parseDefaultRetentionQuery(query)
+		if testCase.success != (err == nil) {
+			t.Fatalf("Test %d: Expected success %v, got error %v", i+1, testCase.success, err)
+		}
+		if err == nil && r != testCase.retention {
+			t.Errorf("Test %d: Expected %v, got %v", i+1, testCase.retention, r)
+		}
+	}
+}
+
+func TestParseRetentionQuery(t *testing.T) {
+	now := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		query   string
+		mode    objectlock.RetMode
+		until   time.Time
+		success bool
+	}{
+		{"", "", time.Time{}, true},
+		{"mode=governance&until=2021-02-01T00:00:00Z", objectlock.RetGovernance, time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC), true},
+		{"mode=COMPLIANCE&until=2021-02-01T01:00:00%2B01:00", objectlock.RetCompliance, time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC), true},
+		{"mode=COMPLIANCE&until=2021-01-01T00:00:00Z", "", time.Time{}, false},
+		{"mode=COMPLIANCE&until=tomorrow", "", time.Time{}, false},
+		{"mode=OTHER&until=2021-02-01T00:00:00Z", "", time.Time{}, false},
+		{"until=2021-02-01T00:00:00Z", "", time.Time{}, false},
+	}
+	for i, testCase := range testCases {
+		query, err := url.ParseQuery(testCase.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		retention, err := parseRetentionQuery(query, now)
+		if testCase.success != (err == nil) {
+			t.Fatalf("Test %d: Expected success %v, got error %v", i+1, testCase.success, err)
+		}
+		if err == nil && (retention.Mode != testCase.mode || !retention.RetainUntilDate.Equal(testCase.until)) {
+			t.Errorf("Test %d: Expected %s until %v, got %s until %v", i+1, testCase.mode, testCase.until, retention.Mode, retention.RetainUntilDate.Time)
+		}
+	}
+}
+
+func TestCheckRetentionChange(t *testing.T) {
+	now := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	retention := func(mode objectlock.RetMode, until time.Time) objectlock.ObjectRetention {
+		r := objectlock.ObjectRetention{Mode: mode}
+		r.RetainUntilDate.Time = until
+		return r
+	}
+	feb := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	mar := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	compliance := map[string]string{"x-amz-object-lock-mode": "COMPLIANCE", "x-amz-object-lock-retain-until-date": "2021-02-01T00:00:00Z"}
+	governance := map[string]string{"x-amz-object-lock-mode": "GOVERNANCE", "x-amz-object-lock-retain-until-date": "2021-02-01T00:00:00Z"}
+	expired := map[string]string{"x-amz-object-lock-mode": "COMPLIANCE", "x-amz-object-lock-retain-until-date": "2021-01-01T00:00:00Z"}
+
+	testCases := []struct {
+		current   map[string]string
+		retention objectlock.ObjectRetention
+		locked    bool
+	}{
+		{map[string]string{}, retention(objectlock.RetCompliance, feb), false},
+		{governance, objectlock.ObjectRetention{}, false},
+		{governance, retention(objectlock.RetGovernance, now.Add(time.Hour)), false},
+		{compliance, retention(objectlock.RetCompliance, mar), false},
+		{compliance, retention(objectlock.RetCompliance, feb), false},
+		{compliance, retention(objectlock.RetCompliance, now.Add(time.Hour)), true},
+		{compliance, retention(objectlock.RetGovernance, mar), true},
+		{compliance, objectlock.ObjectRetention{}, true},
+		{expired, objectlock.ObjectRetention{}, false},
+	}
+	for i, testCase := range testCases {
+		err := checkRetentionChange("bucket", "object", testCase.current, testCase.retention, now)
+		if testCase.locked != (err != nil) {
+			t.Fatalf("Test %d: Expected locked %v, got error %v", i+1, testCase.locked, err)
+		}
+		if err != nil {
+			if resp, ok := err.(miniogo.ErrorResponse); !ok || resp.StatusCode != http.StatusForbidden {
+				t.Errorf("Test %d: Expected an object locked error, got %v", i+1, err)
+			}
+		}
+	}
+}
+
+func TestAzureContainerLock(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/locked":
+			w.Header().Set(azureImmutableContainerHeader, "true")
+			w.Header().Set("x-ms-meta-"+azureDefaultRetentionMetaKey, "GOVERNANCE:30d")
+		case "/plain":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &azureObjects{
+		client: azblob.NewServiceURL(*u, azureVersionPipeline{azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{})}),
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		lock, err := a.containerLock(ctx, "locked")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := &azureDefaultRetention{Mode: objectlock.RetGovernance, Days: 30}
+		if !lock.enabled || !reflect.DeepEqual(lock.defaultRetention, expected) {
+			t.Fatalf("Expected object lock with default retention %v, got %+v", expected, lock)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected the container lock state to be cached, got %d requests", requests)
+	}
+
+	requested := map[string]string{"x-amz-object-lock-legal-hold": "ON"}
+	if err = a.checkObjectLockRequest(ctx, "locked", "object", requested); err != nil {
+		t.Errorf("Expected a legal hold to be accepted on a locked bucket, got %v", err)
+	}
+	if err = a.checkObjectLockRequest(ctx, "plain", "object", requested); err == nil {
+		t.Error("Expected a legal hold to be rejected on a bucket without object lock")
+	} else if _, ok := err.(minio.InvalidArgument); !ok {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+	if err = a.checkObjectLockRequest(ctx, "missing", "object", map[string]string{"X-Amz-Meta-A": "b"}); err != nil {
+		t.Errorf("Expected metadata without object lock to be accepted, got %v", err)
+	}
+}
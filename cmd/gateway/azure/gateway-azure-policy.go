@@ -0,0 +1,300 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package azure
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	miniogo "github.com/minio/minio-go/v7"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/bucket/policy"
+	"github.com/minio/minio/pkg/bucket/policy/condition"
+)
+
+// Bucket policies are translated to the public access level of the
+// container where Azure has an equivalent:
+//
+// - Anonymous read of all blobs maps to the PublicAccessBlob access level,
+//   adding anonymous listing maps to PublicAccessContainer.
+//
+// Stored access policies of the container only govern SAS tokens, they
+// are left as they are and play no part in anonymous access.
+//
+// The complete policy document is kept in the metadata of the container,
+// which anonymous clients cannot read, and is what the gateway enforces,
+// so statements without an Azure equivalent keep working for S3 clients.
+// When the public access level is changed outside of the gateway the
+// document is ignored and the policy is derived from the access level.
+
+const (
+	// Metadata keys of the container holding the policy document and the
+	// public access level which was set along with it.
+	azurePolicyMetaKey       = "miniopolicy"
+	azurePolicyAccessMetaKey = "miniopolicyaccess"
+
+	// Maximum size of the encoded policy document, container metadata
+	// is limited to 8 KiB in total.
+	azureMaxPolicySize = 6 * 1024
+
+	// Time policies are cached for, they are looked up for every
+	// anonymous request and may be changed by other gateways.
+	azurePolicyCacheTTL = 10 * time.Second
+)
+
+// Permissions of the container public access levels and the S3 actions
+// they grant.
+var azurePolicyPermissions = []struct {
+	permission string
+	action     policy.Action
+}{
+	{"r", policy.GetObjectAction},
+	{"w", policy.PutObjectAction},
+	{"d", policy.DeleteObjectAction},
+	{"l", policy.ListBucketAction},
+}
+
+// isAnonymousStatement returns true if the statement applies to everyone.
+func isAnonymousStatement(statement policy.Statement) bool {
+	return statement.Principal.AWS.Contains("*")
+}
+
+// getStatementPermissions returns the permissions equivalent to the
+// actions of the statement, ok is false if the statement has actions or
+// resources Azure cannot express.
+func getStatementPermissions(bucket string, statement policy.Statement) (permissions string, ok bool) {
+	objects := policy.NewResource(bucket, "*")
+	container := policy.NewResource(bucket, "")
+	for resource := range statement.Resources {
+		if resource != objects && resource != container {
+			return "", false
+		}
+	}
+
+	actions := policy.NewActionSet(statement.Actions.ToSlice()...)
+	for _, p := range azurePolicyPermissions {
+		if !actions.Contains(p.action) {
+			continue
+		}
+		resource := objects
+		if p.action == policy.ListBucketAction {
+			resource = container
+		}
+		if _, found := statement.Resources[resource]; !found {
+			return "", false
+		}
+		permissions += p.permission
+		delete(actions, p.action)
+	}
+	// Location of the bucket is public information on Azure.
+	delete(actions, policy.GetBucketLocationAction)
+	return permissions, len(actions) == 0 && permissions != ""
+}
+
+// azurePolicyToPublicAccess translates the bucket policy into the public
+// access level of the container which grants the same anonymous reads
+// natively, statements which have no Azure equivalent are left to the
+// gateway.
+func azurePolicyToPublicAccess(bucket string, bucketPolicy *policy.Policy) azblob.PublicAccessType {
+	var read, list bool
+	for _, statement := range bucketPolicy.Statements {
+		if statement.Effect != policy.Allow {
+			// Azure has no way to deny access, so the container
+			// must not grant anything the policy might deny.
+			return azblob.PublicAccessNone
+		}
+		if !isAnonymousStatement(statement) || len(statement.Conditions) > 0 {
+			continue
+		}
+		permissions, ok := getStatementPermissions(bucket, statement)
+		if !ok {
+			continue
+		}
+		read = read || strings.Contains(permissions, "r")
+		list = list || strings.Contains(permissions, "l")
+	}
+
+	switch {
+	case read && list:
+		return azblob.PublicAccessContainer
+	case read:
+		return azblob.PublicAccessBlob
+	}
+	return azblob.PublicAccessNone
+}
+
+// azurePublicAccessToPolicy translates the public access level of the
+// container into the equivalent bucket policy, nil if the container is
+// private.
+func azurePublicAccessToPolicy(bucket string, access azblob.PublicAccessType) *policy.Policy {
+	bucketPolicy := &policy.Policy{Version: policy.DefaultVersion}
+	switch access {
+	case azblob.PublicAccessContainer:
+		bucketPolicy.Statements = append(bucketPolicy.Statements, policy.NewStatement(
+			policy.Allow,
+			policy.NewPrincipal("*"),
+			policy.NewActionSet(
+				policy.GetBucketLocationAction,
+				policy.ListBucketAction,
+				policy.GetObjectAction,
+			),
+			policy.NewResourceSet(
+				policy.NewResource(bucket, ""),
+				policy.NewResource(bucket, "*"),
+			),
+			condition.NewFunctions(),
+		))
+	case azblob.PublicAccessBlob:
+		bucketPolicy.Statements = append(bucketPolicy.Statements, policy.NewStatement(
+			policy.Allow,
+			policy.NewPrincipal("*"),
+			policy.NewActionSet(policy.GetObjectAction),
+			policy.NewResourceSet(policy.NewResource(bucket, "*")),
+			condition.NewFunctions(),
+		))
+	default:
+		return nil
+	}
+	return bucketPolicy
+}
+
+// encodeAzurePublicAccess encodes the public access level as kept in the
+// container metadata, which does not take empty values.
+func encodeAzurePublicAccess(access azblob.PublicAccessType) string {
+	if access == azblob.PublicAccessNone {
+		return "private"
+	}
+	return string(access)
+}
+
+// azureContainerPolicy returns the policy of a container from its public
+// access level and metadata: the stored document if the access level is
+// still the one set along with it, or else the policy equivalent to the
+// access level. It is nil if the container has no policy.
+func azureContainerPolicy(bucket string, access azblob.PublicAccessType, metadata azblob.Metadata) (*policy.Policy, error) {
+	encoded, ok := metadata[azurePolicyMetaKey]
+	if !ok || metadata[azurePolicyAccessMetaKey] != encodeAzurePublicAccess(access) {
+		return azurePublicAccessToPolicy(bucket, access), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return policy.ParseConfig(strings.NewReader(string(data)), bucket)
+}
+
+// azureCachedPolicy is the cached policy of a container, nil if it has
+// none.
+type azureCachedPolicy struct {
+	policy  *policy.Policy
+	expires time.Time
+}
+
+// errAzurePolicyTooLarge is returned for policies which do not fit in the
+// metadata of the container.
+func errAzurePolicyTooLarge(bucket string) error {
+	return miniogo.ErrorResponse{
+		Code:       "PolicyTooLarge",
+		Message:    "Policy exceeds the maximum allowed document size.",
+		BucketName: bucket,
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+// setContainerPolicy sets the public access level of the container,
+// keeping its stored access policies, and keeps the encoded policy along
+// with it in the metadata of the container, or removes it if empty.
+func (a *azureObjects) setContainerPolicy(ctx context.Context, bucket string, access azblob.PublicAccessType, encoded string) error {
+	defer a.policies.Delete(bucket)
+
+	container := a.client.NewContainerURL(bucket)
+	acl, err := container.GetAccessPolicy(ctx, azblob.LeaseAccessConditions{})
+	if err != nil {
+		return azureToObjectError(err, bucket)
+	}
+	if _, err = container.SetAccessPolicy(ctx, access, acl.Items, azblob.ContainerAccessConditions{}); err != nil {
+		return azureToObjectError(err, bucket)
+	}
+
+	props, err := container.GetProperties(ctx, azblob.LeaseAccessConditions{})
+	if err != nil {
+		return azureToObjectError(err, bucket)
+	}
+	metadata := props.NewMetadata()
+	if encoded != "" {
+		metadata[azurePolicyMetaKey] = encoded
+		metadata[azurePolicyAccessMetaKey] = encodeAzurePublicAccess(access)
+	} else {
+		delete(metadata, azurePolicyMetaKey)
+		delete(metadata, azurePolicyAccessMetaKey)
+	}
+	_, err = container.SetMetadata(ctx, metadata, azblob.ContainerAccessConditions{})
+	return azureToObjectError(err, bucket)
+}
+
+// SetBucketPolicy - Sets the container public access level to the native
+// equivalent of the policy and keeps the policy in the container metadata
+// to be enforced by the gateway.
+func (a *azureObjects) SetBucketPolicy(ctx context.Context, bucket string, bucketPolicy *policy.Policy) error {
+	data, err := json.Marshal(bucketPolicy)
+	if err != nil {
+		// This should not happen.
+		logger.LogIf(ctx, err)
+		return azureToObjectError(err, bucket)
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	if len(encoded) > azureMaxPolicySize {
+		return errAzurePolicyTooLarge(bucket)
+	}
+	return a.setContainerPolicy(ctx, bucket, azurePolicyToPublicAccess(bucket, bucketPolicy), encoded)
+}
+
+// GetBucketPolicy - Returns the policy kept by SetBucketPolicy, or the
+// policy equivalent to the container public access level if it was
+// changed since. Policies are cached for a short time, as they are looked
+// up for every anonymous request.
+func (a *azureObjects) GetBucketPolicy(ctx context.Context, bucket string) (*policy.Policy, error) {
+	var bucketPolicy *policy.Policy
+	if v, ok := a.policies.Load(bucket); ok && time.Now().Before(v.(azureCachedPolicy).expires) {
+		bucketPolicy = v.(azureCachedPolicy).policy
+	} else {
+		props, err := a.client.NewContainerURL(bucket).GetProperties(ctx, azblob.LeaseAccessConditions{})
+		if err != nil {
+			return nil, azureToObjectError(err, bucket)
+		}
+		if bucketPolicy, err = azureContainerPolicy(bucket, props.BlobPublicAccess(), props.NewMetadata()); err != nil {
+			return nil, err
+		}
+		a.policies.Store(bucket, azureCachedPolicy{policy: bucketPolicy, expires: time.Now().Add(azurePolicyCacheTTL)})
+	}
+	if bucketPolicy == nil {
+		return nil, minio.BucketPolicyNotFound{Bucket: bucket}
+	}
+	return bucketPolicy, nil
+}
+
+// DeleteBucketPolicy - Set the container public access level to "private"
+// and removes the policy from the container metadata.
+func (a *azureObjects) DeleteBucketPolicy(ctx context.Context, bucket string) error {
+	return a.setContainerPolicy(ctx, bucket, azblob.PublicAccessNone, "")
+}
@@ -0,0 +1,174 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package azure
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/bucket/policy"
+)
+
+func parseTestPolicy(t *testing.T, s string) *policy.Policy {
+	t.Helper()
+	p, err := policy.ParseConfig(strings.NewReader(s), "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAzurePolicyToPublicAccess(t *testing.T) {
+	testCases := []struct {
+		policy   string
+		expected azblob.PublicAccessType
+	}{
+		// Read and list maps to container access.
+		{`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetBucketLocation","s3:ListBucket"],"Resource":["arn:aws:s3:::bucket"]},
+		  {"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/*"]}]}`,
+			azblob.PublicAccessContainer},
+		// Read without list maps to blob access.
+		{`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/*"]}]}`,
+			azblob.PublicAccessBlob},
+		// Prefixes cannot be expressed on Azure.
+		{`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/public/*"]}]}`,
+			azblob.PublicAccessNone},
+		// Deny statements disable any native access.
+		{`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/*"]},
+		  {"Effect":"Deny","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/secret/*"]}]}`,
+			azblob.PublicAccessNone},
+		// Time-bounded grants are only enforced by the gateway.
+		{`{"Version":"2012-10-17","Statement":[{"Sid":"january","Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject","s3:PutObject"],"Resource":["arn:aws:s3:::bucket/*"],
+		  "Condition":{"DateGreaterThan":{"aws:CurrentTime":"2021-01-01T00:00:00Z"},"DateLessThan":{"aws:CurrentTime":"2021-02-01T00:00:00Z"}}}]}`,
+			azblob.PublicAccessNone},
+		// Other conditions are only enforced by the gateway.
+		{`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/*"],
+		  "Condition":{"IpAddress":{"aws:SourceIp":"10.0.0.0/8"}}}]}`,
+			azblob.PublicAccessNone},
+	}
+
+	for i, testCase := range testCases {
+		if access := azurePolicyToPublicAccess("bucket", parseTestPolicy(t, testCase.policy)); access != testCase.expected {
+			t.Errorf("Test %d: Expected %q, got %q", i+1, testCase.expected, access)
+		}
+	}
+}
+
+func TestAzurePublicAccessToPolicy(t *testing.T) {
+	if p := azurePublicAccessToPolicy("bucket", azblob.PublicAccessNone); p != nil {
+		t.Fatalf("Expected no policy, got %v", p)
+	}
+
+	// The translation must be stable in both directions.
+	for _, access := range []azblob.PublicAccessType{azblob.PublicAccessBlob, azblob.PublicAccessContainer} {
+		p := azurePublicAccessToPolicy("bucket", access)
+		if err := p.Validate("bucket"); err != nil {
+			t.Fatal(err)
+		}
+		if got := azurePolicyToPublicAccess("bucket", p); got != access {
+			t.Errorf("Expected %q, got %q", access, got)
+		}
+	}
+}
+
+func TestAzureContainerPolicy(t *testing.T) {
+	stored := parseTestPolicy(t, `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/public/*"]}]}`)
+	data, err := stored.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := azblob.Metadata{
+		azurePolicyMetaKey:       base64.StdEncoding.EncodeToString(data),
+		azurePolicyAccessMetaKey: encodeAzurePublicAccess(azblob.PublicAccessNone),
+	}
+
+	p, err := azureContainerPolicy("bucket", azblob.PublicAccessNone, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p, stored) {
+		t.Errorf("Expected the stored policy %v, got %v", stored, p)
+	}
+
+	// The access level was changed outside of the gateway.
+	p, err = azureContainerPolicy("bucket", azblob.PublicAccessBlob, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := azurePublicAccessToPolicy("bucket", azblob.PublicAccessBlob); !reflect.DeepEqual(p, expected) {
+		t.Errorf("Expected the policy of the access level %v, got %v", expected, p)
+	}
+
+	if p, err = azureContainerPolicy("bucket", azblob.PublicAccessNone, azblob.Metadata{}); err != nil || p != nil {
+		t.Errorf("Expected no policy, got %v, %v", p, err)
+	}
+
+	metadata[azurePolicyMetaKey] = "not base64"
+	if _, err = azureContainerPolicy("bucket", azblob.PublicAccessNone, metadata); err == nil {
+		t.Error("Expected an invalid stored policy to be rejected")
+	}
+}
+
+func TestAzureGetBucketPolicyCache(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/public":
+			w.Header().Set("x-ms-blob-public-access", "blob")
+		case "/private":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &azureObjects{
+		client: azblob.NewServiceURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{})),
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		p, err := a.GetBucketPolicy(ctx, "public")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := azurePublicAccessToPolicy("public", azblob.PublicAccessBlob); !reflect.DeepEqual(p, expected) {
+			t.Errorf("Expected %v, got %v", expected, p)
+		}
+		if _, err = a.GetBucketPolicy(ctx, "private"); err == nil {
+			t.Error("Expected no policy for a private container")
+		} else if _, ok := err.(minio.BucketPolicyNotFound); !ok {
+			t.Errorf("Expected BucketPolicyNotFound, got %v", err)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("Expected policies to be cached, got %d requests", requests)
+	}
+}
@@ -19,6 +19,7 @@ package azure
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
@@ -34,19 +35,21 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-blob-go/azblob"
 	humanize "github.com/dustin/go-humanize"
+	"github.com/gorilla/mux"
 	"github.com/minio/cli"
 	ming "github.com/minio/ming/cmd"
-	miniogopolicy "github.com/minio/minio-go/v7/pkg/policy"
 	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/config"
+	"github.com/minio/minio/cmd/crypto"
 	"github.com/minio/minio/cmd/logger"
 	"github.com/minio/minio/pkg/auth"
-	"github.com/minio/minio/pkg/bucket/policy"
-	"github.com/minio/minio/pkg/bucket/policy/condition"
 	"github.com/minio/minio/pkg/env"
 	"github.com/minio/minio/pkg/madmin"
 )
@@ -59,6 +62,8 @@ const (
 	azureMarkerPrefix             = "{minio}"
 	metadataPartNamePrefix        = ming.GatewayMinioSysTmp + "multipart/v1/%s.%x"
 	maxPartsCount                 = 10000
+	azureCopyFromURLMaxSize       = 256 * humanize.MiByte
+	azureSASExpiry                = 15 * time.Minute
 )
 
 var (
@@ -96,6 +101,12 @@ EXAMPLES:
      {{.Prompt}} {{.EnvVarSetCommand}} MINIO_CACHE_WATERMARK_HIGH{{.AssignmentOperator}}85
      {{.Prompt}} {{.HelpName}}
 
+  3. Start ming server for Azure Data Lake Storage Gen2 with hierarchical namespace enabled.
+     {{.Prompt}} {{.EnvVarSetCommand}} MINIO_ROOT_USER{{.AssignmentOperator}}azureaccountname
+     {{.Prompt}} {{.EnvVarSetCommand}} MINIO_ROOT_PASSWORD{{.AssignmentOperator}}azureaccountkey
+     {{.Prompt}} {{.EnvVarSetCommand}} MINIO_AZURE_HNS{{.AssignmentOperator}}on
+     {{.Prompt}} {{.HelpName}}
+
 `
 
 	ming.RegisterGatewayCommand(cli.Command{
@@ -125,12 +136,13 @@ func azureGatewayMain(ctx *cli.Context) {
 	// Validate gateway arguments.
 	logger.FatalIf(ming.ValidateGatewayArguments(serverAddr, host), "Invalid argument")
 
-	ming.StartGateway(ctx, &Azure{host})
+	ming.StartGateway(ctx, &Azure{host: host})
 }
 
 // Azure implements Gateway.
 type Azure struct {
-	host string
+	host  string
+	layer atomic.Value // *azureObjects, set once the gateway layer is initialized
 }
 
 // Name implements Gateway interface.
@@ -169,6 +181,11 @@ func (g *Azure) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, erro
 		return nil, err
 	}
 
+	hns, err := config.ParseBool(env.Get("MINIO_AZURE_HNS", config.EnableOff))
+	if err != nil {
+		return nil, fmt.Errorf("MINIO_AZURE_HNS should be 'on' or 'off': %w", err)
+	}
+
 	credential, err := azblob.NewSharedKeyCredential(creds.AccessKey, creds.SecretKey)
 	if err != nil {
 		if _, ok := err.(base64.CorruptInputError); ok {
@@ -202,14 +219,20 @@ func (g *Azure) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, erro
 		}),
 	})
 
-	client := azblob.NewServiceURL(*endpointURL, pipeline)
+	client := azblob.NewServiceURL(*endpointURL, azureVersionPipeline{pipeline})
 
-	return &azureObjects{
-		endpoint:   endpointURL,
-		httpClient: httpClient,
-		client:     client,
-		metrics:    metrics,
-	}, nil
+	a := &azureObjects{
+		endpoint:     endpointURL,
+		dfsEndpoint:  parseDFSEndpoint(endpointURL),
+		httpClient:   httpClient,
+		httpPipeline: pipeline,
+		credential:   credential,
+		client:       client,
+		metrics:      metrics,
+		hns:          hns,
+	}
+	g.layer.Store(a)
+	return a, nil
 }
 
 func parseStorageEndpoint(host string, accountName string) (*url.URL, error) {
@@ -250,6 +273,11 @@ func (g *Azure) Production() bool {
 	return true
 }
 
+// RegisterAdminRouter registers the object lock admin API.
+func (g *Azure) RegisterAdminRouter(router *mux.Router) {
+	g.registerObjectLockRouter(router)
+}
+
 // s3MetaToAzureProperties converts metadata meant for S3 PUT/COPY
 // object into Azure data structures - BlobMetadata and
 // BlobProperties.
@@ -263,6 +291,9 @@ func (g *Azure) Production() bool {
 // Content-Encoding, etc. Such metadata that is accepted by S3 is
 // copied into BlobProperties.
 //
+// The internal metadata of the handlers, such as the sealed keys of
+// encrypted objects, is kept in the azureInternalMetaKey metadata.
+//
 // Header names are canonicalized as in http.Header.
 func s3MetaToAzureProperties(ctx context.Context, s3Metadata map[string]string) (azblob.Metadata, azblob.BlobHTTPHeaders, error) {
 	for k := range s3Metadata {
@@ -288,14 +319,24 @@ func s3MetaToAzureProperties(ctx context.Context, s3Metadata map[string]string)
 	var blobMeta azblob.Metadata = make(map[string]string)
 	var err error
 	var props azblob.BlobHTTPHeaders
+	internal := make(map[string]string)
 	for k, v := range s3Metadata {
+		if strings.HasPrefix(http.CanonicalHeaderKey(k), minio.ReservedMetadataPrefix) {
+			// Keep the key as is, the handlers look up
+			// some of them in lower case.
+			internal[k] = v
+			continue
+		}
 		k = http.CanonicalHeaderKey(k)
 		switch {
 		case strings.HasPrefix(k, "X-Amz-Meta-"):
 			// Strip header prefix, to let Azure SDK
 			// handle it for storage.
-			k = strings.Replace(k, "X-Amz-Meta-", "", 1)
-			blobMeta[encodeKey(k)] = v
+			k = encodeKey(strings.Replace(k, "X-Amz-Meta-", "", 1))
+			if isAzureInternalMetaKey(k) {
+				return azblob.Metadata{}, azblob.BlobHTTPHeaders{}, minio.UnsupportedMetadata{}
+			}
+			blobMeta[k] = v
 		// All cases below, extract common metadata that is
 		// accepted by S3 into BlobProperties for setting on
 		// Azure - see
@@ -314,7 +355,15 @@ func s3MetaToAzureProperties(ctx context.Context, s3Metadata map[string]string)
 			props.ContentLanguage = v
 		}
 	}
-	return blobMeta, props, err
+	if err != nil {
+		return blobMeta, props, err
+	}
+	if len(internal) > 0 {
+		if blobMeta[azureInternalMetaKey], err = encodeAzureInternalMeta(internal); err != nil {
+			return blobMeta, props, err
+		}
+	}
+	return blobMeta, props, nil
 }
 
 const (
@@ -381,6 +430,18 @@ func azurePropertiesToS3Meta(meta azblob.Metadata, props azblob.BlobHTTPHeaders,
 
 	s3Metadata := make(map[string]string)
 	for k, v := range meta {
+		if k == azureInternalMetaKey {
+			// An invalid value leaves the internal metadata
+			// unset, an encrypted object then fails to decrypt.
+			internal, _ := decodeAzureInternalMeta(v)
+			for ik, iv := range internal {
+				s3Metadata[ik] = iv
+			}
+			continue
+		}
+		if isAzureInternalMetaKey(k) {
+			continue
+		}
 		// k's `x-ms-meta-` prefix is already stripped by
 		// Azure SDK, so we add the AMZ prefix.
 		k = "X-Amz-Meta-" + decodeKey(k)
@@ -417,10 +478,17 @@ func azurePropertiesToS3Meta(meta azblob.Metadata, props azblob.BlobHTTPHeaders,
 // azureObjects - Implements Object layer for Azure blob storage.
 type azureObjects struct {
 	minio.ObjectLayerUnsupported
-	endpoint   *url.URL
-	httpClient *http.Client
-	metrics    *minio.BackendMetrics
-	client     azblob.ServiceURL // Azure sdk client
+	endpoint     *url.URL
+	dfsEndpoint  *url.URL // ADLS Gen2 endpoint, only used when hns is set
+	httpClient   *http.Client
+	httpPipeline pipeline.Pipeline
+	credential   *azblob.SharedKeyCredential
+	metrics      *minio.BackendMetrics
+	client       azblob.ServiceURL // Azure sdk client
+	hns          bool              // Storage account has hierarchical namespace enabled
+
+	containerLocks sync.Map // Container name to its azureContainerLock
+	policies       sync.Map // Container name to its azureCachedPolicy
 }
 
 // Convert azure errors to minio object layer errors.
@@ -465,6 +533,14 @@ func azureCodesToObjectError(err error, serviceCode string, statusCode int, buck
 		err = minio.UnsupportedMetadata{}
 	case "BlobAccessTierNotSupportedForAccountType":
 		err = minio.NotImplemented{}
+	case "BlobImmutableDueToPolicy", "BlobImmutableDueToLegalHold":
+		err = ming.ObjectLocked(bucket, object)
+	case "BlobUsesCustomerSpecifiedEncryption":
+		err = crypto.ErrMissingCustomerKey
+	case "BlobDoesNotUseCustomerSpecifiedEncryption":
+		err = crypto.ErrIncompatibleEncryptionMethod
+	case "ConditionNotMet", "SourceConditionNotMet":
+		err = minio.PreConditionFailed{}
 	case "OutOfRangeInput":
 		err = minio.ObjectNameInvalid{
 			Bucket: bucket,
@@ -532,6 +608,27 @@ func parseAzurePart(metaPartFileName, prefix string) (partID int, err error) {
 	return
 }
 
+// getSASURL returns a URL of the blob which is authorized with a
+// short-lived shared access signature, as required by the server-side
+// copy APIs which read from a source URL.
+func (a *azureObjects) getSASURL(bucket, object string, perms azblob.BlobSASPermissions) (url.URL, error) {
+	blobURL := a.client.NewContainerURL(bucket).NewBlobURL(object)
+	sas, err := azblob.BlobSASSignatureValues{
+		Protocol:      azblob.SASProtocolHTTPSandHTTP,
+		StartTime:     time.Now().UTC().Add(-5 * time.Minute),
+		ExpiryTime:    time.Now().UTC().Add(azureSASExpiry),
+		ContainerName: bucket,
+		BlobName:      object,
+		Permissions:   perms.String(),
+	}.NewSASQueryParameters(a.credential)
+	if err != nil {
+		return url.URL{}, err
+	}
+	parts := azblob.NewBlobURLParts(blobURL.URL())
+	parts.SAS = sas
+	return parts.URL(), nil
+}
+
 // GetMetrics returns this gateway's metrics
 func (a *azureObjects) GetMetrics(ctx context.Context) (*minio.BackendMetrics, error) {
 	return a.metrics, nil
@@ -557,7 +654,8 @@ func (a *azureObjects) StorageInfo(ctx context.Context) (si minio.StorageInfo, _
 // MakeBucketWithLocation - Create a new container on azure backend.
 func (a *azureObjects) MakeBucketWithLocation(ctx context.Context, bucket string, opts minio.BucketOptions) error {
 	// Filter out unsupported features in Azure and return immediately with NotImplemented error
-	if opts.LockEnabled || opts.VersioningEnabled || strings.ContainsAny(bucket, ".") {
+	// Object lock implies versioning, which Azure handles by itself.
+	if (opts.VersioningEnabled && !opts.LockEnabled) || strings.ContainsAny(bucket, ".") {
 		return minio.NotImplemented{}
 	}
 
@@ -569,6 +667,10 @@ func (a *azureObjects) MakeBucketWithLocation(ctx context.Context, bucket string
 		return minio.BucketNameInvalid{Bucket: bucket}
 	}
 
+	if opts.LockEnabled {
+		return a.createImmutableContainer(ctx, bucket)
+	}
+
 	containerURL := a.client.NewContainerURL(bucket)
 	_, err := containerURL.Create(ctx, azblob.Metadata{}, azblob.PublicAccessNone)
 	return azureToObjectError(err, bucket)
@@ -644,6 +746,8 @@ func (a *azureObjects) DeleteBucket(ctx context.Context, bucket string, forceDel
 
 	containerURL := a.client.NewContainerURL(bucket)
 	_, err := containerURL.Delete(ctx, azblob.ContainerAccessConditions{})
+	a.containerLocks.Delete(bucket)
+	a.policies.Delete(bucket)
 	return azureToObjectError(err, bucket)
 }
 
@@ -674,8 +778,14 @@ func (a *azureObjects) ListObjects(ctx context.Context, bucket, prefix, marker,
 	}
 
 	containerURL := a.client.NewContainerURL(bucket)
+	listBlobs := containerURL.ListBlobsHierarchySegment
+	if a.hns {
+		listBlobs = func(ctx context.Context, marker azblob.Marker, delimiter string, o azblob.ListBlobsSegmentOptions) (*azblob.ListBlobsHierarchySegmentResponse, error) {
+			return hnsListBlobsSegment(ctx, containerURL, marker, delimiter, o)
+		}
+	}
 	for len(objects) == 0 && len(prefixes) == 0 {
-		resp, err := containerURL.ListBlobsHierarchySegment(ctx, azureListMarker, delimiter, azblob.ListBlobsSegmentOptions{
+		resp, err := listBlobs(ctx, azureListMarker, delimiter, azblob.ListBlobsSegmentOptions{
 			Prefix:     prefix,
 			MaxResults: int32(maxKeys),
 		})
@@ -713,6 +823,17 @@ func (a *azureObjects) ListObjects(ctx context.Context, bucket, prefix, marker,
 				delete(blob.Metadata, "md5sum")
 			}
 
+			if isAzureHNSFolder(blob.Metadata) {
+				objects = append(objects, minio.ObjectInfo{
+					Bucket:  bucket,
+					Name:    blob.Name,
+					ModTime: blob.Properties.LastModified,
+					ETag:    etag,
+					IsDir:   true,
+				})
+				continue
+			}
+
 			objects = append(objects, minio.ObjectInfo{
 				Bucket:          bucket,
 				Name:            blob.Name,
@@ -787,8 +908,9 @@ func (a *azureObjects) GetObjectNInfo(ctx context.Context, bucket, object string
 		return nil, err
 	}
 
-	var startOffset, length int64
-	startOffset, length, err = rs.GetOffsetLength(objInfo.Size)
+	// The range is translated to the range of the stored stream of
+	// encrypted objects, which fn decrypts.
+	fn, startOffset, length, err := minio.NewGetObjectReader(rs, objInfo, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -805,7 +927,7 @@ func (a *azureObjects) GetObjectNInfo(ctx context.Context, bucket, object string
 	// Setup cleanup function to cause the above go-routine to
 	// exit in case of partial read
 	pipeCloser := func() { pr.Close() }
-	return minio.NewGetObjectReaderFromReader(pr, objInfo, opts, pipeCloser)
+	return fn(pr, h, opts.CheckPrecondFn, pipeCloser)
 }
 
 // GetObject - reads an object from azure. Supports additional
@@ -841,8 +963,16 @@ func (a *azureObjects) getObject(ctx context.Context, bucket, object string, sta
 // GetObjectInfo - reads blob metadata properties and replies back minio.ObjectInfo,
 // uses Azure equivalent `BlobURL.GetProperties`.
 func (a *azureObjects) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	if a.hns && minio.HasSuffix(object, minio.SlashSeparator) {
+		return a.hnsGetDirectoryInfo(ctx, bucket, object)
+	}
+
 	blobURL := a.client.NewContainerURL(bucket).NewBlobURL(object)
-	blob, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{})
+	propsCtx := ctx
+	if enabled, _ := a.isObjectLockEnabled(ctx, bucket); enabled {
+		propsCtx = withAzureVersion(ctx, azureObjectLockVersion)
+	}
+	blob, err := blobURL.GetProperties(propsCtx, azblob.BlobAccessConditions{})
 	if err != nil {
 		return objInfo, azureToObjectError(err, bucket, object)
 	}
@@ -870,10 +1000,34 @@ func (a *azureObjects) GetObjectInfo(ctx context.Context, bucket, object string,
 		etag = metadata["md5sum"]
 		delete(metadata, "md5sum")
 	}
+	var parts []minio.ObjectPartInfo
+	if encoded, ok := metadata[azurePartsKey]; ok {
+		if parts, err = ming.DecodePartLayout(encoded, maxPartsCount); err != nil {
+			logger.LogIf(ctx, err)
+			return objInfo, azureToObjectError(err, bucket, object)
+		}
+	}
+
+	userDefined := azurePropertiesToS3Meta(metadata, blob.NewHTTPHeaders(), blob.ContentLength())
+	for k, v := range azureObjectLockToS3Meta(blob.Response().Header) {
+		userDefined[k] = v
+	}
+	if a.hns {
+		if isAzureHNSFolder(metadata) {
+			return objInfo, minio.ObjectNotFound{Bucket: bucket, Object: object}
+		}
+		acl, err := a.dfsGetAccessControl(ctx, bucket, object)
+		if err != nil {
+			return objInfo, err
+		}
+		for k, v := range acl {
+			userDefined[k] = v
+		}
+	}
 
 	return minio.ObjectInfo{
 		Bucket:          bucket,
-		UserDefined:     azurePropertiesToS3Meta(metadata, blob.NewHTTPHeaders(), blob.ContentLength()),
+		UserDefined:     userDefined,
 		ETag:            etag,
 		InnerETag:       realETag,
 		ModTime:         blob.LastModified(),
@@ -882,6 +1036,7 @@ func (a *azureObjects) GetObjectInfo(ctx context.Context, bucket, object string,
 		ContentType:     blob.ContentType(),
 		ContentEncoding: blob.ContentEncoding(),
 		StorageClass:    azureTierToS3StorageClass(blob.AccessTier()),
+		Parts:           parts,
 	}, nil
 }
 
@@ -893,6 +1048,24 @@ func (a *azureObjects) PutObject(ctx context.Context, bucket, object string, r *
 		opts.UserDefined = map[string]string{}
 	}
 
+	var acl http.Header
+	if a.hns {
+		if minio.HasSuffix(object, minio.SlashSeparator) && data.Size() == 0 {
+			if err = a.dfsCreateDirectory(ctx, bucket, object); err != nil {
+				return objInfo, err
+			}
+			return a.GetObjectInfo(ctx, bucket, object, opts)
+		}
+		acl, opts.UserDefined = extractHNSAccessControl(opts.UserDefined)
+	}
+
+	if err = a.checkObjectLockRequest(ctx, bucket, object, opts.UserDefined); err != nil {
+		return objInfo, err
+	}
+	if err = a.checkObjectNotLocked(ctx, bucket, object); err != nil {
+		return objInfo, err
+	}
+
 	metadata, properties, err := s3MetaToAzureProperties(ctx, opts.UserDefined)
 	if err != nil {
 		return objInfo, azureToObjectError(err, bucket, object)
@@ -920,15 +1093,33 @@ func (a *azureObjects) PutObject(ctx context.Context, bucket, object string, r *
 	if err != nil {
 		return objInfo, azureToObjectError(err, bucket, object)
 	}
+	if err = a.dfsSetAccessControl(ctx, bucket, object, acl); err != nil {
+		return objInfo, err
+	}
+	if err = a.applyObjectLock(ctx, bucket, object, opts.UserDefined); err != nil {
+		return objInfo, err
+	}
 	return a.GetObjectInfo(ctx, bucket, object, opts)
 }
 
 // CopyObject - Copies a blob from source container to destination container.
 // Uses Azure equivalent `BlobURL.StartCopyFromURL`.
+//
+// With hierarchical namespace enabled the copy is made into a temporary
+// blob which is renamed onto the destination once its properties are
+// set, so readers never observe a partially copied object.
 func (a *azureObjects) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, dstOpts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
 	if srcOpts.CheckPrecondFn != nil && srcOpts.CheckPrecondFn(srcInfo) {
 		return minio.ObjectInfo{}, minio.PreConditionFailed{}
 	}
+
+	if err = a.checkObjectLockRequest(ctx, destBucket, destObject, srcInfo.UserDefined); err != nil {
+		return objInfo, err
+	}
+	if err = a.checkObjectNotLocked(ctx, destBucket, destObject); err != nil {
+		return objInfo, err
+	}
+
 	srcBlob := a.client.NewContainerURL(srcBucket).NewBlobURL(srcObject)
 	srcBlobURL := srcBlob.URL()
 
@@ -936,27 +1127,74 @@ func (a *azureObjects) CopyObject(ctx context.Context, srcBucket, srcObject, des
 	if err != nil {
 		return objInfo, azureToObjectError(err, srcBucket, srcObject)
 	}
-	destBlob := a.client.NewContainerURL(destBucket).NewBlobURL(destObject)
 
-	azureMeta, props, err := s3MetaToAzureProperties(ctx, srcInfo.UserDefined)
+	// Encrypted streams are bound to their object and key, copies which
+	// encrypt again write the stream the handlers prepared.
+	srcMetadata := srcProps.NewMetadata()
+	stored := azurePropertiesToS3Meta(srcMetadata, srcProps.NewHTTPHeaders(), srcProps.ContentLength())
+	if ming.CopyRewrites(stored, srcInfo.UserDefined, srcBucket == destBucket && srcObject == destObject) {
+		return a.PutObject(ctx, destBucket, destObject, srcInfo.PutObjReader, minio.ObjectOptions{
+			ServerSideEncryption: dstOpts.ServerSideEncryption,
+			UserDefined:          srcInfo.UserDefined,
+		})
+	}
+
+	copyObject := destObject
+	var acl http.Header
+	userDefined := srcInfo.UserDefined
+	if a.hns {
+		copyObject = path.Join(ming.GatewayMinioSysTmp, "copy", minio.MustGetUUID())
+		acl, userDefined = extractHNSAccessControl(userDefined)
+	}
+	destBlob := a.client.NewContainerURL(destBucket).NewBlobURL(copyObject)
+	if a.hns {
+		defer func() {
+			if err != nil {
+				// Remove the temporary blob, gone once it is renamed.
+				destBlob.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+			}
+		}()
+	}
+
+	azureMeta, props, err := s3MetaToAzureProperties(ctx, userDefined)
 	if err != nil {
 		return objInfo, azureToObjectError(err, srcBucket, srcObject)
 	}
 	props.ContentMD5 = srcProps.ContentMD5()
 	azureMeta["md5sum"] = srcInfo.ETag
-	res, err := destBlob.StartCopyFromURL(ctx, srcBlobURL, azureMeta, azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{})
-	if err != nil {
-		return objInfo, azureToObjectError(err, srcBucket, srcObject)
+	if parts, ok := srcMetadata[azurePartsKey]; ok {
+		azureMeta[azurePartsKey] = parts
 	}
-	// StartCopyFromURL is an asynchronous operation so need to poll for completion,
-	// see https://docs.microsoft.com/en-us/rest/api/storageservices/copy-blob#remarks.
-	copyStatus := res.CopyStatus()
-	for copyStatus != azblob.CopyStatusSuccess {
-		destProps, err := destBlob.GetProperties(ctx, azblob.BlobAccessConditions{})
+
+	if a.hns && srcProps.ContentLength() <= azureCopyFromURLMaxSize {
+		// Copy synchronously, the source has to be authorized
+		// through a SAS for this API.
+		srcBlobURL, err = a.getSASURL(srcBucket, srcObject, azblob.BlobSASPermissions{Read: true})
+		if err != nil {
+			return objInfo, err
+		}
+		_, err = destBlob.ToBlockBlobURL().CopyFromURL(ctx, srcBlobURL, azureMeta, azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{}, nil)
+		if err != nil {
+			return objInfo, azureToObjectError(err, srcBucket, srcObject)
+		}
+	} else {
+		res, err := destBlob.StartCopyFromURL(ctx, srcBlobURL, azureMeta, azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{})
 		if err != nil {
 			return objInfo, azureToObjectError(err, srcBucket, srcObject)
 		}
-		copyStatus = destProps.CopyStatus()
+		// StartCopyFromURL is an asynchronous operation so need to poll for completion,
+		// see https://docs.microsoft.com/en-us/rest/api/storageservices/copy-blob#remarks.
+		copyStatus := res.CopyStatus()
+		for copyStatus != azblob.CopyStatusSuccess {
+			destProps, err := destBlob.GetProperties(ctx, azblob.BlobAccessConditions{})
+			if err != nil {
+				return objInfo, azureToObjectError(err, srcBucket, srcObject)
+			}
+			copyStatus = destProps.CopyStatus()
+			if copyStatus == azblob.CopyStatusFailed || copyStatus == azblob.CopyStatusAborted {
+				return objInfo, fmt.Errorf("copy of %s/%s %s: %s", srcBucket, srcObject, copyStatus, destProps.CopyStatusDescription())
+			}
+		}
 	}
 
 	// Azure will copy metadata from the source object when an empty metadata map is provided.
@@ -982,12 +1220,43 @@ func (a *azureObjects) CopyObject(ctx context.Context, srcBucket, srcObject, des
 		}
 	}
 
+	if a.hns {
+		// Renames do not create the parent directories of their target.
+		if dir := path.Dir(destObject); dir != "." {
+			if err = a.dfsCreateDirectory(ctx, destBucket, dir); err != nil {
+				return objInfo, err
+			}
+		}
+		if err = a.dfsRename(ctx, destBucket, copyObject, destObject); err != nil {
+			return objInfo, err
+		}
+		if err = a.dfsSetAccessControl(ctx, destBucket, destObject, acl); err != nil {
+			return objInfo, err
+		}
+	}
+	if err = a.applyObjectLock(ctx, destBucket, destObject, srcInfo.UserDefined); err != nil {
+		return objInfo, err
+	}
+
 	return a.GetObjectInfo(ctx, destBucket, destObject, dstOpts)
 }
 
 // DeleteObject - Deletes a blob on azure container, uses Azure
 // equivalent `BlobURL.Delete`.
 func (a *azureObjects) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	if a.hns && minio.HasSuffix(object, minio.SlashSeparator) {
+		// Only empty directories are removed, like on the NAS backend.
+		a.hnsDeleteEmptyDirs(ctx, bucket, strings.TrimSuffix(object, minio.SlashSeparator))
+		return minio.ObjectInfo{
+			Bucket: bucket,
+			Name:   object,
+		}, nil
+	}
+
+	if err := a.checkObjectNotLocked(ctx, bucket, object); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
 	blob := a.client.NewContainerURL(bucket).NewBlobURL(object)
 	_, err := blob.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
 	if err != nil {
@@ -996,6 +1265,9 @@ func (a *azureObjects) DeleteObject(ctx context.Context, bucket, object string,
 			return minio.ObjectInfo{}, err
 		}
 	}
+	if a.hns {
+		a.hnsDeleteEmptyDirs(ctx, bucket, path.Dir(object))
+	}
 	return minio.ObjectInfo{
 		Bucket: bucket,
 		Name:   object,
@@ -1040,6 +1312,28 @@ func getAzureMetadataPartPrefix(uploadID, objectName string) string {
 	return fmt.Sprintf(metadataPartNamePrefix, uploadID, sha256.Sum256([]byte(objectName)))
 }
 
+// getMultipartMetadata returns the metadata saved by NewMultipartUpload.
+func (a *azureObjects) getMultipartMetadata(ctx context.Context, bucket, object, uploadID string) (metadata azureMultipartMetadata, err error) {
+	metadataObject := getAzureMetadataObjectName(object, uploadID)
+	blobURL := a.client.NewContainerURL(bucket).NewBlobURL(metadataObject)
+	blob, err := blobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		err = azureToObjectError(err, bucket, object)
+		if _, ok := err.(minio.ObjectNotFound); ok {
+			err = minio.InvalidUploadID{UploadID: uploadID}
+		}
+		return metadata, err
+	}
+
+	metadataReader := blob.Body(azblob.RetryReaderOptions{MaxRetryRequests: azureDownloadRetryAttempts})
+	defer metadataReader.Close()
+	if err = json.NewDecoder(metadataReader).Decode(&metadata); err != nil {
+		logger.LogIf(ctx, err)
+		return metadata, azureToObjectError(err, bucket, metadataObject)
+	}
+	return metadata, nil
+}
+
 func (a *azureObjects) checkUploadIDExists(ctx context.Context, bucketName, objectName, uploadID string) (err error) {
 	blobURL := a.client.NewContainerURL(bucketName).NewBlobURL(
 		getAzureMetadataObjectName(objectName, uploadID))
@@ -1059,6 +1353,10 @@ func (a *azureObjects) checkUploadIDExists(ctx context.Context, bucketName, obje
 
 // NewMultipartUpload - Use Azure equivalent `BlobURL.Upload`.
 func (a *azureObjects) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (uploadID string, err error) {
+	if err = a.checkObjectLockRequest(ctx, bucket, object, opts.UserDefined); err != nil {
+		return "", err
+	}
+
 	uploadID, err = getAzureUploadID()
 	if err != nil {
 		logger.LogIf(ctx, err)
@@ -1081,19 +1379,108 @@ func (a *azureObjects) NewMultipartUpload(ctx context.Context, bucket, object st
 	return uploadID, nil
 }
 
+// CopyObjectPart - Use Azure equivalent `BlobURL.StageBlockFromURL`, the
+// source range is read by Azure through a short-lived SAS URL so the data
+// never passes through the gateway.
 func (a *azureObjects) CopyObjectPart(ctx context.Context, srcBucket, srcObject, dstBucket, dstObject string, uploadID string, partID int,
 	startOffset int64, length int64, srcInfo minio.ObjectInfo, srcOpts, dstOpts minio.ObjectOptions) (info minio.PartInfo, err error) {
-	return a.PutObjectPart(ctx, dstBucket, dstObject, uploadID, partID, srcInfo.PutObjReader, dstOpts)
+	if err = checkAzureUploadID(ctx, uploadID); err != nil {
+		return info, err
+	}
+
+	metadata, err := a.getMultipartMetadata(ctx, dstBucket, dstObject, uploadID)
+	if err != nil {
+		return info, err
+	}
+	_, srcEncrypted := crypto.IsEncrypted(srcInfo.UserDefined)
+	_, dstEncrypted := crypto.IsEncrypted(metadata.Metadata)
+	if srcEncrypted || dstEncrypted {
+		// The handlers decrypt and encrypt the data again, it cannot be
+		// copied by Azure, copy it through the gateway instead.
+		return a.PutObjectPart(ctx, dstBucket, dstObject, uploadID, partID, srcInfo.PutObjReader, dstOpts)
+	}
+
+	srcURL, err := a.getSASURL(srcBucket, srcObject, azblob.BlobSASPermissions{Read: true})
+	if err != nil {
+		return info, azureToObjectError(err, srcBucket, srcObject)
+	}
+
+	// Every block is read from the source version the handlers checked
+	// the copy conditions against.
+	srcCond := azblob.ModifiedAccessConditions{}
+	if srcInfo.InnerETag != "" {
+		srcCond.IfMatch = azblob.ETag(srcInfo.InnerETag)
+	}
+
+	partMetaV1 := newPartMetaV1(uploadID, partID)
+	blobURL := a.client.NewContainerURL(dstBucket).NewBlockBlobURL(dstObject)
+	subPartSize := int64(azureUploadChunkSize)
+	for offset := startOffset; offset < startOffset+length; offset += subPartSize {
+		if remainingSize := startOffset + length - offset; remainingSize < subPartSize {
+			subPartSize = remainingSize
+		}
+
+		id := base64.StdEncoding.EncodeToString([]byte(minio.MustGetUUID()))
+		_, err = blobURL.StageBlockFromURL(ctx, id, srcURL, offset, subPartSize,
+			azblob.LeaseAccessConditions{}, srcCond)
+		if err != nil {
+			return info, azureToObjectError(err, srcBucket, srcObject)
+		}
+		partMetaV1.BlockIDs = append(partMetaV1.BlockIDs, id)
+	}
+
+	partMetaV1.ETag = azureCopyPartETag(srcInfo, startOffset, length)
+	partMetaV1.Size = length
+	if err = a.putPartMetadata(ctx, dstBucket, dstObject, uploadID, partID, partMetaV1); err != nil {
+		return info, err
+	}
+
+	info.PartNumber = partID
+	info.ETag = partMetaV1.ETag
+	info.LastModified = minio.UTCNow()
+	info.Size = length
+	return info, nil
+}
+
+// azureCopyPartETag returns the ETag of a part copied on the server side.
+// The MD5 of the copied range is not known to the gateway, it is the ETag
+// of the source when the whole single part object is copied, otherwise a
+// value which is unique to the source version and the copied range.
+func azureCopyPartETag(srcInfo minio.ObjectInfo, startOffset, length int64) string {
+	etag := strings.Trim(srcInfo.ETag, "\"")
+	if startOffset == 0 && length == srcInfo.Size && len(etag) == 32 && !strings.Contains(etag, "-") {
+		return etag
+	}
+	sum := md5.Sum([]byte(fmt.Sprintf("%s:%d:%d", etag, startOffset, length)))
+	return hex.EncodeToString(sum[:])
+}
+
+// putPartMetadata maintains per part md5sum and block IDs in a temporary
+// part metadata file until the upload is finalized.
+func (a *azureObjects) putPartMetadata(ctx context.Context, bucket, object, uploadID string, partID int, partMetaV1 *partMetadataV1) error {
+	metadataObject := getAzureMetadataPartName(object, uploadID, partID)
+	jsonData, err := json.Marshal(partMetaV1)
+	if err != nil {
+		logger.LogIf(ctx, err)
+		return err
+	}
+
+	blobURL := a.client.NewContainerURL(bucket).NewBlockBlobURL(metadataObject)
+	_, err = blobURL.Upload(ctx, bytes.NewReader(jsonData), azblob.BlobHTTPHeaders{}, azblob.Metadata{}, azblob.BlobAccessConditions{})
+	if err != nil {
+		return azureToObjectError(err, bucket, metadataObject)
+	}
+	return nil
 }
 
 // PutObjectPart - Use Azure equivalent `BlobURL.StageBlock`.
 func (a *azureObjects) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, r *minio.PutObjReader, opts minio.ObjectOptions) (info minio.PartInfo, err error) {
 	data := r.Reader
-	if err = a.checkUploadIDExists(ctx, bucket, object, uploadID); err != nil {
+	if err = checkAzureUploadID(ctx, uploadID); err != nil {
 		return info, err
 	}
 
-	if err = checkAzureUploadID(ctx, uploadID); err != nil {
+	if err = a.checkUploadIDExists(ctx, bucket, object, uploadID); err != nil {
 		return info, err
 	}
 
@@ -1120,22 +1507,10 @@ func (a *azureObjects) PutObjectPart(ctx context.Context, bucket, object, upload
 
 	partMetaV1.ETag = r.MD5CurrentHexString()
 	partMetaV1.Size = data.Size()
-
-	// maintain per part md5sum in a temporary part metadata file until upload
-	// is finalized.
-	metadataObject := getAzureMetadataPartName(object, uploadID, partID)
-	var jsonData []byte
-	if jsonData, err = json.Marshal(partMetaV1); err != nil {
-		logger.LogIf(ctx, err)
+	if err = a.putPartMetadata(ctx, bucket, object, uploadID, partID, partMetaV1); err != nil {
 		return info, err
 	}
 
-	blobURL := a.client.NewContainerURL(bucket).NewBlockBlobURL(metadataObject)
-	_, err = blobURL.Upload(ctx, bytes.NewReader(jsonData), azblob.BlobHTTPHeaders{}, azblob.Metadata{}, azblob.BlobAccessConditions{})
-	if err != nil {
-		return info, azureToObjectError(err, bucket, metadataObject)
-	}
-
 	info.PartNumber = partID
 	info.ETag = partMetaV1.ETag
 	info.LastModified = minio.UTCNow()
@@ -1283,30 +1658,20 @@ func (a *azureObjects) AbortMultipartUpload(ctx context.Context, bucket, object,
 // CompleteMultipartUpload - Use Azure equivalent `BlobURL.CommitBlockList`.
 func (a *azureObjects) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
 	metadataObject := getAzureMetadataObjectName(object, uploadID)
-	if err = a.checkUploadIDExists(ctx, bucket, object, uploadID); err != nil {
-		return objInfo, err
-	}
-
 	if err = checkAzureUploadID(ctx, uploadID); err != nil {
 		return objInfo, err
 	}
 
-	blobURL := a.client.NewContainerURL(bucket).NewBlobURL(metadataObject)
-	blob, err := blobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false)
+	metadata, err := a.getMultipartMetadata(ctx, bucket, object, uploadID)
 	if err != nil {
-		return objInfo, azureToObjectError(err, bucket, metadataObject)
-	}
-
-	var metadata azureMultipartMetadata
-	metadataReader := blob.Body(azblob.RetryReaderOptions{MaxRetryRequests: azureDownloadRetryAttempts})
-	if err = json.NewDecoder(metadataReader).Decode(&metadata); err != nil {
-		logger.LogIf(ctx, err)
-		return objInfo, azureToObjectError(err, bucket, metadataObject)
+		return objInfo, err
 	}
+	blobURL := a.client.NewContainerURL(bucket).NewBlobURL(metadataObject)
 
 	objBlob := a.client.NewContainerURL(bucket).NewBlockBlobURL(object)
 
 	var allBlocks []string
+	var parts []minio.ObjectPartInfo
 	for i, part := range uploadedParts {
 		var partMetadata partMetadataV1
 		partMetadataObject := getAzureMetadataPartName(object, uploadID, part.PartNumber)
@@ -1326,6 +1691,7 @@ func (a *azureObjects) CompleteMultipartUpload(ctx context.Context, bucket, obje
 			return objInfo, minio.InvalidPart{}
 		}
 		allBlocks = append(allBlocks, partMetadata.BlockIDs...)
+		parts = append(parts, minio.ObjectPartInfo{Number: part.PartNumber, Size: partMetadata.Size})
 		if i < (len(uploadedParts)-1) && partMetadata.Size < azureS3MinPartSize {
 			return objInfo, minio.PartTooSmall{
 				PartNumber: uploadedParts[i].PartNumber,
@@ -1335,11 +1701,23 @@ func (a *azureObjects) CompleteMultipartUpload(ctx context.Context, bucket, obje
 		}
 	}
 
+	var acl http.Header
+	if a.hns {
+		acl, metadata.Metadata = extractHNSAccessControl(metadata.Metadata)
+	}
+
 	objMetadata, objProperties, err := s3MetaToAzureProperties(ctx, metadata.Metadata)
 	if err != nil {
 		return objInfo, azureToObjectError(err, bucket, object)
 	}
 	objMetadata["md5sum"] = minio.ComputeCompleteMultipartMD5(uploadedParts)
+	if _, ok := crypto.IsEncrypted(metadata.Metadata); ok {
+		objMetadata[azurePartsKey] = ming.EncodePartLayout(parts)
+	}
+
+	if err = a.checkObjectNotLocked(ctx, bucket, object); err != nil {
+		return objInfo, err
+	}
 
 	_, err = objBlob.CommitBlockList(ctx, allBlocks, objProperties, objMetadata, azblob.BlobAccessConditions{})
 	if err != nil {
@@ -1366,92 +1744,23 @@ func (a *azureObjects) CompleteMultipartUpload(ctx context.Context, bucket, obje
 	logger.GetReqInfo(ctx).AppendTags("uploadID", uploadID)
 	logger.LogIf(ctx, derr)
 
-	return a.GetObjectInfo(ctx, bucket, object, minio.ObjectOptions{})
-}
-
-// SetBucketPolicy - Azure supports three types of container policies:
-// azblob.PublicAccessContainer - readonly in minio terminology
-// azblob.PublicAccessBlob - readonly without listing in minio terminology
-// azblob.PublicAccessNone - none in minio terminology
-// As the common denominator for minio and azure is readonly and none, we support
-// these two policies at the bucket level.
-func (a *azureObjects) SetBucketPolicy(ctx context.Context, bucket string, bucketPolicy *policy.Policy) error {
-	policyInfo, err := minio.PolicyToBucketAccessPolicy(bucketPolicy)
-	if err != nil {
-		// This should not happen.
-		logger.LogIf(ctx, err)
-		return azureToObjectError(err, bucket)
-	}
-
-	var policies []minio.BucketAccessPolicy
-	for prefix, policy := range miniogopolicy.GetPolicies(policyInfo.Statements, bucket, "") {
-		policies = append(policies, minio.BucketAccessPolicy{
-			Prefix: prefix,
-			Policy: policy,
-		})
-	}
-	prefix := bucket + "/*" // For all objects inside the bucket.
-	if len(policies) != 1 {
-		return minio.NotImplemented{}
-	}
-	if policies[0].Prefix != prefix {
-		return minio.NotImplemented{}
+	if err = a.dfsSetAccessControl(ctx, bucket, object, acl); err != nil {
+		return objInfo, err
 	}
-	if policies[0].Policy != miniogopolicy.BucketPolicyReadOnly {
-		return minio.NotImplemented{}
+	if err = a.applyObjectLock(ctx, bucket, object, metadata.Metadata); err != nil {
+		return objInfo, err
 	}
-	perm := azblob.PublicAccessContainer
-	container := a.client.NewContainerURL(bucket)
-	_, err = container.SetAccessPolicy(ctx, perm, nil, azblob.ContainerAccessConditions{})
-	return azureToObjectError(err, bucket)
-}
 
-// GetBucketPolicy - Get the container ACL and convert it to canonical []bucketAccessPolicy
-func (a *azureObjects) GetBucketPolicy(ctx context.Context, bucket string) (*policy.Policy, error) {
-	container := a.client.NewContainerURL(bucket)
-	perm, err := container.GetAccessPolicy(ctx, azblob.LeaseAccessConditions{})
-	if err != nil {
-		return nil, azureToObjectError(err, bucket)
-	}
-
-	permAccessType := perm.BlobPublicAccess()
-
-	if permAccessType == azblob.PublicAccessNone {
-		return nil, minio.BucketPolicyNotFound{Bucket: bucket}
-	} else if permAccessType != azblob.PublicAccessContainer {
-		return nil, azureToObjectError(minio.NotImplemented{})
-	}
-
-	return &policy.Policy{
-		Version: policy.DefaultVersion,
-		Statements: []policy.Statement{
-			policy.NewStatement(
-				policy.Allow,
-				policy.NewPrincipal("*"),
-				policy.NewActionSet(
-					policy.GetBucketLocationAction,
-					policy.ListBucketAction,
-					policy.GetObjectAction,
-				),
-				policy.NewResourceSet(
-					policy.NewResource(bucket, ""),
-					policy.NewResource(bucket, "*"),
-				),
-				condition.NewFunctions(),
-			),
-		},
-	}, nil
-}
-
-// DeleteBucketPolicy - Set the container ACL to "private"
-func (a *azureObjects) DeleteBucketPolicy(ctx context.Context, bucket string) error {
-	perm := azblob.PublicAccessNone
-	containerURL := a.client.NewContainerURL(bucket)
-	_, err := containerURL.SetAccessPolicy(ctx, perm, nil, azblob.ContainerAccessConditions{})
-	return azureToObjectError(err)
+	return a.GetObjectInfo(ctx, bucket, object, minio.ObjectOptions{})
 }
 
 // IsCompressionSupported returns whether compression is applicable for this layer.
 func (a *azureObjects) IsCompressionSupported() bool {
 	return false
 }
+
+// IsEncryptionSupported returns whether server side encryption is implemented for this layer,
+// the data is encrypted by the gateway, see gateway-azure-encryption.go.
+func (a *azureObjects) IsEncryptionSupported() bool {
+	return minio.GlobalKMS != nil || minio.GlobalGatewaySSE.IsSet()
+}
@@ -24,7 +24,9 @@ import (
 	"testing"
 
 	"github.com/Azure/azure-storage-blob-go/azblob"
+	ming "github.com/minio/ming/cmd"
 	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/crypto"
 )
 
 func TestParseStorageEndpoint(t *testing.T) {
@@ -100,6 +102,14 @@ func TestS3MetaToAzureProperties(t *testing.T) {
 		}
 	}
 
+	// User metadata may not shadow the internal metadata.
+	for _, k := range []string{"X-Amz-Meta-X-Minio-Internal", "X-Amz-Meta-x-minio-internal-parts"} {
+		_, _, err = s3MetaToAzureProperties(minio.GlobalContext, map[string]string{k: "value"})
+		if _, ok := err.(minio.UnsupportedMetadata); !ok {
+			t.Fatalf("Test failed with unexpected error %v for %s, expected UnsupportedMetadata", err, k)
+		}
+	}
+
 	headers = map[string]string{
 		"content-md5": "Dce7bmCX61zvxzP5QmfelQ==",
 	}
@@ -206,6 +216,22 @@ func TestAzureCodesToObjectError(t *testing.T) {
 			nil, "InvalidMetadata", 0,
 			minio.UnsupportedMetadata{}, "", "",
 		},
+		{
+			nil, "BlobUsesCustomerSpecifiedEncryption", http.StatusConflict,
+			crypto.ErrMissingCustomerKey, "bucket", "object",
+		},
+		{
+			nil, "BlobImmutableDueToPolicy", http.StatusConflict,
+			ming.ObjectLocked("bucket", "object"), "bucket", "object",
+		},
+		{
+			nil, "BlobImmutableDueToLegalHold", http.StatusConflict,
+			ming.ObjectLocked("bucket", "object"), "bucket", "object",
+		},
+		{
+			nil, "SourceConditionNotMet", http.StatusPreconditionFailed,
+			minio.PreConditionFailed{}, "bucket", "object",
+		},
 		{
 			nil, "", http.StatusNotFound,
 			minio.ObjectNotFound{
@@ -264,3 +290,25 @@ func TestCheckAzureUploadID(t *testing.T) {
 		}
 	}
 }
+
+func TestAzureCopyPartETag(t *testing.T) {
+	srcInfo := minio.ObjectInfo{ETag: "\"5d41402abc4b2a76b9719d911017c592\"", Size: 10}
+
+	// Copying the whole single part object keeps the MD5 of the source.
+	if etag := azureCopyPartETag(srcInfo, 0, 10); etag != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("Expected the source ETag, got %s", etag)
+	}
+
+	partial := azureCopyPartETag(srcInfo, 0, 5)
+	if len(partial) != 32 || partial == "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("Unexpected ETag %s for a range", partial)
+	}
+	if etag := azureCopyPartETag(srcInfo, 5, 5); etag == partial {
+		t.Errorf("Expected different ETags for different ranges, got %s", etag)
+	}
+
+	multipart := minio.ObjectInfo{ETag: "5d41402abc4b2a76b9719d911017c592-2", Size: 10}
+	if etag := azureCopyPartETag(multipart, 0, 10); len(etag) != 32 || etag == multipart.ETag {
+		t.Errorf("Unexpected ETag %s for a multipart source", etag)
+	}
+}
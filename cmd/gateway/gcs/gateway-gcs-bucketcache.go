@@ -0,0 +1,150 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+)
+
+// Default time bucket attributes are cached for.
+const gcsBucketCacheTTL = time.Minute
+
+// gcsBucketCache caches the attributes of existing buckets, which object
+// calls need to tell a missing bucket from a missing object and to report
+// the bucket retention policy, and whether they have object retention
+// enabled, which the storage client does not report. Entries expire after
+// the TTL and are dropped when the gateway changes the bucket.
+type gcsBucketCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]gcsBucketCacheEntry
+	retention map[string]gcsRetentionCacheEntry
+}
+
+type gcsBucketCacheEntry struct {
+	attrs   *storage.BucketAttrs
+	expires time.Time
+}
+
+type gcsRetentionCacheEntry struct {
+	enabled bool
+	expires time.Time
+}
+
+func newGCSBucketCache(ttl time.Duration) *gcsBucketCache {
+	return &gcsBucketCache{
+		ttl:       ttl,
+		entries:   make(map[string]gcsBucketCacheEntry),
+		retention: make(map[string]gcsRetentionCacheEntry),
+	}
+}
+
+// get returns the cached attributes of the bucket, nil if they are not
+// cached or expired.
+func (c *gcsBucketCache) get(bucket string) *storage.BucketAttrs {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[bucket]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, bucket)
+		return nil
+	}
+	return entry.attrs
+}
+
+// set caches the attributes of the bucket.
+func (c *gcsBucketCache) set(bucket string, attrs *storage.BucketAttrs) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[bucket] = gcsBucketCacheEntry{attrs: attrs, expires: time.Now().Add(c.ttl)}
+}
+
+// getRetention returns whether the bucket has object retention enabled,
+// ok is false if it is not cached or expired.
+func (c *gcsBucketCache) getRetention(bucket string) (enabled, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.retention[bucket]
+	if !ok {
+		return false, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.retention, bucket)
+		return false, false
+	}
+	return entry.enabled, true
+}
+
+// setRetention caches whether the bucket has object retention enabled.
+func (c *gcsBucketCache) setRetention(bucket string, enabled bool) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retention[bucket] = gcsRetentionCacheEntry{enabled: enabled, expires: time.Now().Add(c.ttl)}
+}
+
+// invalidate drops the cached attributes of the bucket.
+func (c *gcsBucketCache) invalidate(bucket string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, bucket)
+	delete(c.retention, bucket)
+}
+
+// bucketAttrs returns the attributes of the bucket, from the cache when
+// they are recent enough.
+func (l *gcsGateway) bucketAttrs(ctx context.Context, bucket string) (*storage.BucketAttrs, error) {
+	if attrs := l.buckets.get(bucket); attrs != nil {
+		return attrs, nil
+	}
+	attrs, err := l.client.Bucket(bucket).Attrs(ctx)
+	if err != nil {
+		logger.LogIf(ctx, err, logger.Application)
+		return nil, gcsToObjectError(err, bucket)
+	}
+	l.buckets.set(bucket, attrs)
+	return attrs, nil
+}
+
+// gcsToObjectBucketError converts the error of a call on a version of an
+// object. GCS reports missing buckets as missing objects, the bucket is
+// only looked up when the object is not found.
+func (l *gcsGateway) gcsToObjectBucketError(ctx context.Context, err error, bucket, object, versionID string) error {
+	err = gcsToObjectVersionError(err, bucket, object, versionID)
+	switch err.(type) {
+	case minio.ObjectNotFound, minio.VersionNotFound:
+		if _, bErr := l.bucketAttrs(ctx, bucket); bErr != nil {
+			return bErr
+		}
+	}
+	return err
+}
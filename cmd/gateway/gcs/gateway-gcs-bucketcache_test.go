@@ -0,0 +1,77 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestGCSBucketCache(t *testing.T) {
+	attrs := &storage.BucketAttrs{Name: "bucket"}
+
+	c := newGCSBucketCache(time.Minute)
+	if c.get("bucket") != nil {
+		t.Fatal("Expected an empty cache")
+	}
+	c.set("bucket", attrs)
+	if c.get("bucket") != attrs {
+		t.Fatal("Expected cached bucket attributes")
+	}
+	c.invalidate("bucket")
+	if c.get("bucket") != nil {
+		t.Fatal("Expected invalidated bucket attributes")
+	}
+
+	// Expired entries are dropped.
+	c.set("bucket", attrs)
+	c.entries["bucket"] = gcsBucketCacheEntry{attrs: attrs, expires: time.Now().Add(-time.Second)}
+	if c.get("bucket") != nil || len(c.entries) != 0 {
+		t.Fatal("Expected expired bucket attributes to be dropped")
+	}
+
+	// Object retention is cached and dropped along with the attributes,
+	// both when enabled and when not.
+	for _, enabled := range []bool{false, true} {
+		c.setRetention("bucket", enabled)
+		if got, ok := c.getRetention("bucket"); !ok || got != enabled {
+			t.Fatalf("Expected cached object retention %v, got %v, %v", enabled, got, ok)
+		}
+		c.retention["bucket"] = gcsRetentionCacheEntry{enabled: enabled, expires: time.Now().Add(-time.Second)}
+		if _, ok := c.getRetention("bucket"); ok || len(c.retention) != 0 {
+			t.Fatal("Expected expired object retention to be dropped")
+		}
+	}
+	c.setRetention("bucket", true)
+	c.invalidate("bucket")
+	if _, ok := c.getRetention("bucket"); ok {
+		t.Fatal("Expected invalidated object retention")
+	}
+
+	// A zero TTL disables the cache.
+	c = newGCSBucketCache(0)
+	c.set("bucket", attrs)
+	c.setRetention("bucket", true)
+	if c.get("bucket") != nil {
+		t.Fatal("Expected no caching with a zero TTL")
+	}
+	if _, ok := c.getRetention("bucket"); ok {
+		t.Fatal("Expected no caching of object retention with a zero TTL")
+	}
+}
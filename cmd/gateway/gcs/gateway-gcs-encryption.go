@@ -0,0 +1,32 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+// Objects are encrypted by the MinIO handlers, see ming.EncodePartLayout.
+// The internal metadata of the handlers is kept with the custom metadata
+// of the object and the layout of multipart objects in gcsPartsMetaKey.
+// Copies which encrypt again are streamed through the gateway.
+// Customer-supplied and Cloud KMS keys are never set by the gateway, the
+// handlers do not hand the keys of a request to the object layer.
+
+const (
+	// Metadata holding the part layout of encrypted multipart objects.
+	gcsPartsMetaKey = "minioparts"
+
+	// Maximum number of parts of a multipart upload.
+	gcsMaxPartCount = 10000
+)
@@ -0,0 +1,56 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/crypto"
+)
+
+// The internal metadata of objects encrypted by the gateway, and the part
+// sizes of encrypted multipart objects, must survive a round trip through
+// the GCS object attributes.
+func TestGCSEncryptedMetadata(t *testing.T) {
+	l := &gcsGateway{storageClasses: newGCSStorageClassTable(nil)}
+	metadata := map[string]string{
+		"X-Amz-Meta-A":               "b",
+		crypto.MetaSealedKeySSEC:     "sealed",
+		crypto.MetaIV:                "iv",
+		crypto.MetaAlgorithm:         crypto.SealAlgorithm,
+		crypto.MetaMultipart:         "",
+		"X-Minio-Internal-Unrelated": "c",
+	}
+	var attrs storage.ObjectAttrs
+	l.applyMetadataToGCSAttrs(metadata, &attrs)
+	attrs.Metadata[gcsPartsMetaKey] = "1:2:16:0,3:1:8:0"
+
+	objInfo := l.fromGCSAttrsToObjectInfo(&attrs)
+	if !reflect.DeepEqual(objInfo.UserDefined, metadata) {
+		t.Errorf("Expected %v, got %v", metadata, objInfo.UserDefined)
+	}
+	if _, ok := crypto.IsEncrypted(objInfo.UserDefined); !ok {
+		t.Errorf("Expected the object to be encrypted")
+	}
+	expected := []minio.ObjectPartInfo{{Number: 1, Size: 16}, {Number: 2, Size: 16}, {Number: 3, Size: 8}}
+	if !reflect.DeepEqual(objInfo.Parts, expected) {
+		t.Errorf("Expected parts %v, got %v", expected, objInfo.Parts)
+	}
+}
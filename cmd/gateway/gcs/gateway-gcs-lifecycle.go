@@ -0,0 +1,284 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/gorilla/mux"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/bucket/lifecycle"
+)
+
+// S3 lifecycle rules translate into GCS lifecycle rules, one per action:
+// expirations delete live objects, transitions set the storage class of
+// live objects, and their noncurrent version variants act on noncurrent
+// generations. GCS counts the age of noncurrent generations from their
+// creation rather than from when they became noncurrent.
+//
+// GCS rules can neither be disabled nor filtered by prefix or tags, rules
+// with a filter are not supported and disabled rules are dropped. GCS has
+// no delete markers to expire nor incomplete multipart uploads to abort,
+// rules with those actions are not supported either.
+//
+// The MinIO server does not forward lifecycle requests to gateways, the
+// rules are managed through the admin API of the gateway instead.
+
+// parseGCSLifecycle parses and validates the S3 lifecycle configuration.
+// AbortIncompleteMultipartUpload actions, which the lifecycle package does
+// not decode, are rejected.
+func parseGCSLifecycle(data []byte) (*lifecycle.Lifecycle, error) {
+	var actions struct {
+		Rules []struct {
+			AbortIncompleteMultipartUpload *struct{} `xml:"AbortIncompleteMultipartUpload"`
+		} `xml:"Rule"`
+	}
+	if err := xml.Unmarshal(data, &actions); err != nil {
+		return nil, err
+	}
+	for _, rule := range actions.Rules {
+		if rule.AbortIncompleteMultipartUpload != nil {
+			return nil, minio.NotImplemented{}
+		}
+	}
+	lc, err := lifecycle.ParseLifecycleConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err = lc.Validate(); err != nil {
+		return nil, err
+	}
+	return lc, nil
+}
+
+// s3ToGCSLifecycle translates the S3 lifecycle configuration into GCS
+// lifecycle rules.
+func (l *gcsGateway) s3ToGCSLifecycle(lc *lifecycle.Lifecycle) (storage.Lifecycle, error) {
+	var gcsLifecycle storage.Lifecycle
+	for _, rule := range lc.Rules {
+		if rule.Status != lifecycle.Enabled {
+			continue
+		}
+		if rule.GetPrefix() != "" || rule.Tags() != "" ||
+			rule.Expiration.DeleteMarker != (lifecycle.ExpireDeleteMarker{}) {
+			return gcsLifecycle, minio.NotImplemented{}
+		}
+
+		add := func(action storage.LifecycleAction, liveness storage.Liveness, days int, date time.Time) {
+			gcsLifecycle.Rules = append(gcsLifecycle.Rules, storage.LifecycleRule{
+				Action: action,
+				Condition: storage.LifecycleCondition{
+					AgeInDays:     int64(days),
+					CreatedBefore: date,
+					Liveness:      liveness,
+				},
+			})
+		}
+		deleteAction := storage.LifecycleAction{Type: storage.DeleteAction}
+		if days, date := int(rule.Expiration.Days), rule.Expiration.Date.Time; days > 0 || !date.IsZero() {
+			add(deleteAction, storage.Live, days, date)
+		}
+		if days, date := int(rule.Transition.Days), rule.Transition.Date.Time; days > 0 || !date.IsZero() {
+			action := storage.LifecycleAction{Type: storage.SetStorageClassAction, StorageClass: l.storageClasses.GCS(rule.Transition.StorageClass)}
+			add(action, storage.Live, days, date)
+		}
+		if days := int(rule.NoncurrentVersionExpiration.NoncurrentDays); days > 0 {
+			add(deleteAction, storage.Archived, days, time.Time{})
+		}
+		if days := int(rule.NoncurrentVersionTransition.NoncurrentDays); days > 0 {
+			action := storage.LifecycleAction{Type: storage.SetStorageClassAction, StorageClass: l.storageClasses.GCS(rule.NoncurrentVersionTransition.StorageClass)}
+			add(action, storage.Archived, days, time.Time{})
+		}
+	}
+	for _, rule := range gcsLifecycle.Rules {
+		if rule.Action.Type == storage.SetStorageClassAction && rule.Action.StorageClass == "" {
+			// Transitions to storage classes without a GCS class.
+			return gcsLifecycle, minio.NotImplemented{}
+		}
+	}
+	return gcsLifecycle, nil
+}
+
+// gcsLifecycleDays is an action of a lifecycle rule in the S3 XML format.
+type gcsLifecycleDays struct {
+	Days           int64  `xml:"Days,omitempty" json:"days,omitempty"`
+	NoncurrentDays int64  `xml:"NoncurrentDays,omitempty" json:"noncurrentDays,omitempty"`
+	Date           string `xml:"Date,omitempty" json:"date,omitempty"`
+	StorageClass   string `xml:"StorageClass,omitempty" json:"storageClass,omitempty"`
+}
+
+// gcsLifecycleRule is a lifecycle rule in the S3 XML format.
+type gcsLifecycleRule struct {
+	Status                      string            `xml:"Status" json:"status"`
+	Prefix                      string            `xml:"Filter>Prefix" json:"-"`
+	Expiration                  *gcsLifecycleDays `xml:"Expiration,omitempty" json:"expiration,omitempty"`
+	Transition                  *gcsLifecycleDays `xml:"Transition,omitempty" json:"transition,omitempty"`
+	NoncurrentVersionExpiration *gcsLifecycleDays `xml:"NoncurrentVersionExpiration,omitempty" json:"noncurrentVersionExpiration,omitempty"`
+	NoncurrentVersionTransition *gcsLifecycleDays `xml:"NoncurrentVersionTransition,omitempty" json:"noncurrentVersionTransition,omitempty"`
+}
+
+// gcsToS3LifecycleRules translates the GCS lifecycle rules into S3
+// lifecycle rules, rules with conditions S3 cannot express are left out.
+func (l *gcsGateway) gcsToS3LifecycleRules(gcsLifecycle storage.Lifecycle) []gcsLifecycleRule {
+	var rules []gcsLifecycleRule
+	for _, gcsRule := range gcsLifecycle.Rules {
+		cond := gcsRule.Condition
+		if len(cond.MatchesStorageClasses) > 0 || cond.NumNewerVersions > 0 ||
+			(cond.AgeInDays > 0) == !cond.CreatedBefore.IsZero() {
+			continue
+		}
+
+		current := &gcsLifecycleDays{Days: cond.AgeInDays}
+		if !cond.CreatedBefore.IsZero() {
+			current.Date = cond.CreatedBefore.UTC().Format(time.RFC3339)
+		}
+		noncurrent := &gcsLifecycleDays{NoncurrentDays: cond.AgeInDays}
+
+		rule := gcsLifecycleRule{Status: string(lifecycle.Enabled)}
+		switch gcsRule.Action.Type {
+		case storage.DeleteAction:
+			if cond.Liveness != storage.Archived {
+				rule.Expiration = current
+			}
+			if cond.Liveness != storage.Live && cond.AgeInDays > 0 {
+				rule.NoncurrentVersionExpiration = noncurrent
+			}
+		case storage.SetStorageClassAction:
+			current.StorageClass = l.storageClasses.S3(gcsRule.Action.StorageClass)
+			noncurrent.StorageClass = current.StorageClass
+			if cond.Liveness != storage.Archived {
+				rule.Transition = current
+			}
+			if cond.Liveness != storage.Live && cond.AgeInDays > 0 {
+				rule.NoncurrentVersionTransition = noncurrent
+			}
+		}
+		if rule.Expiration == nil && rule.Transition == nil &&
+			rule.NoncurrentVersionExpiration == nil && rule.NoncurrentVersionTransition == nil {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// gcsToS3Lifecycle translates the GCS lifecycle rules into an S3
+// lifecycle configuration, nil without rules S3 can express.
+func (l *gcsGateway) gcsToS3Lifecycle(gcsLifecycle storage.Lifecycle) (*lifecycle.Lifecycle, error) {
+	var config struct {
+		XMLName xml.Name           `xml:"LifecycleConfiguration"`
+		Rules   []gcsLifecycleRule `xml:"Rule"`
+	}
+	if config.Rules = l.gcsToS3LifecycleRules(gcsLifecycle); len(config.Rules) == 0 {
+		return nil, nil
+	}
+
+	data, err := xml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return lifecycle.ParseLifecycleConfig(bytes.NewReader(data))
+}
+
+// setBucketLifecycle replaces the lifecycle rules of the bucket.
+func (l *gcsGateway) setBucketLifecycle(ctx context.Context, bucket string, lc *lifecycle.Lifecycle) error {
+	gcsLifecycle, err := l.s3ToGCSLifecycle(lc)
+	if err != nil {
+		return err
+	}
+	if len(gcsLifecycle.Rules) == 0 {
+		return l.deleteBucketLifecycle(ctx, bucket)
+	}
+	_, err = l.client.Bucket(bucket).Update(ctx, storage.BucketAttrsToUpdate{Lifecycle: &gcsLifecycle})
+	l.buckets.invalidate(bucket)
+	logger.LogIf(ctx, err)
+	return gcsToObjectError(err, bucket)
+}
+
+// getBucketLifecycleRules returns the lifecycle rules of the bucket.
+func (l *gcsGateway) getBucketLifecycleRules(ctx context.Context, bucket string) ([]gcsLifecycleRule, error) {
+	attrs, err := l.client.Bucket(bucket).Attrs(ctx)
+	if err != nil {
+		logger.LogIf(ctx, err)
+		return nil, gcsToObjectError(err, bucket)
+	}
+	rules := l.gcsToS3LifecycleRules(attrs.Lifecycle)
+	if len(rules) == 0 {
+		return nil, minio.BucketLifecycleNotFound{Bucket: bucket}
+	}
+	return rules, nil
+}
+
+// deleteBucketLifecycle removes all lifecycle rules of the bucket, the
+// storage client cannot clear them so the bucket is patched directly.
+func (l *gcsGateway) deleteBucketLifecycle(ctx context.Context, bucket string) error {
+	err := l.jsonRequest(ctx, http.MethodPatch, "b/"+url.PathEscape(bucket), url.Values{"fields": []string{"lifecycle"}},
+		map[string]interface{}{"lifecycle": nil}, nil)
+	l.buckets.invalidate(bucket)
+	logger.LogIf(ctx, err)
+	return gcsToObjectError(err, bucket)
+}
+
+// gcsLifecycleInfo is the lifecycle configuration returned by the admin API.
+type gcsLifecycleInfo struct {
+	Rules []gcsLifecycleRule `json:"rules"`
+}
+
+// registerLifecycleRouter registers the lifecycle admin APIs, which take
+// the bucket as query parameter:
+//
+//	GET    /lifecycle  returns the lifecycle rules
+//	PUT    /lifecycle  replaces the rules with the S3 lifecycle
+//	                   configuration of the request body
+//	DELETE /lifecycle  removes all lifecycle rules
+func (g *GCS) registerLifecycleRouter(router *mux.Router) {
+	router.Methods(http.MethodGet).Path("/lifecycle").HandlerFunc(g.bucketAdminHandler(
+		func(ctx context.Context, l *gcsGateway, bucket string, r *http.Request) (interface{}, error) {
+			rules, err := l.getBucketLifecycleRules(ctx, bucket)
+			if err != nil {
+				return nil, err
+			}
+			return gcsLifecycleInfo{Rules: rules}, nil
+		}))
+	router.Methods(http.MethodPut).Path("/lifecycle").HandlerFunc(g.bucketAdminHandler(
+		func(ctx context.Context, l *gcsGateway, bucket string, r *http.Request) (interface{}, error) {
+			data, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			lc, err := parseGCSLifecycle(data)
+			if err != nil {
+				if _, ok := err.(minio.NotImplemented); ok {
+					return nil, err
+				}
+				return nil, minio.InvalidArgument{Bucket: bucket, Err: err}
+			}
+			return nil, l.setBucketLifecycle(ctx, bucket, lc)
+		}))
+	router.Methods(http.MethodDelete).Path("/lifecycle").HandlerFunc(g.bucketAdminHandler(
+		func(ctx context.Context, l *gcsGateway, bucket string, r *http.Request) (interface{}, error) {
+			return nil, l.deleteBucketLifecycle(ctx, bucket)
+		}))
+}
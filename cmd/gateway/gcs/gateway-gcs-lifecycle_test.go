@@ -0,0 +1,162 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/minio/minio/pkg/bucket/lifecycle"
+)
+
+func parseTestLifecycle(t *testing.T, s string) *lifecycle.Lifecycle {
+	t.Helper()
+	lc, err := lifecycle.ParseLifecycleConfig(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lc
+}
+
+func TestS3ToGCSLifecycle(t *testing.T) {
+	l := &gcsGateway{storageClasses: newGCSStorageClassTable(nil)}
+	lc := parseTestLifecycle(t, `<LifecycleConfiguration>
+<Rule><Status>Enabled</Status><Filter><Prefix></Prefix></Filter>
+  <Expiration><Days>365</Days></Expiration>
+  <Transition><Days>30</Days><StorageClass>GLACIER</StorageClass></Transition>
+  <NoncurrentVersionExpiration><NoncurrentDays>90</NoncurrentDays></NoncurrentVersionExpiration>
+</Rule>
+<Rule><Status>Enabled</Status><Filter><Prefix></Prefix></Filter>
+  <Expiration><Date>2022-01-01T00:00:00Z</Date></Expiration>
+</Rule>
+<Rule><Status>Disabled</Status><Filter><Prefix></Prefix></Filter>
+  <Expiration><Days>1</Days></Expiration>
+</Rule>
+</LifecycleConfiguration>`)
+
+	expected := storage.Lifecycle{Rules: []storage.LifecycleRule{
+		{
+			Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: storage.LifecycleCondition{AgeInDays: 365, Liveness: storage.Live},
+		},
+		{
+			Action:    storage.LifecycleAction{Type: storage.SetStorageClassAction, StorageClass: "COLDLINE"},
+			Condition: storage.LifecycleCondition{AgeInDays: 30, Liveness: storage.Live},
+		},
+		{
+			Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: storage.LifecycleCondition{AgeInDays: 90, Liveness: storage.Archived},
+		},
+		{
+			Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: storage.LifecycleCondition{CreatedBefore: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), Liveness: storage.Live},
+		},
+	}}
+	gcsLifecycle, err := l.s3ToGCSLifecycle(lc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gcsLifecycle, expected) {
+		t.Fatalf("Expected %#v, got %#v", expected, gcsLifecycle)
+	}
+
+	// The rules read back as equivalent S3 rules.
+	lc, err = l.gcsToS3Lifecycle(gcsLifecycle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lc.Rules) != 4 {
+		t.Fatalf("Expected 4 rules, got %d", len(lc.Rules))
+	}
+	if got, err := l.s3ToGCSLifecycle(lc); err != nil || !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %#v, got %#v, %v", expected, got, err)
+	}
+
+	// GCS rules cannot be filtered.
+	lc = parseTestLifecycle(t, `<LifecycleConfiguration><Rule><Status>Enabled</Status>
+<Filter><Prefix>logs/</Prefix></Filter><Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`)
+	if _, err = l.s3ToGCSLifecycle(lc); err == nil {
+		t.Errorf("Expected prefix filter to be rejected")
+	}
+
+	// GCS has no delete markers to expire.
+	lc = parseTestLifecycle(t, `<LifecycleConfiguration><Rule><Status>Enabled</Status>
+<Filter><Prefix></Prefix></Filter><Expiration><ExpiredObjectDeleteMarker>true</ExpiredObjectDeleteMarker></Expiration></Rule></LifecycleConfiguration>`)
+	if _, err = l.s3ToGCSLifecycle(lc); err == nil {
+		t.Errorf("Expected delete marker expiration to be rejected")
+	}
+}
+
+func TestParseGCSLifecycle(t *testing.T) {
+	testCases := []struct {
+		config string
+		ok     bool
+	}{
+		{`<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter><Prefix></Prefix></Filter>
+<Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`, true},
+		{`<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter><Prefix></Prefix></Filter>
+<AbortIncompleteMultipartUpload><DaysAfterInitiation>7</DaysAfterInitiation></AbortIncompleteMultipartUpload>
+<Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`, false},
+		{`<LifecycleConfiguration></LifecycleConfiguration>`, false},
+		{`<LifecycleConfiguration><Rule><Status>Enabled</Status>`, false},
+	}
+	for i, testCase := range testCases {
+		_, err := parseGCSLifecycle([]byte(testCase.config))
+		if testCase.ok != (err == nil) {
+			t.Errorf("Test %d: unexpected error %v", i+1, err)
+		}
+	}
+}
+
+func TestGCSToS3Lifecycle(t *testing.T) {
+	l := &gcsGateway{storageClasses: newGCSStorageClassTable(nil)}
+	gcsLifecycle := storage.Lifecycle{Rules: []storage.LifecycleRule{
+		// Applies to live and noncurrent generations.
+		{
+			Action:    storage.LifecycleAction{Type: storage.SetStorageClassAction, StorageClass: "NEARLINE"},
+			Condition: storage.LifecycleCondition{AgeInDays: 30},
+		},
+		// Conditions S3 cannot express.
+		{
+			Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: storage.LifecycleCondition{NumNewerVersions: 3},
+		},
+		{
+			Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: storage.LifecycleCondition{AgeInDays: 30, MatchesStorageClasses: []string{"NEARLINE"}},
+		},
+	}}
+	lc, err := l.gcsToS3Lifecycle(gcsLifecycle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lc.Rules) != 1 {
+		t.Fatalf("Expected 1 rule, got %d", len(lc.Rules))
+	}
+	rule := lc.Rules[0]
+	if rule.Transition.Days != 30 || rule.Transition.StorageClass != "STANDARD_IA" ||
+		rule.NoncurrentVersionTransition.NoncurrentDays != 30 || rule.NoncurrentVersionTransition.StorageClass != "STANDARD_IA" {
+		t.Errorf("Unexpected rule %#v", rule)
+	}
+
+	if lc, err = l.gcsToS3Lifecycle(storage.Lifecycle{}); lc != nil || err != nil {
+		t.Errorf("Expected no lifecycle, got %v, %v", lc, err)
+	}
+}
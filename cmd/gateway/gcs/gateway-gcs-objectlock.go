@@ -0,0 +1,546 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/gorilla/mux"
+	minio "github.com/minio/minio/cmd"
+	xhttp "github.com/minio/minio/cmd/http"
+	"github.com/minio/minio/cmd/logger"
+	objectlock "github.com/minio/minio/pkg/bucket/object/lock"
+	raw "google.golang.org/api/storage/v1"
+)
+
+// S3 Object Lock maps onto the GCS retention features: buckets created
+// with object lock enabled have object retention enabled, the default
+// retention of a bucket is its retention policy, GOVERNANCE being an
+// unlocked and COMPLIANCE a locked policy, retention of an object is its
+// object retention, Unlocked for GOVERNANCE and Locked for COMPLIANCE,
+// and legal holds are temporary or event-based holds.
+//
+// GCS enforces all of them, deletes and overwrites it refuses surface as
+// object locked errors. MinIO server reports object lock as disabled for
+// gateway buckets and rejects the S3 requests setting it, so retention,
+// holds and default retention are set through the admin API of the
+// gateway.
+
+const (
+	gcsRetentionUnlocked = "Unlocked"
+	gcsRetentionLocked   = "Locked"
+
+	// Retention period of a year in the S3 default retention.
+	gcsRetentionYear = 365 * 24 * time.Hour
+	gcsRetentionDay  = 24 * time.Hour
+)
+
+// gcsObjectRetention is the retention of an object in the GCS JSON API.
+type gcsObjectRetention struct {
+	Mode            string `json:"mode,omitempty"`
+	RetainUntilTime string `json:"retainUntilTime,omitempty"`
+}
+
+// parseGCSLegalHold parses the kind of hold used for legal holds, either
+// "temporary" or "event-based", and returns true for event-based holds.
+func parseGCSLegalHold(s string) (eventBased bool, err error) {
+	switch strings.ToLower(s) {
+	case "", "temporary":
+		return false, nil
+	case "event-based":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid legal hold %q, expected temporary or event-based", s)
+}
+
+// s3ToGCSRetentionPolicy translates the default retention of the object
+// lock configuration into a bucket retention policy, a zero retention
+// period removes the policy. locked is true for COMPLIANCE retention.
+func s3ToGCSRetentionPolicy(config *objectlock.Config) (rp storage.RetentionPolicy, locked bool) {
+	if config.Rule == nil {
+		return rp, false
+	}
+	retention := config.Rule.DefaultRetention
+	switch {
+	case retention.Days != nil:
+		rp.RetentionPeriod = time.Duration(*retention.Days) * gcsRetentionDay
+	case retention.Years != nil:
+		rp.RetentionPeriod = time.Duration(*retention.Years) * gcsRetentionYear
+	}
+	return rp, retention.Mode == objectlock.RetCompliance
+}
+
+// gcsToS3ObjectLockConfig translates the bucket retention policy into an
+// object lock configuration, periods which are not whole days are rounded
+// up.
+func gcsToS3ObjectLockConfig(rp *storage.RetentionPolicy) *objectlock.Config {
+	config := objectlock.NewObjectLockConfig()
+	if rp == nil || rp.RetentionPeriod <= 0 {
+		return config
+	}
+	config.Rule = &struct {
+		DefaultRetention objectlock.DefaultRetention `xml:"DefaultRetention"`
+	}{}
+	retention := &config.Rule.DefaultRetention
+	retention.Mode = objectlock.RetGovernance
+	if rp.IsLocked {
+		retention.Mode = objectlock.RetCompliance
+	}
+	if rp.RetentionPeriod%gcsRetentionYear == 0 {
+		years := uint64(rp.RetentionPeriod / gcsRetentionYear)
+		retention.Years = &years
+	} else {
+		days := uint64((rp.RetentionPeriod + gcsRetentionDay - 1) / gcsRetentionDay)
+		retention.Days = &days
+	}
+	return config
+}
+
+// s3ToGCSObjectRetention translates the S3 retention of an object, nil
+// for none.
+func s3ToGCSObjectRetention(retention objectlock.ObjectRetention) *gcsObjectRetention {
+	if !retention.Mode.Valid() {
+		return nil
+	}
+	mode := gcsRetentionUnlocked
+	if retention.Mode == objectlock.RetCompliance {
+		mode = gcsRetentionLocked
+	}
+	return &gcsObjectRetention{
+		Mode:            mode,
+		RetainUntilTime: retention.RetainUntilDate.UTC().Format(time.RFC3339),
+	}
+}
+
+// gcsObjectLockToS3Meta returns the S3 object lock metadata of an object,
+// retention is its object retention, if any. Objects without an object
+// retention are retained by the bucket retention policy, policyLocked
+// tells whether it is locked. Legal holds are only reported on buckets
+// with object lock enabled.
+func gcsObjectLockToS3Meta(attrs *storage.ObjectAttrs, retention *gcsObjectRetention, policyLocked, lockEnabled bool) map[string]string {
+	meta := make(map[string]string)
+	var mode objectlock.RetMode
+	var until time.Time
+	if retention != nil && retention.Mode != "" {
+		mode = objectlock.RetGovernance
+		if retention.Mode == gcsRetentionLocked {
+			mode = objectlock.RetCompliance
+		}
+		until, _ = time.Parse(time.RFC3339, retention.RetainUntilTime)
+	} else if !attrs.RetentionExpirationTime.IsZero() {
+		mode = objectlock.RetGovernance
+		if policyLocked {
+			mode = objectlock.RetCompliance
+		}
+		until = attrs.RetentionExpirationTime
+	}
+	if mode != "" && !until.IsZero() {
+		meta[strings.ToLower(xhttp.AmzObjectLockMode)] = string(mode)
+		meta[strings.ToLower(xhttp.AmzObjectLockRetainUntilDate)] = until.UTC().Format(time.RFC3339)
+	}
+
+	if attrs.TemporaryHold || attrs.EventBasedHold {
+		meta[strings.ToLower(xhttp.AmzObjectLockLegalHold)] = string(objectlock.LegalHoldOn)
+	} else if lockEnabled {
+		meta[strings.ToLower(xhttp.AmzObjectLockLegalHold)] = string(objectlock.LegalHoldOff)
+	}
+	return meta
+}
+
+// isObjectRetentionEnabled returns true if the bucket has object retention
+// enabled. It is cached along with the bucket attributes, as it may be
+// enabled on existing buckets or the bucket recreated outside of the
+// gateway.
+func (l *gcsGateway) isObjectRetentionEnabled(ctx context.Context, bucket string) (bool, error) {
+	if enabled, ok := l.buckets.getRetention(bucket); ok {
+		return enabled, nil
+	}
+	var attrs struct {
+		ObjectRetention struct {
+			Mode string `json:"mode"`
+		} `json:"objectRetention"`
+	}
+	err := l.jsonRequest(ctx, http.MethodGet, "b/"+url.PathEscape(bucket), url.Values{"fields": []string{"objectRetention"}}, nil, &attrs)
+	if err != nil {
+		return false, gcsToObjectError(err, bucket)
+	}
+	enabled := attrs.ObjectRetention.Mode == "Enabled"
+	l.buckets.setRetention(bucket, enabled)
+	return enabled, nil
+}
+
+// objectRetentionPath returns the JSON API path and query of a generation
+// of the object, for reading and setting its retention.
+func objectRetentionPath(bucket, object string, generation int64) (string, url.Values) {
+	query := url.Values{"fields": []string{"retention"}}
+	if generation > 0 {
+		query.Set("generation", strconv.FormatInt(generation, 10))
+	}
+	return "b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(object), query
+}
+
+// gcsObjectResource is the JSON API resource of an object together with
+// its object retention, which the storage client does not know of.
+type gcsObjectResource struct {
+	raw.Object
+	Retention *gcsObjectRetention `json:"retention"`
+}
+
+// objectAttrsWithRetention returns the attributes and the object retention
+// of the generation of the object, nil if it has none, in a single request
+// rather than asking for the retention on its own.
+func (l *gcsGateway) objectAttrsWithRetention(ctx context.Context, bucket, object string, generation int64) (*storage.ObjectAttrs, *gcsObjectRetention, error) {
+	var o gcsObjectResource
+	path, query := objectRetentionPath(bucket, object, generation)
+	query.Del("fields")
+	query.Set("projection", "full")
+	if err := l.jsonRequest(ctx, http.MethodGet, path, query, nil, &o); err != nil {
+		return nil, nil, err
+	}
+	return newGCSObjectAttrs(&o.Object), o.Retention, nil
+}
+
+// newGCSObjectAttrs converts an object resource of the JSON API to the
+// attributes the storage client returns for it, without the ACL.
+func newGCSObjectAttrs(o *raw.Object) *storage.ObjectAttrs {
+	parseTime := func(s string) time.Time {
+		t, _ := time.Parse(time.RFC3339, s)
+		return t
+	}
+	attrs := &storage.ObjectAttrs{
+		Bucket:                  o.Bucket,
+		Name:                    o.Name,
+		ContentType:             o.ContentType,
+		ContentLanguage:         o.ContentLanguage,
+		CacheControl:            o.CacheControl,
+		EventBasedHold:          o.EventBasedHold,
+		TemporaryHold:           o.TemporaryHold,
+		RetentionExpirationTime: parseTime(o.RetentionExpirationTime),
+		ContentEncoding:         o.ContentEncoding,
+		ContentDisposition:      o.ContentDisposition,
+		Size:                    int64(o.Size),
+		MediaLink:               o.MediaLink,
+		Metadata:                o.Metadata,
+		Generation:              o.Generation,
+		Metageneration:          o.Metageneration,
+		StorageClass:            o.StorageClass,
+		KMSKeyName:              o.KmsKeyName,
+		Created:                 parseTime(o.TimeCreated),
+		Deleted:                 parseTime(o.TimeDeleted),
+		Updated:                 parseTime(o.Updated),
+		Etag:                    o.Etag,
+	}
+	if o.Owner != nil {
+		attrs.Owner = o.Owner.Entity
+	}
+	if o.CustomerEncryption != nil {
+		attrs.CustomerKeySHA256 = o.CustomerEncryption.KeySha256
+	}
+	attrs.MD5, _ = base64.StdEncoding.DecodeString(o.Md5Hash)
+	if crc, err := base64.StdEncoding.DecodeString(o.Crc32c); err == nil && len(crc) == 4 {
+		attrs.CRC32C = binary.BigEndian.Uint32(crc)
+	}
+	return attrs
+}
+
+// setObjectRetention sets the object retention of the generation of the
+// object, an empty retention removes an unlocked retention.
+func (l *gcsGateway) setObjectRetention(ctx context.Context, bucket, object string, generation int64, retention objectlock.ObjectRetention) error {
+	path, query := objectRetentionPath(bucket, object, generation)
+	// Needed to shorten or remove an unlocked retention, S3 clients
+	// have to bypass governance retention for that.
+	query.Set("overrideUnlockedRetention", "true")
+	err := l.jsonRequest(ctx, http.MethodPatch, path, query,
+		map[string]interface{}{"retention": s3ToGCSObjectRetention(retention)}, nil)
+	logger.LogIf(ctx, err)
+	return gcsToObjectError(err, bucket, object)
+}
+
+// setObjectLegalHold sets or releases the legal hold of the object,
+// releasing it releases both kinds of holds.
+func (l *gcsGateway) setObjectLegalHold(ctx context.Context, bucket, object string, generation int64, legalHold objectlock.ObjectLegalHold) error {
+	var update storage.ObjectAttrsToUpdate
+	switch {
+	case legalHold.Status != objectlock.LegalHoldOn:
+		update.TemporaryHold, update.EventBasedHold = false, false
+	case l.eventBasedHold:
+		update.EventBasedHold = true
+	default:
+		update.TemporaryHold = true
+	}
+	_, err := l.client.Bucket(bucket).Object(object).Generation(generation).Update(ctx, update)
+	logger.LogIf(ctx, err)
+	return gcsToObjectError(err, bucket, object)
+}
+
+// makeLockedBucket creates a versioned bucket with object retention
+// enabled, which the storage client cannot create.
+func (l *gcsGateway) makeLockedBucket(ctx context.Context, bucket, location string) error {
+	query := url.Values{
+		"project":               []string{l.projectID},
+		"enableObjectRetention": []string{"true"},
+	}
+	err := l.jsonRequest(ctx, http.MethodPost, "b", query, map[string]interface{}{
+		"name":       bucket,
+		"location":   location,
+		"versioning": map[string]bool{"enabled": true},
+	}, nil)
+	logger.LogIf(ctx, err)
+	return gcsToObjectError(err, bucket)
+}
+
+// setBucketObjectLockConfig sets the default retention of the bucket as
+// its retention policy, COMPLIANCE retention locks the policy which can
+// then only be extended.
+func (l *gcsGateway) setBucketObjectLockConfig(ctx context.Context, bucket string, config *objectlock.Config) error {
+	bkt := l.client.Bucket(bucket)
+	attrs, err := bkt.Attrs(ctx)
+	if err != nil {
+		logger.LogIf(ctx, err)
+		return gcsToObjectError(err, bucket)
+	}
+	rp, locked := s3ToGCSRetentionPolicy(config)
+	if attrs.RetentionPolicy != nil && attrs.RetentionPolicy.IsLocked && !locked {
+		// Locked policies cannot be unlocked.
+		return minio.InvalidArgument{Bucket: bucket, Err: errors.New("COMPLIANCE default retention cannot be changed or removed")}
+	}
+
+	attrs, err = bkt.Update(ctx, storage.BucketAttrsToUpdate{RetentionPolicy: &rp})
+	l.buckets.invalidate(bucket)
+	if err != nil {
+		logger.LogIf(ctx, err)
+		return gcsToObjectError(err, bucket)
+	}
+	if locked && !attrs.RetentionPolicy.IsLocked {
+		err = bkt.If(storage.BucketConditions{MetagenerationMatch: attrs.MetaGeneration}).LockRetentionPolicy(ctx)
+		logger.LogIf(ctx, err)
+	}
+	return gcsToObjectError(err, bucket)
+}
+
+// getBucketObjectLockConfig returns the object lock configuration of the
+// bucket, buckets with object retention or a retention policy have object
+// lock enabled.
+func (l *gcsGateway) getBucketObjectLockConfig(ctx context.Context, bucket string) (*objectlock.Config, error) {
+	attrs, err := l.client.Bucket(bucket).Attrs(ctx)
+	if err != nil {
+		logger.LogIf(ctx, err)
+		return nil, gcsToObjectError(err, bucket)
+	}
+	if attrs.RetentionPolicy == nil {
+		enabled, err := l.isObjectRetentionEnabled(ctx, bucket)
+		if err != nil {
+			return nil, err
+		}
+		if !enabled {
+			return nil, minio.BucketObjectLockConfigNotFound{Bucket: bucket}
+		}
+	}
+	return gcsToS3ObjectLockConfig(attrs.RetentionPolicy), nil
+}
+
+// gcsObjectLockInfo is the retention and legal hold of an object as
+// answered by the object lock admin API.
+type gcsObjectLockInfo struct {
+	Mode            objectlock.RetMode         `json:"mode,omitempty"`
+	RetainUntilDate *time.Time                 `json:"retainUntilDate,omitempty"`
+	LegalHold       objectlock.LegalHoldStatus `json:"legalHold"`
+}
+
+// gcsDefaultRetention is the default retention of a bucket as answered by
+// the object lock admin API, a period in either days or years.
+type gcsDefaultRetention struct {
+	Mode  objectlock.RetMode `json:"mode"`
+	Days  uint64             `json:"days,omitempty"`
+	Years uint64             `json:"years,omitempty"`
+}
+
+// newGCSObjectLockInfo returns the retention and legal hold of the S3
+// object lock metadata.
+func newGCSObjectLockInfo(meta map[string]string) gcsObjectLockInfo {
+	info := gcsObjectLockInfo{LegalHold: objectlock.LegalHoldOff}
+	if retention := objectlock.GetObjectRetentionMeta(meta); retention.Mode.Valid() {
+		until := retention.RetainUntilDate.UTC()
+		info.Mode, info.RetainUntilDate = retention.Mode, &until
+	}
+	if legalHold := objectlock.GetObjectLegalHoldMeta(meta); legalHold.Status.Valid() {
+		info.LegalHold = legalHold.Status
+	}
+	return info
+}
+
+// parseGCSRetentionQuery parses the retention of an object from the mode
+// and until parameters, both empty removing the retention.
+func parseGCSRetentionQuery(query url.Values, now time.Time) (retention objectlock.ObjectRetention, err error) {
+	mode, until := query.Get("mode"), query.Get("until")
+	if mode == "" && until == "" {
+		return retention, nil
+	}
+	retention.Mode = objectlock.RetMode(strings.ToUpper(mode))
+	if !retention.Mode.Valid() {
+		return retention, minio.InvalidArgument{Err: objectlock.ErrUnknownWORMModeDirective}
+	}
+	t, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		return retention, minio.InvalidArgument{Err: objectlock.ErrInvalidRetentionDate}
+	}
+	if !t.After(now) {
+		return retention, minio.InvalidArgument{Err: objectlock.ErrPastObjectLockRetainDate}
+	}
+	retention.RetainUntilDate.Time = t.UTC()
+	return retention, nil
+}
+
+// parseGCSDefaultRetentionQuery parses the default retention of a bucket
+// from the mode and days or years parameters.
+func parseGCSDefaultRetentionQuery(query url.Values) (*objectlock.Config, error) {
+	mode := objectlock.RetMode(strings.ToUpper(query.Get("mode")))
+	if !mode.Valid() {
+		return nil, minio.InvalidArgument{Err: objectlock.ErrUnknownWORMModeDirective}
+	}
+	days, years := query.Get("days"), query.Get("years")
+	if (days == "") == (years == "") {
+		return nil, minio.InvalidArgument{Err: errors.New("default retention needs either days or years")}
+	}
+	period, err := strconv.ParseUint(days+years, 10, 32)
+	if err != nil || period == 0 {
+		return nil, minio.InvalidArgument{Err: errors.New("default retention period must be a positive integer")}
+	}
+
+	config := objectlock.NewObjectLockConfig()
+	config.Rule = &struct {
+		DefaultRetention objectlock.DefaultRetention `xml:"DefaultRetention"`
+	}{}
+	config.Rule.DefaultRetention.Mode = mode
+	if days != "" {
+		config.Rule.DefaultRetention.Days = &period
+	} else {
+		config.Rule.DefaultRetention.Years = &period
+	}
+	return config, nil
+}
+
+// registerObjectLockRouter registers the object lock admin API, which
+// reports and sets the retention and legal hold of objects, of their
+// latest version unless versionId is given, and the default retention of
+// buckets:
+//
+//	GET    /minio/admin/v3/gcs/retention?bucket=<bucket>&object=<object>[&versionId=<versionId>]
+//	PUT    /minio/admin/v3/gcs/retention?bucket=<bucket>&object=<object>[&versionId=<versionId>][&mode=<mode>&until=<date>]
+//	PUT    /minio/admin/v3/gcs/legal-hold?bucket=<bucket>&object=<object>[&versionId=<versionId>]&status=<ON|OFF>
+//	GET    /minio/admin/v3/gcs/default-retention?bucket=<bucket>
+//	PUT    /minio/admin/v3/gcs/default-retention?bucket=<bucket>&mode=<mode>&(days=<days>|years=<years>)
+//	DELETE /minio/admin/v3/gcs/default-retention?bucket=<bucket>
+func (g *GCS) registerObjectLockRouter(router *mux.Router) {
+	// handler returns the handler of the bucket of the request, given its
+	// query parameters.
+	handler := func(f func(ctx context.Context, l *gcsGateway, bucket string, query url.Values) (interface{}, error)) http.HandlerFunc {
+		return g.bucketAdminHandler(func(ctx context.Context, l *gcsGateway, bucket string, r *http.Request) (interface{}, error) {
+			return f(ctx, l, bucket, r.URL.Query())
+		})
+	}
+
+	// objectHandler returns the handler of the version of the object of
+	// the request, in a bucket with object retention enabled.
+	objectHandler := func(f func(ctx context.Context, l *gcsGateway, bucket, object string, generation int64, query url.Values) (interface{}, error)) http.HandlerFunc {
+		return handler(func(ctx context.Context, l *gcsGateway, bucket string, query url.Values) (interface{}, error) {
+			enabled, err := l.isObjectRetentionEnabled(ctx, bucket)
+			if err != nil {
+				return nil, err
+			}
+			if !enabled {
+				return nil, minio.InvalidArgument{Bucket: bucket, Err: errors.New("bucket does not have object lock enabled")}
+			}
+			object, versionID := query.Get("object"), query.Get("versionId")
+			handle, err := l.objectHandle(ctx, bucket, object, versionID)
+			if err != nil {
+				return nil, err
+			}
+			attrs, err := handle.Attrs(ctx)
+			if err != nil {
+				return nil, gcsToObjectVersionError(err, bucket, object, versionID)
+			}
+			return f(ctx, l, bucket, object, attrs.Generation, query)
+		})
+	}
+
+	router.Methods(http.MethodGet).Path("/retention").HandlerFunc(objectHandler(
+		func(ctx context.Context, l *gcsGateway, bucket, object string, generation int64, query url.Values) (interface{}, error) {
+			objInfo, err := l.GetObjectInfo(ctx, bucket, object, minio.ObjectOptions{VersionID: query.Get("versionId")})
+			if err != nil {
+				return nil, err
+			}
+			return newGCSObjectLockInfo(objInfo.UserDefined), nil
+		}))
+	router.Methods(http.MethodPut).Path("/retention").HandlerFunc(objectHandler(
+		func(ctx context.Context, l *gcsGateway, bucket, object string, generation int64, query url.Values) (interface{}, error) {
+			retention, err := parseGCSRetentionQuery(query, time.Now().UTC())
+			if err != nil {
+				return nil, err
+			}
+			return nil, l.setObjectRetention(ctx, bucket, object, generation, retention)
+		}))
+	router.Methods(http.MethodPut).Path("/legal-hold").HandlerFunc(objectHandler(
+		func(ctx context.Context, l *gcsGateway, bucket, object string, generation int64, query url.Values) (interface{}, error) {
+			legalHold := objectlock.ObjectLegalHold{Status: objectlock.LegalHoldStatus(strings.ToUpper(query.Get("status")))}
+			if !legalHold.Status.Valid() {
+				return nil, minio.InvalidArgument{Bucket: bucket, Object: object, Err: objectlock.ErrMalformedXML}
+			}
+			return nil, l.setObjectLegalHold(ctx, bucket, object, generation, legalHold)
+		}))
+	router.Methods(http.MethodGet).Path("/default-retention").HandlerFunc(handler(
+		func(ctx context.Context, l *gcsGateway, bucket string, query url.Values) (interface{}, error) {
+			config, err := l.getBucketObjectLockConfig(ctx, bucket)
+			if err != nil {
+				return nil, err
+			}
+			if config.Rule == nil {
+				return nil, minio.BucketObjectLockConfigNotFound{Bucket: bucket}
+			}
+			retention := config.Rule.DefaultRetention
+			r := gcsDefaultRetention{Mode: retention.Mode}
+			if retention.Days != nil {
+				r.Days = *retention.Days
+			}
+			if retention.Years != nil {
+				r.Years = *retention.Years
+			}
+			return r, nil
+		}))
+	router.Methods(http.MethodPut).Path("/default-retention").HandlerFunc(handler(
+		func(ctx context.Context, l *gcsGateway, bucket string, query url.Values) (interface{}, error) {
+			config, err := parseGCSDefaultRetentionQuery(query)
+			if err != nil {
+				return nil, err
+			}
+			return nil, l.setBucketObjectLockConfig(ctx, bucket, config)
+		}))
+	router.Methods(http.MethodDelete).Path("/default-retention").HandlerFunc(handler(
+		func(ctx context.Context, l *gcsGateway, bucket string, query url.Values) (interface{}, error) {
+			return nil, l.setBucketObjectLockConfig(ctx, bucket, objectlock.NewObjectLockConfig())
+		}))
+}
@@ -0,0 +1,253 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	miniogo "github.com/minio/minio-go/v7"
+	minio "github.com/minio/minio/cmd"
+	objectlock "github.com/minio/minio/pkg/bucket/object/lock"
+	"google.golang.org/api/googleapi"
+)
+
+func TestParseGCSLegalHold(t *testing.T) {
+	testCases := []struct {
+		value      string
+		eventBased bool
+		success    bool
+	}{
+		{"", false, true},
+		{"temporary", false, true},
+		{"Event-Based", true, true},
+		{"permanent", false, false},
+	}
+	for i, testCase := range testCases {
+		eventBased, err := parseGCSLegalHold(testCase.value)
+		if testCase.success != (err == nil) || eventBased != testCase.eventBased {
+			t.Errorf("Test %d: Expected %v, %v, got %v, %v", i+1, testCase.eventBased, testCase.success, eventBased, err)
+		}
+	}
+}
+
+func TestGCSRetentionPolicy(t *testing.T) {
+	testCases := []struct {
+		config string
+		period time.Duration
+		locked bool
+	}{
+		{`<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled></ObjectLockConfiguration>`, 0, false},
+		{`<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled><Rule><DefaultRetention>
+<Mode>GOVERNANCE</Mode><Days>30</Days></DefaultRetention></Rule></ObjectLockConfiguration>`, 30 * 24 * time.Hour, false},
+		{`<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled><Rule><DefaultRetention>
+<Mode>COMPLIANCE</Mode><Years>2</Years></DefaultRetention></Rule></ObjectLockConfiguration>`, 2 * 365 * 24 * time.Hour, true},
+	}
+	for i, testCase := range testCases {
+		config, err := objectlock.ParseObjectLockConfig(strings.NewReader(testCase.config))
+		if err != nil {
+			t.Fatalf("Test %d: %v", i+1, err)
+		}
+		rp, locked := s3ToGCSRetentionPolicy(config)
+		if rp.RetentionPeriod != testCase.period || locked != testCase.locked {
+			t.Errorf("Test %d: Expected %v, %v, got %v, %v", i+1, testCase.period, testCase.locked, rp.RetentionPeriod, locked)
+		}
+
+		// The policy reads back as the same configuration.
+		rp.IsLocked = locked
+		got, gotLocked := s3ToGCSRetentionPolicy(gcsToS3ObjectLockConfig(&rp))
+		if got.RetentionPeriod != rp.RetentionPeriod || gotLocked != locked {
+			t.Errorf("Test %d: Expected %v, %v, got %v, %v", i+1, rp.RetentionPeriod, locked, got.RetentionPeriod, gotLocked)
+		}
+	}
+
+	// Periods set on GCS are rounded up to whole days.
+	config := gcsToS3ObjectLockConfig(&storage.RetentionPolicy{RetentionPeriod: 36 * time.Hour})
+	if config.Rule == nil || config.Rule.DefaultRetention.Days == nil || *config.Rule.DefaultRetention.Days != 2 {
+		t.Errorf("Expected 2 days of retention, got %#v", config.Rule)
+	}
+}
+
+func TestGCSObjectLockToS3Meta(t *testing.T) {
+	until := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	retention := s3ToGCSObjectRetention(objectlock.ObjectRetention{
+		Mode:            objectlock.RetCompliance,
+		RetainUntilDate: objectlock.RetentionDate{Time: until},
+	})
+	if *retention != (gcsObjectRetention{Mode: gcsRetentionLocked, RetainUntilTime: "2030-01-01T00:00:00Z"}) {
+		t.Fatalf("Unexpected retention %#v", retention)
+	}
+
+	testCases := []struct {
+		attrs        storage.ObjectAttrs
+		retention    *gcsObjectRetention
+		policyLocked bool
+		lockEnabled  bool
+		expected     map[string]string
+	}{
+		{storage.ObjectAttrs{}, nil, false, false, map[string]string{}},
+		{storage.ObjectAttrs{}, nil, false, true, map[string]string{
+			"x-amz-object-lock-legal-hold": "OFF",
+		}},
+		{storage.ObjectAttrs{TemporaryHold: true}, retention, false, true, map[string]string{
+			"x-amz-object-lock-mode":              "COMPLIANCE",
+			"x-amz-object-lock-retain-until-date": "2030-01-01T00:00:00Z",
+			"x-amz-object-lock-legal-hold":        "ON",
+		}},
+		// Retained by the bucket retention policy.
+		{storage.ObjectAttrs{EventBasedHold: true, RetentionExpirationTime: until}, nil, false, true, map[string]string{
+			"x-amz-object-lock-mode":              "GOVERNANCE",
+			"x-amz-object-lock-retain-until-date": "2030-01-01T00:00:00Z",
+			"x-amz-object-lock-legal-hold":        "ON",
+		}},
+		{storage.ObjectAttrs{RetentionExpirationTime: until}, nil, true, true, map[string]string{
+			"x-amz-object-lock-mode":              "COMPLIANCE",
+			"x-amz-object-lock-retain-until-date": "2030-01-01T00:00:00Z",
+			"x-amz-object-lock-legal-hold":        "OFF",
+		}},
+	}
+	for i, testCase := range testCases {
+		meta := gcsObjectLockToS3Meta(&testCase.attrs, testCase.retention, testCase.policyLocked, testCase.lockEnabled)
+		if !reflect.DeepEqual(meta, testCase.expected) {
+			t.Errorf("Test %d: Expected %v, got %v", i+1, testCase.expected, meta)
+		}
+	}
+}
+
+func TestGCSToObjectLockError(t *testing.T) {
+	for _, reason := range []string{"retentionPolicyNotMet", "objectUnderActiveHold"} {
+		err := gcsToObjectError(&googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: reason}}}, "bucket", "object")
+		if resp, ok := err.(miniogo.ErrorResponse); !ok || resp.Code != "AccessDenied" || resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: Expected an object locked error, got %#v", reason, err)
+		}
+	}
+	err := gcsToObjectError(&googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}, "bucket", "object")
+	if _, ok := err.(minio.PrefixAccessDenied); !ok {
+		t.Errorf("forbidden: Expected PrefixAccessDenied, got %#v", err)
+	}
+}
+
+func TestParseGCSRetentionQuery(t *testing.T) {
+	now := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		query   string
+		mode    objectlock.RetMode
+		until   time.Time
+		success bool
+	}{
+		{"", "", time.Time{}, true},
+		{"mode=governance&until=2021-02-01T00:00:00Z", objectlock.RetGovernance, time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC), true},
+		{"mode=COMPLIANCE&until=2021-02-01T01:00:00%2B01:00", objectlock.RetCompliance, time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC), true},
+		{"mode=COMPLIANCE&until=2021-01-01T00:00:00Z", "", time.Time{}, false},
+		{"mode=COMPLIANCE&until=tomorrow", "", time.Time{}, false},
+		{"mode=OTHER&until=2021-02-01T00:00:00Z", "", time.Time{}, false},
+		{"until=2021-02-01T00:00:00Z", "", time.Time{}, false},
+	}
+	for i, testCase := range testCases {
+		query, err := url.ParseQuery(testCase.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		retention, err := parseGCSRetentionQuery(query, now)
+		if testCase.success != (err == nil) {
+			t.Fatalf("Test %d: Expected success %v, got error %v", i+1, testCase.success, err)
+		}
+		if err == nil && (retention.Mode != testCase.mode || !retention.RetainUntilDate.Equal(testCase.until)) {
+			t.Errorf("Test %d: Expected %s until %v, got %s until %v", i+1, testCase.mode, testCase.until, retention.Mode, retention.RetainUntilDate.Time)
+		}
+	}
+}
+
+func TestParseGCSDefaultRetentionQuery(t *testing.T) {
+	testCases := []struct {
+		query   string
+		period  time.Duration
+		locked  bool
+		success bool
+	}{
+		{"mode=GOVERNANCE&days=30", 30 * gcsRetentionDay, false, true},
+		{"mode=compliance&years=1", gcsRetentionYear, true, true},
+		{"mode=GOVERNANCE", 0, false, false},
+		{"mode=GOVERNANCE&days=1&years=1", 0, false, false},
+		{"mode=GOVERNANCE&days=0", 0, false, false},
+		{"mode=GOVERNANCE&days=-1", 0, false, false},
+		{"days=30", 0, false, false},
+	}
+	for i, testCase := range testCases {
+		query, err := url.ParseQuery(testCase.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := parseGCSDefaultRetentionQuery(query)
+		if testCase.success != (err == nil) {
+			t.Fatalf("Test %d: Expected success %v, got error %v", i+1, testCase.success, err)
+		}
+		if err != nil {
+			continue
+		}
+		rp, locked := s3ToGCSRetentionPolicy(config)
+		if rp.RetentionPeriod != testCase.period || locked != testCase.locked {
+			t.Errorf("Test %d: Expected period %v locked %v, got %v locked %v", i+1, testCase.period, testCase.locked, rp.RetentionPeriod, locked)
+		}
+	}
+}
+
+func TestGCSGetObjectInfoRetention(t *testing.T) {
+	var requests []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+		if r.URL.Path != "/storage/v1/b/bucket/o/object" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"bucket":"bucket","name":"object","size":"4","generation":"42",` +
+			`"md5Hash":"jVAFyKzY4yMjXHfVwjB9Dw==","updated":"2021-01-01T00:00:00Z",` +
+			`"temporaryHold":true,"retention":{"mode":"Locked","retainUntilTime":"2030-01-01T00:00:00Z"}}`))
+	})
+	l, closer := newTestGCSGateway(t, handler, false)
+	defer closer()
+	l.storageClasses = newGCSStorageClassTable(nil)
+	l.buckets.setRetention("bucket", true)
+
+	objInfo, err := l.GetObjectInfo(context.Background(), "bucket", "object", minio.ObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || !strings.Contains(requests[0], "projection=full") {
+		t.Errorf("Expected the retention to be read with the attributes, got requests %v", requests)
+	}
+	if objInfo.Size != 4 || objInfo.ETag != "8d5005c8acd8e323235c77d5c2307d0f" || !objInfo.ModTime.Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected object info %#v", objInfo)
+	}
+	expected := map[string]string{
+		"x-amz-object-lock-mode":              "COMPLIANCE",
+		"x-amz-object-lock-retain-until-date": "2030-01-01T00:00:00Z",
+		"x-amz-object-lock-legal-hold":        "ON",
+	}
+	for k, v := range expected {
+		if objInfo.UserDefined[k] != v {
+			t.Errorf("Expected %s to be %q, got %q", k, v, objInfo.UserDefined[k])
+		}
+	}
+}
@@ -0,0 +1,630 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/bucket/policy"
+	"github.com/minio/minio/pkg/bucket/policy/condition"
+)
+
+// Bucket policies are translated into bindings of the bucket IAM policy,
+// which also apply to buckets with uniform bucket-level access:
+//
+// - The actions of a statement select the predefined storage role which
+//   grants exactly them, see gcsPolicyRoles.
+//
+// - The anonymous principal is allUsers, other principals are mapped to
+//   IAM members through the MINIO_GCS_PRINCIPALS table or used as is when
+//   they already are IAM members.
+//
+// - Object prefixes of the resources and aws:CurrentTime bounds become
+//   the CEL expression of an IAM condition.
+//
+// The gateway owns the allUsers and allAuthenticatedUsers members of the
+// unconditioned bindings of these roles, and the bindings whose condition
+// is titled gcsPolicyConditionTitle, which it gives to every other member.
+// Any other binding or member, such as the project convenience members GCS
+// adds to new buckets or members granted outside the gateway, is left
+// untouched and not reported. Statements IAM cannot express are rejected
+// as a whole.
+
+const (
+	// Version of the IAM policies which supports conditions.
+	gcsIAMPolicyVersion = 3
+
+	// Resource type of buckets in IAM conditions.
+	gcsIAMBucketResourceType = "storage.googleapis.com/Bucket"
+
+	gcsAllUsers              = "allUsers"
+	gcsAllAuthenticatedUsers = "allAuthenticatedUsers"
+
+	// Title of the conditions of the bindings the gateway creates, the
+	// description holds the statement id.
+	gcsPolicyConditionTitle = "minio-bucket-policy"
+)
+
+// gcsPolicyRoles lists the predefined storage roles bucket policies are
+// translated into, with the S3 actions each role grants. Actions on the
+// bucket itself are the ones on the bucket resource.
+var gcsPolicyRoles = []struct {
+	role          string
+	bucketActions []policy.Action
+	objectActions []policy.Action
+}{
+	{"roles/storage.legacyObjectReader", nil, []policy.Action{policy.GetObjectAction}},
+	{"roles/storage.objectViewer", []policy.Action{policy.ListBucketAction}, []policy.Action{policy.GetObjectAction}},
+	{"roles/storage.objectCreator", nil, []policy.Action{policy.PutObjectAction}},
+	{"roles/storage.legacyBucketWriter", []policy.Action{policy.ListBucketAction}, []policy.Action{policy.PutObjectAction, policy.DeleteObjectAction}},
+	{"roles/storage.objectAdmin", []policy.Action{policy.ListBucketAction}, []policy.Action{policy.GetObjectAction, policy.PutObjectAction, policy.DeleteObjectAction}},
+}
+
+// S3 actions which come along with others, they are dropped from the
+// statements and added back to those granting the actions they go with.
+var (
+	gcsImpliedActions = []policy.Action{policy.GetBucketLocationAction}
+	gcsUploadActions  = []policy.Action{
+		policy.ListBucketMultipartUploadsAction,
+		policy.AbortMultipartUploadAction,
+		policy.ListMultipartUploadPartsAction,
+	}
+)
+
+// gcsIAMCondition is the condition of an IAM binding.
+type gcsIAMCondition struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Expression  string `json:"expression"`
+}
+
+// gcsIAMBinding binds members to a role, under a condition if any.
+type gcsIAMBinding struct {
+	Role      string           `json:"role"`
+	Members   []string         `json:"members"`
+	Condition *gcsIAMCondition `json:"condition,omitempty"`
+}
+
+// gcsIAMPolicy is the IAM policy of a bucket.
+type gcsIAMPolicy struct {
+	Version  int             `json:"version"`
+	Etag     string          `json:"etag,omitempty"`
+	Bindings []gcsIAMBinding `json:"bindings"`
+}
+
+// isGCSPolicyRole returns true for the roles bucket policies translate to.
+func isGCSPolicyRole(role string) bool {
+	for _, r := range gcsPolicyRoles {
+		if r.role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// isGCSPublicMember returns true for the members IAM conditions cannot
+// apply to.
+func isGCSPublicMember(member string) bool {
+	return member == gcsAllUsers || member == gcsAllAuthenticatedUsers
+}
+
+// isGCSPolicyBinding returns true if the gateway created the conditional
+// binding.
+func isGCSPolicyBinding(binding gcsIAMBinding) bool {
+	return binding.Condition != nil && binding.Condition.Title == gcsPolicyConditionTitle
+}
+
+// isGCSMember returns true if the principal already is an IAM member.
+func isGCSMember(principal string) bool {
+	for _, prefix := range []string{"user:", "serviceAccount:", "group:", "domain:"} {
+		if strings.HasPrefix(principal, prefix) {
+			return true
+		}
+	}
+	return principal == gcsAllAuthenticatedUsers
+}
+
+// parseGCSPrincipals parses the S3 principal to IAM member table, given
+// as comma separated "principal=member" pairs.
+func parseGCSPrincipals(s string) (map[string]string, error) {
+	principals := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i <= 0 || i == len(kv)-1 {
+			return nil, fmt.Errorf("invalid principal mapping %q, expected principal=member", kv)
+		}
+		principals[kv[:i]] = kv[i+1:]
+	}
+	return principals, nil
+}
+
+// gcsPolicyTranslator translates between bucket policies and IAM
+// bindings of a bucket.
+type gcsPolicyTranslator struct {
+	bucket     string
+	principals map[string]string // S3 principal to IAM member
+}
+
+// member returns the IAM member of the S3 principal.
+func (t gcsPolicyTranslator) member(principal string) (string, error) {
+	switch {
+	case principal == "*":
+		return gcsAllUsers, nil
+	case t.principals[principal] != "":
+		return t.principals[principal], nil
+	case isGCSMember(principal):
+		return principal, nil
+	}
+	return "", policy.Errorf("principal %s has no GCS IAM member, map it with MINIO_GCS_PRINCIPALS", principal)
+}
+
+// principal returns the S3 principal of the IAM member.
+func (t gcsPolicyTranslator) principal(member string) string {
+	if member == gcsAllUsers {
+		return "*"
+	}
+	for principal, m := range t.principals {
+		if m == member {
+			return principal
+		}
+	}
+	return member
+}
+
+// objectName returns the IAM resource name of the object.
+func (t gcsPolicyTranslator) objectName(object string) string {
+	return "projects/_/buckets/" + t.bucket + "/objects/" + object
+}
+
+// role returns the role which grants exactly the actions of the statement,
+// listing is true if the role grants actions on the bucket itself.
+func (t gcsPolicyTranslator) role(statement policy.Statement) (role string, listing bool, err error) {
+	actions := policy.NewActionSet(statement.Actions.ToSlice()...)
+	for _, action := range gcsImpliedActions {
+		delete(actions, action)
+	}
+	for _, action := range gcsUploadActions {
+		if actions.Contains(action) && !actions.Contains(policy.PutObjectAction) {
+			return "", false, policy.Errorf("statement %s: action %s requires %s", statement.SID, action, policy.PutObjectAction)
+		}
+		delete(actions, action)
+	}
+
+	for _, r := range gcsPolicyRoles {
+		granted := policy.NewActionSet(append(append([]policy.Action{}, r.bucketActions...), r.objectActions...)...)
+		if granted.Equals(actions) {
+			return r.role, len(r.bucketActions) > 0, nil
+		}
+	}
+	return "", false, policy.Errorf("statement %s: no GCS storage role grants exactly the actions %s", statement.SID, actions)
+}
+
+// objectCondition returns the CEL expression restricting the objects the
+// statement applies to, empty when it applies to all objects.
+func (t gcsPolicyTranslator) objectCondition(statement policy.Statement, listing bool) (string, error) {
+	var clauses []string
+	var all, bucket bool
+	for resource := range statement.Resources {
+		if resource.BucketName != t.bucket {
+			return "", policy.Errorf("statement %s: resource %s is not in bucket %s", statement.SID, resource, t.bucket)
+		}
+		pattern := strings.TrimPrefix(strings.TrimPrefix(resource.Pattern, t.bucket), "/")
+		switch {
+		case pattern == "":
+			bucket = true
+		case pattern == "*":
+			all = true
+		case !strings.ContainsAny(strings.TrimSuffix(pattern, "*"), "*?"):
+			if strings.HasSuffix(pattern, "*") {
+				clauses = append(clauses, fmt.Sprintf("resource.name.startsWith(%s)", strconv.Quote(t.objectName(strings.TrimSuffix(pattern, "*")))))
+			} else {
+				clauses = append(clauses, fmt.Sprintf("resource.name == %s", strconv.Quote(t.objectName(pattern))))
+			}
+		default:
+			return "", policy.Errorf("statement %s: resource %s has wildcards other than a trailing *", statement.SID, resource)
+		}
+	}
+	if listing && !bucket {
+		return "", policy.Errorf("statement %s: %s requires the bucket resource %s", statement.SID, policy.ListBucketAction, t.bucket)
+	}
+	if !all && len(clauses) == 0 {
+		return "", policy.Errorf("statement %s: no object resource in bucket %s", statement.SID, t.bucket)
+	}
+	if all {
+		return "", nil
+	}
+	sort.Strings(clauses)
+	if listing {
+		// Listing is authorized against the bucket itself.
+		clauses = append([]string{fmt.Sprintf("resource.type == %q", gcsIAMBucketResourceType)}, clauses...)
+	}
+	return strings.Join(clauses, " || "), nil
+}
+
+// allObjectsCondition returns the CEL expression matching every object of
+// the bucket, and the bucket itself for listing roles, which marks the
+// bindings of members granted access to all objects.
+func (t gcsPolicyTranslator) allObjectsCondition(listing bool) string {
+	expression := fmt.Sprintf("resource.name.startsWith(%s)", strconv.Quote(t.objectName("")))
+	if listing {
+		expression = fmt.Sprintf("resource.type == %q || %s", gcsIAMBucketResourceType, expression)
+	}
+	return expression
+}
+
+// timeCondition returns the CEL expression of the aws:CurrentTime bounds
+// of the statement, which must be its only conditions.
+func (t gcsPolicyTranslator) timeCondition(statement policy.Statement) ([]string, error) {
+	data, err := json.Marshal(statement.Conditions)
+	if err != nil {
+		return nil, err
+	}
+	var conditions map[string]map[string][]string
+	if err = json.Unmarshal(data, &conditions); err != nil {
+		return nil, err
+	}
+
+	var clauses []string
+	for name, keys := range conditions {
+		for key, values := range keys {
+			if key != string(condition.AWSCurrentTime) || len(values) != 1 {
+				return nil, policy.Errorf("statement %s: condition %s on %s has no GCS IAM equivalent", statement.SID, name, key)
+			}
+			ts, err := time.Parse(time.RFC3339, values[0])
+			if err != nil {
+				return nil, policy.Errorf("statement %s: invalid time %s", statement.SID, values[0])
+			}
+			var op string
+			switch name {
+			case "DateGreaterThan":
+				op = ">"
+			case "DateGreaterThanEquals":
+				op = ">="
+			case "DateLessThan":
+				op = "<"
+			case "DateLessThanEquals":
+				op = "<="
+			default:
+				return nil, policy.Errorf("statement %s: condition %s on %s has no GCS IAM equivalent", statement.SID, name, key)
+			}
+			clauses = append(clauses, fmt.Sprintf("request.time %s timestamp(%q)", op, ts.UTC().Format(time.RFC3339)))
+		}
+	}
+	sort.Strings(clauses)
+	return clauses, nil
+}
+
+// toBindings translates the bucket policy into IAM bindings.
+func (t gcsPolicyTranslator) toBindings(bucketPolicy *policy.Policy) ([]gcsIAMBinding, error) {
+	var bindings []gcsIAMBinding
+	index := make(map[string]int) // role and expression to binding
+	add := func(role, expression string, sid policy.ID, members []string) {
+		key := role + "\n" + expression
+		if j, ok := index[key]; ok {
+			bindings[j].Members = append(bindings[j].Members, members...)
+			return
+		}
+		binding := gcsIAMBinding{Role: role, Members: members}
+		if expression != "" {
+			binding.Condition = &gcsIAMCondition{
+				Title:       gcsPolicyConditionTitle,
+				Description: string(sid),
+				Expression:  expression,
+			}
+		}
+		index[key] = len(bindings)
+		bindings = append(bindings, binding)
+	}
+	for _, statement := range bucketPolicy.Statements {
+		if statement.Effect != policy.Allow {
+			return nil, policy.Errorf("statement %s: GCS IAM policies cannot deny access", statement.SID)
+		}
+		role, listing, err := t.role(statement)
+		if err != nil {
+			return nil, err
+		}
+		objects, err := t.objectCondition(statement, listing)
+		if err != nil {
+			return nil, err
+		}
+		clauses, err := t.timeCondition(statement)
+		if err != nil {
+			return nil, err
+		}
+		if objects != "" {
+			if len(clauses) > 0 && strings.Contains(objects, " || ") {
+				objects = "(" + objects + ")"
+			}
+			clauses = append([]string{objects}, clauses...)
+		}
+		expression := strings.Join(clauses, " && ")
+
+		// Public members go to the unconditioned binding of the role, the
+		// others to a binding the gateway marks with its condition.
+		var public, members []string
+		for _, principal := range statement.Principal.AWS.ToSlice() {
+			member, err := t.member(principal)
+			if err != nil {
+				return nil, err
+			}
+			if !isGCSPublicMember(member) {
+				members = append(members, member)
+				continue
+			}
+			if expression != "" {
+				return nil, policy.Errorf("statement %s: GCS IAM conditions cannot apply to anonymous access", statement.SID)
+			}
+			public = append(public, member)
+		}
+		sort.Strings(public)
+		sort.Strings(members)
+
+		if len(public) > 0 {
+			add(role, "", statement.SID, public)
+		}
+		if len(members) > 0 {
+			if expression == "" {
+				expression = t.allObjectsCondition(listing)
+			}
+			add(role, expression, statement.SID, members)
+		}
+	}
+	return bindings, nil
+}
+
+var (
+	gcsCELStartsWith = regexp.MustCompile(`^resource\.name\.startsWith\(("(?:[^"\\]|\\.)*")\)$`)
+	gcsCELNameEquals = regexp.MustCompile(`^resource\.name == ("(?:[^"\\]|\\.)*")$`)
+	gcsCELTime       = regexp.MustCompile(`^request\.time (<|<=|>|>=) timestamp\("([^"]*)"\)$`)
+)
+
+// toStatement translates the IAM binding back into a bucket policy
+// statement, ok is false for bindings the gateway does not own or bucket
+// policies cannot express.
+func (t gcsPolicyTranslator) toStatement(binding gcsIAMBinding) (statement policy.Statement, ok bool) {
+	if binding.Condition != nil && !isGCSPolicyBinding(binding) {
+		return statement, false
+	}
+
+	var bucketActions, objectActions []policy.Action
+	for _, r := range gcsPolicyRoles {
+		if r.role == binding.Role {
+			bucketActions, objectActions = r.bucketActions, r.objectActions
+		}
+	}
+
+	principals := policy.NewPrincipal()
+	for _, member := range binding.Members {
+		if binding.Condition != nil || isGCSPublicMember(member) {
+			principals.AWS.Add(t.principal(member))
+		}
+	}
+	if principals.AWS.IsEmpty() || len(objectActions) == 0 {
+		return statement, false
+	}
+
+	objects := policy.NewResourceSet()
+	var functions []condition.Function
+	var expression string
+	if binding.Condition != nil {
+		expression = binding.Condition.Expression
+	}
+	for _, clause := range strings.Split(expression, " && ") {
+		if clause == "" {
+			continue
+		}
+		if m := gcsCELTime.FindStringSubmatch(clause); m != nil {
+			ts, err := time.Parse(time.RFC3339, m[2])
+			if err != nil {
+				return statement, false
+			}
+			var f condition.Function
+			switch m[1] {
+			case ">":
+				f, err = condition.NewDateGreaterThanFunc(condition.AWSCurrentTime, ts)
+			case ">=":
+				f, err = condition.NewDateGreaterThanEqualsFunc(condition.AWSCurrentTime, ts)
+			case "<":
+				f, err = condition.NewDateLessThanFunc(condition.AWSCurrentTime, ts)
+			case "<=":
+				f, err = condition.NewDateLessThanEqualsFunc(condition.AWSCurrentTime, ts)
+			}
+			if err != nil {
+				return statement, false
+			}
+			functions = append(functions, f)
+			continue
+		}
+		if strings.HasPrefix(clause, "(") {
+			clause = strings.TrimSuffix(strings.TrimPrefix(clause, "("), ")")
+		}
+		for _, atom := range strings.Split(clause, " || ") {
+			var name, suffix string
+			if m := gcsCELStartsWith.FindStringSubmatch(atom); m != nil {
+				name, suffix = m[1], "*"
+			} else if m := gcsCELNameEquals.FindStringSubmatch(atom); m != nil {
+				name = m[1]
+			} else if atom == fmt.Sprintf("resource.type == %q", gcsIAMBucketResourceType) {
+				continue
+			} else {
+				return statement, false
+			}
+			name, err := strconv.Unquote(name)
+			if err != nil || !strings.HasPrefix(name, t.objectName("")) {
+				return statement, false
+			}
+			objects.Add(policy.NewResource(t.bucket, strings.TrimPrefix(name, t.objectName(""))+suffix))
+		}
+	}
+	if len(objects) == 0 {
+		objects.Add(policy.NewResource(t.bucket, "*"))
+	}
+
+	actions := policy.NewActionSet(objectActions...)
+	resources := objects
+	if len(bucketActions) > 0 {
+		actions.Add(policy.GetBucketLocationAction)
+		resources = policy.NewResourceSet(policy.NewResource(t.bucket, ""))
+		for resource := range objects {
+			resources.Add(resource)
+		}
+	}
+	for _, action := range bucketActions {
+		actions.Add(action)
+	}
+	if actions.Contains(policy.PutObjectAction) {
+		for _, action := range gcsUploadActions {
+			actions.Add(action)
+		}
+	}
+
+	statement = policy.NewStatement(policy.Allow, principals, actions, resources, condition.NewFunctions(functions...))
+	if binding.Condition != nil {
+		statement.SID = policy.ID(binding.Condition.Description)
+	}
+	return statement, true
+}
+
+// getIAMPolicy returns the IAM policy of the bucket.
+func (l *gcsGateway) getIAMPolicy(ctx context.Context, bucket string) (*gcsIAMPolicy, error) {
+	var iamPolicy gcsIAMPolicy
+	query := url.Values{"optionsRequestedPolicyVersion": []string{strconv.Itoa(gcsIAMPolicyVersion)}}
+	if err := l.jsonRequest(ctx, http.MethodGet, "b/"+url.PathEscape(bucket)+"/iam", query, nil, &iamPolicy); err != nil {
+		logger.LogIf(ctx, err)
+		return nil, gcsToObjectError(err, bucket)
+	}
+	return &iamPolicy, nil
+}
+
+// mergePolicyBindings replaces the bindings and members the gateway owns
+// in the current bindings with the translated ones.
+func mergePolicyBindings(current, bindings []gcsIAMBinding) []gcsIAMBinding {
+	var merged []gcsIAMBinding
+	for _, binding := range current {
+		switch {
+		case !isGCSPolicyRole(binding.Role):
+		case binding.Condition != nil:
+			if isGCSPolicyBinding(binding) {
+				continue
+			}
+		default:
+			var members []string
+			for _, member := range binding.Members {
+				if !isGCSPublicMember(member) {
+					members = append(members, member)
+				}
+			}
+			if len(members) == 0 {
+				continue
+			}
+			binding.Members = members
+		}
+		merged = append(merged, binding)
+	}
+
+	for _, binding := range bindings {
+		if binding.Condition == nil {
+			// Join the unconditioned binding of the role kept above,
+			// IAM allows a single one per role.
+			i := 0
+			for ; i < len(merged); i++ {
+				if merged[i].Role == binding.Role && merged[i].Condition == nil {
+					break
+				}
+			}
+			if i < len(merged) {
+				merged[i].Members = append(merged[i].Members, binding.Members...)
+				continue
+			}
+		}
+		merged = append(merged, binding)
+	}
+	return merged
+}
+
+// setPolicyBindings replaces the bindings bucket policies translate to
+// in the IAM policy of the bucket, the etag of the policy read guards
+// against concurrent updates.
+func (l *gcsGateway) setPolicyBindings(ctx context.Context, bucket string, bindings []gcsIAMBinding) error {
+	iamPolicy, err := l.getIAMPolicy(ctx, bucket)
+	if err != nil {
+		return err
+	}
+
+	iamPolicy.Bindings = mergePolicyBindings(iamPolicy.Bindings, bindings)
+	iamPolicy.Version = gcsIAMPolicyVersion
+
+	err = l.jsonRequest(ctx, http.MethodPut, "b/"+url.PathEscape(bucket)+"/iam", nil, iamPolicy, nil)
+	logger.LogIf(ctx, err)
+	return gcsToObjectError(err, bucket)
+}
+
+// SetBucketPolicy translates the bucket policy into bindings of the
+// bucket IAM policy.
+func (l *gcsGateway) SetBucketPolicy(ctx context.Context, bucket string, bucketPolicy *policy.Policy) error {
+	t := gcsPolicyTranslator{bucket: bucket, principals: l.principals}
+	bindings, err := t.toBindings(bucketPolicy)
+	if err != nil {
+		return err
+	}
+	return l.setPolicyBindings(ctx, bucket, bindings)
+}
+
+// GetBucketPolicy returns the bucket policy equivalent to the bindings of
+// the bucket IAM policy.
+func (l *gcsGateway) GetBucketPolicy(ctx context.Context, bucket string) (*policy.Policy, error) {
+	iamPolicy, err := l.getIAMPolicy(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	t := gcsPolicyTranslator{bucket: bucket, principals: l.principals}
+	bucketPolicy := &policy.Policy{Version: policy.DefaultVersion}
+	for _, binding := range iamPolicy.Bindings {
+		if !isGCSPolicyRole(binding.Role) {
+			continue
+		}
+		if statement, ok := t.toStatement(binding); ok {
+			bucketPolicy.Statements = append(bucketPolicy.Statements, statement)
+		}
+	}
+	if len(bucketPolicy.Statements) == 0 {
+		return nil, minio.BucketPolicyNotFound{Bucket: bucket}
+	}
+	return bucketPolicy, nil
+}
+
+// DeleteBucketPolicy removes the bindings bucket policies translate to
+// from the bucket IAM policy.
+func (l *gcsGateway) DeleteBucketPolicy(ctx context.Context, bucket string) error {
+	return l.setPolicyBindings(ctx, bucket, nil)
+}
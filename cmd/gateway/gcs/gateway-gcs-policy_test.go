@@ -0,0 +1,236 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/minio/minio/pkg/bucket/policy"
+)
+
+func TestParseGCSPrincipals(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected map[string]string
+		success  bool
+	}{
+		{"", map[string]string{}, true},
+		{"arn:aws:iam::1:user/alice=user:alice@example.com", map[string]string{"arn:aws:iam::1:user/alice": "user:alice@example.com"}, true},
+		{"alice", nil, false},
+		{"alice=", nil, false},
+	}
+	for i, testCase := range testCases {
+		principals, err := parseGCSPrincipals(testCase.value)
+		if testCase.success != (err == nil) {
+			t.Fatalf("Test %d: Expected success %v, got error %v", i+1, testCase.success, err)
+		}
+		if err == nil && !reflect.DeepEqual(principals, testCase.expected) {
+			t.Errorf("Test %d: Expected %v, got %v", i+1, testCase.expected, principals)
+		}
+	}
+}
+
+func TestGCSPolicyToBindings(t *testing.T) {
+	translator := gcsPolicyTranslator{
+		bucket:     "bucket",
+		principals: map[string]string{"arn:aws:iam::1:user/alice": "user:alice@example.com"},
+	}
+	testCases := []struct {
+		policy   string
+		expected []gcsIAMBinding
+	}{
+		// Canned read-only policy.
+		{`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},
+"Action":["s3:GetBucketLocation","s3:ListBucket","s3:GetObject"],"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"]}]}`,
+			[]gcsIAMBinding{{Role: "roles/storage.objectViewer", Members: []string{"allUsers"}}}},
+		// Canned read-write policy.
+		{`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},
+"Action":["s3:GetBucketLocation","s3:ListBucket","s3:ListBucketMultipartUploads","s3:AbortMultipartUpload",
+"s3:DeleteObject","s3:GetObject","s3:ListMultipartUploadParts","s3:PutObject"],
+"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"]}]}`,
+			[]gcsIAMBinding{{Role: "roles/storage.objectAdmin", Members: []string{"allUsers"}}}},
+		// Prefix and time bounds.
+		{`{"Version":"2012-10-17","Statement":[{"Sid":"alice","Effect":"Allow","Principal":{"AWS":["arn:aws:iam::1:user/alice"]},
+"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/docs/*","arn:aws:s3:::bucket/README"],
+"Condition":{"DateLessThan":{"aws:CurrentTime":["2030-01-01T00:00:00Z"]}}}]}`,
+			[]gcsIAMBinding{{
+				Role:    "roles/storage.legacyObjectReader",
+				Members: []string{"user:alice@example.com"},
+				Condition: &gcsIAMCondition{
+					Title:       "minio-bucket-policy",
+					Description: "alice",
+					Expression: `(resource.name == "projects/_/buckets/bucket/objects/README" || ` +
+						`resource.name.startsWith("projects/_/buckets/bucket/objects/docs/")) && ` +
+						`request.time < timestamp("2030-01-01T00:00:00Z")`,
+				},
+			}}},
+		// Listing is not restricted to the prefix.
+		{`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["user:bob@example.com"]},
+"Action":["s3:ListBucket","s3:GetObject"],"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/docs/*"]}]}`,
+			[]gcsIAMBinding{{
+				Role:    "roles/storage.objectViewer",
+				Members: []string{"user:bob@example.com"},
+				Condition: &gcsIAMCondition{
+					Title: "minio-bucket-policy",
+					Expression: `resource.type == "storage.googleapis.com/Bucket" || ` +
+						`resource.name.startsWith("projects/_/buckets/bucket/objects/docs/")`,
+				},
+			}}},
+		// Members other than allUsers are bound under a condition.
+		{`{"Version":"2012-10-17","Statement":[{"Sid":"readers","Effect":"Allow","Principal":{"AWS":["*","user:bob@example.com"]},
+"Action":["s3:ListBucket","s3:GetObject"],"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"]}]}`,
+			[]gcsIAMBinding{
+				{Role: "roles/storage.objectViewer", Members: []string{"allUsers"}},
+				{
+					Role:    "roles/storage.objectViewer",
+					Members: []string{"user:bob@example.com"},
+					Condition: &gcsIAMCondition{
+						Title:       "minio-bucket-policy",
+						Description: "readers",
+						Expression: `resource.type == "storage.googleapis.com/Bucket" || ` +
+							`resource.name.startsWith("projects/_/buckets/bucket/objects/")`,
+					},
+				},
+			}},
+	}
+	for i, testCase := range testCases {
+		bucketPolicy, err := policy.ParseConfig(bytes.NewReader([]byte(testCase.policy)), "bucket")
+		if err != nil {
+			t.Fatalf("Test %d: %v", i+1, err)
+		}
+		bindings, err := translator.toBindings(bucketPolicy)
+		if err != nil {
+			t.Fatalf("Test %d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(bindings, testCase.expected) {
+			t.Fatalf("Test %d: Expected %#v, got %#v", i+1, testCase.expected, bindings)
+		}
+
+		// The bindings read back as an equivalent policy.
+		var readBack policy.Policy
+		for _, binding := range bindings {
+			statement, ok := translator.toStatement(binding)
+			if !ok {
+				t.Fatalf("Test %d: binding %#v does not read back", i+1, binding)
+			}
+			readBack.Statements = append(readBack.Statements, statement)
+		}
+		again, err := translator.toBindings(&readBack)
+		if err != nil || !reflect.DeepEqual(again, bindings) {
+			t.Errorf("Test %d: Expected %#v, got %#v, %v", i+1, bindings, again, err)
+		}
+	}
+}
+
+func TestGCSMergePolicyBindings(t *testing.T) {
+	translator := gcsPolicyTranslator{bucket: "bucket"}
+	foreign := []gcsIAMBinding{
+		{Role: "roles/storage.objectViewer", Members: []string{"projectViewer:project", "serviceAccount:gateway@project.iam.gserviceaccount.com"}},
+		{Role: "roles/storage.objectAdmin", Members: []string{"user:carol@example.com"}, Condition: &gcsIAMCondition{
+			Title:      "expires",
+			Expression: `request.time < timestamp("2030-01-01T00:00:00Z")`,
+		}},
+		{Role: "roles/storage.admin", Members: []string{"allUsers"}},
+	}
+
+	bucketPolicy, err := policy.ParseConfig(bytes.NewReader([]byte(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow",
+"Principal":{"AWS":["*","user:bob@example.com"]},"Action":["s3:ListBucket","s3:GetObject"],
+"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"]}]}`)), "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bindings, err := translator.toBindings(bucketPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Setting the policy joins allUsers to the foreign objectViewer binding.
+	merged := mergePolicyBindings(foreign, bindings)
+	expected := []gcsIAMBinding{
+		{Role: "roles/storage.objectViewer", Members: []string{"projectViewer:project", "serviceAccount:gateway@project.iam.gserviceaccount.com", "allUsers"}},
+		foreign[1],
+		foreign[2],
+		bindings[1],
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("Expected %#v, got %#v", expected, merged)
+	}
+
+	// Only the gateway members read back.
+	var statements int
+	for _, binding := range merged {
+		if !isGCSPolicyRole(binding.Role) {
+			continue
+		}
+		statement, ok := translator.toStatement(binding)
+		if !ok {
+			continue
+		}
+		statements++
+		principals := statement.Principal.AWS.ToSlice()
+		for _, principal := range principals {
+			if principal != "*" && principal != "user:bob@example.com" {
+				t.Errorf("Unexpected principal %s in %v", principal, principals)
+			}
+		}
+	}
+	if statements != 2 {
+		t.Errorf("Expected 2 statements, got %d", statements)
+	}
+
+	// Deleting the policy restores the foreign bindings.
+	if merged = mergePolicyBindings(merged, nil); !reflect.DeepEqual(merged, foreign) {
+		t.Errorf("Expected %#v, got %#v", foreign, merged)
+	}
+}
+
+func TestGCSPolicyToBindingsErrors(t *testing.T) {
+	translator := gcsPolicyTranslator{bucket: "bucket"}
+	testCases := []string{
+		// Deny statement.
+		`{"Version":"2012-10-17","Statement":[{"Effect":"Deny","Principal":{"AWS":["*"]},
+"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/*"]}]}`,
+		// Unmapped principal.
+		`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["arn:aws:iam::1:user/alice"]},
+"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/*"]}]}`,
+		// No role grants exactly these actions.
+		`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},
+"Action":["s3:PutObject","s3:DeleteObject"],"Resource":["arn:aws:s3:::bucket/*"]}]}`,
+		// Wildcard in the middle of the resource.
+		`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["user:bob@example.com"]},
+"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/*.txt"]}]}`,
+		// Anonymous access under a condition.
+		`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},
+"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/public/*"]}]}`,
+		// Condition with no IAM equivalent.
+		`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["user:bob@example.com"]},
+"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/*"],"Condition":{"IpAddress":{"aws:SourceIp":["10.0.0.0/8"]}}}]}`,
+	}
+	for i, testCase := range testCases {
+		bucketPolicy, err := policy.ParseConfig(bytes.NewReader([]byte(testCase)), "bucket")
+		if err != nil {
+			t.Fatalf("Test %d: %v", i+1, err)
+		}
+		if _, err = translator.toBindings(bucketPolicy); err == nil {
+			t.Errorf("Test %d: Expected an error", i+1)
+		} else if _, ok := err.(policy.Error); !ok {
+			t.Errorf("Test %d: Expected a policy error, got %#v", i+1, err)
+		}
+	}
+}
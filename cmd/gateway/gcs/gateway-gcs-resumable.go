@@ -0,0 +1,311 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/crypto"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/hash"
+	"google.golang.org/api/googleapi"
+	raw "google.golang.org/api/storage/v1"
+)
+
+// Parts of multipart uploads are written through GCS resumable upload
+// sessions. The session URI of each part is saved in the multipart meta
+// file before any data is sent, so a retry of the part, on this gateway
+// or on another one sharing the bucket, asks GCS how much of the part is
+// already committed and only sends the rest. The whole part is still read
+// from the client, the committed bytes are hashed and skipped, and the MD5
+// GCS computed for the part is checked against the one of the retry.
+
+const (
+	// Base URL of the GCS JSON API upload endpoint.
+	gcsUploadEndpoint = "https://www.googleapis.com/upload/storage/v1/"
+
+	// Size of the chunks sent to resumable upload sessions, GCS requires a
+	// multiple of 256KiB for all chunks but the last.
+	gcsResumableChunkSize = 8 * 1024 * 1024
+
+	// Number of times the multipart meta file is read and written again
+	// when parts uploaded in parallel save their sessions concurrently.
+	gcsMultipartMetaUpdateRetries = 10
+)
+
+// gcsMultipartSession is the resumable upload session of a part.
+type gcsMultipartSession struct {
+	URI  string `json:"uri"`  // Session URI
+	ETag string `json:"etag"` // ETag the part object is named after
+	Size int64  `json:"size"` // Size of the part
+}
+
+// startResumableUpload starts a resumable upload session for the object
+// and returns its URI. size is -1 when unknown.
+func (l *gcsGateway) startResumableUpload(ctx context.Context, bucket, object string, size int64) (string, error) {
+	query := url.Values{"uploadType": []string{"resumable"}, "name": []string{object}}
+	body, err := json.Marshal(map[string]string{"name": object})
+	if err != nil {
+		return "", err
+	}
+	u := gcsUploadEndpoint + "b/" + url.PathEscape(bucket) + "/o?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if size >= 0 {
+		req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err = googleapi.CheckResponse(resp); err != nil {
+		return "", err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	uri := resp.Header.Get("Location")
+	if uri == "" {
+		return "", fmt.Errorf("no session URI in the response to starting a resumable upload of %s", object)
+	}
+	return uri, nil
+}
+
+// parseGCSCommittedRange returns the offset following the bytes GCS
+// committed, as reported in the Range header of an incomplete session.
+func parseGCSCommittedRange(r string) (int64, error) {
+	if r == "" {
+		return 0, nil
+	}
+	end := strings.TrimPrefix(r, "bytes=0-")
+	if end == r {
+		return 0, fmt.Errorf("unexpected committed range %q", r)
+	}
+	n, err := strconv.ParseInt(end, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected committed range %q", r)
+	}
+	return n + 1, nil
+}
+
+// putResumableChunk sends the chunk at offset to the session, the session
+// is finalized when last is set. Returns the object once finalized, or
+// the offset GCS expects next.
+func (l *gcsGateway) putResumableChunk(ctx context.Context, uri string, chunk []byte, offset int64, last bool) (*raw.Object, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uri, bytes.NewReader(chunk))
+	if err != nil {
+		return nil, 0, err
+	}
+	total := "*"
+	if last {
+		total = strconv.FormatInt(offset+int64(len(chunk)), 10)
+	}
+	if len(chunk) == 0 {
+		req.Header.Set("Content-Range", "bytes */"+total)
+	} else {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", offset, offset+int64(len(chunk))-1, total))
+	}
+
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPermanentRedirect {
+		io.Copy(ioutil.Discard, resp.Body)
+		next, err := parseGCSCommittedRange(resp.Header.Get("Range"))
+		return nil, next, err
+	}
+	if err = googleapi.CheckResponse(resp); err != nil {
+		return nil, 0, err
+	}
+	var obj raw.Object
+	if err = json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+		return nil, 0, err
+	}
+	return &obj, 0, nil
+}
+
+// resumableOffset returns the offset following the bytes committed to
+// the session, done is set when the session is already finalized.
+func (l *gcsGateway) resumableOffset(ctx context.Context, uri string) (offset int64, done bool, err error) {
+	obj, offset, err := l.putResumableChunk(ctx, uri, nil, 0, false)
+	return offset, obj != nil, err
+}
+
+// resumeUpload sends the data following offset to the session, in chunks
+// resent from where GCS stopped committing them, and finalizes it. size
+// is the total size of the object, -1 when unknown.
+func (l *gcsGateway) resumeUpload(ctx context.Context, uri string, r io.Reader, offset, size int64) (*raw.Object, error) {
+	buf := make([]byte, gcsResumableChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return nil, err
+		}
+		if size >= 0 && offset+int64(n) >= size {
+			last = true
+		}
+
+		chunk := buf[:n]
+		for {
+			obj, next, err := l.putResumableChunk(ctx, uri, chunk, offset, last)
+			if err != nil {
+				return nil, err
+			}
+			if obj != nil {
+				return obj, nil
+			}
+			if next <= offset && (len(chunk) == 0 || next < offset) || next > offset+int64(len(chunk)) {
+				return nil, fmt.Errorf("resumable upload committed %d bytes, expected %d to %d", next, offset, offset+int64(len(chunk)))
+			}
+			chunk, offset = chunk[next-offset:], next
+			if len(chunk) == 0 && !last {
+				break
+			}
+		}
+	}
+}
+
+// readMultipartMeta reads the live multipart meta file, and returns the
+// generation read. Parts uploaded in parallel replace the file, so the
+// generation of attributes read earlier may no longer exist.
+func (l *gcsGateway) readMultipartMeta(ctx context.Context, attrs *storage.ObjectAttrs) (meta gcsMultipartMetaV1, generation int64, err error) {
+	r, err := l.client.Bucket(attrs.Bucket).Object(attrs.Name).NewReader(ctx)
+	if err != nil {
+		return meta, 0, err
+	}
+	defer r.Close()
+	err = json.NewDecoder(r).Decode(&meta)
+	return meta, r.Attrs.Generation, err
+}
+
+// saveMultipartSession saves the session of the part in the multipart
+// meta file, which is only replaced if it did not change since it was
+// read, and read again otherwise.
+func (l *gcsGateway) saveMultipartSession(ctx context.Context, attrs *storage.ObjectAttrs, partNumber int, session gcsMultipartSession) error {
+	for i := 0; ; i++ {
+		meta, generation, err := l.readMultipartMeta(ctx, attrs)
+		if err != nil {
+			return err
+		}
+		if meta.Sessions == nil {
+			meta.Sessions = make(map[string]gcsMultipartSession)
+		}
+		meta.Sessions[strconv.Itoa(partNumber)] = session
+
+		handle := l.client.Bucket(attrs.Bucket).Object(attrs.Name)
+		w := handle.If(storage.Conditions{GenerationMatch: generation}).NewWriter(ctx)
+		w.ContentType = attrs.ContentType
+		w.ContentEncoding = attrs.ContentEncoding
+		w.CacheControl = attrs.CacheControl
+		w.ContentDisposition = attrs.ContentDisposition
+		w.ContentLanguage = attrs.ContentLanguage
+		w.Metadata = attrs.Metadata
+		if err = json.NewEncoder(w).Encode(meta); err != nil {
+			w.Close()
+			return err
+		}
+		err = w.Close()
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusPreconditionFailed && i < gcsMultipartMetaUpdateRetries {
+			continue
+		}
+		return err
+	}
+}
+
+// uploadPart writes the part through a resumable upload session, resuming
+// the session saved for the part when it is retried with the same data.
+// etag is the MD5 of the part sent by the client, if any.
+func (l *gcsGateway) uploadPart(ctx context.Context, metaAttrs *storage.ObjectAttrs, uploadID string, partNumber int,
+	data *hash.Reader, etag string) (string, error) {
+	bucket, size := metaAttrs.Bucket, data.Size()
+	meta, _, err := l.readMultipartMeta(ctx, metaAttrs)
+	if err != nil {
+		return "", err
+	}
+
+	// Parts encrypted by the gateway differ on every retry, they are
+	// always uploaded again.
+	_, encrypted := crypto.IsEncrypted(metaAttrs.Metadata)
+
+	var offset int64
+	session, ok := meta.Sessions[strconv.Itoa(partNumber)]
+	if ok && !encrypted && session.Size == size && (etag == "" || etag == session.ETag) {
+		var done bool
+		offset, done, err = l.resumableOffset(ctx, session.URI)
+		if err != nil || done {
+			// Expired sessions, or parts written completely before
+			// the retry, are uploaded again.
+			logger.LogIf(ctx, err)
+			ok, offset = false, 0
+		}
+	} else {
+		ok = false
+	}
+	if !ok {
+		if etag == "" {
+			// Generate random ETag.
+			etag = minio.GenETag()
+		}
+		session = gcsMultipartSession{ETag: etag, Size: size}
+		session.URI, err = l.startResumableUpload(ctx, bucket, gcsMultipartDataName(uploadID, partNumber, etag), size)
+		if err != nil {
+			return "", err
+		}
+		if err = l.saveMultipartSession(ctx, metaAttrs, partNumber, session); err != nil {
+			return "", err
+		}
+	}
+
+	// The committed bytes are read for the MD5 of the part.
+	if _, err = io.CopyN(ioutil.Discard, data, offset); err != nil {
+		return "", err
+	}
+	obj, err := l.resumeUpload(ctx, session.URI, data, offset, size)
+	if err != nil {
+		return "", err
+	}
+
+	if md5sum, err := base64.StdEncoding.DecodeString(obj.Md5Hash); err == nil && len(md5sum) > 0 &&
+		!bytes.Equal(md5sum, data.MD5Current()) {
+		// The committed bytes differ from the retry.
+		l.client.Bucket(bucket).Object(obj.Name).Generation(obj.Generation).Delete(ctx)
+		return "", hash.BadDigest{
+			ExpectedMD5:   hex.EncodeToString(md5sum),
+			CalculatedMD5: hex.EncodeToString(data.MD5Current()),
+		}
+	}
+	return session.ETag, nil
+}
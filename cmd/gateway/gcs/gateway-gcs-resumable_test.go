@@ -0,0 +1,282 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/minio/minio/pkg/hash"
+	"google.golang.org/api/option"
+)
+
+func TestParseGCSCommittedRange(t *testing.T) {
+	testCases := []struct {
+		header string
+		offset int64
+		ok     bool
+	}{
+		{"", 0, true},
+		{"bytes=0-0", 1, true},
+		{"bytes=0-262143", 262144, true},
+		{"bytes=1-10", 0, false},
+		{"bytes=0-", 0, false},
+	}
+	for i, testCase := range testCases {
+		offset, err := parseGCSCommittedRange(testCase.header)
+		if testCase.ok != (err == nil) {
+			t.Errorf("Test %d: unexpected error %v", i+1, err)
+		}
+		if offset != testCase.offset {
+			t.Errorf("Test %d: expected offset %d, got %d", i+1, testCase.offset, offset)
+		}
+	}
+}
+
+// fakeGCSSession is a resumable upload session committing at most limit
+// bytes of each chunk.
+type fakeGCSSession struct {
+	data  []byte
+	limit int
+	done  bool
+}
+
+func (s *fakeGCSSession) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	var start int
+	var total string
+	if n, _ := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-", &start); n == 1 {
+		if start != len(s.data) {
+			http.Error(w, "bad offset", http.StatusBadRequest)
+			return
+		}
+		if len(body) > s.limit {
+			body = body[:s.limit]
+		}
+		s.data = append(s.data, body...)
+	}
+	total = r.Header.Get("Content-Range")[strings.LastIndex(r.Header.Get("Content-Range"), "/")+1:]
+	if total != "*" && strconv.Itoa(len(s.data)) == total {
+		s.done = true
+	}
+	if s.done {
+		fmt.Fprintf(w, `{"name":"object","size":"%d"}`, len(s.data))
+		return
+	}
+	if len(s.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(s.data)-1))
+	}
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
+
+func TestGCSResumeUpload(t *testing.T) {
+	data := bytes.Repeat([]byte("minio"), gcsResumableChunkSize/2)
+	l := &gcsGateway{httpClient: http.DefaultClient}
+	ctx := context.Background()
+
+	// Chunks are resent from where the session stopped committing.
+	session := &fakeGCSSession{limit: gcsResumableChunkSize / 2}
+	server := httptest.NewServer(session)
+	defer server.Close()
+	obj, err := l.resumeUpload(ctx, server.URL, bytes.NewReader(data), 0, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Name != "object" || !bytes.Equal(session.data, data) {
+		t.Fatalf("Expected %d bytes uploaded, got %d", len(data), len(session.data))
+	}
+
+	// Interrupted sessions continue from the committed offset.
+	session = &fakeGCSSession{data: data[:gcsResumableChunkSize], limit: len(data)}
+	server = httptest.NewServer(session)
+	defer server.Close()
+	offset, done, err := l.resumableOffset(ctx, server.URL)
+	if err != nil || done || offset != gcsResumableChunkSize {
+		t.Fatalf("Expected offset %d, got %d, %v, %v", gcsResumableChunkSize, offset, done, err)
+	}
+	if _, err = l.resumeUpload(ctx, server.URL, bytes.NewReader(data[offset:]), offset, int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(session.data, data) {
+		t.Fatalf("Expected %d bytes uploaded, got %d", len(data), len(session.data))
+	}
+	if _, done, _ = l.resumableOffset(ctx, server.URL); !done {
+		t.Fatal("Expected a finalized session")
+	}
+
+	// Objects of unknown size are finalized with an empty chunk.
+	session = &fakeGCSSession{limit: len(data)}
+	server = httptest.NewServer(session)
+	defer server.Close()
+	if _, err = l.resumeUpload(ctx, server.URL, bytes.NewReader(data[:gcsResumableChunkSize]), 0, -1); err != nil {
+		t.Fatal(err)
+	}
+	if !session.done || len(session.data) != gcsResumableChunkSize {
+		t.Fatalf("Expected a finalized session of %d bytes, got %d", gcsResumableChunkSize, len(session.data))
+	}
+}
+
+// fakeGCSObject is an object of fakeGCSServer.
+type fakeGCSObject struct {
+	data       []byte
+	generation int64
+}
+
+// fakeGCSServer serves object reads, conditional multipart uploads and
+// resumable upload sessions of a single bucket.
+type fakeGCSServer struct {
+	mu         sync.Mutex
+	generation int64
+	objects    map[string]*fakeGCSObject
+	sessions   map[string]*fakeGCSSession
+}
+
+func (s *fakeGCSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/session/"):
+		s.sessions[strings.TrimPrefix(r.URL.Path, "/session/")].ServeHTTP(w, r)
+	case r.Method == http.MethodPost && r.URL.Query().Get("uploadType") == "resumable":
+		id := strconv.Itoa(len(s.sessions))
+		s.sessions[id] = &fakeGCSSession{limit: gcsResumableChunkSize}
+		w.Header().Set("Location", "http://"+r.Host+"/session/"+id)
+	case r.Method == http.MethodPost && r.URL.Query().Get("uploadType") == "multipart":
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		var attrs struct {
+			Name string `json:"name"`
+		}
+		part, err := mr.NextPart()
+		if err == nil {
+			err = json.NewDecoder(part).Decode(&attrs)
+		}
+		if err == nil {
+			part, err = mr.NextPart()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := ioutil.ReadAll(part)
+		if match := r.URL.Query().Get("ifGenerationMatch"); match != "" {
+			var generation int64
+			if obj, ok := s.objects[attrs.Name]; ok {
+				generation = obj.generation
+			}
+			if match != strconv.FormatInt(generation, 10) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				fmt.Fprint(w, `{"error":{"code":412,"message":"conditionNotMet"}}`)
+				return
+			}
+		}
+		s.generation++
+		s.objects[attrs.Name] = &fakeGCSObject{data: data, generation: s.generation}
+		fmt.Fprintf(w, `{"bucket":"bucket","name":%q,"generation":"%d","size":"%d"}`, attrs.Name, s.generation, len(data))
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/bucket/"):
+		obj, ok := s.objects[strings.TrimPrefix(r.URL.Path, "/bucket/")]
+		if generation := r.URL.Query().Get("generation"); ok && generation != "" && generation != strconv.FormatInt(obj.generation, 10) {
+			ok = false
+		}
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-Goog-Generation", strconv.FormatInt(obj.generation, 10))
+		w.Header().Set("X-Goog-Metageneration", "1")
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Write(obj.data)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestGCSUploadPartsConcurrently(t *testing.T) {
+	fake := &fakeGCSServer{
+		generation: 1,
+		objects:    make(map[string]*fakeGCSObject),
+		sessions:   make(map[string]*fakeGCSSession),
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	transport, err := newGCSEndpointTransport(server.URL, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	httpClient := &http.Client{Transport: transport}
+	client, err := storage.NewClient(ctx, option.WithHTTPClient(httpClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &gcsGateway{client: client, httpClient: httpClient}
+
+	uploadID := "upload"
+	meta, _ := json.Marshal(gcsMultipartMetaV1{Version: gcsMinioMultipartMetaCurrentVersion, Bucket: "bucket", Object: "object"})
+	fake.objects[gcsMultipartMetaName(uploadID)] = &fakeGCSObject{data: meta, generation: 1}
+	// Every part starts from the attributes read before any was saved.
+	metaAttrs := &storage.ObjectAttrs{Bucket: "bucket", Name: gcsMultipartMetaName(uploadID), Generation: 1}
+
+	const parts = 8
+	var wg sync.WaitGroup
+	errs := make([]error, parts)
+	for i := 0; i < parts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := []byte(fmt.Sprintf("part %d", i+1))
+			r, err := hash.NewReader(bytes.NewReader(data), int64(len(data)), "", "", int64(len(data)))
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			_, errs[i] = l.uploadPart(ctx, metaAttrs, uploadID, i+1, r, "")
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Part %d: %v", i+1, err)
+		}
+	}
+
+	saved, _, err := l.readMultipartMeta(ctx, metaAttrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Sessions) != parts {
+		t.Errorf("Expected %d saved sessions, got %d", parts, len(saved.Sessions))
+	}
+}
@@ -0,0 +1,104 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// gcsStorageClassTable translates between S3 and GCS storage classes.
+type gcsStorageClassTable struct {
+	toGCS map[string]string
+	toS3  map[string]string
+}
+
+// S3 storage classes are mapped to the GCS class with the closest access
+// pattern by default, GCS classes read back as the first S3 class mapped
+// to them. MinIO server only accepts STANDARD and REDUCED_REDUNDANCY in
+// x-amz-storage-class, the other classes are reached by lifecycle
+// transitions.
+var gcsDefaultStorageClasses = [][2]string{
+	{"STANDARD", "STANDARD"},
+	{"STANDARD_IA", "NEARLINE"},
+	{"ONEZONE_IA", "NEARLINE"},
+	{"REDUCED_REDUNDANCY", "NEARLINE"},
+	{"GLACIER", "COLDLINE"},
+	{"DEEP_ARCHIVE", "ARCHIVE"},
+}
+
+// newGCSStorageClassTable returns the default storage class table with
+// the overrides applied.
+func newGCSStorageClassTable(overrides [][2]string) *gcsStorageClassTable {
+	t := &gcsStorageClassTable{
+		toGCS: make(map[string]string),
+		toS3: map[string]string{
+			// Legacy classes are served like STANDARD.
+			"MULTI_REGIONAL":               "STANDARD",
+			"REGIONAL":                     "STANDARD",
+			"DURABLE_REDUCED_AVAILABILITY": "STANDARD",
+		},
+	}
+	classes := append(append([][2]string{}, gcsDefaultStorageClasses...), overrides...)
+	for _, c := range classes {
+		t.toGCS[c[0]] = c[1]
+	}
+	for _, c := range classes {
+		s3Class, gcsClass := c[0], c[1]
+		if t.toGCS[s3Class] != gcsClass {
+			// Overridden.
+			continue
+		}
+		if cur, ok := t.toS3[gcsClass]; ok && t.toGCS[cur] == gcsClass {
+			continue
+		}
+		t.toS3[gcsClass] = s3Class
+	}
+	return t
+}
+
+// parseGCSStorageClasses parses the storage class table, given as comma
+// separated "S3CLASS=GCSCLASS" pairs which override the default mapping.
+func parseGCSStorageClasses(s string) (*gcsStorageClassTable, error) {
+	var overrides [][2]string
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i <= 0 || i == len(kv)-1 {
+			return nil, fmt.Errorf("invalid storage class mapping %q, expected S3CLASS=GCSCLASS", kv)
+		}
+		overrides = append(overrides, [2]string{strings.ToUpper(kv[:i]), strings.ToUpper(kv[i+1:])})
+	}
+	return newGCSStorageClassTable(overrides), nil
+}
+
+// GCS returns the GCS storage class of the S3 storage class, empty for
+// the bucket default class.
+func (t *gcsStorageClassTable) GCS(s3Class string) string {
+	return t.toGCS[strings.ToUpper(s3Class)]
+}
+
+// S3 returns the S3 storage class of the GCS storage class.
+func (t *gcsStorageClassTable) S3(gcsClass string) string {
+	if s3Class, ok := t.toS3[gcsClass]; ok {
+		return s3Class
+	}
+	return gcsClass
+}
@@ -0,0 +1,111 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/minio/minio-go/v7/pkg/tags"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// Metadata key holding the S3 tags of an object, URL query encoded
+	// as in x-amz-tagging. User metadata is always stored with the
+	// x-goog-meta- prefix, so the key cannot collide with it.
+	gcsTagsMetaKey = "minio-tags"
+
+	// Number of times a tags update is retried when the metadata of the
+	// object changed between reading and patching it.
+	gcsTagsUpdateRetries = 3
+)
+
+// updateObjectTags sets the tags of the object, removes them if tagStr
+// is empty. The metadata patch only applies to the generation and
+// metageneration read, so concurrent updates of the object are not lost.
+func (l *gcsGateway) updateObjectTags(ctx context.Context, bucket, object, tagStr string, opts minio.ObjectOptions) error {
+	var value *string
+	if tagStr != "" {
+		value = &tagStr
+	}
+	body := map[string]interface{}{
+		"metadata": map[string]*string{gcsTagsMetaKey: value},
+	}
+
+	for i := 0; ; i++ {
+		handle, err := l.objectHandle(ctx, bucket, object, opts.VersionID)
+		if err != nil {
+			return err
+		}
+		attrs, err := handle.Attrs(ctx)
+		if err != nil {
+			logger.LogIf(ctx, err)
+			return gcsToObjectVersionError(err, bucket, object, opts.VersionID)
+		}
+
+		query := url.Values{}
+		query.Set("generation", strconv.FormatInt(attrs.Generation, 10))
+		query.Set("ifGenerationMatch", strconv.FormatInt(attrs.Generation, 10))
+		query.Set("ifMetagenerationMatch", strconv.FormatInt(attrs.Metageneration, 10))
+		path := "b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(object)
+		err = l.jsonRequest(ctx, http.MethodPatch, path, query, body, nil)
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusPreconditionFailed && i < gcsTagsUpdateRetries {
+			continue
+		}
+		logger.LogIf(ctx, err)
+		return gcsToObjectError(err, bucket, object)
+	}
+}
+
+// GetObjectTags returns the tags of the object.
+func (l *gcsGateway) GetObjectTags(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (*tags.Tags, error) {
+	objInfo, err := l.GetObjectInfo(ctx, bucket, object, opts)
+	if err != nil {
+		return nil, err
+	}
+	return tags.ParseObjectTags(objInfo.UserTags)
+}
+
+// PutObjectTags replaces the tags of the object.
+func (l *gcsGateway) PutObjectTags(ctx context.Context, bucket, object string, tagStr string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	tagObj, err := tags.ParseObjectTags(tagStr)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	if err = l.updateObjectTags(ctx, bucket, object, tagObj.String(), opts); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	return l.GetObjectInfo(ctx, bucket, object, opts)
+}
+
+// DeleteObjectTags removes the tags of the object.
+func (l *gcsGateway) DeleteObjectTags(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	if err := l.updateObjectTags(ctx, bucket, object, "", opts); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	return l.GetObjectInfo(ctx, bucket, object, opts)
+}
+
+// IsTaggingSupported returns whether object tagging is implemented for this layer.
+func (l *gcsGateway) IsTaggingSupported() bool {
+	return true
+}
@@ -0,0 +1,58 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestGCSObjectTagsMeta(t *testing.T) {
+	l := &gcsGateway{storageClasses: newGCSStorageClassTable(nil)}
+	// User metadata named like the tags key does not collide with it.
+	headers := map[string]string{
+		"X-Amz-Tagging":         "project=ming&team=gateway",
+		"X-Amz-Meta-Minio-Tags": "value",
+	}
+	expectedMeta := map[string]string{
+		gcsTagsMetaKey:           "project=ming&team=gateway",
+		"x-goog-meta-Minio-Tags": "value",
+	}
+
+	var attrs storage.ObjectAttrs
+	l.applyMetadataToGCSAttrs(headers, &attrs)
+	if !reflect.DeepEqual(attrs.Metadata, expectedMeta) {
+		t.Fatalf("Expected %#v, got %#v", expectedMeta, attrs.Metadata)
+	}
+
+	objInfo := l.fromGCSAttrsToObjectInfo(&attrs)
+	if objInfo.UserTags != headers["X-Amz-Tagging"] {
+		t.Errorf("Expected tags %s, got %s", headers["X-Amz-Tagging"], objInfo.UserTags)
+	}
+	expectedUserDefined := map[string]string{"X-Amz-Meta-Minio-Tags": "value"}
+	if !reflect.DeepEqual(objInfo.UserDefined, expectedUserDefined) {
+		t.Errorf("Expected %#v, got %#v", expectedUserDefined, objInfo.UserDefined)
+	}
+
+	// Objects without tags.
+	l.applyMetadataToGCSAttrs(map[string]string{"X-Amz-Tagging": ""}, &attrs)
+	if len(attrs.Metadata) != 0 || l.fromGCSAttrsToObjectInfo(&attrs).UserTags != "" {
+		t.Errorf("Expected no tags, got %#v", attrs.Metadata)
+	}
+}
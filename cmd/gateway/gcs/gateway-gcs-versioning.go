@@ -0,0 +1,371 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	ming "github.com/minio/ming/cmd"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"google.golang.org/api/iterator"
+)
+
+// S3 versions map onto GCS object generations. GCS keeps the overwritten
+// and deleted generations of objects of versioned buckets as noncurrent
+// generations, the version ID of an object is derived from its generation.
+// S3 requires version IDs to be UUIDs, the generation is kept in the last
+// 8 bytes of one.
+//
+// GCS has no delete markers, an object whose newest generation is
+// noncurrent was deleted and is listed with a delete marker on top of
+// its generations. Deleting that delete marker restores the newest
+// generation as a new live generation.
+
+const (
+	gcsVersionKindObject       = 0
+	gcsVersionKindDeleteMarker = 1
+
+	// Version ID of objects written while versioning was not enabled.
+	gcsNullVersionID = "null"
+)
+
+// gcsVersionID returns the S3 version ID of the generation, or of the
+// delete marker on top of it.
+func gcsVersionID(generation int64, deleteMarker bool) string {
+	var b [16]byte
+	if deleteMarker {
+		binary.BigEndian.PutUint64(b[:8], gcsVersionKindDeleteMarker)
+	}
+	binary.BigEndian.PutUint64(b[8:], uint64(generation))
+	s := hex.EncodeToString(b[:])
+	return fmt.Sprintf("%s-%s-%s-%s-%s", s[:8], s[8:12], s[12:16], s[16:20], s[20:])
+}
+
+// parseGCSVersionID returns the generation of the S3 version ID and
+// whether it is the one of a delete marker.
+func parseGCSVersionID(versionID string) (generation int64, deleteMarker bool, err error) {
+	b, err := hex.DecodeString(strings.Replace(versionID, "-", "", 4))
+	if err != nil || len(b) != 16 {
+		return 0, false, errInvalidGCSVersionID
+	}
+	switch binary.BigEndian.Uint64(b[:8]) {
+	case gcsVersionKindObject:
+	case gcsVersionKindDeleteMarker:
+		deleteMarker = true
+	default:
+		return 0, false, errInvalidGCSVersionID
+	}
+	generation = int64(binary.BigEndian.Uint64(b[8:]))
+	if generation <= 0 {
+		return 0, false, errInvalidGCSVersionID
+	}
+	return generation, deleteMarker, nil
+}
+
+// isGCSVersioned returns true if the version ID names a specific version
+// rather than the latest one.
+func isGCSVersioned(versionID string) bool {
+	return versionID != "" && versionID != gcsNullVersionID
+}
+
+// gcsVersionsToObjectInfos returns the versions of an object, newest
+// first, from its generations, adding a delete marker if the newest
+// generation is noncurrent.
+func (l *gcsGateway) gcsVersionsToObjectInfos(generations []*storage.ObjectAttrs) []minio.ObjectInfo {
+	if len(generations) == 0 {
+		return nil
+	}
+	sort.Slice(generations, func(i, j int) bool {
+		return generations[i].Generation > generations[j].Generation
+	})
+
+	latest := generations[0]
+	deleted := !latest.Deleted.IsZero()
+	numVersions := len(generations)
+	if deleted {
+		numVersions++
+	}
+
+	infos := make([]minio.ObjectInfo, 0, numVersions)
+	if deleted {
+		infos = append(infos, minio.ObjectInfo{
+			Bucket:       latest.Bucket,
+			Name:         latest.Name,
+			ModTime:      latest.Deleted,
+			VersionID:    gcsVersionID(latest.Generation, true),
+			IsLatest:     true,
+			DeleteMarker: true,
+			NumVersions:  numVersions,
+		})
+	}
+	for i, attrs := range generations {
+		info := l.fromGCSAttrsToObjectInfo(attrs)
+		info.VersionID = gcsVersionID(attrs.Generation, false)
+		info.IsLatest = i == 0 && !deleted
+		info.NumVersions = numVersions
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// objectGenerations returns all generations of the object.
+func (l *gcsGateway) objectGenerations(ctx context.Context, bucket, object string) ([]*storage.ObjectAttrs, error) {
+	it := l.client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: object, Versions: true})
+	var generations []*storage.ObjectAttrs
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return generations, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The object sorts before all other names it prefixes.
+		if attrs.Name != object {
+			return generations, nil
+		}
+		generations = append(generations, attrs)
+	}
+}
+
+// isDeleteMarker returns true if the delete marker on top of the
+// generation is the latest version of the object.
+func (l *gcsGateway) isDeleteMarker(ctx context.Context, bucket, object string, generation int64) (bool, error) {
+	generations, err := l.objectGenerations(ctx, bucket, object)
+	if err != nil {
+		return false, err
+	}
+	infos := l.gcsVersionsToObjectInfos(generations)
+	return len(infos) > 0 && infos[0].DeleteMarker && infos[0].VersionID == gcsVersionID(generation, true), nil
+}
+
+// objectHandle returns the handle of the requested version of the object,
+// a delete marker cannot be read.
+func (l *gcsGateway) objectHandle(ctx context.Context, bucket, object, versionID string) (*storage.ObjectHandle, error) {
+	handle := l.client.Bucket(bucket).Object(object)
+	if !isGCSVersioned(versionID) {
+		return handle, nil
+	}
+	generation, deleteMarker, err := parseGCSVersionID(versionID)
+	if err != nil {
+		return nil, minio.VersionNotFound{Bucket: bucket, Object: object, VersionID: versionID}
+	}
+	if deleteMarker {
+		isMarker, err := l.isDeleteMarker(ctx, bucket, object, generation)
+		if err != nil {
+			return nil, gcsToObjectError(err, bucket, object)
+		}
+		if isMarker {
+			return nil, minio.MethodNotAllowed{Bucket: bucket, Object: object, VersionID: versionID}
+		}
+		return nil, minio.VersionNotFound{Bucket: bucket, Object: object, VersionID: versionID}
+	}
+	return handle.Generation(generation), nil
+}
+
+// gcsToObjectVersionError converts the error of an operation on a version
+// of an object.
+func gcsToObjectVersionError(err error, bucket, object, versionID string) error {
+	err = gcsToObjectError(err, bucket, object)
+	if _, ok := err.(minio.ObjectNotFound); ok && isGCSVersioned(versionID) {
+		return minio.VersionNotFound{Bucket: bucket, Object: object, VersionID: versionID}
+	}
+	return err
+}
+
+// fromGCSAttrsToWrittenObjectInfo converts the attributes of an object
+// just written to gateway ObjectInfo, with the version ID of its
+// generation in versioned buckets.
+func (l *gcsGateway) fromGCSAttrsToWrittenObjectInfo(ctx context.Context, attrs *storage.ObjectAttrs) (minio.ObjectInfo, error) {
+	objInfo := l.fromGCSAttrsToObjectInfo(attrs)
+	bucketAttrs, err := l.bucketAttrs(ctx, attrs.Bucket)
+	if err != nil {
+		return objInfo, err
+	}
+	if bucketAttrs.VersioningEnabled {
+		objInfo.VersionID = gcsVersionID(attrs.Generation, false)
+		objInfo.IsLatest = true
+	}
+	return objInfo, nil
+}
+
+// deleteLatestObjectVersion deletes the live generation of the object of
+// a versioned bucket, which GCS keeps as a noncurrent generation, and
+// returns the delete marker listed on top of it.
+func (l *gcsGateway) deleteLatestObjectVersion(ctx context.Context, bucket, object string) (minio.ObjectInfo, error) {
+	handle := l.client.Bucket(bucket).Object(object)
+	attrs, err := handle.Attrs(ctx)
+	if err != nil {
+		logger.LogIf(ctx, err)
+		return minio.ObjectInfo{}, gcsToObjectError(err, bucket, object)
+	}
+	// The generation must still be live for the delete marker to be on
+	// top of it.
+	err = handle.If(storage.Conditions{GenerationMatch: attrs.Generation}).Delete(ctx)
+	if err != nil {
+		logger.LogIf(ctx, err)
+		return minio.ObjectInfo{}, gcsToObjectError(err, bucket, object)
+	}
+	return minio.ObjectInfo{
+		Bucket:       bucket,
+		Name:         object,
+		VersionID:    gcsVersionID(attrs.Generation, true),
+		DeleteMarker: true,
+		IsLatest:     true,
+	}, nil
+}
+
+// deleteObjectVersion permanently deletes a generation of the object, or
+// removes its delete marker by restoring the newest generation.
+func (l *gcsGateway) deleteObjectVersion(ctx context.Context, bucket, object, versionID string) (minio.ObjectInfo, error) {
+	objInfo := minio.ObjectInfo{Bucket: bucket, Name: object, VersionID: versionID}
+	generation, deleteMarker, err := parseGCSVersionID(versionID)
+	if err != nil {
+		return objInfo, minio.VersionNotFound{Bucket: bucket, Object: object, VersionID: versionID}
+	}
+
+	handle := l.client.Bucket(bucket).Object(object)
+	if !deleteMarker {
+		if err = handle.Generation(generation).Delete(ctx); err != nil {
+			logger.LogIf(ctx, err)
+			return objInfo, gcsToObjectVersionError(err, bucket, object, versionID)
+		}
+		return objInfo, nil
+	}
+
+	objInfo.DeleteMarker = true
+	isMarker, err := l.isDeleteMarker(ctx, bucket, object, generation)
+	if err != nil {
+		return objInfo, gcsToObjectError(err, bucket, object)
+	}
+	if !isMarker {
+		// Only the latest delete marker exists.
+		return objInfo, minio.VersionNotFound{Bucket: bucket, Object: object, VersionID: versionID}
+	}
+	copier := handle.If(storage.Conditions{DoesNotExist: true}).CopierFrom(handle.Generation(generation))
+	if _, err = copier.Run(ctx); err != nil {
+		logger.LogIf(ctx, err)
+		return objInfo, gcsToObjectError(err, bucket, object)
+	}
+	return objInfo, nil
+}
+
+// ListObjectVersions lists the generations of the objects of the bucket.
+func (l *gcsGateway) ListObjectVersions(ctx context.Context, bucket, prefix, marker, versionMarker, delimiter string, maxKeys int) (minio.ListObjectVersionsInfo, error) {
+	var result minio.ListObjectVersionsInfo
+	if maxKeys <= 0 {
+		return result, nil
+	}
+
+	count := 0
+	// add appends the versions of an object to the result, false is
+	// returned once the result is full.
+	add := func(infos []minio.ObjectInfo) bool {
+		for _, info := range infos {
+			if count == maxKeys {
+				result.IsTruncated = true
+				return false
+			}
+			result.Objects = append(result.Objects, info)
+			result.NextMarker, result.NextVersionIDMarker = info.Name, info.VersionID
+			count++
+		}
+		return true
+	}
+
+	// Resume within the versions of the marker object.
+	if marker != "" && versionMarker != "" {
+		generations, err := l.objectGenerations(ctx, bucket, marker)
+		if err != nil {
+			logger.LogIf(ctx, err)
+			return result, gcsToObjectError(err, bucket, prefix)
+		}
+		infos := l.gcsVersionsToObjectInfos(generations)
+		for i, info := range infos {
+			if info.VersionID == versionMarker {
+				infos = infos[i+1:]
+				break
+			}
+		}
+		if !add(infos) {
+			return result, nil
+		}
+	}
+
+	it := l.client.Bucket(bucket).Objects(ctx, &storage.Query{
+		Delimiter: delimiter,
+		Prefix:    prefix,
+		Versions:  true,
+	})
+	if marker != "" {
+		it.PageInfo().Token = toGCSPageToken(marker)
+	}
+
+	var generations []*storage.ObjectAttrs
+	for {
+		attrs, err := it.Next()
+		if err != nil && err != iterator.Done {
+			logger.LogIf(ctx, err)
+			return result, gcsToObjectError(err, bucket, prefix)
+		}
+		if len(generations) > 0 && (err == iterator.Done || attrs.Name != generations[0].Name) {
+			if !add(l.gcsVersionsToObjectInfos(generations)) {
+				return result, nil
+			}
+			generations = nil
+		}
+		if err == iterator.Done {
+			break
+		}
+
+		if attrs.Prefix == ming.GatewayMinioSysTmp {
+			continue
+		}
+		if !strings.HasPrefix(prefix, ming.GatewayMinioSysTmp) &&
+			(strings.HasPrefix(attrs.Prefix, ming.GatewayMinioSysTmp) || strings.HasPrefix(attrs.Name, ming.GatewayMinioSysTmp)) {
+			continue
+		}
+
+		if attrs.Prefix != "" {
+			if attrs.Prefix <= marker {
+				continue
+			}
+			if count == maxKeys {
+				result.IsTruncated = true
+				return result, nil
+			}
+			result.Prefixes = append(result.Prefixes, attrs.Prefix)
+			result.NextMarker, result.NextVersionIDMarker = attrs.Prefix, ""
+			count++
+			continue
+		}
+		if attrs.Name <= marker {
+			continue
+		}
+		generations = append(generations, attrs)
+	}
+
+	result.NextMarker, result.NextVersionIDMarker = "", ""
+	return result, nil
+}
@@ -0,0 +1,178 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	minio "github.com/minio/minio/cmd"
+	"google.golang.org/api/option"
+)
+
+func TestGCSVersionID(t *testing.T) {
+	testCases := []struct {
+		generation   int64
+		deleteMarker bool
+		versionID    string
+	}{
+		{1, false, "00000000-0000-0000-0000-000000000001"},
+		{1616161616161616, false, "00000000-0000-0000-0005-bde3f307eb50"},
+		{1616161616161616, true, "00000000-0000-0001-0005-bde3f307eb50"},
+	}
+
+	for i, testCase := range testCases {
+		versionID := gcsVersionID(testCase.generation, testCase.deleteMarker)
+		if versionID != testCase.versionID {
+			t.Errorf("Test %d: expected %s, got %s", i+1, testCase.versionID, versionID)
+		}
+		generation, deleteMarker, err := parseGCSVersionID(versionID)
+		if err != nil || generation != testCase.generation || deleteMarker != testCase.deleteMarker {
+			t.Errorf("Test %d: expected %d, %v, got %d, %v, %v", i+1,
+				testCase.generation, testCase.deleteMarker, generation, deleteMarker, err)
+		}
+	}
+
+	for _, versionID := range []string{
+		"null",
+		"e7e1f2ba-5b6c-4ad3-8c5f-1e0c9e2f0a11",
+		"00000000-0000-0000-0000-000000000000",
+		"00000000-0000-0000-0000-00000000000",
+	} {
+		if _, _, err := parseGCSVersionID(versionID); err == nil {
+			t.Errorf("Expected %s to be rejected", versionID)
+		}
+	}
+}
+
+func TestGCSVersionsToObjectInfos(t *testing.T) {
+	l := &gcsGateway{storageClasses: newGCSStorageClassTable(nil)}
+	now := time.Now().UTC()
+	generations := []*storage.ObjectAttrs{
+		{Bucket: "bucket", Name: "object", Generation: 1, Deleted: now.Add(-time.Hour)},
+		{Bucket: "bucket", Name: "object", Generation: 3},
+		{Bucket: "bucket", Name: "object", Generation: 2, Deleted: now.Add(-time.Minute)},
+	}
+	infos := l.gcsVersionsToObjectInfos(generations)
+	if len(infos) != 3 {
+		t.Fatalf("Expected 3 versions, got %d", len(infos))
+	}
+	for i, generation := range []int64{3, 2, 1} {
+		if infos[i].VersionID != gcsVersionID(generation, false) || infos[i].DeleteMarker {
+			t.Errorf("Version %d: expected generation %d, got %s", i+1, generation, infos[i].VersionID)
+		}
+		if infos[i].IsLatest != (i == 0) {
+			t.Errorf("Version %d: unexpected latest %v", i+1, infos[i].IsLatest)
+		}
+	}
+
+	// The object was deleted after its last generation.
+	for _, attrs := range generations {
+		if attrs.Generation == 3 {
+			attrs.Deleted = now
+		}
+	}
+	infos = l.gcsVersionsToObjectInfos(generations)
+	if len(infos) != 4 {
+		t.Fatalf("Expected 4 versions, got %d", len(infos))
+	}
+	marker := infos[0]
+	if !marker.DeleteMarker || !marker.IsLatest || marker.VersionID != gcsVersionID(3, true) || !marker.ModTime.Equal(now) {
+		t.Errorf("Expected delete marker on top of generation 3, got %#v", marker)
+	}
+	if infos[1].IsLatest || infos[1].NumVersions != 4 {
+		t.Errorf("Expected noncurrent generation 3, got %#v", infos[1])
+	}
+}
+
+// newTestGCSGateway returns a gateway sending its requests to the handler,
+// with cached attributes of a bucket named bucket.
+func newTestGCSGateway(t *testing.T, handler http.Handler, versioned bool) (*gcsGateway, func()) {
+	server := httptest.NewServer(handler)
+	transport, err := newGCSEndpointTransport(server.URL, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient := &http.Client{Transport: transport}
+	client, err := storage.NewClient(context.Background(), option.WithHTTPClient(httpClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &gcsGateway{client: client, httpClient: httpClient, buckets: newGCSBucketCache(time.Minute)}
+	l.buckets.set("bucket", &storage.BucketAttrs{Name: "bucket", VersioningEnabled: versioned})
+	return l, server.Close
+}
+
+func TestGCSWrittenObjectVersion(t *testing.T) {
+	attrs := &storage.ObjectAttrs{Bucket: "bucket", Name: "object", Generation: 42}
+	for _, versioned := range []bool{false, true} {
+		l, closer := newTestGCSGateway(t, http.NotFoundHandler(), versioned)
+		objInfo, err := l.fromGCSAttrsToWrittenObjectInfo(context.Background(), attrs)
+		closer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := ""
+		if versioned {
+			expected = gcsVersionID(42, false)
+		}
+		if objInfo.VersionID != expected || objInfo.IsLatest != versioned {
+			t.Errorf("Versioned %v: Expected version %q, got %q (latest %v)", versioned, expected, objInfo.VersionID, objInfo.IsLatest)
+		}
+	}
+}
+
+func TestGCSDeleteObjectVersioned(t *testing.T) {
+	var deletes []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/bucket/o/object":
+			fmt.Fprint(w, `{"bucket":"bucket","name":"object","generation":"42","size":"1"}`)
+		case r.Method == http.MethodDelete && r.URL.Path == "/storage/v1/b/bucket/o/object":
+			deletes = append(deletes, r.URL.Query().Get("ifGenerationMatch"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	})
+
+	l, closer := newTestGCSGateway(t, handler, true)
+	defer closer()
+	objInfo, err := l.DeleteObject(context.Background(), "bucket", "object", minio.ObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !objInfo.DeleteMarker || objInfo.VersionID != gcsVersionID(42, true) {
+		t.Errorf("Expected delete marker %s, got %+v", gcsVersionID(42, true), objInfo)
+	}
+	if len(deletes) != 1 || deletes[0] != "42" {
+		t.Errorf("Expected a delete of generation 42, got %q", deletes)
+	}
+
+	dobjects, errs := l.DeleteObjects(context.Background(), "bucket", []minio.ObjectToDelete{{ObjectName: "object"}}, minio.ObjectOptions{})
+	if errs[0] != nil {
+		t.Fatal(errs[0])
+	}
+	if !dobjects[0].DeleteMarker || dobjects[0].DeleteMarkerVersionID != gcsVersionID(42, true) {
+		t.Errorf("Expected delete marker %s, got %+v", gcsVersionID(42, true), dobjects[0])
+	}
+}
@@ -17,6 +17,7 @@
 package gcs
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/hex"
@@ -25,30 +26,34 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/storage"
 	humanize "github.com/dustin/go-humanize"
+	"github.com/gorilla/mux"
 	"github.com/minio/cli"
 	ming "github.com/minio/ming/cmd"
-	miniogopolicy "github.com/minio/minio-go/v7/pkg/policy"
 	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/crypto"
+	xhttp "github.com/minio/minio/cmd/http"
 	"github.com/minio/minio/cmd/logger"
 	"github.com/minio/minio/pkg/auth"
-	"github.com/minio/minio/pkg/bucket/policy"
-	"github.com/minio/minio/pkg/bucket/policy/condition"
 	"github.com/minio/minio/pkg/env"
 	"github.com/minio/minio/pkg/madmin"
+	"github.com/minio/minio/pkg/sync/errgroup"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
 var (
@@ -60,6 +65,9 @@ var (
 
 	// Invalid format.
 	errGCSFormat = fmt.Errorf("Unknown format")
+
+	// Version ID not derived from a generation.
+	errInvalidGCSVersionID = fmt.Errorf("Invalid GCS version id")
 )
 
 const (
@@ -72,7 +80,10 @@ const (
 	gcsMinioMultipartMeta = "gcs.json"
 
 	// gcs.json version number
-	gcsMinioMultipartMetaCurrentVersion = "1"
+	gcsMinioMultipartMetaCurrentVersion = "2"
+
+	// gcs.json version number without resumable upload sessions
+	gcsMinioMultipartMetaVersion1 = "1"
 
 	// token prefixed with GCS returned marker to differentiate
 	// from user supplied marker.
@@ -82,6 +93,19 @@ const (
 	// Refer https://cloud.google.com/storage/docs/composite-objects
 	gcsMaxComponents = 32
 
+	// Number of compose calls run in parallel while building a level
+	// of the compose tree of a multipart upload.
+	gcsComposeConcurrency = 16
+
+	// Metadata key holding the S3 ETag of objects created by a multipart
+	// upload, GCS only has a CRC32C for composite objects.
+	gcsMultipartETagMetaKey = "md5sum"
+
+	// Metadata key of the multipart meta object holding the storage
+	// class of the object being uploaded, the meta object itself is
+	// stored in the bucket default class.
+	gcsMultipartStorageClassMetaKey = "storageclass"
+
 	// Every 24 hours we scan minio.sys.tmp to delete expired multiparts in minio.sys.tmp
 	gcsCleanupInterval = time.Hour * 24
 
@@ -90,6 +114,17 @@ const (
 
 	// Project ID key in credentials.json
 	gcsProjectIDKey = "project_id"
+
+	// Base URL of the GCS JSON API, for the requests the storage client
+	// does not cover.
+	gcsJSONAPIEndpoint = "https://www.googleapis.com/storage/v1/"
+
+	// Address of Google's production endpoint probed by StorageInfo.
+	gcsBackendHost = "storage.googleapis.com:443"
+
+	// Environment variable pointing the gateway to a GCS emulator,
+	// the same one honoured by the Google Cloud client libraries.
+	gcsEmulatorHostEnv = "STORAGE_EMULATOR_HOST"
 )
 
 func init() {
@@ -97,7 +132,7 @@ func init() {
   {{.HelpName}} - {{.Usage}}
 
 USAGE:
-  {{.HelpName}} {{if .VisibleFlags}}[FLAGS]{{end}} [PROJECTID]
+  {{.HelpName}} {{if .VisibleFlags}}[FLAGS]{{end}} [PROJECTID] [ENDPOINT]
 {{if .VisibleFlags}}
 FLAGS:
   {{range .VisibleFlags}}{{.}}
@@ -105,6 +140,12 @@ FLAGS:
 PROJECTID:
   optional GCS project-id expected GOOGLE_APPLICATION_CREDENTIALS env is not set
 
+ENDPOINT:
+  optional GCS endpoint, requests meant for Google's production endpoints are sent here instead
+
+STORAGE_EMULATOR_HOST:
+  address of a GCS emulator, used without authentication when ENDPOINT is not set
+
 GOOGLE_APPLICATION_CREDENTIALS:
   path to credentials.json, generated it from here https://developers.google.com/identity/protocols/application-default-credentials
 
@@ -126,6 +167,12 @@ EXAMPLES:
      {{.Prompt}} {{.EnvVarSetCommand}} MINIO_CACHE_WATERMARK_HIGH{{.AssignmentOperator}}85
      {{.Prompt}} {{.EnvVarSetCommand}} MINIO_CACHE_QUOTA{{.AssignmentOperator}}90
      {{.Prompt}} {{.HelpName}} mygcsprojectid
+
+  3. Start ming server for a local GCS emulator
+     {{.Prompt}} {{.EnvVarSetCommand}} STORAGE_EMULATOR_HOST{{.AssignmentOperator}}localhost:4443
+     {{.Prompt}} {{.EnvVarSetCommand}} MINIO_ROOT_USER{{.AssignmentOperator}}accesskey
+     {{.Prompt}} {{.EnvVarSetCommand}} MINIO_ROOT_PASSWORD{{.AssignmentOperator}}secretkey
+     {{.Prompt}} {{.HelpName}} mygcsprojectid
 `
 
 	ming.RegisterGatewayCommand(cli.Command{
@@ -139,7 +186,12 @@ EXAMPLES:
 
 // Handler for 'ming gcs' command line.
 func gcsGatewayMain(ctx *cli.Context) {
-	projectID := ctx.Args().First()
+	projectID, endpoint := ctx.Args().Get(0), ctx.Args().Get(1)
+	if strings.Contains(projectID, "://") && endpoint == "" {
+		// Only the endpoint is given, the project id comes from
+		// the credentials.
+		projectID, endpoint = "", projectID
+	}
 	if projectID == "" && os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
 		logger.LogIf(minio.GlobalContext, errGCSProjectIDNotFound, logger.Application)
 		cli.ShowCommandHelpAndExit(ctx, ming.GCSBackendGateway, 1)
@@ -151,12 +203,73 @@ func gcsGatewayMain(ctx *cli.Context) {
 		cli.ShowCommandHelpAndExit(ctx, ming.GCSBackendGateway, 1)
 	}
 
-	ming.StartGateway(ctx, &GCS{projectID})
+	emulator := false
+	if endpoint == "" {
+		if host := env.Get(gcsEmulatorHostEnv, ""); host != "" {
+			endpoint, emulator = host, true
+			if !strings.Contains(endpoint, "://") {
+				// Emulators listen on plain http by default.
+				endpoint = "http://" + endpoint
+			}
+		}
+	}
+
+	serverAddr := ctx.GlobalString("address")
+	if serverAddr == "" || serverAddr == ":"+minio.GlobalMinioDefaultPort {
+		serverAddr = ctx.String("address")
+	}
+	// Validate gateway arguments.
+	logger.FatalIf(ming.ValidateGatewayArguments(serverAddr, endpoint), "Invalid argument")
+
+	ming.StartGateway(ctx, &GCS{projectID: projectID, endpoint: endpoint, emulator: emulator})
 }
 
 // GCS implements Azure.
 type GCS struct {
 	projectID string
+	endpoint  string
+	emulator  bool
+	layer     atomic.Value // *gcsGateway, set once the gateway layer is initialized
+}
+
+// gcsEndpointTransport sends the requests meant for Google's production
+// endpoints to a custom endpoint instead.
+type gcsEndpointTransport struct {
+	scheme    string
+	host      string
+	transport http.RoundTripper
+}
+
+func newGCSEndpointTransport(endpoint string, transport http.RoundTripper) (*gcsEndpointTransport, error) {
+	host, secure, err := ming.ParseGatewayEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	return &gcsEndpointTransport{scheme: scheme, host: host, transport: transport}, nil
+}
+
+// backendHost returns the address of the endpoint probed by StorageInfo,
+// at the port of its scheme unless it has one.
+func (t *gcsEndpointTransport) backendHost() string {
+	if _, _, err := net.SplitHostPort(t.host); err == nil {
+		return t.host
+	}
+	return t.host + ":" + t.scheme
+}
+
+// RoundTrip implements http.RoundTripper, the JSON API is served by
+// www.googleapis.com and object reads by storage.googleapis.com.
+func (t *gcsEndpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.URL.Host {
+	case "www.googleapis.com", "storage.googleapis.com":
+		req = req.Clone(req.Context())
+		req.URL.Scheme, req.URL.Host, req.Host = t.scheme, t.host, t.host
+	}
+	return t.transport.RoundTrip(req)
 }
 
 // Name returns the name of gcs ObjectLayer.
@@ -178,32 +291,81 @@ func (g *GCS) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error)
 		}
 	}
 
+	storageClasses, err := parseGCSStorageClasses(env.Get("MINIO_GCS_STORAGE_CLASSES", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	eventBasedHold, err := parseGCSLegalHold(env.Get("MINIO_GCS_LEGAL_HOLD", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	principals, err := parseGCSPrincipals(env.Get("MINIO_GCS_PRINCIPALS", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	bucketCacheTTL, err := time.ParseDuration(env.Get("MINIO_GCS_BUCKET_CACHE_TTL", gcsBucketCacheTTL.String()))
+	if err != nil {
+		return nil, err
+	}
+
 	metrics := minio.NewMetrics()
 
-	t := &minio.MetricsTransport{
+	var t http.RoundTripper = &minio.MetricsTransport{
 		Transport: minio.NewGatewayHTTPTransport(),
 		Metrics:   metrics,
 	}
+	backendHost := gcsBackendHost
+	if g.endpoint != "" {
+		et, err := newGCSEndpointTransport(g.endpoint, t)
+		if err != nil {
+			return nil, err
+		}
+		t, backendHost = et, et.backendHost()
+	}
 
-	// Initialize a GCS client.
 	// Send user-agent in this format for Google to obtain usage insights while participating in the
 	// Google Cloud Technology Partners (https://cloud.google.com/partners/)
-	client, err := storage.NewClient(ctx, option.WithUserAgent(fmt.Sprintf("MinIO/%s (GPN:MinIO;)", minio.Version)))
+	opts := []option.ClientOption{
+		option.WithScopes(storage.ScopeFullControl),
+		option.WithUserAgent(fmt.Sprintf("MinIO/%s (GPN:MinIO;)", minio.Version)),
+	}
+	if g.emulator {
+		opts = append(opts, option.WithoutAuthentication())
+	}
+
+	// Authenticate on top of the instrumented transport so that all
+	// requests to GCS are accounted in the backend metrics.
+	t, err = htransport.NewTransport(ctx, t, opts...)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := &http.Client{Transport: t}
+
+	// Initialize a GCS client.
+	client, err := storage.NewClient(ctx, option.WithHTTPClient(httpClient))
 	if err != nil {
 		return nil, err
 	}
 
 	gcs := &gcsGateway{
-		client:    client,
-		projectID: g.projectID,
-		metrics:   metrics,
-		httpClient: &http.Client{
-			Transport: t,
-		},
+		client:      client,
+		projectID:   g.projectID,
+		metrics:     metrics,
+		httpClient:  httpClient,
+		backendHost: backendHost,
+		principals:  principals,
+		buckets:     newGCSBucketCache(bucketCacheTTL),
+
+		storageClasses: storageClasses,
+		eventBasedHold: eventBasedHold,
 	}
 
 	// Start background process to cleanup old files in minio.sys.tmp
 	go gcs.CleanupGCSMinioSysTmp(ctx)
+	g.layer.Store(gcs)
 	return gcs, nil
 }
 
@@ -212,12 +374,35 @@ func (g *GCS) Production() bool {
 	return true
 }
 
-// Stored in gcs.json - Contents of this file is not used anywhere. It can be
-// used for debugging purposes.
+// RegisterAdminRouter registers the object lock and lifecycle admin APIs.
+func (g *GCS) RegisterAdminRouter(router *mux.Router) {
+	g.registerObjectLockRouter(router)
+	g.registerLifecycleRouter(router)
+}
+
+// bucketAdminHandler returns the handler of an admin API acting on the
+// bucket given as query parameter.
+func (g *GCS) bucketAdminHandler(f func(ctx context.Context, l *gcsGateway, bucket string, r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return ming.AdminHandler(func(r *http.Request) (interface{}, error) {
+		l, _ := g.layer.Load().(*gcsGateway)
+		if l == nil {
+			return nil, errors.New("gcs gateway not initialized")
+		}
+		bucket := r.URL.Query().Get("bucket")
+		if !minio.IsValidBucketName(bucket) {
+			return nil, minio.BucketNameInvalid{Bucket: bucket}
+		}
+		return f(r.Context(), l, bucket, r)
+	})
+}
+
+// Stored in gcs.json - Holds the resumable upload sessions of the parts,
+// the rest can be used for debugging purposes.
 type gcsMultipartMetaV1 struct {
-	Version string `json:"version"` // Version number
-	Bucket  string `json:"bucket"`  // Bucket name
-	Object  string `json:"object"`  // Object name
+	Version  string                         `json:"version"`            // Version number
+	Bucket   string                         `json:"bucket"`             // Bucket name
+	Object   string                         `json:"object"`             // Object name
+	Sessions map[string]gcsMultipartSession `json:"sessions,omitempty"` // Upload sessions by part number
 }
 
 // Returns name of the multipart meta object.
@@ -225,6 +410,11 @@ func gcsMultipartMetaName(uploadID string) string {
 	return fmt.Sprintf("%s/%s/%s", gcsMinioMultipartPathV1, uploadID, gcsMinioMultipartMeta)
 }
 
+// Returns name of an intermediate object of the compose tree.
+func gcsMultipartComposeName(uploadID string, level, index int) string {
+	return fmt.Sprintf("%stmp/%s/composed-object-%02d-%05d", ming.GatewayMinioSysTmp, uploadID, level, index)
+}
+
 // Returns name of the part object.
 func gcsMultipartDataName(uploadID string, partNumber int, etag string) string {
 	return fmt.Sprintf("%s/%s/%05d.%s", gcsMinioMultipartPathV1, uploadID, partNumber, etag)
@@ -318,6 +508,16 @@ func gcsToObjectError(err error, params ...string) error {
 			break
 		}
 		err = minio.BucketNotEmpty{Bucket: bucket}
+	case "retentionPolicyNotMet", "objectUnderActiveHold":
+		// Deletes and overwrites refused by a retention policy, an
+		// object retention or a hold.
+		err = ming.ObjectLocked(bucket, object)
+	case "resourceIsEncryptedWithCustomerEncryptionKey", "customerEncryptionKeySha256IsRequired":
+		err = crypto.ErrMissingCustomerKey
+	case "resourceNotEncryptedWithCustomerEncryptionKey":
+		err = crypto.ErrIncompatibleEncryptionMethod
+	case "customerEncryptionKeyIsIncorrect", "customerEncryptionKeyFormatIsInvalid":
+		err = crypto.ErrInvalidCustomerKey
 	}
 
 	return err
@@ -338,10 +538,16 @@ func isValidGCSProjectIDFormat(projectID string) bool {
 // gcsGateway - Implements gateway for MinIO and GCS compatible object storage servers.
 type gcsGateway struct {
 	minio.ObjectLayerUnsupported
-	client     *storage.Client
-	httpClient *http.Client
-	metrics    *minio.BackendMetrics
-	projectID  string
+	client      *storage.Client
+	httpClient  *http.Client
+	backendHost string // address probed by StorageInfo
+	metrics     *minio.BackendMetrics
+	projectID   string
+	principals  map[string]string // S3 principal to IAM member
+	buckets     *gcsBucketCache
+
+	storageClasses   *gcsStorageClassTable
+	eventBasedHold   bool     // Legal holds are event-based rather than temporary holds
 }
 
 // Returns projectID from the GOOGLE_APPLICATION_CREDENTIALS file.
@@ -357,6 +563,45 @@ func gcsParseProjectID(credsFile string) (projectID string, err error) {
 	return googleCreds[gcsProjectIDKey], err
 }
 
+// jsonRequest sends a request to the GCS JSON API for the operations the
+// storage client does not cover, in is sent as JSON body when not nil and
+// the response is decoded into out when not nil. Failed requests return
+// the *googleapi.Error of the response.
+func (l *gcsGateway) jsonRequest(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	u := gcsJSONAPIEndpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err = googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
 // GetMetrics returns this gateway's metrics
 func (l *gcsGateway) GetMetrics(ctx context.Context) (*minio.BackendMetrics, error) {
 	return l.metrics, nil
@@ -364,7 +609,8 @@ func (l *gcsGateway) GetMetrics(ctx context.Context) (*minio.BackendMetrics, err
 
 // Cleanup old files in minio.sys.tmp of the given bucket.
 func (l *gcsGateway) CleanupGCSMinioSysTmpBucket(ctx context.Context, bucket string) {
-	it := l.client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: ming.GatewayMinioSysTmp, Versions: false})
+	// Noncurrent generations of versioned buckets are removed as well.
+	it := l.client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: ming.GatewayMinioSysTmp, Versions: true})
 	for {
 		attrs, err := it.Next()
 		if err != nil {
@@ -377,7 +623,7 @@ func (l *gcsGateway) CleanupGCSMinioSysTmpBucket(ctx context.Context, bucket str
 		}
 		if time.Since(attrs.Updated) > gcsMultipartExpiry {
 			// Delete files older than 2 weeks.
-			err := l.client.Bucket(bucket).Object(attrs.Name).Delete(ctx)
+			err := l.client.Bucket(bucket).Object(attrs.Name).Generation(attrs.Generation).Delete(ctx)
 			if err != nil {
 				reqInfo := &logger.ReqInfo{BucketName: bucket, ObjectName: attrs.Name}
 				ctx := logger.SetReqInfo(minio.GlobalContext, reqInfo)
@@ -413,17 +659,14 @@ func (l *gcsGateway) Shutdown(ctx context.Context) error {
 // StorageInfo - Not relevant to GCS backend.
 func (l *gcsGateway) StorageInfo(ctx context.Context) (si minio.StorageInfo, _ []error) {
 	si.Backend.Type = madmin.Gateway
-	si.Backend.GatewayOnline = minio.IsBackendOnline(ctx, "storage.googleapis.com:443")
+	si.Backend.GatewayOnline = minio.IsBackendOnline(ctx, l.backendHost)
 	return si, nil
 }
 
 // MakeBucketWithLocation - Create a new container on GCS backend.
 func (l *gcsGateway) MakeBucketWithLocation(ctx context.Context, bucket string, opts minio.BucketOptions) error {
-	if opts.LockEnabled || opts.VersioningEnabled {
-		return minio.NotImplemented{}
-	}
-
 	bkt := l.client.Bucket(bucket)
+	l.buckets.invalidate(bucket)
 
 	// we'll default to the us multi-region in case of us-east-1
 	location := opts.Location
@@ -431,8 +674,13 @@ func (l *gcsGateway) MakeBucketWithLocation(ctx context.Context, bucket string,
 		location = "us"
 	}
 
+	if opts.LockEnabled {
+		return l.makeLockedBucket(ctx, bucket, location)
+	}
+
 	err := bkt.Create(ctx, l.projectID, &storage.BucketAttrs{
-		Location: location,
+		Location:          location,
+		VersioningEnabled: opts.VersioningEnabled,
 	})
 	logger.LogIf(ctx, err)
 	return gcsToObjectError(err, bucket)
@@ -508,7 +756,7 @@ func (l *gcsGateway) DeleteBucket(ctx context.Context, bucket string, forceDelet
 	}
 	if gcsMinioPathFound {
 		// Remove minio.sys.tmp before deleting the bucket.
-		itObject = l.client.Bucket(bucket).Objects(ctx, &storage.Query{Versions: false, Prefix: ming.GatewayMinioSysTmp})
+		itObject = l.client.Bucket(bucket).Objects(ctx, &storage.Query{Versions: true, Prefix: ming.GatewayMinioSysTmp})
 		for {
 			objAttrs, err := itObject.Next()
 			if err == iterator.Done {
@@ -518,7 +766,7 @@ func (l *gcsGateway) DeleteBucket(ctx context.Context, bucket string, forceDelet
 				logger.LogIf(ctx, err)
 				return gcsToObjectError(err)
 			}
-			err = l.client.Bucket(bucket).Object(objAttrs.Name).Delete(ctx)
+			err = l.client.Bucket(bucket).Object(objAttrs.Name).Generation(objAttrs.Generation).Delete(ctx)
 			if err != nil {
 				logger.LogIf(ctx, err)
 				return gcsToObjectError(err)
@@ -527,6 +775,7 @@ func (l *gcsGateway) DeleteBucket(ctx context.Context, bucket string, forceDelet
 	}
 	err := l.client.Bucket(bucket).Delete(ctx)
 	logger.LogIf(ctx, err)
+	l.buckets.invalidate(bucket)
 	return gcsToObjectError(err, bucket)
 }
 
@@ -627,7 +876,7 @@ func (l *gcsGateway) ListObjects(ctx context.Context, bucket string, prefix stri
 			if attrs.Prefix != "" {
 				prefixes = append(prefixes, attrs.Prefix)
 			} else {
-				objects = append(objects, fromGCSAttrsToObjectInfo(attrs))
+				objects = append(objects, l.fromGCSAttrsToObjectInfo(attrs))
 			}
 
 			// The NextMarker property should only be set in the response if a delimiter is used
@@ -714,7 +963,7 @@ func (l *gcsGateway) ListObjectsV2(ctx context.Context, bucket, prefix, continua
 			if attrs.Prefix != "" {
 				prefixes = append(prefixes, attrs.Prefix)
 			} else {
-				objects = append(objects, fromGCSAttrsToObjectInfo(attrs))
+				objects = append(objects, l.fromGCSAttrsToObjectInfo(attrs))
 			}
 		}
 
@@ -742,8 +991,9 @@ func (l *gcsGateway) GetObjectNInfo(ctx context.Context, bucket, object string,
 		return nil, err
 	}
 
-	var startOffset, length int64
-	startOffset, length, err = rs.GetOffsetLength(objInfo.Size)
+	// The range is translated to the range of the stored stream of
+	// encrypted objects, which fn decrypts.
+	fn, startOffset, length, err := minio.NewGetObjectReader(rs, objInfo, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -756,7 +1006,7 @@ func (l *gcsGateway) GetObjectNInfo(ctx context.Context, bucket, object string,
 	// Setup cleanup function to cause the above go-routine to
 	// exit in case of partial read
 	pipeCloser := func() { pr.Close() }
-	return minio.NewGetObjectReaderFromReader(pr, objInfo, opts, pipeCloser)
+	return fn(pr, h, opts.CheckPrecondFn, pipeCloser)
 }
 
 // GetObject - reads an object from GCS. Supports additional
@@ -766,24 +1016,20 @@ func (l *gcsGateway) GetObjectNInfo(ctx context.Context, bucket, object string,
 // startOffset indicates the starting read location of the object.
 // length indicates the total length of the object.
 func (l *gcsGateway) getObject(ctx context.Context, bucket string, key string, startOffset int64, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) error {
-	// if we want to mimic S3 behavior exactly, we need to verify if bucket exists first,
-	// otherwise gcs will just return object not exist in case of non-existing bucket
-	if _, err := l.client.Bucket(bucket).Attrs(ctx); err != nil {
-		logger.LogIf(ctx, err, logger.Application)
-		return gcsToObjectError(err, bucket)
-	}
-
 	// GCS storage decompresses a gzipped object by default and returns the data.
 	// Refer to https://cloud.google.com/storage/docs/transcoding#decompressive_transcoding
 	// Need to set `Accept-Encoding` header to `gzip` when issuing a GetObject call, to be able
 	// to download the object in compressed state.
 	// Calling ReadCompressed with true accomplishes that.
-	object := l.client.Bucket(bucket).Object(key).ReadCompressed(true)
+	object, err := l.objectHandle(ctx, bucket, key, opts.VersionID)
+	if err != nil {
+		return err
+	}
 
-	r, err := object.NewRangeReader(ctx, startOffset, length)
+	r, err := object.ReadCompressed(true).NewRangeReader(ctx, startOffset, length)
 	if err != nil {
 		logger.LogIf(ctx, err, logger.Application)
-		return gcsToObjectError(err, bucket, key)
+		return l.gcsToObjectBucketError(ctx, err, bucket, key, opts.VersionID)
 	}
 	defer r.Close()
 
@@ -796,7 +1042,7 @@ func (l *gcsGateway) getObject(ctx context.Context, bucket string, key string, s
 }
 
 // fromGCSAttrsToObjectInfo converts GCS BucketAttrs to gateway ObjectInfo
-func fromGCSAttrsToObjectInfo(attrs *storage.ObjectAttrs) minio.ObjectInfo {
+func (l *gcsGateway) fromGCSAttrsToObjectInfo(attrs *storage.ObjectAttrs) minio.ObjectInfo {
 	// All google cloud storage objects have a CRC32c hash, whereas composite objects may not have a MD5 hash
 	// Refer https://cloud.google.com/storage/docs/hashes-etags. Use CRC32C for ETag
 	metadata := make(map[string]string)
@@ -804,7 +1050,17 @@ func fromGCSAttrsToObjectInfo(attrs *storage.ObjectAttrs) minio.ObjectInfo {
 		expiry time.Time
 		e      error
 	)
+	var parts []minio.ObjectPartInfo
 	for k, v := range attrs.Metadata {
+		if k == gcsTagsMetaKey {
+			continue
+		}
+		if k == gcsPartsMetaKey {
+			// An invalid value leaves the parts unset, the object
+			// then fails to decrypt.
+			parts, _ = ming.DecodePartLayout(v, gcsMaxPartCount)
+			continue
+		}
 		k = http.CanonicalHeaderKey(k)
 		// Translate the GCS custom metadata prefix
 		if strings.HasPrefix(k, "X-Goog-Meta-") {
@@ -835,9 +1091,17 @@ func fromGCSAttrsToObjectInfo(attrs *storage.ObjectAttrs) minio.ObjectInfo {
 	}
 
 	etag := hex.EncodeToString(attrs.MD5)
+	if md5sum, ok := attrs.Metadata[gcsMultipartETagMetaKey]; ok {
+		etag = md5sum
+		delete(metadata, http.CanonicalHeaderKey(gcsMultipartETagMetaKey))
+	}
 	if etag == "" {
 		etag = minio.ToS3ETag(fmt.Sprintf("%d", attrs.CRC32C))
 	}
+	var storageClass string
+	if attrs.StorageClass != "" {
+		storageClass = l.storageClasses.S3(attrs.StorageClass)
+	}
 	return minio.ObjectInfo{
 		Name:            attrs.Name,
 		Bucket:          attrs.Bucket,
@@ -847,12 +1111,15 @@ func fromGCSAttrsToObjectInfo(attrs *storage.ObjectAttrs) minio.ObjectInfo {
 		UserDefined:     metadata,
 		ContentType:     attrs.ContentType,
 		ContentEncoding: attrs.ContentEncoding,
+		StorageClass:    storageClass,
 		Expires:         expiry,
+		UserTags:        attrs.Metadata[gcsTagsMetaKey],
+		Parts:           parts,
 	}
 }
 
 // applyMetadataToGCSAttrs applies metadata to a GCS ObjectAttrs instance
-func applyMetadataToGCSAttrs(metadata map[string]string, attrs *storage.ObjectAttrs) {
+func (l *gcsGateway) applyMetadataToGCSAttrs(metadata map[string]string, attrs *storage.ObjectAttrs) {
 	attrs.Metadata = make(map[string]string)
 	for k, v := range metadata {
 		k = http.CanonicalHeaderKey(k)
@@ -871,26 +1138,67 @@ func applyMetadataToGCSAttrs(metadata map[string]string, attrs *storage.ObjectAt
 			attrs.ContentDisposition = v
 		case k == "Content-Language":
 			attrs.ContentLanguage = v
+		case k == http.CanonicalHeaderKey(xhttp.AmzStorageClass):
+			attrs.StorageClass = l.storageClasses.GCS(v)
+		case k == http.CanonicalHeaderKey(xhttp.AmzObjectTagging):
+			if v != "" {
+				attrs.Metadata[gcsTagsMetaKey] = v
+			}
+		case strings.HasPrefix(k, minio.ReservedMetadataPrefix):
+			// Internal metadata of the handlers, such as the
+			// sealed keys of encrypted objects.
+			attrs.Metadata[k] = v
 		}
 	}
 }
 
 // GetObjectInfo - reads object info and replies back ObjectInfo
 func (l *gcsGateway) GetObjectInfo(ctx context.Context, bucket string, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
-	// if we want to mimic S3 behavior exactly, we need to verify if bucket exists first,
-	// otherwise gcs will just return object not exist in case of non-existing bucket
-	if _, err := l.client.Bucket(bucket).Attrs(ctx); err != nil {
-		logger.LogIf(ctx, err, logger.Application)
-		return minio.ObjectInfo{}, gcsToObjectError(err, bucket)
+	handle, err := l.objectHandle(ctx, bucket, object, opts.VersionID)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	retentionEnabled, err := l.isObjectRetentionEnabled(ctx, bucket)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	var (
+		attrs     *storage.ObjectAttrs
+		retention *gcsObjectRetention
+	)
+	if retentionEnabled {
+		// The retention is read along with the attributes, the storage
+		// client does not return it.
+		var generation int64
+		if isGCSVersioned(opts.VersionID) {
+			generation, _, _ = parseGCSVersionID(opts.VersionID)
+		}
+		attrs, retention, err = l.objectAttrsWithRetention(ctx, bucket, object, generation)
+	} else {
+		attrs, err = handle.Attrs(ctx)
 	}
-
-	attrs, err := l.client.Bucket(bucket).Object(object).Attrs(ctx)
 	if err != nil {
 		logger.LogIf(ctx, err)
-		return minio.ObjectInfo{}, gcsToObjectError(err, bucket, object)
+		return minio.ObjectInfo{}, l.gcsToObjectBucketError(ctx, err, bucket, object, opts.VersionID)
+	}
+	bucketAttrs, err := l.bucketAttrs(ctx, bucket)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	objInfo := l.fromGCSAttrsToObjectInfo(attrs)
+
+	policyLocked := bucketAttrs.RetentionPolicy != nil && bucketAttrs.RetentionPolicy.IsLocked
+	lockEnabled := retentionEnabled || bucketAttrs.RetentionPolicy != nil
+	for k, v := range gcsObjectLockToS3Meta(attrs, retention, policyLocked, lockEnabled) {
+		objInfo.UserDefined[k] = v
 	}
 
-	return fromGCSAttrsToObjectInfo(attrs), nil
+	if bucketAttrs.VersioningEnabled || isGCSVersioned(opts.VersionID) {
+		objInfo.VersionID = gcsVersionID(attrs.Generation, false)
+		objInfo.IsLatest = attrs.Deleted.IsZero()
+	}
+	return objInfo, nil
 }
 
 // PutObject - Create a new object with the incoming data,
@@ -901,37 +1209,34 @@ func (l *gcsGateway) PutObject(ctx context.Context, bucket string, key string, r
 
 	defer cancel()
 
-	// if we want to mimic S3 behavior exactly, we need to verify if bucket exists first,
-	// otherwise gcs will just return object not exist in case of non-existing bucket
-	if _, err := l.client.Bucket(bucket).Attrs(nctx); err != nil {
-		logger.LogIf(ctx, err, logger.Application)
-		return minio.ObjectInfo{}, gcsToObjectError(err, bucket)
-	}
-
 	object := l.client.Bucket(bucket).Object(key)
 
 	w := object.NewWriter(nctx)
 
-	// Disable "chunked" uploading in GCS client if the size of the data to be uploaded is below
-	// the current chunk-size of the writer. This avoids an unnecessary memory allocation.
-	if data.Size() < int64(w.ChunkSize) {
+	// Large objects are sent through a resumable upload session, in chunks
+	// the GCS client retries on their own. Disable "chunked" uploading if the
+	// size of the data to be uploaded is below the chunk size, this avoids an
+	// unnecessary memory allocation.
+	w.ChunkSize = gcsResumableChunkSize
+	if data.Size() >= 0 && data.Size() < int64(w.ChunkSize) {
 		w.ChunkSize = 0
 	}
-	applyMetadataToGCSAttrs(opts.UserDefined, &w.ObjectAttrs)
+	l.applyMetadataToGCSAttrs(opts.UserDefined, &w.ObjectAttrs)
 
 	if _, err := io.Copy(w, data); err != nil {
 		// Close the object writer upon error.
 		logger.LogIf(ctx, err)
-		return minio.ObjectInfo{}, gcsToObjectError(err, bucket, key)
+		return minio.ObjectInfo{}, l.gcsToObjectBucketError(ctx, err, bucket, key, "")
 	}
 
-	// Close the object writer upon success.
+	// Close the object writer upon success, uploads to a missing bucket
+	// fail here.
 	if err := w.Close(); err != nil {
 		logger.LogIf(ctx, err)
-		return minio.ObjectInfo{}, gcsToObjectError(err, bucket, key)
+		return minio.ObjectInfo{}, l.gcsToObjectBucketError(ctx, err, bucket, key, "")
 	}
 
-	return fromGCSAttrsToObjectInfo(w.Attrs()), nil
+	return l.fromGCSAttrsToWrittenObjectInfo(ctx, w.Attrs())
 }
 
 // CopyObject - Copies a blob from source container to destination container.
@@ -940,24 +1245,57 @@ func (l *gcsGateway) CopyObject(ctx context.Context, srcBucket string, srcObject
 	if srcOpts.CheckPrecondFn != nil && srcOpts.CheckPrecondFn(srcInfo) {
 		return minio.ObjectInfo{}, minio.PreConditionFailed{}
 	}
-	src := l.client.Bucket(srcBucket).Object(srcObject)
-	dst := l.client.Bucket(destBucket).Object(destObject)
+	src, err := l.objectHandle(ctx, srcBucket, srcObject, srcOpts.VersionID)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	srcAttrs, err := src.Attrs(ctx)
+	if err != nil {
+		logger.LogIf(ctx, err)
+		return minio.ObjectInfo{}, l.gcsToObjectBucketError(ctx, err, srcBucket, srcObject, srcOpts.VersionID)
+	}
+
+	// Encrypted streams are bound to their object and key, copies which
+	// encrypt again write the stream the handlers prepared.
+	sameObject := srcBucket == destBucket && srcObject == destObject && srcOpts.VersionID == ""
+	if ming.CopyRewrites(l.fromGCSAttrsToObjectInfo(srcAttrs).UserDefined, srcInfo.UserDefined, sameObject) {
+		return l.PutObject(ctx, destBucket, destObject, srcInfo.PutObjReader, minio.ObjectOptions{
+			ServerSideEncryption: dstOpts.ServerSideEncryption,
+			UserDefined:          srcInfo.UserDefined,
+		})
+	}
 
+	// Copies are GCS rewrites, which also move the object to the
+	// requested storage class.
+	dst := l.client.Bucket(destBucket).Object(destObject)
 	copier := dst.CopierFrom(src)
-	applyMetadataToGCSAttrs(srcInfo.UserDefined, &copier.ObjectAttrs)
+	l.applyMetadataToGCSAttrs(srcInfo.UserDefined, &copier.ObjectAttrs)
+	if parts, ok := srcAttrs.Metadata[gcsPartsMetaKey]; ok {
+		copier.Metadata[gcsPartsMetaKey] = parts
+	}
 
 	attrs, err := copier.Run(ctx)
 	if err != nil {
 		logger.LogIf(ctx, err)
 		return minio.ObjectInfo{}, gcsToObjectError(err, destBucket, destObject)
 	}
-
-	return fromGCSAttrsToObjectInfo(attrs), nil
+	return l.fromGCSAttrsToWrittenObjectInfo(ctx, attrs)
 }
 
 // DeleteObject - Deletes a blob in bucket
 func (l *gcsGateway) DeleteObject(ctx context.Context, bucket string, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
-	err := l.client.Bucket(bucket).Object(object).Delete(ctx)
+	if isGCSVersioned(opts.VersionID) {
+		return l.deleteObjectVersion(ctx, bucket, object, opts.VersionID)
+	}
+	bucketAttrs, err := l.bucketAttrs(ctx, bucket)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	if bucketAttrs.VersioningEnabled {
+		return l.deleteLatestObjectVersion(ctx, bucket, object)
+	}
+
+	err = l.client.Bucket(bucket).Object(object).Delete(ctx)
 	if err != nil {
 		logger.LogIf(ctx, err)
 		return minio.ObjectInfo{}, gcsToObjectError(err, bucket, object)
@@ -973,10 +1311,16 @@ func (l *gcsGateway) DeleteObjects(ctx context.Context, bucket string, objects [
 	errs := make([]error, len(objects))
 	dobjects := make([]minio.DeletedObject, len(objects))
 	for idx, object := range objects {
-		_, errs[idx] = l.DeleteObject(ctx, bucket, object.ObjectName, opts)
-		if errs[idx] == nil {
+		opts.VersionID = object.VersionID
+		objInfo, err := l.DeleteObject(ctx, bucket, object.ObjectName, opts)
+		if errs[idx] = err; err == nil {
 			dobjects[idx] = minio.DeletedObject{
 				ObjectName: object.ObjectName,
+				VersionID:  object.VersionID,
+			}
+			if objInfo.DeleteMarker {
+				dobjects[idx].DeleteMarker = true
+				dobjects[idx].DeleteMarkerVersionID = objInfo.VersionID
 			}
 		}
 	}
@@ -994,12 +1338,16 @@ func (l *gcsGateway) NewMultipartUpload(ctx context.Context, bucket string, key
 	w := l.client.Bucket(bucket).Object(meta).NewWriter(ctx)
 	defer w.Close()
 
-	applyMetadataToGCSAttrs(o.UserDefined, &w.ObjectAttrs)
+	l.applyMetadataToGCSAttrs(o.UserDefined, &w.ObjectAttrs)
+	if w.StorageClass != "" {
+		w.Metadata[gcsMultipartStorageClassMetaKey] = w.StorageClass
+		w.StorageClass = ""
+	}
 
 	if err = json.NewEncoder(w).Encode(gcsMultipartMetaV1{
-		gcsMinioMultipartMetaCurrentVersion,
-		bucket,
-		key,
+		Version: gcsMinioMultipartMetaCurrentVersion,
+		Bucket:  bucket,
+		Object:  key,
 	}); err != nil {
 		logger.LogIf(ctx, err)
 		return "", gcsToObjectError(err, bucket, key)
@@ -1087,38 +1435,24 @@ func (l *gcsGateway) ListMultipartUploads(ctx context.Context, bucket string, pr
 	}, nil
 }
 
-// Checks if minio.sys.tmp/multipart/v1/<upload-id>/gcs.json exists, returns
-// an object layer compatible error upon any error.
-func (l *gcsGateway) checkUploadIDExists(ctx context.Context, bucket string, key string, uploadID string) error {
-	_, err := l.client.Bucket(bucket).Object(gcsMultipartMetaName(uploadID)).Attrs(ctx)
+// Checks if minio.sys.tmp/multipart/v1/<upload-id>/gcs.json exists and
+// returns its attributes, returns an object layer compatible error upon
+// any error.
+func (l *gcsGateway) checkUploadIDExists(ctx context.Context, bucket string, key string, uploadID string) (*storage.ObjectAttrs, error) {
+	attrs, err := l.client.Bucket(bucket).Object(gcsMultipartMetaName(uploadID)).Attrs(ctx)
 	logger.LogIf(ctx, err)
-	return gcsToObjectError(err, bucket, key, uploadID)
+	return attrs, gcsToObjectError(err, bucket, key, uploadID)
 }
 
 // PutObjectPart puts a part of object in bucket
 func (l *gcsGateway) PutObjectPart(ctx context.Context, bucket string, key string, uploadID string, partNumber int, r *minio.PutObjReader, opts minio.ObjectOptions) (minio.PartInfo, error) {
 	data := r.Reader
-	if err := l.checkUploadIDExists(ctx, bucket, key, uploadID); err != nil {
+	metaAttrs, err := l.checkUploadIDExists(ctx, bucket, key, uploadID)
+	if err != nil {
 		return minio.PartInfo{}, err
 	}
-	etag := data.MD5HexString()
-	if etag == "" {
-		// Generate random ETag.
-		etag = minio.GenETag()
-	}
-	object := l.client.Bucket(bucket).Object(gcsMultipartDataName(uploadID, partNumber, etag))
-	w := object.NewWriter(ctx)
-	// Disable "chunked" uploading in GCS client. If enabled, it can cause a corner case
-	// where it tries to upload 0 bytes in the last chunk and get error from server.
-	w.ChunkSize = 0
-	if _, err := io.Copy(w, data); err != nil {
-		// Make sure to close object writer upon error.
-		w.Close()
-		logger.LogIf(ctx, err)
-		return minio.PartInfo{}, gcsToObjectError(err, bucket, key)
-	}
-	// Make sure to close the object writer upon success.
-	if err := w.Close(); err != nil {
+	etag, err := l.uploadPart(ctx, metaAttrs, uploadID, partNumber, data, data.MD5HexString())
+	if err != nil {
 		logger.LogIf(ctx, err)
 		return minio.PartInfo{}, gcsToObjectError(err, bucket, key)
 	}
@@ -1253,15 +1587,80 @@ func (l *gcsGateway) cleanupMultipartUpload(ctx context.Context, bucket, key, up
 
 // AbortMultipartUpload aborts a ongoing multipart upload
 func (l *gcsGateway) AbortMultipartUpload(ctx context.Context, bucket string, key string, uploadID string, opts minio.ObjectOptions) error {
-	if err := l.checkUploadIDExists(ctx, bucket, key, uploadID); err != nil {
+	if _, err := l.checkUploadIDExists(ctx, bucket, key, uploadID); err != nil {
 		return err
 	}
 	return l.cleanupMultipartUpload(ctx, bucket, key, uploadID)
 }
 
+// gcsComposeTree reduces the parts to at most gcsMaxComponents objects by
+// composing them into intermediate objects, as many levels deep as needed,
+// the compose calls of a level run in parallel. The intermediate objects
+// are returned for cleanup, including when an error is returned.
+func gcsComposeTree(ctx context.Context, parts []string, name func(level, index int) string,
+	compose func(ctx context.Context, dst string, srcs []string) error) (top, intermediates []string, err error) {
+	for level := 0; len(parts) > gcsMaxComponents; level++ {
+		next := make([]string, (len(parts)+gcsMaxComponents-1)/gcsMaxComponents)
+		g := errgroup.WithNErrs(len(next)).WithConcurrency(gcsComposeConcurrency)
+		for i := range next {
+			start := i * gcsMaxComponents
+			end := start + gcsMaxComponents
+			if end > len(parts) {
+				end = len(parts)
+			}
+			dst, srcs := name(level, i), parts[start:end]
+			next[i] = dst
+			g.Go(func() error {
+				return compose(ctx, dst, srcs)
+			}, i)
+		}
+		intermediates = append(intermediates, next...)
+		if err = g.WaitErr(); err != nil {
+			return nil, intermediates, err
+		}
+		parts = next
+	}
+	return parts, intermediates, nil
+}
+
+// gcsObjectHandles returns the handles of the named objects of the bucket.
+func (l *gcsGateway) gcsObjectHandles(bucket string, names []string) []*storage.ObjectHandle {
+	handles := make([]*storage.ObjectHandle, len(names))
+	for i, name := range names {
+		handles[i] = l.client.Bucket(bucket).Object(name)
+	}
+	return handles
+}
+
+// compose composes the source objects of the bucket into dst with the
+// attributes given.
+func (l *gcsGateway) compose(ctx context.Context, bucket, dst string, srcs []string, attrs storage.ObjectAttrs) (*storage.ObjectAttrs, error) {
+	composer := l.client.Bucket(bucket).Object(dst).ComposerFrom(l.gcsObjectHandles(bucket, srcs)...)
+	composer.ObjectAttrs = attrs
+	return composer.Run(ctx)
+}
+
+// deleteObjects removes the named objects of the bucket in parallel,
+// errors are only logged.
+func (l *gcsGateway) deleteObjects(ctx context.Context, bucket string, names []string) {
+	g := errgroup.WithNErrs(len(names)).WithConcurrency(gcsComposeConcurrency)
+	for i, name := range names {
+		name := name
+		g.Go(func() error {
+			return l.client.Bucket(bucket).Object(name).Delete(ctx)
+		}, i)
+	}
+	for _, err := range g.Wait() {
+		if err != nil && err != storage.ErrObjectNotExist {
+			logger.LogIf(ctx, err)
+		}
+	}
+}
+
 // CompleteMultipartUpload completes ongoing multipart upload and finalizes object
 // Note that there is a limit (currently 32) to the number of components that can
-// be composed in a single operation. There is a per-project rate limit (currently 200)
+// be composed in a single operation, larger uploads are composed through a tree
+// of intermediate objects. There is a per-project rate limit (currently 200)
 // to the number of source objects you can compose per second.
 func (l *gcsGateway) CompleteMultipartUpload(ctx context.Context, bucket string, key string, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
 	meta := gcsMultipartMetaName(uploadID)
@@ -1287,7 +1686,8 @@ func (l *gcsGateway) CompleteMultipartUpload(ctx context.Context, bucket string,
 		return minio.ObjectInfo{}, gcsToObjectError(err, bucket, key)
 	}
 
-	if multipartMeta.Version != gcsMinioMultipartMetaCurrentVersion {
+	if multipartMeta.Version != gcsMinioMultipartMetaCurrentVersion &&
+		multipartMeta.Version != gcsMinioMultipartMetaVersion1 {
 		logger.LogIf(ctx, errGCSFormat)
 		return minio.ObjectInfo{}, gcsToObjectError(errGCSFormat, bucket, key)
 	}
@@ -1299,11 +1699,10 @@ func (l *gcsGateway) CompleteMultipartUpload(ctx context.Context, bucket string,
 		}, bucket, key)
 	}
 
-	var parts []*storage.ObjectHandle
+	var parts []string
 	partSizes := make([]int64, len(uploadedParts))
 	for i, uploadedPart := range uploadedParts {
-		parts = append(parts, l.client.Bucket(bucket).Object(gcsMultipartDataName(uploadID,
-			uploadedPart.PartNumber, uploadedPart.ETag)))
+		parts = append(parts, gcsMultipartDataName(uploadID, uploadedPart.PartNumber, uploadedPart.ETag))
 		partAttr, pErr := l.client.Bucket(bucket).Object(gcsMultipartDataName(uploadID, uploadedPart.PartNumber, uploadedPart.ETag)).Attrs(ctx)
 		if pErr != nil {
 			logger.LogIf(ctx, pErr)
@@ -1328,180 +1727,64 @@ func (l *gcsGateway) CompleteMultipartUpload(ctx context.Context, bucket string,
 		}
 	}
 
-	// Returns name of the composed object.
-	gcsMultipartComposeName := func(uploadID string, composeNumber int) string {
-		return fmt.Sprintf("%s/tmp/%s/composed-object-%05d", ming.GatewayMinioSysTmp, uploadID, composeNumber)
+	// Compose the parts into intermediate objects until few enough
+	// remain to be composed into the final object.
+	composeName := func(level, index int) string {
+		return gcsMultipartComposeName(uploadID, level, index)
 	}
-
-	composeCount := int(math.Ceil(float64(len(parts)) / float64(gcsMaxComponents)))
-	if composeCount > 1 {
-		// Create composes of every 32 parts.
-		composeParts := make([]*storage.ObjectHandle, composeCount)
-		for i := 0; i < composeCount; i++ {
-			// Create 'composed-object-N' using next 32 parts.
-			composeParts[i] = l.client.Bucket(bucket).Object(gcsMultipartComposeName(uploadID, i))
-			start := i * gcsMaxComponents
-			end := start + gcsMaxComponents
-			if end > len(parts) {
-				end = len(parts)
-			}
-
-			composer := composeParts[i].ComposerFrom(parts[start:end]...)
-			composer.ContentType = partZeroAttrs.ContentType
-			composer.Metadata = partZeroAttrs.Metadata
-
-			if _, err = composer.Run(ctx); err != nil {
-				logger.LogIf(ctx, err)
-				return minio.ObjectInfo{}, gcsToObjectError(err, bucket, key)
-			}
-		}
-
-		// As composes are successfully created, final object needs to be created using composes.
-		parts = composeParts
+	compose := func(ctx context.Context, dst string, srcs []string) error {
+		_, err := l.compose(ctx, bucket, dst, srcs, storage.ObjectAttrs{})
+		return err
 	}
-
-	composer := l.client.Bucket(bucket).Object(key).ComposerFrom(parts...)
-	composer.ContentType = partZeroAttrs.ContentType
-	composer.ContentEncoding = partZeroAttrs.ContentEncoding
-	composer.CacheControl = partZeroAttrs.CacheControl
-	composer.ContentDisposition = partZeroAttrs.ContentDisposition
-	composer.ContentLanguage = partZeroAttrs.ContentLanguage
-	composer.Metadata = partZeroAttrs.Metadata
-	attrs, err := composer.Run(ctx)
+	parts, intermediates, err := gcsComposeTree(ctx, parts, composeName, compose)
+	defer l.deleteObjects(ctx, bucket, intermediates)
 	if err != nil {
 		logger.LogIf(ctx, err)
 		return minio.ObjectInfo{}, gcsToObjectError(err, bucket, key)
 	}
-	if err = l.cleanupMultipartUpload(ctx, bucket, key, uploadID); err != nil {
-		return minio.ObjectInfo{}, gcsToObjectError(err, bucket, key)
-	}
-	return fromGCSAttrsToObjectInfo(attrs), nil
-}
-
-// SetBucketPolicy - Set policy on bucket
-func (l *gcsGateway) SetBucketPolicy(ctx context.Context, bucket string, bucketPolicy *policy.Policy) error {
-	policyInfo, err := minio.PolicyToBucketAccessPolicy(bucketPolicy)
-	if err != nil {
-		logger.LogIf(ctx, err)
-		return gcsToObjectError(err, bucket)
-	}
 
-	var policies []minio.BucketAccessPolicy
-	for prefix, policy := range miniogopolicy.GetPolicies(policyInfo.Statements, bucket, "") {
-		policies = append(policies, minio.BucketAccessPolicy{
-			Prefix: prefix,
-			Policy: policy,
-		})
-	}
-
-	prefix := bucket + "/*" // For all objects inside the bucket.
-
-	if len(policies) != 1 {
-		logger.LogIf(ctx, minio.NotImplemented{})
-		return minio.NotImplemented{}
-	}
-	if policies[0].Prefix != prefix {
-		logger.LogIf(ctx, minio.NotImplemented{})
-		return minio.NotImplemented{}
+	metadata := make(map[string]string, len(partZeroAttrs.Metadata)+1)
+	for k, v := range partZeroAttrs.Metadata {
+		metadata[k] = v
 	}
-
-	acl := l.client.Bucket(bucket).ACL()
-	if policies[0].Policy == miniogopolicy.BucketPolicyNone {
-		if err := acl.Delete(ctx, storage.AllUsers); err != nil {
-			logger.LogIf(ctx, err)
-			return gcsToObjectError(err, bucket)
+	metadata[gcsMultipartETagMetaKey] = minio.ComputeCompleteMultipartMD5(uploadedParts)
+	storageClass := metadata[gcsMultipartStorageClassMetaKey]
+	delete(metadata, gcsMultipartStorageClassMetaKey)
+	if _, ok := crypto.IsEncrypted(metadata); ok {
+		layout := make([]minio.ObjectPartInfo, len(uploadedParts))
+		for i, uploadedPart := range uploadedParts {
+			layout[i] = minio.ObjectPartInfo{Number: uploadedPart.PartNumber, Size: partSizes[i]}
 		}
-		return nil
+		metadata[gcsPartsMetaKey] = ming.EncodePartLayout(layout)
 	}
 
-	var role storage.ACLRole
-	switch policies[0].Policy {
-	case miniogopolicy.BucketPolicyReadOnly:
-		role = storage.RoleReader
-	case miniogopolicy.BucketPolicyWriteOnly:
-		role = storage.RoleWriter
-	default:
-		logger.LogIf(ctx, minio.NotImplemented{})
-		return minio.NotImplemented{}
+	composeAttrs := storage.ObjectAttrs{
+		ContentType:        partZeroAttrs.ContentType,
+		ContentEncoding:    partZeroAttrs.ContentEncoding,
+		CacheControl:       partZeroAttrs.CacheControl,
+		ContentDisposition: partZeroAttrs.ContentDisposition,
+		ContentLanguage:    partZeroAttrs.ContentLanguage,
+		StorageClass:       storageClass,
+		Metadata:           metadata,
 	}
-
-	if err := acl.Set(ctx, storage.AllUsers, role); err != nil {
-		logger.LogIf(ctx, err)
-		return gcsToObjectError(err, bucket)
-	}
-
-	return nil
-}
-
-// GetBucketPolicy - Get policy on bucket
-func (l *gcsGateway) GetBucketPolicy(ctx context.Context, bucket string) (*policy.Policy, error) {
-	rules, err := l.client.Bucket(bucket).ACL().List(ctx)
+	attrs, err := l.compose(ctx, bucket, key, parts, composeAttrs)
 	if err != nil {
-		return nil, gcsToObjectError(err, bucket)
-	}
-
-	var readOnly, writeOnly bool
-	for _, r := range rules {
-		if r.Entity != storage.AllUsers || r.Role == storage.RoleOwner {
-			continue
-		}
-
-		switch r.Role {
-		case storage.RoleReader:
-			readOnly = true
-		case storage.RoleWriter:
-			writeOnly = true
-		}
+		logger.LogIf(ctx, err)
+		return minio.ObjectInfo{}, gcsToObjectError(err, bucket, key)
 	}
-
-	actionSet := policy.NewActionSet()
-	if readOnly {
-		actionSet.Add(policy.GetBucketLocationAction)
-		actionSet.Add(policy.ListBucketAction)
-		actionSet.Add(policy.GetObjectAction)
-	}
-	if writeOnly {
-		actionSet.Add(policy.GetBucketLocationAction)
-		actionSet.Add(policy.ListBucketMultipartUploadsAction)
-		actionSet.Add(policy.AbortMultipartUploadAction)
-		actionSet.Add(policy.DeleteObjectAction)
-		actionSet.Add(policy.ListMultipartUploadPartsAction)
-		actionSet.Add(policy.PutObjectAction)
-	}
-
-	// Return NoSuchBucketPolicy error, when policy is not set
-	if len(actionSet) == 0 {
-		return nil, gcsToObjectError(minio.BucketPolicyNotFound{}, bucket)
-	}
-
-	return &policy.Policy{
-		Version: policy.DefaultVersion,
-		Statements: []policy.Statement{
-			policy.NewStatement(
-				policy.Allow,
-				policy.NewPrincipal("*"),
-				actionSet,
-				policy.NewResourceSet(
-					policy.NewResource(bucket, ""),
-					policy.NewResource(bucket, "*"),
-				),
-				condition.NewFunctions(),
-			),
-		},
-	}, nil
-}
-
-// DeleteBucketPolicy - Delete all policies on bucket
-func (l *gcsGateway) DeleteBucketPolicy(ctx context.Context, bucket string) error {
-	// This only removes the storage.AllUsers policies
-	if err := l.client.Bucket(bucket).ACL().Delete(ctx, storage.AllUsers); err != nil {
-		return gcsToObjectError(err, bucket)
+	if err = l.cleanupMultipartUpload(ctx, bucket, key, uploadID); err != nil {
+		return minio.ObjectInfo{}, gcsToObjectError(err, bucket, key)
 	}
-
-	return nil
+	return l.fromGCSAttrsToWrittenObjectInfo(ctx, attrs)
 }
 
 // IsCompressionSupported returns whether compression is applicable for this layer.
 func (l *gcsGateway) IsCompressionSupported() bool {
 	return false
 }
+
+// IsEncryptionSupported returns whether server side encryption is implemented for this layer,
+// the data is encrypted by the gateway, see gateway-gcs-encryption.go.
+func (l *gcsGateway) IsEncryptionSupported() bool {
+	return minio.GlobalKMS != nil || minio.GlobalGatewaySSE.IsSet()
+}
@@ -17,11 +17,17 @@
 package gcs
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -388,6 +394,7 @@ func TestGCSToObjectError(t *testing.T) {
 }
 
 func TestS3MetaToGCSAttributes(t *testing.T) {
+	l := &gcsGateway{storageClasses: newGCSStorageClassTable(nil)}
 	headers := map[string]string{
 		"accept-encoding":          "gzip",
 		"content-encoding":         "gzip",
@@ -410,7 +417,7 @@ func TestS3MetaToGCSAttributes(t *testing.T) {
 	}
 
 	attrs := storage.ObjectAttrs{}
-	applyMetadataToGCSAttrs(headers, &attrs)
+	l.applyMetadataToGCSAttrs(headers, &attrs)
 
 	if !reflect.DeepEqual(attrs.Metadata, expectedHeaders) {
 		t.Fatalf("Test failed, expected %#v, got %#v", expectedHeaders, attrs.Metadata)
@@ -434,6 +441,7 @@ func TestS3MetaToGCSAttributes(t *testing.T) {
 }
 
 func TestGCSAttrsToObjectInfo(t *testing.T) {
+	l := &gcsGateway{storageClasses: newGCSStorageClassTable(nil)}
 	metadata := map[string]string{
 		"x-goog-meta-Hdr":           "value",
 		"x-goog-meta-x_amz_key":     "hu3ZSqtqwn+aL4V2VhAeov4i+bG3KyCtRMSXQFRHXOk=",
@@ -467,7 +475,7 @@ func TestGCSAttrsToObjectInfo(t *testing.T) {
 	}
 	expectedETag := minio.ToS3ETag(fmt.Sprintf("%d", attrs.CRC32C))
 
-	objInfo := fromGCSAttrsToObjectInfo(&attrs)
+	objInfo := l.fromGCSAttrsToObjectInfo(&attrs)
 	if !reflect.DeepEqual(objInfo.UserDefined, expectedMeta) {
 		t.Fatalf("Test failed, expected %#v, got %#v", expectedMeta, objInfo.UserDefined)
 	}
@@ -488,3 +496,196 @@ func TestGCSAttrsToObjectInfo(t *testing.T) {
 		t.Fatalf("Test failed with ETag mistmatch, expected %s, got %s", expectedETag, objInfo.ETag)
 	}
 }
+
+func TestGCSAttrsToObjectInfoMultipartETag(t *testing.T) {
+	l := &gcsGateway{storageClasses: newGCSStorageClassTable(nil)}
+	etag := "2ae4e3fc7bb2bf8b5d2a6b55e3ec3d83-3"
+	attrs := storage.ObjectAttrs{
+		Name:     "test-obj",
+		Bucket:   "test-bucket",
+		CRC32C:   45312398,
+		Metadata: map[string]string{gcsMultipartETagMetaKey: etag, "x-goog-meta-Hdr": "value"},
+	}
+
+	objInfo := l.fromGCSAttrsToObjectInfo(&attrs)
+	if objInfo.ETag != etag {
+		t.Fatalf("Test failed with ETag mistmatch, expected %s, got %s", etag, objInfo.ETag)
+	}
+	expectedMeta := map[string]string{"X-Amz-Meta-Hdr": "value"}
+	if !reflect.DeepEqual(objInfo.UserDefined, expectedMeta) {
+		t.Fatalf("Test failed, expected %#v, got %#v", expectedMeta, objInfo.UserDefined)
+	}
+}
+
+func TestGCSComposeTree(t *testing.T) {
+	testCases := []struct {
+		parts         int
+		top           int
+		intermediates int
+	}{
+		{1, 1, 0},
+		{gcsMaxComponents, gcsMaxComponents, 0},
+		{gcsMaxComponents + 1, 2, 2},
+		{1024, 32, 32},
+		{1025, 2, 33 + 2},
+		{10000, 10, 313 + 10},
+	}
+
+	for i, testCase := range testCases {
+		parts := make([]string, testCase.parts)
+		for j := range parts {
+			parts[j] = gcsMultipartDataName("a", j+1, "b")
+		}
+
+		var mu sync.Mutex
+		composed := make(map[string]int)
+		name := func(level, index int) string {
+			return gcsMultipartComposeName("a", level, index)
+		}
+		compose := func(ctx context.Context, dst string, srcs []string) error {
+			if len(srcs) > gcsMaxComponents {
+				return fmt.Errorf("%d components composed into %s", len(srcs), dst)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			count := 0
+			for _, src := range srcs {
+				if n, ok := composed[src]; ok {
+					count += n
+					continue
+				}
+				count++
+			}
+			composed[dst] = count
+			return nil
+		}
+
+		top, intermediates, err := gcsComposeTree(context.Background(), parts, name, compose)
+		if err != nil {
+			t.Fatalf("Test %d: %v", i+1, err)
+		}
+		if len(top) != testCase.top || len(intermediates) != testCase.intermediates {
+			t.Fatalf("Test %d: expected %d top and %d intermediate objects, got %d and %d",
+				i+1, testCase.top, testCase.intermediates, len(top), len(intermediates))
+		}
+		total := 0
+		for _, obj := range top {
+			if n, ok := composed[obj]; ok {
+				total += n
+			} else {
+				total++
+			}
+		}
+		if total != testCase.parts {
+			t.Errorf("Test %d: expected %d parts to be composed, got %d", i+1, testCase.parts, total)
+		}
+	}
+
+	// Intermediate objects are returned for cleanup on failure.
+	parts := make([]string, 2*gcsMaxComponents)
+	_, intermediates, err := gcsComposeTree(context.Background(), parts,
+		func(level, index int) string { return gcsMultipartComposeName("a", level, index) },
+		func(ctx context.Context, dst string, srcs []string) error { return errors.New("compose failed") })
+	if err == nil || len(intermediates) != 2 {
+		t.Errorf("expected error and 2 intermediate objects, got %v and %d", err, len(intermediates))
+	}
+}
+
+func TestGCSEndpointTransport(t *testing.T) {
+	var hosts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host+r.URL.Path)
+	}))
+	defer server.Close()
+
+	transport, err := newGCSEndpointTransport(server.URL, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverHost := strings.TrimPrefix(server.URL, "http://")
+
+	testCases := []struct {
+		url      string
+		expected string
+	}{
+		{"https://www.googleapis.com/storage/v1/b/bucket", serverHost + "/storage/v1/b/bucket"},
+		{"https://www.googleapis.com/upload/storage/v1/b/bucket/o", serverHost + "/upload/storage/v1/b/bucket/o"},
+		{"https://storage.googleapis.com/bucket/object", serverHost + "/bucket/object"},
+		{server.URL + "/other", serverHost + "/other"},
+	}
+
+	client := &http.Client{Transport: transport}
+	for i, testCase := range testCases {
+		hosts = nil
+		resp, err := client.Get(testCase.url)
+		if err != nil {
+			t.Fatalf("Test %d: %v", i+1, err)
+		}
+		resp.Body.Close()
+		if len(hosts) != 1 || hosts[0] != testCase.expected {
+			t.Errorf("Test %d: expected request to %s, got %v", i+1, testCase.expected, hosts)
+		}
+	}
+
+	if host := transport.backendHost(); host != serverHost {
+		t.Errorf("expected backend host %s, got %s", serverHost, host)
+	}
+	for endpoint, expected := range map[string]string{
+		"http://localhost":  "localhost:http",
+		"https://gcs.local": "gcs.local:https",
+	} {
+		et, err := newGCSEndpointTransport(endpoint, http.DefaultTransport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host := et.backendHost(); host != expected {
+			t.Errorf("expected backend host %s of %s, got %s", expected, endpoint, host)
+		}
+	}
+
+	if _, err = newGCSEndpointTransport("ftp://localhost:4443", http.DefaultTransport); err == nil {
+		t.Errorf("expected unsupported scheme to fail")
+	}
+}
+
+func TestGCSStorageClasses(t *testing.T) {
+	table, err := parseGCSStorageClasses("REDUCED_REDUNDANCY=coldline, GLACIER=ARCHIVE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		s3Class  string
+		gcsClass string
+	}{
+		{"STANDARD", "STANDARD"},
+		{"STANDARD_IA", "NEARLINE"},
+		{"REDUCED_REDUNDANCY", "COLDLINE"},
+		{"GLACIER", "ARCHIVE"},
+		{"DEEP_ARCHIVE", "ARCHIVE"},
+		{"UNKNOWN", ""},
+	}
+	for i, testCase := range testCases {
+		if got := table.GCS(testCase.s3Class); got != testCase.gcsClass {
+			t.Errorf("Test %d: expected %s, got %s", i+1, testCase.gcsClass, got)
+		}
+	}
+
+	// GCS classes read back as the first S3 class still mapped to them.
+	reverse := map[string]string{
+		"STANDARD":       "STANDARD",
+		"NEARLINE":       "STANDARD_IA",
+		"COLDLINE":       "REDUCED_REDUNDANCY",
+		"ARCHIVE":        "DEEP_ARCHIVE",
+		"MULTI_REGIONAL": "STANDARD",
+		"UNKNOWN":        "UNKNOWN",
+	}
+	for gcsClass, s3Class := range reverse {
+		if got := table.S3(gcsClass); got != s3Class {
+			t.Errorf("%s: expected %s, got %s", gcsClass, s3Class, got)
+		}
+	}
+
+	if _, err = parseGCSStorageClasses("GLACIER"); err == nil {
+		t.Errorf("Expected invalid mapping to fail")
+	}
+}
@@ -0,0 +1,67 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"strings"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/crypto"
+)
+
+// Objects are encrypted and compressed by the MinIO handlers, see
+// ming.EncodePartLayout. Their internal metadata is kept in the
+// hdfsInternalXAttr attribute of the file and the layout of multipart
+// objects, whose compressed parts also restart the stream, in the
+// hdfsPartsXAttr attribute.
+
+// IsEncryptionSupported returns whether server side encryption is implemented for this layer.
+func (n *hdfsObjects) IsEncryptionSupported() bool {
+	return minio.GlobalKMS != nil || minio.GlobalGatewaySSE.IsSet()
+}
+
+// IsCompressionSupported returns whether compression is applicable for this layer.
+func (n *hdfsObjects) IsCompressionSupported() bool {
+	return true
+}
+
+// hdfsIsInternalMetadata returns whether the metadata key is internal to
+// the MinIO handlers.
+func hdfsIsInternalMetadata(k string) bool {
+	return strings.HasPrefix(strings.ToLower(k), minio.ReservedMetadataPrefixLower)
+}
+
+// hdfsInternalMetadata returns the internal metadata of the handlers.
+func hdfsInternalMetadata(metadata map[string]string) map[string]string {
+	internal := make(map[string]string)
+	for k, v := range metadata {
+		if hdfsIsInternalMetadata(k) {
+			internal[k] = v
+		}
+	}
+	return internal
+}
+
+// hdfsIsTransformed returns whether the object of the metadata is stored
+// encrypted or compressed, its stored size differing from its actual size.
+func hdfsIsTransformed(metadata map[string]string) bool {
+	if _, ok := crypto.IsEncrypted(metadata); ok {
+		return true
+	}
+	_, ok := metadata[minio.ReservedMetadataPrefix+"compression"]
+	return ok
+}
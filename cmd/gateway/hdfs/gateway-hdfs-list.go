@@ -0,0 +1,506 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/sync/errgroup"
+)
+
+// Objects are listed from the paged directory listings of the namenode,
+// which returns the children of a directory sorted by name:
+//
+// - The directories of a listing are held back until their key, the name
+//   followed by a slash, sorts before the next entry, so entries come in
+//   the order of their keys without reading a directory as a whole.
+//
+// - Directories are only read when they may hold keys of the listing.
+//   Subtrees sorting before the marker are skipped, and so are the keys
+//   sharing a common prefix once it is returned, which makes delimited
+//   listings read a single directory. Empty directories, listed as
+//   objects, are told apart by the number of children the namenode
+//   returns with them.
+//
+// - The lister of a truncated listing is kept for the request of its next
+//   page, as minio.TreeWalkPool keeps tree walks. The HDFS client cannot
+//   start a listing after a name, so the directories on the path of a
+//   marker without lister are paged through from their first entry.
+
+const (
+	// Number of entries read from the namenode at once, the default
+	// dfs.ls.limit of the namenode.
+	hdfsListPageSize = 1000
+
+	// Maximum number of keys of a listing, as in S3.
+	hdfsMaxObjectList = 1000
+
+	// Number of objects whose attributes are read concurrently.
+	hdfsListInfoConcurrency = 10
+
+	// Time the lister of a truncated listing is kept.
+	hdfsListTimeout = 30 * time.Minute
+
+	// Maximum number of kept listers.
+	hdfsMaxListers = 1000
+)
+
+// hdfsDirReader reads the entries of a directory by pages, as
+// *hdfs.FileReader does.
+type hdfsDirReader interface {
+	Stat() os.FileInfo
+	Readdir(n int) ([]os.FileInfo, error)
+}
+
+// hdfsDirOpener opens the directory of the path.
+type hdfsDirOpener func(name string) (hdfsDirReader, error)
+
+// openDir opens the directory of the path for listing.
+func (n *hdfsObjects) openDir(name string) (hdfsDirReader, error) {
+	f, err := n.clnt.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if !f.Stat().IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	return f, nil
+}
+
+// hdfsIsNotDir returns whether the error reports a missing directory,
+// removed or never created, or a file in its place.
+func hdfsIsNotDir(err error) bool {
+	return os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR)
+}
+
+// hdfsChildrenNum returns the number of children of the directory, -1 if
+// the namenode did not return it.
+func hdfsChildrenNum(fi os.FileInfo) int32 {
+	if status, ok := fi.Sys().(*hdfs.FileStatus); ok {
+		return status.GetChildrenNum()
+	}
+	return -1
+}
+
+// hdfsListEntry is an object or a common prefix of a listing.
+type hdfsListEntry struct {
+	key string // object name, ending with a slash for directories
+	fi  os.FileInfo
+}
+
+// hdfsDirCursor returns the entries of a directory in the order of their
+// keys.
+type hdfsDirCursor struct {
+	key      string      // key of the directory, ending with a slash unless the bucket
+	fi       os.FileInfo // nil for the bucket and the parent of the prefix
+	dir      hdfsDirReader
+	page     []os.FileInfo
+	eof      bool
+	nonEmpty bool
+	pending  []hdfsListEntry // directories held back, sorted by key
+}
+
+// readPage reads the next page of entries of the directory.
+func (c *hdfsDirCursor) readPage() error {
+	page, err := c.dir.Readdir(hdfsListPageSize)
+	if err == io.EOF || hdfsIsNotDir(err) {
+		// Directories removed meanwhile end.
+		c.eof = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(page) < hdfsListPageSize {
+		c.eof = true
+	}
+	if len(page) > 0 {
+		c.nonEmpty = true
+	}
+	c.page = page
+	return nil
+}
+
+// next returns the next entry of the directory, false at its end.
+func (c *hdfsDirCursor) next() (hdfsListEntry, bool, error) {
+	for {
+		if len(c.page) == 0 && !c.eof {
+			if err := c.readPage(); err != nil {
+				return hdfsListEntry{}, false, err
+			}
+		}
+		if len(c.page) == 0 {
+			if len(c.pending) == 0 {
+				return hdfsListEntry{}, false, nil
+			}
+			return c.popPending(), true, nil
+		}
+
+		fi := c.page[0]
+		key := c.key + fi.Name()
+		if len(c.pending) > 0 && c.pending[0].key < key {
+			return c.popPending(), true, nil
+		}
+		c.page = c.page[1:]
+		if !fi.IsDir() {
+			return hdfsListEntry{key: key, fi: fi}, true, nil
+		}
+
+		// Names continuing with a byte sorting before the slash, as in
+		// "a-b" after the directory "a", sort before the directory.
+		e := hdfsListEntry{key: key + hdfsSeparator, fi: fi}
+		i := sort.Search(len(c.pending), func(i int) bool { return c.pending[i].key > e.key })
+		c.pending = append(c.pending, hdfsListEntry{})
+		copy(c.pending[i+1:], c.pending[i:])
+		c.pending[i] = e
+	}
+}
+
+func (c *hdfsDirCursor) popPending() hdfsListEntry {
+	e := c.pending[0]
+	c.pending = c.pending[1:]
+	return e
+}
+
+// hdfsLister returns the objects and common prefixes of a listing in the
+// order of their keys.
+type hdfsLister struct {
+	open      hdfsDirOpener
+	root      string // path of the bucket directory
+	prefix    string
+	delimiter string
+	marker    string // keys up to the marker are skipped
+	skip      string // keys of the last common prefix are skipped
+
+	started bool
+	stack   []*hdfsDirCursor
+	peeked  *hdfsListEntry
+}
+
+// newHDFSLister returns the lister of the keys of the prefix after the
+// marker, in the bucket directory root.
+func newHDFSLister(open hdfsDirOpener, root, prefix, delimiter, marker string) *hdfsLister {
+	return &hdfsLister{
+		open:      open,
+		root:      root,
+		prefix:    prefix,
+		delimiter: delimiter,
+		marker:    marker,
+	}
+}
+
+// start opens the directory of the prefix.
+func (l *hdfsLister) start() error {
+	l.started = true
+	base := l.prefix[:strings.LastIndex(l.prefix, hdfsSeparator)+1]
+	dir, err := l.open(minio.PathJoin(l.root, base))
+	if hdfsIsNotDir(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c := &hdfsDirCursor{key: base, dir: dir}
+	if base != "" && base == l.prefix {
+		// The directory of the prefix is an object when empty.
+		c.fi = dir.Stat()
+	}
+	l.stack = append(l.stack, c)
+	return nil
+}
+
+// commonPrefix returns the common prefix of the key, empty if the key has
+// no delimiter after the prefix.
+func (l *hdfsLister) commonPrefix(key string) string {
+	if l.delimiter == "" {
+		return ""
+	}
+	i := strings.Index(key[len(l.prefix):], l.delimiter)
+	if i < 0 {
+		return ""
+	}
+	return key[:len(l.prefix)+i+len(l.delimiter)]
+}
+
+// peek returns the next entry of the listing without consuming it, false
+// at the end. Common prefixes have no file info.
+func (l *hdfsLister) peek() (hdfsListEntry, bool, error) {
+	if l.peeked == nil {
+		e, ok, err := l.read()
+		if err != nil || !ok {
+			return hdfsListEntry{}, false, err
+		}
+		l.peeked = &e
+	}
+	return *l.peeked, true, nil
+}
+
+// next returns the next entry of the listing, false at the end.
+func (l *hdfsLister) next() (hdfsListEntry, bool, error) {
+	e, ok, err := l.peek()
+	l.peeked = nil
+	return e, ok, err
+}
+
+func (l *hdfsLister) read() (hdfsListEntry, bool, error) {
+	if !l.started {
+		if err := l.start(); err != nil {
+			return hdfsListEntry{}, false, err
+		}
+	}
+	for len(l.stack) > 0 {
+		c := l.stack[len(l.stack)-1]
+		e, ok, err := c.next()
+		if err != nil {
+			return hdfsListEntry{}, false, err
+		}
+		if !ok {
+			l.stack = l.stack[:len(l.stack)-1]
+			// Empty directories are objects.
+			if c.fi != nil && !c.nonEmpty && c.key > l.marker {
+				return hdfsListEntry{key: c.key, fi: c.fi}, true, nil
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(e.key, l.prefix) {
+			if e.key > l.prefix {
+				// Past the keys of the prefix, all in the directory of
+				// the prefix.
+				l.stack = l.stack[:0]
+			}
+			continue
+		}
+		if l.skip != "" && strings.HasPrefix(e.key, l.skip) {
+			continue
+		}
+		if prefix := l.commonPrefix(e.key); prefix != "" {
+			l.skip = prefix
+			if prefix <= l.marker {
+				continue
+			}
+			return hdfsListEntry{key: prefix}, true, nil
+		}
+
+		if !e.fi.IsDir() {
+			if e.key <= l.marker {
+				continue
+			}
+			return e, true, nil
+		}
+		if e.key <= l.marker && !strings.HasPrefix(l.marker, e.key) {
+			// All keys of the directory sort before the marker.
+			continue
+		}
+		if hdfsChildrenNum(e.fi) == 0 {
+			if e.key <= l.marker {
+				continue
+			}
+			return e, true, nil
+		}
+		dir, err := l.open(minio.PathJoin(l.root, e.key))
+		if hdfsIsNotDir(err) {
+			continue
+		}
+		if err != nil {
+			return hdfsListEntry{}, false, err
+		}
+		l.stack = append(l.stack, &hdfsDirCursor{key: e.key, fi: e.fi, dir: dir})
+	}
+	return hdfsListEntry{}, false, nil
+}
+
+// hdfsListParams identify the listing a lister continues.
+type hdfsListParams struct {
+	bucket, prefix, delimiter, marker string
+}
+
+// hdfsListPool keeps the listers of truncated listings by the marker of
+// their next page.
+type hdfsListPool struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	listers map[hdfsListParams][]*hdfsPooledLister
+	size    int
+}
+
+type hdfsPooledLister struct {
+	lister *hdfsLister
+	timer  *time.Timer
+}
+
+// newHDFSListPool returns a pool keeping listers for the timeout.
+func newHDFSListPool(timeout time.Duration) *hdfsListPool {
+	return &hdfsListPool{
+		timeout: timeout,
+		listers: make(map[hdfsListParams][]*hdfsPooledLister),
+	}
+}
+
+// release removes and returns a lister continuing the listing, nil if
+// there is none.
+func (p *hdfsListPool) release(params hdfsListParams) *hdfsLister {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pls := p.listers[params]
+	if len(pls) == 0 {
+		return nil
+	}
+	pl := pls[0]
+	p.removeLocked(params, pl)
+	pl.timer.Stop()
+	return pl.lister
+}
+
+// set keeps the lister continuing the listing, unless the pool is full.
+func (p *hdfsListPool) set(params hdfsListParams, l *hdfsLister) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.size >= hdfsMaxListers {
+		return
+	}
+	pl := &hdfsPooledLister{lister: l}
+	pl.timer = time.AfterFunc(p.timeout, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.removeLocked(params, pl)
+	})
+	p.listers[params] = append(p.listers[params], pl)
+	p.size++
+}
+
+func (p *hdfsListPool) removeLocked(params hdfsListParams, pl *hdfsPooledLister) {
+	pls := p.listers[params]
+	for i := range pls {
+		if pls[i] != pl {
+			continue
+		}
+		pls = append(pls[:i], pls[i+1:]...)
+		p.size--
+		break
+	}
+	if len(pls) == 0 {
+		delete(p.listers, params)
+	} else {
+		p.listers[params] = pls
+	}
+}
+
+// list returns up to maxKeys entries of the listing, followed by the next
+// entry if there are more, with the lister kept for the listing or a new
+// one of the bucket directory root. The lister of a truncated listing is
+// kept for its next page.
+func (p *hdfsListPool) list(open hdfsDirOpener, root string, params hdfsListParams, maxKeys int) ([]hdfsListEntry, error) {
+	l := p.release(params)
+	if l == nil {
+		l = newHDFSLister(open, root, params.prefix, params.delimiter, params.marker)
+	}
+	var entries []hdfsListEntry
+	for len(entries) < maxKeys {
+		e, ok, err := l.next()
+		if err != nil || !ok {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	e, ok, err := l.peek()
+	if err != nil || !ok {
+		return entries, err
+	}
+	params.marker = entries[len(entries)-1].key
+	p.set(params, l)
+	return append(entries, e), nil
+}
+
+// ListObjects lists the objects of the bucket, see hdfsLister.
+func (n *hdfsObjects) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (loi minio.ListObjectsInfo, err error) {
+	if n, err = n.forRequest(ctx, bucket); err != nil {
+		return loi, err
+	}
+
+	if _, err = n.clnt.Stat(n.hdfsPathJoin(bucket)); err != nil {
+		return loi, hdfsToObjectErr(ctx, err, bucket)
+	}
+	if !minio.IsValidObjectPrefix(prefix) {
+		return loi, minio.ObjectNameInvalid{Bucket: bucket, Object: prefix}
+	}
+	if marker != "" && !strings.HasPrefix(marker, prefix) {
+		return loi, minio.InvalidMarkerPrefixCombination{Marker: marker, Prefix: prefix}
+	}
+	// Keys never start with a slash.
+	if maxKeys == 0 || strings.HasPrefix(prefix, hdfsSeparator) {
+		return loi, nil
+	}
+	if maxKeys < 0 || maxKeys > hdfsMaxObjectList {
+		maxKeys = hdfsMaxObjectList
+	}
+
+	params := hdfsListParams{bucket, prefix, delimiter, marker}
+	entries, err := n.listPool.list(n.openDir, n.hdfsPathJoin(bucket), params, maxKeys)
+	if err != nil {
+		return loi, hdfsToObjectErr(ctx, err, bucket, prefix)
+	}
+	if len(entries) > maxKeys {
+		entries = entries[:maxKeys]
+		loi.IsTruncated = true
+		loi.NextMarker = entries[maxKeys-1].key
+	}
+
+	// The attributes of the objects are read concurrently, objects
+	// removed meanwhile are skipped.
+	infos := make([]*minio.ObjectInfo, len(entries))
+	g := errgroup.WithNErrs(len(entries)).WithConcurrency(hdfsListInfoConcurrency)
+	for i := range entries {
+		if entries[i].fi == nil {
+			continue
+		}
+		i := i
+		g.Go(func() error {
+			e := entries[i]
+			objInfo, err := n.objectInfo(ctx, bucket, e.key, e.fi)
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return hdfsToObjectErr(ctx, err, bucket, e.key)
+			}
+			infos[i] = &objInfo
+			return nil
+		}, i)
+	}
+	if err = g.WaitErr(); err != nil {
+		return minio.ListObjectsInfo{}, err
+	}
+	for i, objInfo := range infos {
+		if entries[i].fi == nil {
+			loi.Prefixes = append(loi.Prefixes, entries[i].key)
+		} else if objInfo != nil {
+			loi.Objects = append(loi.Objects, *objInfo)
+		}
+	}
+	return loi, nil
+}
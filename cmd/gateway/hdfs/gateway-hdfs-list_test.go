@@ -0,0 +1,367 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2"
+	minio "github.com/minio/minio/cmd"
+)
+
+// fakeFileInfo is the status of a file or directory of a fake namenode,
+// with the number of children of directories.
+type fakeFileInfo struct {
+	name   string
+	size   int64
+	dir    bool
+	status hdfs.FileStatus
+}
+
+func (fi *fakeFileInfo) Name() string       { return fi.name }
+func (fi *fakeFileInfo) Size() int64        { return fi.size }
+func (fi *fakeFileInfo) Mode() os.FileMode  { return 0 }
+func (fi *fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *fakeFileInfo) IsDir() bool        { return fi.dir }
+func (fi *fakeFileInfo) Sys() interface{}   { return &fi.status }
+
+// fakeNamenode serves the listings of a tree of files, counting its calls
+// as a namenode would receive them.
+type fakeNamenode struct {
+	dirs  map[string][]os.FileInfo // entries of directories, sorted by name
+	calls int64
+}
+
+// newFakeNamenode returns a namenode of the keys below /bucket, keys
+// ending with a slash are empty directories.
+func newFakeNamenode(keys []string) *fakeNamenode {
+	nn := &fakeNamenode{dirs: map[string][]os.FileInfo{"/bucket": nil}}
+	children := make(map[string]map[string]bool)
+	for _, key := range keys {
+		name := path.Join("/bucket", key)
+		isDir := strings.HasSuffix(key, hdfsSeparator)
+		for name != "/bucket" {
+			dir := path.Dir(name)
+			if children[dir] == nil {
+				children[dir] = make(map[string]bool)
+			}
+			children[dir][path.Base(name)] = children[dir][path.Base(name)] || isDir
+			if isDir {
+				if _, ok := nn.dirs[name]; !ok {
+					nn.dirs[name] = nil
+				}
+			}
+			name, isDir = dir, true
+		}
+	}
+	for dir, names := range children {
+		for name, isDir := range names {
+			nn.dirs[dir] = append(nn.dirs[dir], &fakeFileInfo{name: name, size: int64(len(name)), dir: isDir})
+		}
+		sort.Slice(nn.dirs[dir], func(i, j int) bool { return nn.dirs[dir][i].Name() < nn.dirs[dir][j].Name() })
+	}
+	for dir, fis := range nn.dirs {
+		for _, fi := range fis {
+			if fi.IsDir() {
+				n := int32(len(nn.dirs[path.Join(dir, fi.Name())]))
+				fi.(*fakeFileInfo).status.ChildrenNum = &n
+			}
+		}
+	}
+	return nn
+}
+
+// open opens the directory, a getFileInfo call.
+func (nn *fakeNamenode) open(name string) (hdfsDirReader, error) {
+	atomic.AddInt64(&nn.calls, 1)
+	fis, ok := nn.dirs[path.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return &fakeDir{nn: nn, name: path.Base(name), fis: fis}, nil
+}
+
+// fakeDir reads a directory of a fake namenode as *hdfs.FileReader does,
+// with a getListing call per hdfsListPageSize entries.
+type fakeDir struct {
+	nn   *fakeNamenode
+	name string
+	fis  []os.FileInfo
+	pos  int
+}
+
+func (d *fakeDir) Stat() os.FileInfo {
+	n := int32(len(d.fis))
+	fi := &fakeFileInfo{name: d.name, dir: true}
+	fi.status.ChildrenNum = &n
+	return fi
+}
+
+func (d *fakeDir) Readdir(n int) ([]os.FileInfo, error) {
+	if n <= 0 {
+		d.pos = 0
+		n = len(d.fis)
+	}
+	end := d.pos + n
+	if end > len(d.fis) {
+		end = len(d.fis)
+	}
+	atomic.AddInt64(&d.nn.calls, int64((end-d.pos)/hdfsListPageSize+1))
+	if n > 0 && end == d.pos {
+		return nil, io.EOF
+	}
+	fis := d.fis[d.pos:end]
+	d.pos = end
+	return fis, nil
+}
+
+// listKeys lists the keys of a listing of the fake namenode by pages of
+// maxKeys, with the listers of a pool or a new one for each page.
+func listKeys(t testing.TB, nn *fakeNamenode, prefix, marker, delimiter string, maxKeys int, pooled bool) []string {
+	var keys []string
+	pool := newHDFSListPool(time.Minute)
+	for {
+		if !pooled {
+			pool = newHDFSListPool(time.Minute)
+		}
+		params := hdfsListParams{"bucket", prefix, delimiter, marker}
+		entries, err := pool.list(nn.open, "/bucket", params, maxKeys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		more := len(entries) > maxKeys
+		if more {
+			entries = entries[:maxKeys]
+		}
+		for _, e := range entries {
+			keys = append(keys, e.key)
+		}
+		if !more {
+			return keys
+		}
+		marker = entries[len(entries)-1].key
+	}
+}
+
+// s3ListKeys returns the keys and common prefixes of a listing of the
+// keys in S3.
+func s3ListKeys(keys []string, prefix, marker, delimiter string) []string {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	var result []string
+	for _, key := range sorted {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				key = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if key <= marker || (len(result) > 0 && result[len(result)-1] == key) {
+			continue
+		}
+		result = append(result, key)
+	}
+	return result
+}
+
+func TestHDFSLister(t *testing.T) {
+	keys := []string{
+		"a-b", "a.c", "a/b-c/d", "a/b.c", "a/b/c", "a/b/d/",
+		"a!/x", "ab/c", "b/", "c/d/e/f", "c/d/e-f", "c/d-e",
+		"dir/empty/", "dir/file", "x y/z", "z",
+	}
+	for i := 0; i < 2*hdfsListPageSize+10; i++ {
+		keys = append(keys, fmt.Sprintf("many/%05d", i), fmt.Sprintf("many/%05d-/x", i))
+	}
+	nn := newFakeNamenode(keys)
+
+	prefixes := []string{"", "a", "a/", "a/b", "c/d/", "dir/empty/", "many/0", "missing/", "z/"}
+	markers := []string{"", "a-b", "a/b/", "a/b-c/d", "c/d/e", "many/00999", "many/01000-/x"}
+	for _, prefix := range prefixes {
+		for _, marker := range markers {
+			if !strings.HasPrefix(marker, prefix) {
+				continue
+			}
+			for _, delimiter := range []string{"", hdfsSeparator, "-", "/b"} {
+				want := s3ListKeys(keys, prefix, marker, delimiter)
+				// Pages without lister read the directories on the path of
+				// their marker again, unpooled listings use larger pages.
+				for _, maxKeys := range []int{1, 3, 200, hdfsMaxObjectList} {
+					for _, pooled := range []bool{true, false} {
+						if !pooled && maxKeys < 200 {
+							continue
+						}
+						got := listKeys(t, nn, prefix, marker, delimiter, maxKeys, pooled)
+						if !reflect.DeepEqual(got, want) {
+							i := 0
+							for i < len(got) && i < len(want) && got[i] == want[i] {
+								i++
+							}
+							t.Fatalf("prefix %q marker %q delimiter %q maxKeys %d pooled %v: got %d keys, want %d, first difference at %d: got %q, want %q",
+								prefix, marker, delimiter, maxKeys, pooled, len(got), len(want), i, got[i:], want[i:])
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// benchListLayer is the object layer the tree walk checks the bucket of.
+type benchListLayer struct {
+	minio.ObjectLayer
+}
+
+func (benchListLayer) GetBucketInfo(ctx context.Context, bucket string) (minio.BucketInfo, error) {
+	return minio.BucketInfo{Name: bucket}, nil
+}
+
+// treeWalkListKeys lists the keys of the fake namenode by pages of
+// maxKeys as the gateway did with minio.TreeWalkPool: reading directories
+// whole, checking the emptiness of each directory, and reading the parent
+// directory of the first object of each page again for its file info.
+func treeWalkListKeys(b *testing.B, nn *fakeNamenode, prefix, delimiter string, maxKeys int) int {
+	readDir := func(name string) ([]os.FileInfo, error) {
+		d, err := nn.open(name)
+		if err != nil {
+			return nil, err
+		}
+		return d.Readdir(0)
+	}
+	isLeaf := func(bucket, leafPath string) bool {
+		return !strings.HasSuffix(leafPath, hdfsSeparator)
+	}
+	isLeafDir := func(bucket, leafPath string) bool {
+		d, err := nn.open(path.Join("/", bucket, leafPath))
+		if err != nil {
+			return false
+		}
+		fis, err := d.Readdir(1)
+		return err == io.EOF || (err == nil && len(fis) == 0)
+	}
+	listDir := func(bucket, prefixDir, prefixEntry string) (emptyDir bool, entries []string, delayIsLeaf bool) {
+		fis, err := readDir(path.Join("/", bucket, prefixDir))
+		if err != nil {
+			return
+		}
+		if len(fis) == 0 {
+			return true, nil, false
+		}
+		for _, fi := range fis {
+			if fi.IsDir() {
+				entries = append(entries, fi.Name()+hdfsSeparator)
+			} else {
+				entries = append(entries, fi.Name())
+			}
+		}
+		entries, delayIsLeaf = minio.FilterListEntries(bucket, prefixDir, entries, prefixEntry, isLeaf)
+		return false, entries, delayIsLeaf
+	}
+
+	tpool := minio.NewTreeWalkPool(time.Minute)
+	var count int
+	marker := ""
+	for {
+		// The file infos were shared by the concurrent calls unguarded.
+		var mu sync.Mutex
+		fileInfos := make(map[string]os.FileInfo)
+		getObjectInfo := func(ctx context.Context, bucket, entry string) (minio.ObjectInfo, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			name := path.Clean(path.Join("/", bucket, entry))
+			fi, ok := fileInfos[name]
+			if !ok {
+				fis, err := readDir(path.Dir(name))
+				if err != nil {
+					return minio.ObjectInfo{}, err
+				}
+				for _, fi := range fis {
+					fileInfos[path.Join(path.Dir(name), fi.Name())] = fi
+				}
+				fi = fileInfos[name]
+			}
+			delete(fileInfos, name)
+			return minio.ObjectInfo{Bucket: bucket, Name: entry, Size: fi.Size(), IsDir: fi.IsDir()}, nil
+		}
+		loi, err := minio.ListObjects(context.Background(), benchListLayer{}, "bucket", prefix, marker, delimiter, maxKeys,
+			tpool, listDir, isLeaf, isLeafDir, getObjectInfo, getObjectInfo)
+		if err != nil {
+			b.Fatal(err)
+		}
+		count += len(loi.Objects) + len(loi.Prefixes)
+		if !loi.IsTruncated {
+			return count
+		}
+		marker = loi.NextMarker
+	}
+}
+
+// BenchmarkHDFSListObjects lists a directory of many files, and one of
+// many directories with a delimiter, by pages of 1000 keys with the
+// former tree walk and the native lister. The calls to the namenode per
+// listing are reported as calls/op.
+func BenchmarkHDFSListObjects(b *testing.B) {
+	const files, dirs = 50000, 5000
+	var keys []string
+	for i := 0; i < files; i++ {
+		keys = append(keys, fmt.Sprintf("files/%08d", i))
+	}
+	for i := 0; i < dirs; i++ {
+		keys = append(keys, fmt.Sprintf("dirs/%08d/object", i))
+	}
+	nn := newFakeNamenode(keys)
+
+	for _, bc := range []struct {
+		name, prefix, delimiter string
+		count                   int
+	}{
+		{"files", "files/", "", files},
+		{"dirs-delimited", "dirs/", hdfsSeparator, dirs},
+	} {
+		b.Run(bc.name+"/treewalk", func(b *testing.B) {
+			atomic.StoreInt64(&nn.calls, 0)
+			for i := 0; i < b.N; i++ {
+				if count := treeWalkListKeys(b, nn, bc.prefix, bc.delimiter, hdfsMaxObjectList); count != bc.count {
+					b.Fatalf("listed %d keys, want %d", count, bc.count)
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&nn.calls))/float64(b.N), "calls/op")
+		})
+		b.Run(bc.name+"/native", func(b *testing.B) {
+			atomic.StoreInt64(&nn.calls, 0)
+			for i := 0; i < b.N; i++ {
+				if count := len(listKeys(b, nn, bc.prefix, "", bc.delimiter, hdfsMaxObjectList, true)); count != bc.count {
+					b.Fatalf("listed %d keys, want %d", count, bc.count)
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&nn.calls))/float64(b.N), "calls/op")
+		})
+	}
+}
@@ -0,0 +1,532 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2"
+	"github.com/colinmarc/hdfs/v2/hadoopconf"
+	"github.com/gorilla/mux"
+	ming "github.com/minio/ming/cmd"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+	"github.com/minio/minio/pkg/env"
+	"github.com/minio/minio/pkg/madmin"
+)
+
+// A gateway started with viewfs://<table>/ serves the namespaces of the
+// ViewFS mount table of that name in the Hadoop configuration, of HDFS
+// federations or of separate clusters:
+//
+//	fs.viewfs.mounttable.<table>.link./<bucket>  hdfs://<nameservice>/<path>
+//	fs.viewfs.mounttable.<table>.linkFallback    hdfs://<nameservice>/<path>
+//
+// A link mounts its directory as the bucket of its name, its .minio.sys
+// directory is next to it. The fallback mounts a directory of buckets as
+// the gateway of a single namespace does, buckets of links shadow its
+// buckets of the same name. Nameservices are resolved to their namenodes
+// with the HA settings of hdfs-site.xml, dfs.ha.namenodes.<nameservice>
+// and dfs.namenode.rpc-address.<nameservice>.<namenode>, other targets
+// name a namenode.
+//
+// Each mount has its own clients, connected on its first request, and may
+// log in with its own Kerberos principal. Requests for the buckets of a
+// mount which cannot be connected fail with BackendDown, other mounts are
+// served.
+
+const (
+	// Name of the fallback mount.
+	hdfsFallbackMount = "/"
+
+	// Time between connection attempts of a mount.
+	hdfsMountRetryInterval = 30 * time.Second
+
+	// Default mount table of ViewFS.
+	hdfsDefaultMountTable = "default"
+)
+
+// hdfsViewFSTable returns the mount table of a viewfs:// argument, empty
+// if the arguments name namenodes.
+func hdfsViewFSTable(args []string) (string, error) {
+	for _, s := range args {
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme != "viewfs" {
+			continue
+		}
+		if len(args) != 1 {
+			return "", fmt.Errorf("viewfs:// cannot be combined with other namenodes %s", args)
+		}
+		if u.Path != "" && u.Path != hdfsSeparator {
+			return "", fmt.Errorf("unsupported path %s of mount table %s", u.Path, u)
+		}
+		if u.Host == "" {
+			return hdfsDefaultMountTable, nil
+		}
+		return u.Host, nil
+	}
+	return "", nil
+}
+
+// parseHDFSMountTable returns the link targets by bucket and the fallback
+// target of the mount table.
+func parseHDFSMountTable(conf hadoopconf.HadoopConf, table string) (links map[string]string, fallback string, err error) {
+	prefix := "fs.viewfs.mounttable." + table + "."
+	links = make(map[string]string)
+	for k, v := range conf {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		switch key := strings.TrimPrefix(k, prefix); {
+		case strings.HasPrefix(key, "link./"):
+			bucket := strings.TrimSuffix(strings.TrimPrefix(key, "link./"), hdfsSeparator)
+			if !hdfsIsValidBucketName(bucket) || isReservedOrInvalidBucket(bucket, true) {
+				return nil, "", fmt.Errorf("link %s of mount table %s is not a bucket", key, table)
+			}
+			links[bucket] = v
+		case key == "linkFallback":
+			fallback = v
+		case strings.HasPrefix(key, "link"):
+			// Merge and regex links have no bucket to map to.
+			return nil, "", fmt.Errorf("unsupported link %s of mount table %s", key, table)
+		}
+	}
+	if len(links) == 0 && fallback == "" {
+		return nil, "", fmt.Errorf("mount table %s has no links, expected %slink./<bucket> properties", table, prefix)
+	}
+	return links, fallback, nil
+}
+
+// hdfsNameserviceNamenodes returns the RPC addresses of the namenodes of
+// the nameservice, nil if the configuration has no such nameservice.
+func hdfsNameserviceNamenodes(conf hadoopconf.HadoopConf, nameservice string) []string {
+	return hdfsNameserviceAddresses(conf, "dfs.namenode.rpc-address", nameservice)
+}
+
+// hdfsNameserviceWebEndpoint returns the HTTP address of the first
+// namenode of the nameservice, empty if the configuration has none.
+func hdfsNameserviceWebEndpoint(conf hadoopconf.HadoopConf, nameservice string) string {
+	if addrs := hdfsNameserviceAddresses(conf, "dfs.namenode.http-address", nameservice); len(addrs) > 0 {
+		return addrs[0]
+	}
+	return ""
+}
+
+// hdfsNameserviceAddresses returns the addresses of the key of the
+// namenodes of the nameservice, of each of its HA namenodes or of its
+// single namenode.
+func hdfsNameserviceAddresses(conf hadoopconf.HadoopConf, key, nameservice string) []string {
+	if nameservice == "" {
+		return nil
+	}
+	if nns := conf["dfs.ha.namenodes."+nameservice]; nns != "" {
+		var addrs []string
+		for _, nn := range strings.Split(nns, ",") {
+			if addr := conf[key+"."+nameservice+"."+strings.TrimSpace(nn)]; addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+		return addrs
+	}
+	if addr := conf[key+"."+nameservice]; addr != "" {
+		return []string{addr}
+	}
+	return nil
+}
+
+// hdfsNamenodePrincipal returns the service principal name of the
+// namenodes of the nameservice, empty if not set for the nameservice.
+func hdfsNamenodePrincipal(conf hadoopconf.HadoopConf, nameservice string) string {
+	if principal := conf["dfs.namenode.kerberos.principal."+nameservice]; principal != "" {
+		return strings.Split(principal, "@")[0]
+	}
+	return ""
+}
+
+// parseHDFSMountPrincipals parses the mount to Kerberos principal table,
+// given as comma separated "mount=user@REALM" pairs.
+func parseHDFSMountPrincipals(s string) (map[string]string, error) {
+	principals := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i <= 0 || i == len(kv)-1 {
+			return nil, fmt.Errorf("invalid mount principal %q, expected mount=user@REALM", kv)
+		}
+		principals[kv[:i]] = kv[i+1:]
+	}
+	return principals, nil
+}
+
+// hdfsMount is a mount of the mount table.
+type hdfsMount struct {
+	name      string   // bucket of a link, hdfsFallbackMount for the fallback
+	target    string   // URI of the mounted directory
+	namenodes []string // RPC addresses of the namenodes
+	connect   func() (*hdfsObjects, error)
+
+	mu         sync.Mutex
+	layer      *hdfsObjects // nil until connected
+	err        error        // error of the last connection attempt
+	attempted  time.Time
+	connecting bool // a connection attempt is in progress
+}
+
+// get returns the layer of the mount, connecting it on the first request
+// and again after failed attempts. The namenodes are dialed outside of the
+// lock, requests arriving while the mount connects fail fast rather than
+// waiting on a slow or hanging namenode.
+func (m *hdfsMount) get() (*hdfsObjects, error) {
+	m.mu.Lock()
+	if m.layer != nil {
+		defer m.mu.Unlock()
+		return m.layer, nil
+	}
+	if m.connecting || time.Since(m.attempted) < hdfsMountRetryInterval {
+		m.mu.Unlock()
+		return nil, minio.BackendDown{}
+	}
+	m.attempted = time.Now()
+	m.connecting = true
+	m.mu.Unlock()
+
+	layer, err := m.connect()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.layer, m.err, m.connecting = layer, err, false
+	if err != nil {
+		logger.LogIf(minio.GlobalContext, fmt.Errorf("unable to connect mount %s to %s: %w", m.name, m.target, err))
+		return nil, minio.BackendDown{}
+	}
+	return layer, nil
+}
+
+// connected returns the layer of the mount, nil and the error of the last
+// connection attempt if not connected. It does not wait for a connection
+// attempt in progress.
+func (m *hdfsMount) connected() (*hdfsObjects, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.layer, m.err
+}
+
+// hdfsMounts are the mounts of the mount table.
+type hdfsMounts struct {
+	links    map[string]*hdfsMount // link mounts by bucket
+	fallback *hdfsMount            // nil without fallback
+}
+
+// newHDFSMounts returns the layer serving the mounts of the mount table,
+// connecting them. Mounts which cannot be connected are connected again
+// on their next requests.
+func newHDFSMounts(conf hadoopconf.HadoopConf, table string, cfg hdfsConfig) (*hdfsObjects, error) {
+	links, fallback, err := parseHDFSMountTable(conf, table)
+	if err != nil {
+		return nil, err
+	}
+	principals, err := parseHDFSMountPrincipals(env.Get("MINIO_HDFS_MOUNT_PRINCIPALS", ""))
+	if err != nil {
+		return nil, err
+	}
+	for name := range principals {
+		if _, ok := links[name]; !ok && (name != hdfsFallbackMount || fallback == "") {
+			return nil, fmt.Errorf("unknown mount %s of kerberos principal", name)
+		}
+	}
+
+	mounts := &hdfsMounts{links: make(map[string]*hdfsMount)}
+	for bucket, target := range links {
+		if mounts.links[bucket], err = newHDFSMount(conf, bucket, target, principals[bucket], cfg); err != nil {
+			return nil, err
+		}
+	}
+	if fallback != "" {
+		if mounts.fallback, err = newHDFSMount(conf, hdfsFallbackMount, fallback, principals[hdfsFallbackMount], cfg); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range mounts.all() {
+		m.get()
+	}
+	return &hdfsObjects{
+		mounts:         mounts,
+		storageClasses: cfg.storageClasses,
+		trash:          cfg.trash,
+		trashRetention: cfg.trashRetention,
+	}, nil
+}
+
+// newHDFSMount returns the mount of the target URI.
+func newHDFSMount(conf hadoopconf.HadoopConf, name, target, principal string, cfg hdfsConfig) (*hdfsMount, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %s of mount %s: %v", target, name, err)
+	}
+	if u.Scheme != "hdfs" || u.Host == "" {
+		return nil, fmt.Errorf("unsupported target %s of mount %s, only supports hdfs://", target, name)
+	}
+	root := path.Clean(hdfsSeparator + u.Path)
+	link := ""
+	if name != hdfsFallbackMount {
+		if root == hdfsSeparator {
+			return nil, fmt.Errorf("target %s of mount %s is not a directory below the root", target, name)
+		}
+		link = name
+	}
+
+	opts := hdfs.ClientOptionsFromConf(conf)
+	opts.NamenodeDialFunc = hdfsDialFunc
+	opts.DatanodeDialFunc = hdfsDialFunc
+	opts.Addresses = hdfsNameserviceNamenodes(conf, u.Host)
+	if len(opts.Addresses) == 0 {
+		opts.Addresses = []string{u.Host}
+	}
+	if spn := hdfsNamenodePrincipal(conf, u.Host); spn != "" {
+		opts.KerberosServicePrincipleName = spn
+	}
+	if opts, err = hdfsClientUser(opts, principal); err != nil {
+		return nil, fmt.Errorf("mount %s: %v", name, err)
+	}
+	webEndpoint := hdfsNameserviceWebEndpoint(conf, u.Host)
+
+	return &hdfsMount{
+		name:      name,
+		target:    target,
+		namenodes: opts.Addresses,
+		connect: func() (*hdfsObjects, error) {
+			return newHDFSObjects(opts, root, link, webEndpoint, cfg)
+		},
+	}, nil
+}
+
+// all returns the link mounts sorted by bucket, followed by the fallback.
+func (ms *hdfsMounts) all() []*hdfsMount {
+	mounts := make([]*hdfsMount, 0, len(ms.links)+1)
+	for _, m := range ms.links {
+		mounts = append(mounts, m)
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].name < mounts[j].name })
+	if ms.fallback != nil {
+		mounts = append(mounts, ms.fallback)
+	}
+	return mounts
+}
+
+// mount returns the mount of the bucket.
+func (ms *hdfsMounts) mount(bucket string) (*hdfsMount, error) {
+	if m, ok := ms.links[bucket]; ok {
+		return m, nil
+	}
+	if ms.fallback != nil {
+		return ms.fallback, nil
+	}
+	return nil, minio.BucketNotFound{Bucket: bucket}
+}
+
+// mount returns the layer of the namespace of the bucket.
+func (n *hdfsObjects) mount(bucket string) (*hdfsObjects, error) {
+	if n.mounts == nil {
+		return n, nil
+	}
+	m, err := n.mounts.mount(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return m.get()
+}
+
+// listBuckets lists the buckets of all mounts, skipping mounts which are
+// down unless all are.
+func (ms *hdfsMounts) listBuckets(ctx context.Context) ([]minio.BucketInfo, error) {
+	var buckets []minio.BucketInfo
+	var lastErr error
+	listed := false
+	for _, m := range ms.all() {
+		layer, err := m.get()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		mbuckets, err := layer.ListBuckets(ctx)
+		if err != nil {
+			logger.LogIf(ctx, fmt.Errorf("unable to list buckets of mount %s: %w", m.name, err))
+			lastErr = err
+			continue
+		}
+		listed = true
+		for _, bucket := range mbuckets {
+			if _, ok := ms.links[bucket.Name]; ok && m == ms.fallback {
+				continue
+			}
+			buckets = append(buckets, bucket)
+		}
+	}
+	if !listed && lastErr != nil {
+		return nil, lastErr
+	}
+	sort.Sort(byBucketName(buckets))
+	return buckets, nil
+}
+
+// hdfsMountStatus is the health of a mount.
+type hdfsMountStatus struct {
+	Mount     string   `json:"mount"`
+	Target    string   `json:"target"`
+	Namenodes []string `json:"namenodes"`
+	State     string   `json:"state"`
+	Error     string   `json:"error,omitempty"`
+	Capacity  uint64   `json:"capacity"`
+	Used      uint64   `json:"used"`
+	Remaining uint64   `json:"remaining"`
+}
+
+// status returns the health of the mounts, checked concurrently.
+func (ms *hdfsMounts) status() []hdfsMountStatus {
+	mounts := ms.all()
+	statuses := make([]hdfsMountStatus, len(mounts))
+	var wg sync.WaitGroup
+	for i, m := range mounts {
+		statuses[i] = hdfsMountStatus{
+			Mount:     m.name,
+			Target:    m.target,
+			Namenodes: m.namenodes,
+			State:     madmin.DriveStateOffline,
+		}
+		wg.Add(1)
+		go func(m *hdfsMount, status *hdfsMountStatus) {
+			defer wg.Done()
+			layer, err := m.get()
+			if err != nil {
+				if _, lastErr := m.connected(); lastErr != nil {
+					err = lastErr
+				}
+				status.Error = err.Error()
+				return
+			}
+			fsInfo, err := layer.clnt.StatFs()
+			if err != nil {
+				status.Error = err.Error()
+				return
+			}
+			status.State = madmin.DriveStateOk
+			status.Capacity = fsInfo.Capacity
+			status.Used = fsInfo.Used
+			status.Remaining = fsInfo.Remaining
+		}(m, &statuses[i])
+	}
+	wg.Wait()
+	return statuses
+}
+
+// storageInfo reports each mount as a drive, followed by one drive per
+// bucket. The gateway is online when all mounts are.
+func (ms *hdfsMounts) storageInfo(ctx context.Context) (si minio.StorageInfo, errs []error) {
+	si.Backend.Type = madmin.Gateway
+	si.Backend.GatewayOnline = true
+	for _, status := range ms.status() {
+		si.Disks = append(si.Disks, madmin.Disk{
+			Endpoint:       status.Mount,
+			DrivePath:      status.Target,
+			State:          status.State,
+			TotalSpace:     status.Capacity,
+			UsedSpace:      status.Used,
+			AvailableSpace: status.Remaining,
+		})
+		if status.State != madmin.DriveStateOk {
+			si.Backend.GatewayOnline = false
+			errs = append(errs, fmt.Errorf("mount %s is offline: %s", status.Mount, status.Error))
+		}
+	}
+
+	buckets, err := ms.listBuckets(ctx)
+	if err != nil {
+		return si, append(errs, err)
+	}
+	disks, berrs := ms.bucketDisks(ctx, buckets, time.Now())
+	si.Disks = append(si.Disks, disks...)
+	return si, append(errs, berrs...)
+}
+
+// bucketDisks returns the drives of the buckets from the layers of their
+// mounts, cached like those of a single namespace.
+func (ms *hdfsMounts) bucketDisks(ctx context.Context, buckets []minio.BucketInfo, now time.Time) (disks []madmin.Disk, errs []error) {
+	for _, bucket := range buckets {
+		m, err := ms.mount(bucket.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		layer, err := m.get()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		disk, err := layer.disks.get(bucket.Name, now, layer.bucketDisk)
+		if err != nil {
+			errs = append(errs, hdfsToObjectErr(ctx, err, bucket.Name))
+			continue
+		}
+		disks = append(disks, disk)
+	}
+	return disks, errs
+}
+
+// close shuts the connected mounts down.
+func (ms *hdfsMounts) close(ctx context.Context) error {
+	var firstErr error
+	for _, m := range ms.all() {
+		layer, _ := m.connected()
+		if layer == nil {
+			continue
+		}
+		if err := layer.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// registerMountRouter registers the mount admin API, which reports the
+// health of each mount of the mount table:
+//
+//	GET /minio/admin/v3/hdfs/mounts
+func (g *HDFS) registerMountRouter(router *mux.Router) {
+	router.Methods(http.MethodGet).Path("/mounts").HandlerFunc(ming.AdminHandler(
+		func(r *http.Request) (interface{}, error) {
+			n, _ := g.layer.Load().(*hdfsObjects)
+			if n == nil {
+				return nil, errors.New("hdfs gateway not initialized")
+			}
+			if n.mounts == nil {
+				return nil, minio.NotImplemented{API: "HDFS mounts without a mount table"}
+			}
+			return n.mounts.status(), nil
+		}))
+}
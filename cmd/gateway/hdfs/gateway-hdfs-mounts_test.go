@@ -0,0 +1,216 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2/hadoopconf"
+)
+
+func TestHDFSViewFSTable(t *testing.T) {
+	testCases := []struct {
+		args       []string
+		table      string
+		shouldPass bool
+	}{
+		{[]string{"namenode:8020"}, "", true},
+		{[]string{"hdfs://nn1:8020", "hdfs://nn2:8020"}, "", true},
+		{[]string{"viewfs://cluster"}, "cluster", true},
+		{[]string{"viewfs://cluster/"}, "cluster", true},
+		{[]string{"viewfs:///"}, hdfsDefaultMountTable, true},
+		{[]string{"viewfs://cluster/data"}, "", false},
+		{[]string{"viewfs://cluster", "nn1:8020"}, "", false},
+	}
+	for i, testCase := range testCases {
+		table, err := hdfsViewFSTable(testCase.args)
+		if err != nil && testCase.shouldPass {
+			t.Errorf("Test %d: Expected to pass, but failed with: <ERROR> %s", i+1, err)
+		}
+		if err == nil && !testCase.shouldPass {
+			t.Errorf("Test %d: Expected to fail, but passed", i+1)
+		}
+		if table != testCase.table {
+			t.Errorf("Test %d: Expected table %q, got %q", i+1, testCase.table, table)
+		}
+	}
+}
+
+func TestParseHDFSMountTable(t *testing.T) {
+	testCases := []struct {
+		conf       hadoopconf.HadoopConf
+		links      map[string]string
+		fallback   string
+		shouldPass bool
+	}{
+		{
+			hadoopconf.HadoopConf{
+				"fs.viewfs.mounttable.cluster.link./logs":  "hdfs://ns1/logs",
+				"fs.viewfs.mounttable.cluster.link./data/": "hdfs://ns2/data",
+				"fs.viewfs.mounttable.other.link./tmp":     "hdfs://ns3/tmp",
+				"dfs.nameservices":                         "ns1,ns2",
+			},
+			map[string]string{"logs": "hdfs://ns1/logs", "data": "hdfs://ns2/data"}, "", true,
+		},
+		{
+			hadoopconf.HadoopConf{
+				"fs.viewfs.mounttable.cluster.link./logs":   "hdfs://ns1/logs",
+				"fs.viewfs.mounttable.cluster.linkFallback": "hdfs://ns1/",
+			},
+			map[string]string{"logs": "hdfs://ns1/logs"}, "hdfs://ns1/", true,
+		},
+		{
+			hadoopconf.HadoopConf{"fs.viewfs.mounttable.cluster.linkFallback": "hdfs://ns1/"},
+			map[string]string{}, "hdfs://ns1/", true,
+		},
+		// Links must be buckets.
+		{hadoopconf.HadoopConf{"fs.viewfs.mounttable.cluster.link./a/b": "hdfs://ns1/a/b"}, nil, "", false},
+		{hadoopconf.HadoopConf{"fs.viewfs.mounttable.cluster.link./Logs": "hdfs://ns1/logs"}, nil, "", false},
+		{hadoopconf.HadoopConf{"fs.viewfs.mounttable.cluster.link./.minio.sys": "hdfs://ns1/sys"}, nil, "", false},
+		{hadoopconf.HadoopConf{"fs.viewfs.mounttable.cluster.linkMerge./logs": "hdfs://ns1/logs"}, nil, "", false},
+		{hadoopconf.HadoopConf{"fs.viewfs.mounttable.cluster.linkRegx./logs": "hdfs://ns1/logs"}, nil, "", false},
+		{hadoopconf.HadoopConf{"fs.viewfs.mounttable.other.link./logs": "hdfs://ns1/logs"}, nil, "", false},
+	}
+	for i, testCase := range testCases {
+		links, fallback, err := parseHDFSMountTable(testCase.conf, "cluster")
+		if err != nil && testCase.shouldPass {
+			t.Errorf("Test %d: Expected to pass, but failed with: <ERROR> %s", i+1, err)
+		}
+		if err == nil && !testCase.shouldPass {
+			t.Errorf("Test %d: Expected to fail, but passed", i+1)
+		}
+		if !reflect.DeepEqual(links, testCase.links) || fallback != testCase.fallback {
+			t.Errorf("Test %d: Expected links %v and fallback %q, got %v and %q", i+1, testCase.links, testCase.fallback, links, fallback)
+		}
+	}
+}
+
+func TestHDFSNameserviceAddresses(t *testing.T) {
+	conf := hadoopconf.HadoopConf{
+		"dfs.ha.namenodes.ns1":                "nn1, nn2,nn3",
+		"dfs.namenode.rpc-address.ns1.nn1":    "nn1:8020",
+		"dfs.namenode.rpc-address.ns1.nn2":    "nn2:8020",
+		"dfs.namenode.http-address.ns1.nn2":   "nn2:9870",
+		"dfs.namenode.rpc-address.ns2":        "nn4:8020",
+		"dfs.namenode.http-address.ns2":       "nn4:9870",
+		"dfs.namenode.kerberos.principal.ns1": "nn/_HOST@EXAMPLE.COM",
+		"dfs.namenode.rpc-address.ns3.nn1":    "nn5:8020",
+	}
+	testCases := []struct {
+		key, nameservice string
+		addrs            []string
+	}{
+		{"dfs.namenode.rpc-address", "ns1", []string{"nn1:8020", "nn2:8020"}},
+		{"dfs.namenode.http-address", "ns1", []string{"nn2:9870"}},
+		{"dfs.namenode.rpc-address", "ns2", []string{"nn4:8020"}},
+		// Namenodes of nameservices without HA namenodes are not found.
+		{"dfs.namenode.rpc-address", "ns3", nil},
+		{"dfs.namenode.rpc-address", "", nil},
+	}
+	for i, testCase := range testCases {
+		if addrs := hdfsNameserviceAddresses(conf, testCase.key, testCase.nameservice); !reflect.DeepEqual(addrs, testCase.addrs) {
+			t.Errorf("Test %d: Expected %v, got %v", i+1, testCase.addrs, addrs)
+		}
+	}
+
+	if addr := hdfsNameserviceWebEndpoint(conf, "ns1"); addr != "nn2:9870" {
+		t.Errorf("Expected web endpoint nn2:9870, got %q", addr)
+	}
+	if principal := hdfsNamenodePrincipal(conf, "ns1"); principal != "nn/_HOST" {
+		t.Errorf("Expected principal nn/_HOST, got %q", principal)
+	}
+	if principal := hdfsNamenodePrincipal(conf, "ns2"); principal != "" {
+		t.Errorf("Expected no principal, got %q", principal)
+	}
+}
+
+func TestParseHDFSMountPrincipals(t *testing.T) {
+	testCases := []struct {
+		s          string
+		principals map[string]string
+		shouldPass bool
+	}{
+		{"", map[string]string{}, true},
+		{"logs=etl@EXAMPLE.COM, fallback=minio/gw@EXAMPLE.COM", map[string]string{"logs": "etl@EXAMPLE.COM", "fallback": "minio/gw@EXAMPLE.COM"}, true},
+		{"logs", nil, false},
+		{"=etl@EXAMPLE.COM", nil, false},
+		{"logs=", nil, false},
+	}
+	for i, testCase := range testCases {
+		principals, err := parseHDFSMountPrincipals(testCase.s)
+		if err != nil && testCase.shouldPass {
+			t.Errorf("Test %d: Expected to pass, but failed with: <ERROR> %s", i+1, err)
+		}
+		if err == nil && !testCase.shouldPass {
+			t.Errorf("Test %d: Expected to fail, but passed", i+1)
+		}
+		if !reflect.DeepEqual(principals, testCase.principals) {
+			t.Errorf("Test %d: Expected %v, got %v", i+1, testCase.principals, principals)
+		}
+	}
+}
+
+func TestHDFSMountConnect(t *testing.T) {
+	dialing, release := make(chan struct{}), make(chan struct{})
+	connected := &hdfsObjects{}
+	m := &hdfsMount{name: "bucket", connect: func() (*hdfsObjects, error) {
+		close(dialing)
+		<-release
+		return connected, nil
+	}}
+
+	done := make(chan error)
+	go func() {
+		_, err := m.get()
+		done <- err
+	}()
+	<-dialing
+
+	// A hanging connection attempt blocks neither requests nor health checks.
+	if _, err := m.get(); err == nil {
+		t.Error("Expected requests to fail while the mount connects")
+	}
+	if layer, err := m.connected(); layer != nil || err != nil {
+		t.Errorf("Expected no layer and no error while connecting, got %v, %v", layer, err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if layer, err := m.get(); layer != connected || err != nil {
+		t.Errorf("Expected the connected layer, got %v, %v", layer, err)
+	}
+
+	// Failed attempts are retried after the retry interval.
+	dialErr := errors.New("namenode down")
+	m = &hdfsMount{name: "bucket", connect: func() (*hdfsObjects, error) { return nil, dialErr }}
+	if _, err := m.get(); err == nil {
+		t.Fatal("Expected the connection to fail")
+	}
+	if _, err := m.connected(); err != dialErr {
+		t.Errorf("Expected %v, got %v", dialErr, err)
+	}
+	m.attempted = time.Now().Add(-hdfsMountRetryInterval)
+	m.connect = func() (*hdfsObjects, error) { return connected, nil }
+	if layer, err := m.get(); layer != connected || err != nil {
+		t.Errorf("Expected the connected layer, got %v, %v", layer, err)
+	}
+}
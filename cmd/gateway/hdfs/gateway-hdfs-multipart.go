@@ -0,0 +1,687 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2"
+	humanize "github.com/dustin/go-humanize"
+	ming "github.com/minio/ming/cmd"
+	minio "github.com/minio/minio/cmd"
+	xhttp "github.com/minio/minio/cmd/http"
+	"github.com/minio/minio/cmd/logger"
+)
+
+// Each multipart upload is a directory .minio.sys/multipart/<bucket>/<uploadID>
+// holding the manifest written by NewMultipartUpload and one file per part,
+// named after the part number, with its ETag and its size before encryption
+// and compression in extended attributes. Parts are written to the tmp
+// directory and renamed into the upload directory, which replaces a previous
+// upload of the part at once, so they can be uploaded in any order and in
+// parallel. The parts named in CompleteMultipartUpload are concatenated into
+// a new file, renamed onto the object once complete, and the upload
+// directory is removed.
+
+const (
+	// Name of the manifest of an upload.
+	hdfsMultipartManifest = "upload.json"
+
+	// Version of the manifest of an upload.
+	hdfsMultipartManifestVersion = "1"
+
+	// Minimum size of all parts but the last.
+	hdfsMinPartSize = 5 * humanize.MiByte
+
+	// Maximum number of parts of an upload.
+	hdfsMaxPartID = 10000
+
+	// Prefix of the files the parts are concatenated into.
+	hdfsMultipartConcatPrefix = "concat."
+
+	// Attribute holding the size of a part before encryption and
+	// compression, its ETag is held in hdfsETagXAttr.
+	hdfsPartActualSizeXAttr = hdfsXAttrPrefix + "actualsize"
+)
+
+// hdfsMultipartManifestV1 is the manifest of an upload.
+type hdfsMultipartManifestV1 struct {
+	Version   string            `json:"version"`            // Version number
+	Object    string            `json:"object"`             // Object name
+	Initiated time.Time         `json:"initiated"`          // Start of the upload
+	Metadata  map[string]string `json:"metadata,omitempty"` // Metadata of the object
+}
+
+// hdfsPartName returns the name of the file of a part.
+func hdfsPartName(partID int) string {
+	return fmt.Sprintf("%05d", partID)
+}
+
+// hdfsParsePartName returns the part number of the file of a part, ok is
+// false for other files of the upload directory.
+func hdfsParsePartName(name string) (partID int, ok bool) {
+	partID, err := strconv.Atoi(name)
+	if err != nil || partID < 1 || partID > hdfsMaxPartID || name != hdfsPartName(partID) {
+		return 0, false
+	}
+	return partID, true
+}
+
+// multipartPath returns the path of the upload directory, or of a file in
+// it.
+func (n *hdfsObjects) multipartPath(bucket, uploadID string, file ...string) string {
+	return n.hdfsPathJoin(append([]string{minioMetaMultipartBucket, bucket, uploadID}, file...)...)
+}
+
+// readMultipartManifest reads the manifest of the upload of the object,
+// uploads of other objects are reported as invalid.
+func (n *hdfsObjects) readMultipartManifest(ctx context.Context, bucket, object, uploadID string) (manifest hdfsMultipartManifestV1, err error) {
+	data, err := n.clnt.ReadFile(n.multipartPath(bucket, uploadID, hdfsMultipartManifest))
+	if err != nil {
+		return manifest, n.hdfsToObjectBucketErr(ctx, err, bucket, object, uploadID)
+	}
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		logger.LogIf(ctx, err)
+		return manifest, err
+	}
+	if manifest.Object != object {
+		return manifest, minio.InvalidUploadID{Bucket: bucket, Object: object, UploadID: uploadID}
+	}
+	return manifest, nil
+}
+
+// hdfsPart is the file of a part.
+type hdfsPart struct {
+	minio.PartInfo
+	name string
+}
+
+// listParts returns the parts of the upload, sorted by part number. Files
+// of parts without attributes, which are being written, are skipped.
+func (n *hdfsObjects) listParts(bucket, uploadID string) ([]hdfsPart, error) {
+	fis, err := n.clnt.ReadDir(n.multipartPath(bucket, uploadID))
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]hdfsPart, 0, len(fis))
+	for _, fi := range fis {
+		partID, ok := hdfsParsePartName(fi.Name())
+		if !ok || fi.IsDir() {
+			continue
+		}
+		xattrs, err := n.clnt.GetXAttrs(n.multipartPath(bucket, uploadID, fi.Name()), hdfsETagXAttr, hdfsPartActualSizeXAttr)
+		if err != nil {
+			if hdfsIsXAttrNotFound(err) || os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		actualSize, err := strconv.ParseInt(xattrs[hdfsPartActualSizeXAttr], 10, 64)
+		if err != nil {
+			continue
+		}
+		parts = append(parts, hdfsPart{
+			PartInfo: minio.PartInfo{
+				PartNumber:   partID,
+				ETag:         xattrs[hdfsETagXAttr],
+				LastModified: fi.ModTime(),
+				Size:         fi.Size(),
+				ActualSize:   actualSize,
+			},
+			name: fi.Name(),
+		})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+func (n *hdfsObjects) NewMultipartUpload(ctx context.Context, bucket string, object string, opts minio.ObjectOptions) (uploadID string, err error) {
+	if n, err = n.forRequest(ctx, bucket); err != nil {
+		return uploadID, err
+	}
+
+	_, err = n.clnt.Stat(n.hdfsPathJoin(bucket))
+	if err != nil {
+		return uploadID, hdfsToObjectErr(ctx, err, bucket)
+	}
+	// Uploads of a class which cannot be written fail before any part.
+	class, err := n.objectStorageClass(bucket, opts.UserDefined[xhttp.AmzStorageClass])
+	if err != nil {
+		return uploadID, hdfsToObjectErr(ctx, err, bucket)
+	}
+	if _, err = n.multipartStoragePolicy(class); err != nil {
+		return uploadID, err
+	}
+
+	uploadID = minio.MustGetUUID()
+	data, err := json.Marshal(hdfsMultipartManifestV1{
+		Version:   hdfsMultipartManifestVersion,
+		Object:    object,
+		Initiated: minio.UTCNow(),
+		Metadata:  opts.UserDefined,
+	})
+	if err != nil {
+		return uploadID, err
+	}
+
+	// The manifest is written to the tmp directory and renamed into place,
+	// so an upload directory is never seen without its manifest.
+	tmpname := n.hdfsPathJoin(minioMetaTmpBucket, minio.MustGetUUID())
+	w, err := n.clnt.Create(tmpname)
+	if err != nil {
+		return uploadID, hdfsToObjectErr(ctx, err, bucket)
+	}
+	defer n.deleteObject(n.hdfsPathJoin(minioMetaTmpBucket), tmpname)
+	if _, err = w.Write(data); err != nil {
+		w.Close()
+		return uploadID, hdfsToObjectErr(ctx, err, bucket)
+	}
+	if err = w.Close(); err != nil {
+		return uploadID, hdfsToObjectErr(ctx, err, bucket)
+	}
+	if err = n.clnt.MkdirAll(n.hdfsPathJoin(minioMetaMultipartBucket, bucket), n.metaDirMode()); err != nil {
+		return uploadID, hdfsToObjectErr(ctx, err, bucket)
+	}
+	if err = n.clnt.Mkdir(n.multipartPath(bucket, uploadID), os.FileMode(0755)); err != nil {
+		return uploadID, hdfsToObjectErr(ctx, err, bucket)
+	}
+	if err = n.clnt.Rename(tmpname, n.multipartPath(bucket, uploadID, hdfsMultipartManifest)); err != nil {
+		n.clnt.RemoveAll(n.multipartPath(bucket, uploadID))
+		return uploadID, hdfsToObjectErr(ctx, err, bucket)
+	}
+
+	return uploadID, nil
+}
+
+// ListMultipartUploads lists the uploads of the bucket from their manifests,
+// sorted by object name and start time.
+func (n *hdfsObjects) ListMultipartUploads(ctx context.Context, bucket string, prefix string, keyMarker string, uploadIDMarker string, delimiter string, maxUploads int) (lmi minio.ListMultipartsInfo, err error) {
+	if n, err = n.forRequest(ctx, bucket); err != nil {
+		return lmi, err
+	}
+
+	_, err = n.clnt.Stat(n.hdfsPathJoin(bucket))
+	if err != nil {
+		return lmi, hdfsToObjectErr(ctx, err, bucket)
+	}
+
+	lmi = minio.ListMultipartsInfo{
+		KeyMarker:      keyMarker,
+		UploadIDMarker: uploadIDMarker,
+		MaxUploads:     maxUploads,
+		Prefix:         prefix,
+		Delimiter:      delimiter,
+	}
+	if maxUploads == 0 {
+		return lmi, nil
+	}
+
+	fis, err := n.clnt.ReadDir(n.hdfsPathJoin(minioMetaMultipartBucket, bucket))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lmi, nil
+		}
+		return lmi, hdfsToObjectErr(ctx, err, bucket)
+	}
+
+	var uploads []minio.MultipartInfo
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		data, err := n.clnt.ReadFile(n.multipartPath(bucket, fi.Name(), hdfsMultipartManifest))
+		if err != nil {
+			// Uploads completed or aborted while listing.
+			if !os.IsNotExist(err) {
+				logger.LogIf(ctx, err)
+			}
+			continue
+		}
+		var manifest hdfsMultipartManifestV1
+		if err = json.Unmarshal(data, &manifest); err != nil {
+			logger.LogIf(ctx, err)
+			continue
+		}
+		if !strings.HasPrefix(manifest.Object, prefix) {
+			continue
+		}
+		uploads = append(uploads, minio.MultipartInfo{
+			Bucket:    bucket,
+			Object:    manifest.Object,
+			UploadID:  fi.Name(),
+			Initiated: manifest.Initiated,
+		})
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Object != uploads[j].Object {
+			return uploads[i].Object < uploads[j].Object
+		}
+		if !uploads[i].Initiated.Equal(uploads[j].Initiated) {
+			return uploads[i].Initiated.Before(uploads[j].Initiated)
+		}
+		return uploads[i].UploadID < uploads[j].UploadID
+	})
+
+	paginateHDFSUploads(&lmi, uploads)
+	return lmi, nil
+}
+
+// paginateHDFSUploads fills the page of the listing from the uploads
+// sorted by object name and start time, after its markers and grouped by
+// its delimiter.
+func paginateHDFSUploads(lmi *minio.ListMultipartsInfo, uploads []minio.MultipartInfo) {
+	prefix, delimiter := lmi.Prefix, lmi.Delimiter
+	keyMarker, uploadIDMarker := lmi.KeyMarker, lmi.UploadIDMarker
+	maxUploads := lmi.MaxUploads
+
+	// Uploads of the key marker following the upload ID marker are listed,
+	// all of them when there is no upload ID marker.
+	afterMarker := uploadIDMarker == ""
+	for _, upload := range uploads {
+		if upload.Object < keyMarker || (upload.Object == keyMarker && uploadIDMarker == "") {
+			continue
+		}
+		if upload.Object == keyMarker && !afterMarker {
+			afterMarker = upload.UploadID == uploadIDMarker
+			continue
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(upload.Object[len(prefix):], delimiter); i >= 0 {
+				commonPrefix := upload.Object[:len(prefix)+i+len(delimiter)]
+				if commonPrefix <= keyMarker {
+					continue
+				}
+				if l := len(lmi.CommonPrefixes); l > 0 && lmi.CommonPrefixes[l-1] == commonPrefix {
+					continue
+				}
+				if len(lmi.Uploads)+len(lmi.CommonPrefixes) == maxUploads {
+					lmi.IsTruncated = true
+					break
+				}
+				lmi.CommonPrefixes = append(lmi.CommonPrefixes, commonPrefix)
+				lmi.NextKeyMarker, lmi.NextUploadIDMarker = commonPrefix, ""
+				continue
+			}
+		}
+
+		if len(lmi.Uploads)+len(lmi.CommonPrefixes) == maxUploads {
+			lmi.IsTruncated = true
+			break
+		}
+		lmi.Uploads = append(lmi.Uploads, upload)
+		lmi.NextKeyMarker, lmi.NextUploadIDMarker = upload.Object, upload.UploadID
+	}
+	if !lmi.IsTruncated {
+		lmi.NextKeyMarker, lmi.NextUploadIDMarker = "", ""
+	}
+}
+
+func (n *hdfsObjects) checkUploadIDExists(ctx context.Context, bucket, object, uploadID string) (err error) {
+	_, err = n.readMultipartManifest(ctx, bucket, object, uploadID)
+	return err
+}
+
+// GetMultipartInfo returns multipart info of the uploadId of the object
+func (n *hdfsObjects) GetMultipartInfo(ctx context.Context, bucket, object, uploadID string, opts minio.ObjectOptions) (result minio.MultipartInfo, err error) {
+	if n, err = n.forRequest(ctx, bucket); err != nil {
+		return result, err
+	}
+
+	manifest, err := n.readMultipartManifest(ctx, bucket, object, uploadID)
+	if err != nil {
+		return result, err
+	}
+
+	result.Bucket = bucket
+	result.Object = object
+	result.UploadID = uploadID
+	result.Initiated = manifest.Initiated
+	result.UserDefined = manifest.Metadata
+	return result, nil
+}
+
+// ListObjectParts lists the parts uploaded so far, sorted by part number.
+func (n *hdfsObjects) ListObjectParts(ctx context.Context, bucket, object, uploadID string, partNumberMarker int, maxParts int, opts minio.ObjectOptions) (result minio.ListPartsInfo, err error) {
+	if n, err = n.forRequest(ctx, bucket); err != nil {
+		return result, err
+	}
+
+	manifest, err := n.readMultipartManifest(ctx, bucket, object, uploadID)
+	if err != nil {
+		return result, err
+	}
+	parts, err := n.listParts(bucket, uploadID)
+	if err != nil {
+		return result, n.hdfsToObjectBucketErr(ctx, err, bucket, object, uploadID)
+	}
+
+	result = minio.ListPartsInfo{
+		Bucket:           bucket,
+		Object:           object,
+		UploadID:         uploadID,
+		PartNumberMarker: partNumberMarker,
+		MaxParts:         maxParts,
+		UserDefined:      manifest.Metadata,
+	}
+	for _, part := range parts {
+		if part.PartNumber <= partNumberMarker {
+			continue
+		}
+		if len(result.Parts) == maxParts {
+			result.IsTruncated = true
+			break
+		}
+		result.Parts = append(result.Parts, part.PartInfo)
+		result.NextPartNumberMarker = part.PartNumber
+	}
+	return result, nil
+}
+
+func (n *hdfsObjects) CopyObjectPart(ctx context.Context, srcBucket, srcObject, dstBucket, dstObject, uploadID string, partID int,
+	startOffset int64, length int64, srcInfo minio.ObjectInfo, srcOpts, dstOpts minio.ObjectOptions) (minio.PartInfo, error) {
+	return n.PutObjectPart(ctx, dstBucket, dstObject, uploadID, partID, srcInfo.PutObjReader, dstOpts)
+}
+
+// PutObjectPart writes the part to its own file in the upload directory,
+// replacing any previous upload of the part.
+func (n *hdfsObjects) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, r *minio.PutObjReader, opts minio.ObjectOptions) (info minio.PartInfo, err error) {
+	if n, err = n.forRequest(ctx, bucket); err != nil {
+		return info, err
+	}
+
+	if err = n.checkUploadIDExists(ctx, bucket, object, uploadID); err != nil {
+		return info, err
+	}
+
+	tmpname := n.hdfsPathJoin(minioMetaTmpBucket, minio.MustGetUUID())
+	var w *hdfs.FileWriter
+	w, err = n.clnt.Create(tmpname)
+	if err != nil {
+		return info, hdfsToObjectErr(ctx, err, bucket, object, uploadID)
+	}
+	defer n.deleteObject(n.hdfsPathJoin(minioMetaTmpBucket), tmpname)
+	if _, err = io.Copy(w, r.Reader); err != nil {
+		w.Close()
+		return info, hdfsToObjectErr(ctx, err, bucket, object, uploadID)
+	}
+	if err = w.Close(); err != nil {
+		return info, hdfsToObjectErr(ctx, err, bucket, object, uploadID)
+	}
+
+	// The attributes are set before the rename, which commits the part
+	// at once over a concurrent or previous upload of it.
+	etag := r.MD5CurrentHexString()
+	if err = n.clnt.SetXAttr(tmpname, hdfsETagXAttr, etag); err != nil {
+		return info, hdfsToObjectErr(ctx, err, bucket, object, uploadID)
+	}
+	if err = n.clnt.SetXAttr(tmpname, hdfsPartActualSizeXAttr, strconv.FormatInt(r.Reader.ActualSize(), 10)); err != nil {
+		return info, hdfsToObjectErr(ctx, err, bucket, object, uploadID)
+	}
+	if err = n.clnt.Rename(tmpname, n.multipartPath(bucket, uploadID, hdfsPartName(partID))); err != nil {
+		// The upload was completed or aborted meanwhile.
+		return info, hdfsToObjectErr(ctx, err, bucket, object, uploadID)
+	}
+
+	info.PartNumber = partID
+	info.ETag = etag
+	info.LastModified = minio.UTCNow()
+	info.Size = r.Reader.Size()
+	info.ActualSize = r.Reader.ActualSize()
+
+	return info, nil
+}
+
+// concatParts concatenates the files of the parts into a new file with
+// the attributes and the storage policy of the object, and returns its
+// path. The parts are moved into a file of the upload directory with the
+// WebHDFS concat operation when the namenode HTTP server is known,
+// otherwise they are copied into a tmp file. Either way the parts are
+// only consumed by a successful concat, and the file is only renamed onto
+// the object once complete.
+func (n *hdfsObjects) concatParts(ctx context.Context, bucket, uploadID string, parts []hdfsPart, xattrs map[string]string, policy string) (string, error) {
+	paths := make([]string, len(parts))
+	for i, part := range parts {
+		paths[i] = n.multipartPath(bucket, uploadID, part.name)
+	}
+
+	if n.web != nil {
+		// HDFS concatenates into a file of the directory of the parts.
+		target := n.multipartPath(bucket, uploadID, hdfsMultipartConcatPrefix+minio.MustGetUUID())
+		err := n.createConcatTarget(ctx, target, xattrs, policy)
+		if err == nil {
+			err = n.web.concat(ctx, target, paths)
+		}
+		if err == nil {
+			return target, nil
+		}
+		n.clnt.Remove(target)
+		// Clusters refusing the concat of the parts, e.g. for their block
+		// sizes, fall back to copying them.
+		logger.LogIf(ctx, err)
+	}
+
+	tmpname := n.hdfsPathJoin(minioMetaTmpBucket, minio.MustGetUUID())
+	w, err := n.clnt.Create(tmpname)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range paths {
+		var rd *hdfs.FileReader
+		if rd, err = n.clnt.Open(p); err != nil {
+			break
+		}
+		_, err = io.Copy(w, rd)
+		rd.Close()
+		if err != nil {
+			break
+		}
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = n.setObjectXAttrs(tmpname, xattrs)
+	}
+	if err == nil && policy != "" {
+		err = n.setStoragePolicy(ctx, tmpname, policy)
+	}
+	if err != nil {
+		n.deleteObject(n.hdfsPathJoin(minioMetaTmpBucket), tmpname)
+		return "", err
+	}
+	return tmpname, nil
+}
+
+// createConcatTarget creates the empty file the parts are concatenated
+// into, with the attributes and the storage policy of the object which
+// the concat keeps.
+func (n *hdfsObjects) createConcatTarget(ctx context.Context, name string, xattrs map[string]string, policy string) error {
+	w, err := n.clnt.Create(name)
+	if err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if err = n.setObjectXAttrs(name, xattrs); err != nil {
+		return err
+	}
+	if policy != "" {
+		return n.setStoragePolicy(ctx, name, policy)
+	}
+	return nil
+}
+
+// hdfsCompleteParts returns the uploaded parts of the object completed
+// with the parts, which must match distinct uploaded parts, and all but the
+// last one be at least hdfsMinPartSize long.
+func hdfsCompleteParts(parts []minio.CompletePart, uploaded []hdfsPart) ([]hdfsPart, error) {
+	uploadedParts := make(map[int]hdfsPart, len(uploaded))
+	for _, part := range uploaded {
+		uploadedParts[part.PartNumber] = part
+	}
+
+	completeParts := make([]hdfsPart, len(parts))
+	for i, part := range parts {
+		etag := strings.Trim(part.ETag, "\"")
+		uploadedPart, ok := uploadedParts[part.PartNumber]
+		// Parts given twice cannot be concatenated twice.
+		if !ok || uploadedPart.ETag != etag || (i > 0 && part.PartNumber <= parts[i-1].PartNumber) {
+			return nil, minio.InvalidPart{
+				PartNumber: part.PartNumber,
+				ExpETag:    etag,
+				GotETag:    uploadedPart.ETag,
+			}
+		}
+		if i < len(parts)-1 && uploadedPart.ActualSize < hdfsMinPartSize {
+			return nil, minio.PartTooSmall{
+				PartNumber: part.PartNumber,
+				PartSize:   uploadedPart.ActualSize,
+				PartETag:   part.ETag,
+			}
+		}
+		completeParts[i] = uploadedPart
+	}
+	if len(completeParts) == 0 {
+		return nil, minio.InvalidPart{}
+	}
+	return completeParts, nil
+}
+
+// CompleteMultipartUpload validates the parts against the uploaded ones,
+// concatenates them into the object and removes the upload.
+func (n *hdfsObjects) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, parts []minio.CompletePart, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	if n, err = n.forRequest(ctx, bucket); err != nil {
+		return objInfo, err
+	}
+
+	manifest, err := n.readMultipartManifest(ctx, bucket, object, uploadID)
+	if err != nil {
+		return objInfo, err
+	}
+	uploaded, err := n.listParts(bucket, uploadID)
+	if err != nil {
+		return objInfo, n.hdfsToObjectBucketErr(ctx, err, bucket, object, uploadID)
+	}
+	completeParts, err := hdfsCompleteParts(parts, uploaded)
+	if err != nil {
+		return objInfo, err
+	}
+
+	// Calculate s3 compatible md5sum for complete multipart.
+	s3MD5 := minio.ComputeCompleteMultipartMD5(parts)
+	metadata := manifest.Metadata
+	var layout []minio.ObjectPartInfo
+	if hdfsIsTransformed(manifest.Metadata) {
+		// Reading encrypted and compressed objects needs the layout of
+		// their parts, and compressed ones their actual size.
+		metadata = make(map[string]string, len(manifest.Metadata)+1)
+		for k, v := range manifest.Metadata {
+			metadata[k] = v
+		}
+		var actualSize int64
+		layout = make([]minio.ObjectPartInfo, len(completeParts))
+		for i, part := range completeParts {
+			layout[i] = minio.ObjectPartInfo{
+				Number:     part.PartNumber,
+				Size:       part.Size,
+				ActualSize: part.ActualSize,
+			}
+			actualSize += part.ActualSize
+		}
+		if _, ok := metadata[minio.ReservedMetadataPrefix+"compression"]; ok {
+			metadata[minio.ReservedMetadataPrefix+"actual-size"] = strconv.FormatInt(actualSize, 10)
+		}
+	}
+	xattrs, err := hdfsObjectXAttrs(metadata, s3MD5)
+	if err != nil {
+		return objInfo, err
+	}
+	if len(layout) > 0 {
+		xattrs[hdfsPartsXAttr] = ming.EncodePartLayout(layout)
+	}
+	// The parts were written with the policy of the uploads directory,
+	// their blocks are moved to the storage types of the class later.
+	class, err := n.objectStorageClass(bucket, manifest.Metadata[xhttp.AmzStorageClass])
+	if err != nil {
+		return objInfo, hdfsToObjectErr(ctx, err, bucket, object, uploadID)
+	}
+	policy, err := n.multipartStoragePolicy(class)
+	if err != nil {
+		return objInfo, err
+	}
+
+	src, err := n.concatParts(ctx, bucket, uploadID, completeParts, xattrs, policy)
+	if err != nil {
+		return objInfo, hdfsToObjectErr(ctx, err, bucket, object, uploadID)
+	}
+
+	name := n.hdfsPathJoin(bucket, object)
+	dir := path.Dir(name)
+	err = n.renameToObject(bucket, src, name)
+	// Object already exists is an error on HDFS
+	// remove it and then create it again.
+	if os.IsExist(err) {
+		if err = n.clnt.Remove(name); err != nil {
+			if dir != "" {
+				n.deleteObject(n.hdfsPathJoin(bucket), dir)
+			}
+			return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
+		}
+		if err = n.clnt.Rename(src, name); err != nil {
+			if dir != "" {
+				n.deleteObject(n.hdfsPathJoin(bucket), dir)
+			}
+			return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
+		}
+	} else if err != nil {
+		return objInfo, n.hdfsToObjectBucketErr(ctx, err, bucket, object)
+	}
+	if err = n.clnt.RemoveAll(n.multipartPath(bucket, uploadID)); err != nil {
+		logger.LogIf(ctx, err)
+	}
+	fi, err := n.clnt.Stat(name)
+	if err != nil {
+		return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
+	}
+
+	objInfo = fileInfoToObjectInfo(bucket, object, fi)
+	objInfo.StorageClass = n.storageClass(fi)
+	applyXAttrsToObjectInfo(ctx, xattrs, &objInfo)
+	return objInfo, nil
+}
+
+func (n *hdfsObjects) AbortMultipartUpload(ctx context.Context, bucket, object, uploadID string, opts minio.ObjectOptions) (err error) {
+	if n, err = n.forRequest(ctx, bucket); err != nil {
+		return err
+	}
+
+	if err = n.checkUploadIDExists(ctx, bucket, object, uploadID); err != nil {
+		return err
+	}
+	return hdfsToObjectErr(ctx, n.clnt.RemoveAll(n.multipartPath(bucket, uploadID)), bucket, object, uploadID)
+}
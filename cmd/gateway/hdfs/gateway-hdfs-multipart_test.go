@@ -0,0 +1,166 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	minio "github.com/minio/minio/cmd"
+)
+
+func TestHDFSParsePartName(t *testing.T) {
+	testCases := []struct {
+		name   string
+		partID int
+		ok     bool
+	}{
+		{hdfsPartName(1), 1, true},
+		{hdfsPartName(10000), 10000, true},
+		{"00003", 3, true},
+		{"3", 0, false},
+		{"00000", 0, false},
+		{"10001", 0, false},
+		{"-0001", 0, false},
+		{"00003.abc.1", 0, false},
+		{"0000a", 0, false},
+		{hdfsMultipartConcatPrefix + "00003", 0, false},
+		{hdfsMultipartManifest, 0, false},
+	}
+	for i, testCase := range testCases {
+		partID, ok := hdfsParsePartName(testCase.name)
+		if ok != testCase.ok || partID != testCase.partID {
+			t.Errorf("Test %d: Expected %d and %t, got %d and %t", i+1, testCase.partID, testCase.ok, partID, ok)
+		}
+	}
+}
+
+func TestPaginateHDFSUploads(t *testing.T) {
+	var uploads []minio.MultipartInfo
+	for _, upload := range [][2]string{
+		{"a", "1"}, {"a", "2"}, {"b/c", "3"}, {"b/d", "4"}, {"c", "5"}, {"c", "6"}, {"d/e/f", "7"},
+	} {
+		uploads = append(uploads, minio.MultipartInfo{Object: upload[0], UploadID: upload[1]})
+	}
+
+	testCases := []struct {
+		prefix, keyMarker, uploadIDMarker, delimiter string
+		maxUploads                                   int
+		uploadIDs                                    []string
+		prefixes                                     []string
+		truncated                                    bool
+		nextKeyMarker, nextUploadIDMarker            string
+	}{
+		{"", "", "", "", 10, []string{"1", "2", "3", "4", "5", "6", "7"}, nil, false, "", ""},
+		{"", "", "", "", 3, []string{"1", "2", "3"}, nil, true, "b/c", "3"},
+		// The key marker alone skips all the uploads of its object.
+		{"", "a", "", "", 10, []string{"3", "4", "5", "6", "7"}, nil, false, "", ""},
+		// The upload ID marker resumes within the uploads of its object.
+		{"", "a", "1", "", 10, []string{"2", "3", "4", "5", "6", "7"}, nil, false, "", ""},
+		{"", "c", "5", "", 1, []string{"6"}, nil, true, "c", "6"},
+		{"", "c", "6", "", 10, []string{"7"}, nil, false, "", ""},
+		// Delimiters group uploads in common prefixes, listed once.
+		{"", "", "", "/", 10, []string{"1", "2", "5", "6"}, []string{"b/", "d/"}, false, "", ""},
+		{"", "", "", "/", 3, []string{"1", "2"}, []string{"b/"}, true, "b/", ""},
+		{"", "b/", "", "/", 10, []string{"5", "6"}, []string{"d/"}, false, "", ""},
+		{"d/", "", "", "/", 10, nil, []string{"d/e/"}, false, "", ""},
+		{"b/", "b/c", "3", "/", 10, []string{"4"}, nil, false, "", ""},
+	}
+	for i, testCase := range testCases {
+		lmi := minio.ListMultipartsInfo{
+			Prefix:         testCase.prefix,
+			KeyMarker:      testCase.keyMarker,
+			UploadIDMarker: testCase.uploadIDMarker,
+			Delimiter:      testCase.delimiter,
+			MaxUploads:     testCase.maxUploads,
+		}
+		var prefixed []minio.MultipartInfo
+		for _, upload := range uploads {
+			// ListMultipartUploads filters the uploads by prefix.
+			if strings.HasPrefix(upload.Object, testCase.prefix) {
+				prefixed = append(prefixed, upload)
+			}
+		}
+		paginateHDFSUploads(&lmi, prefixed)
+
+		var uploadIDs []string
+		for _, upload := range lmi.Uploads {
+			uploadIDs = append(uploadIDs, upload.UploadID)
+		}
+		if !reflect.DeepEqual(uploadIDs, testCase.uploadIDs) || !reflect.DeepEqual(lmi.CommonPrefixes, testCase.prefixes) {
+			t.Errorf("Test %d: Expected uploads %v and prefixes %v, got %v and %v", i+1,
+				testCase.uploadIDs, testCase.prefixes, uploadIDs, lmi.CommonPrefixes)
+		}
+		if lmi.IsTruncated != testCase.truncated || lmi.NextKeyMarker != testCase.nextKeyMarker || lmi.NextUploadIDMarker != testCase.nextUploadIDMarker {
+			t.Errorf("Test %d: Expected truncated %t at %q/%q, got %t at %q/%q", i+1,
+				testCase.truncated, testCase.nextKeyMarker, testCase.nextUploadIDMarker,
+				lmi.IsTruncated, lmi.NextKeyMarker, lmi.NextUploadIDMarker)
+		}
+	}
+}
+
+func TestHDFSCompleteParts(t *testing.T) {
+	uploaded := []hdfsPart{
+		{PartInfo: minio.PartInfo{PartNumber: 1, ETag: "e1", ActualSize: hdfsMinPartSize}},
+		{PartInfo: minio.PartInfo{PartNumber: 2, ETag: "e2", ActualSize: hdfsMinPartSize - 1}},
+		{PartInfo: minio.PartInfo{PartNumber: 3, ETag: "e3", ActualSize: 1}},
+	}
+	testCases := []struct {
+		parts       []minio.CompletePart
+		partNumbers []int
+		expectedErr error
+	}{
+		{[]minio.CompletePart{{PartNumber: 1, ETag: "e1"}, {PartNumber: 3, ETag: "e3"}}, []int{1, 3}, nil},
+		{[]minio.CompletePart{{PartNumber: 1, ETag: `"e1"`}, {PartNumber: 2, ETag: `"e2"`}}, []int{1, 2}, nil},
+		{[]minio.CompletePart{{PartNumber: 3, ETag: "e3"}}, []int{3}, nil},
+		// Only the last part may be small.
+		{
+			[]minio.CompletePart{{PartNumber: 2, ETag: "e2"}, {PartNumber: 3, ETag: "e3"}}, nil,
+			minio.PartTooSmall{PartNumber: 2, PartSize: hdfsMinPartSize - 1, PartETag: "e2"},
+		},
+		// Parts must have been uploaded with the same ETag.
+		{
+			[]minio.CompletePart{{PartNumber: 1, ETag: "e2"}}, nil,
+			minio.InvalidPart{PartNumber: 1, ExpETag: "e2", GotETag: "e1"},
+		},
+		{
+			[]minio.CompletePart{{PartNumber: 1, ETag: "e1"}, {PartNumber: 4, ETag: "e4"}}, nil,
+			minio.InvalidPart{PartNumber: 4, ExpETag: "e4"},
+		},
+		// Parts must not be given twice.
+		{
+			[]minio.CompletePart{{PartNumber: 1, ETag: "e1"}, {PartNumber: 1, ETag: "e1"}}, nil,
+			minio.InvalidPart{PartNumber: 1, ExpETag: "e1", GotETag: "e1"},
+		},
+		{nil, nil, minio.InvalidPart{}},
+	}
+	for i, testCase := range testCases {
+		parts, err := hdfsCompleteParts(testCase.parts, uploaded)
+		if !reflect.DeepEqual(err, testCase.expectedErr) {
+			t.Errorf("Test %d: Expected error %v, got %v", i+1, testCase.expectedErr, err)
+			continue
+		}
+		var partNumbers []int
+		for _, part := range parts {
+			partNumbers = append(partNumbers, part.PartNumber)
+		}
+		if !reflect.DeepEqual(partNumbers, testCase.partNumbers) {
+			t.Errorf("Test %d: Expected parts %v, got %v", i+1, testCase.partNumbers, partNumbers)
+		}
+	}
+}
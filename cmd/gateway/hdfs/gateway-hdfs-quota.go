@@ -0,0 +1,247 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2"
+	"github.com/gorilla/mux"
+	ming "github.com/minio/ming/cmd"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/madmin"
+)
+
+// HDFS directory quotas limit the space, counting replicas, and the number
+// of files and directories below a directory. Quotas set on bucket
+// directories are reported per bucket by StorageInfo and writes exceeding
+// them fail with a bucket quota error.
+//
+// MinIO server does not forward bucket quota requests to gateways, the
+// space quota of a bucket is set through the admin API of the gateway
+// instead, with the WebHDFS SETQUOTA operation which requires the HDFS
+// superuser. Namespace quotas are left to hdfs dfsadmin -setQuota.
+
+// Drive state of buckets which reached one of their quotas.
+const hdfsDriveStateQuotaExceeded = "quota-exceeded"
+
+// Time the drives of the buckets reported by StorageInfo are cached, each
+// is read from a content summary which walks the whole bucket tree on the
+// namenode.
+const hdfsBucketDiskTTL = 5 * time.Minute
+
+// Java exceptions of the namenode for exceeded quotas.
+var hdfsQuotaExceptions = map[string]bool{
+	"org.apache.hadoop.hdfs.protocol.QuotaExceededException":   true,
+	"org.apache.hadoop.hdfs.protocol.DSQuotaExceededException": true,
+	"org.apache.hadoop.hdfs.protocol.NSQuotaExceededException": true,
+}
+
+// hdfsIsQuotaExceeded returns whether the error reports an exceeded space
+// or namespace quota.
+func hdfsIsQuotaExceeded(err error) bool {
+	var remoteErr hdfs.Error
+	return errors.As(err, &remoteErr) && hdfsQuotaExceptions[remoteErr.Exception()]
+}
+
+// hdfsUsage is the usage and the quotas of a directory, unset quotas are
+// -1 as HDFS reports them.
+type hdfsUsage struct {
+	space, spaceQuota int64 // bytes, counting replicas
+	names, nameQuota  int64 // files and directories
+}
+
+// newHDFSBucketDisk returns the usage and the quotas of the bucket as a
+// drive of the storage info. The total space of buckets without space
+// quota is 0.
+func newHDFSBucketDisk(bucket, name string, usage hdfsUsage) madmin.Disk {
+	disk := madmin.Disk{
+		Endpoint:  bucket,
+		DrivePath: name,
+		State:     madmin.DriveStateOk,
+		UsedSpace: uint64(usage.space),
+	}
+	if usage.spaceQuota >= 0 {
+		disk.TotalSpace = uint64(usage.spaceQuota)
+		if disk.UsedSpace < disk.TotalSpace {
+			disk.AvailableSpace = disk.TotalSpace - disk.UsedSpace
+		} else {
+			disk.State = hdfsDriveStateQuotaExceeded
+		}
+	}
+	// The namespace quota counts the bucket directory itself.
+	if usage.nameQuota >= 0 && usage.names >= usage.nameQuota {
+		disk.State = hdfsDriveStateQuotaExceeded
+	}
+	return disk
+}
+
+// bucketDisk returns the usage and the quotas of the bucket as a drive of
+// the storage info.
+func (n *hdfsObjects) bucketDisk(bucket string) (madmin.Disk, error) {
+	name := n.hdfsPathJoin(bucket)
+	cs, err := n.clnt.GetContentSummary(name)
+	if err != nil {
+		return madmin.Disk{}, err
+	}
+	return newHDFSBucketDisk(bucket, name, hdfsUsage{
+		space:      cs.SizeAfterReplication(),
+		spaceQuota: cs.SpaceQuota(),
+		names:      int64(cs.FileCount() + cs.DirectoryCount()),
+		nameQuota:  int64(cs.NameQuota()),
+	}), nil
+}
+
+// hdfsDiskCache keeps the drives of the buckets for a while.
+type hdfsDiskCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	disks map[string]hdfsCachedDisk
+}
+
+type hdfsCachedDisk struct {
+	disk    madmin.Disk
+	expires time.Time
+}
+
+// newHDFSDiskCache returns a cache keeping drives for the ttl.
+func newHDFSDiskCache(ttl time.Duration) *hdfsDiskCache {
+	return &hdfsDiskCache{ttl: ttl, disks: make(map[string]hdfsCachedDisk)}
+}
+
+// get returns the cached drive of the bucket, loaded again once expired.
+// Errors are not cached.
+func (c *hdfsDiskCache) get(bucket string, now time.Time, load func(bucket string) (madmin.Disk, error)) (madmin.Disk, error) {
+	c.mu.Lock()
+	cached, ok := c.disks[bucket]
+	c.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.disk, nil
+	}
+	disk, err := load(bucket)
+	if err != nil {
+		return disk, err
+	}
+	c.mu.Lock()
+	c.disks[bucket] = hdfsCachedDisk{disk: disk, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return disk, nil
+}
+
+// invalidate drops the drive of the bucket.
+func (c *hdfsDiskCache) invalidate(bucket string) {
+	c.mu.Lock()
+	delete(c.disks, bucket)
+	c.mu.Unlock()
+}
+
+// parseHDFSQuota parses a bucket quota in bytes, which must be positive.
+func parseHDFSQuota(s string) (int64, error) {
+	quota, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if quota <= 0 {
+		return 0, fmt.Errorf("invalid quota %d, expected a positive number of bytes", quota)
+	}
+	return quota, nil
+}
+
+// setBucketQuota sets the space quota of the bucket directory, clears it
+// if quota is negative.
+func (n *hdfsObjects) setBucketQuota(ctx context.Context, bucket string, quota int64) error {
+	if n.web == nil {
+		return minio.NotImplemented{API: "HDFS quotas without WebHDFS"}
+	}
+	name := n.hdfsPathJoin(bucket)
+	if _, err := n.clnt.Stat(name); err != nil {
+		return hdfsToObjectErr(ctx, err, bucket)
+	}
+	err := n.web.setSpaceQuota(ctx, name, quota)
+	n.disks.invalidate(bucket)
+	return hdfsToObjectErr(ctx, err, bucket)
+}
+
+// hdfsBucketQuota is the space quota and the usage of a bucket returned
+// by the admin API, in bytes counting replicas.
+type hdfsBucketQuota struct {
+	Quota uint64 `json:"quota"`
+	Used  uint64 `json:"used"`
+}
+
+// registerQuotaRouter registers the quota admin API, which reads, sets
+// and clears the space quota of a bucket, counting replicas:
+//
+//	GET    /minio/admin/v3/hdfs/quota?bucket=<bucket>
+//	PUT    /minio/admin/v3/hdfs/quota?bucket=<bucket>&quota=<bytes>
+//	DELETE /minio/admin/v3/hdfs/quota?bucket=<bucket>
+func (g *HDFS) registerQuotaRouter(router *mux.Router) {
+	// handler returns the handler of the quota of the bucket of the
+	// request, acting as the gateway user.
+	handler := func(f func(ctx context.Context, n *hdfsObjects, bucket string, query url.Values) (interface{}, error)) http.HandlerFunc {
+		return ming.AdminHandler(func(r *http.Request) (interface{}, error) {
+			n, _ := g.layer.Load().(*hdfsObjects)
+			if n == nil {
+				return nil, errors.New("hdfs gateway not initialized")
+			}
+			query := r.URL.Query()
+			bucket := query.Get("bucket")
+			if !hdfsIsValidBucketName(bucket) {
+				return nil, minio.BucketNameInvalid{Bucket: bucket}
+			}
+			n, err := n.mount(bucket)
+			if err != nil {
+				return nil, err
+			}
+			if n.users != nil {
+				n = n.users.service
+			}
+			return f(r.Context(), n, bucket, query)
+		})
+	}
+
+	router.Methods(http.MethodGet).Path("/quota").HandlerFunc(handler(
+		func(ctx context.Context, n *hdfsObjects, bucket string, query url.Values) (interface{}, error) {
+			disk, err := n.bucketDisk(bucket)
+			if err != nil {
+				return nil, hdfsToObjectErr(ctx, err, bucket)
+			}
+			if disk.TotalSpace == 0 {
+				return nil, minio.BucketQuotaConfigNotFound{Bucket: bucket}
+			}
+			return hdfsBucketQuota{Quota: disk.TotalSpace, Used: disk.UsedSpace}, nil
+		}))
+	router.Methods(http.MethodPut).Path("/quota").HandlerFunc(handler(
+		func(ctx context.Context, n *hdfsObjects, bucket string, query url.Values) (interface{}, error) {
+			quota, err := parseHDFSQuota(query.Get("quota"))
+			if err != nil {
+				return nil, minio.InvalidArgument{Bucket: bucket, Err: err}
+			}
+			return nil, n.setBucketQuota(ctx, bucket, quota)
+		}))
+	router.Methods(http.MethodDelete).Path("/quota").HandlerFunc(handler(
+		func(ctx context.Context, n *hdfsObjects, bucket string, query url.Values) (interface{}, error) {
+			return nil, n.setBucketQuota(ctx, bucket, -1)
+		}))
+}
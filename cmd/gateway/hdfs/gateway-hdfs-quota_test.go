@@ -0,0 +1,140 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/madmin"
+)
+
+func TestNewHDFSBucketDisk(t *testing.T) {
+	testCases := []struct {
+		usage     hdfsUsage
+		total     uint64
+		available uint64
+		state     string
+	}{
+		{hdfsUsage{space: 30, spaceQuota: -1, names: 5, nameQuota: -1}, 0, 0, madmin.DriveStateOk},
+		{hdfsUsage{space: 30, spaceQuota: 90, names: 5, nameQuota: -1}, 90, 60, madmin.DriveStateOk},
+		{hdfsUsage{space: 90, spaceQuota: 90, names: 5, nameQuota: -1}, 90, 0, hdfsDriveStateQuotaExceeded},
+		{hdfsUsage{space: 30, spaceQuota: -1, names: 5, nameQuota: 6}, 0, 0, madmin.DriveStateOk},
+		{hdfsUsage{space: 30, spaceQuota: 90, names: 6, nameQuota: 6}, 90, 60, hdfsDriveStateQuotaExceeded},
+	}
+	for i, testCase := range testCases {
+		disk := newHDFSBucketDisk("bucket", "/bucket", testCase.usage)
+		if disk.Endpoint != "bucket" || disk.UsedSpace != uint64(testCase.usage.space) {
+			t.Errorf("Test %d: unexpected drive %+v", i+1, disk)
+		}
+		if disk.TotalSpace != testCase.total || disk.AvailableSpace != testCase.available || disk.State != testCase.state {
+			t.Errorf("Test %d: Expected total %d, available %d and state %s, got %d, %d and %s", i+1,
+				testCase.total, testCase.available, testCase.state, disk.TotalSpace, disk.AvailableSpace, disk.State)
+		}
+	}
+}
+
+func TestHDFSDiskCache(t *testing.T) {
+	var loads int
+	var loadErr error
+	load := func(bucket string) (madmin.Disk, error) {
+		loads++
+		return madmin.Disk{Endpoint: bucket, UsedSpace: uint64(loads)}, loadErr
+	}
+	c := newHDFSDiskCache(time.Minute)
+	now := time.Now()
+
+	testCases := []struct {
+		now   time.Time
+		used  uint64
+		loads int
+	}{
+		{now, 1, 1},
+		// Cached until the drive expires.
+		{now.Add(30 * time.Second), 1, 1},
+		{now.Add(time.Minute), 2, 2},
+		{now.Add(90 * time.Second), 2, 2},
+	}
+	for i, testCase := range testCases {
+		disk, err := c.get("bucket", testCase.now, load)
+		if err != nil {
+			t.Fatalf("Test %d: %v", i+1, err)
+		}
+		if disk.UsedSpace != testCase.used || loads != testCase.loads {
+			t.Errorf("Test %d: Expected used space %d after %d loads, got %d after %d", i+1, testCase.used, testCase.loads, disk.UsedSpace, loads)
+		}
+	}
+
+	// Invalidated drives are loaded again, errors are not cached.
+	c.invalidate("bucket")
+	loadErr = errors.New("namenode down")
+	if _, err := c.get("bucket", now.Add(90*time.Second), load); err != loadErr {
+		t.Errorf("Expected %v, got %v", loadErr, err)
+	}
+	loadErr = nil
+	if disk, err := c.get("bucket", now.Add(90*time.Second), load); err != nil || disk.UsedSpace != 4 {
+		t.Errorf("Expected used space 4, got %d, %v", disk.UsedSpace, err)
+	}
+}
+
+func TestParseHDFSQuota(t *testing.T) {
+	testCases := []struct {
+		quota    string
+		expected int64
+		ok       bool
+	}{
+		{"1073741824", 1 << 30, true},
+		{"1", 1, true},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"1GiB", 0, false},
+		{"", 0, false},
+	}
+	for i, testCase := range testCases {
+		quota, err := parseHDFSQuota(testCase.quota)
+		if testCase.ok != (err == nil) {
+			t.Errorf("Test %d: unexpected error %v", i+1, err)
+		}
+		if quota != testCase.expected {
+			t.Errorf("Test %d: Expected %d, got %d", i+1, testCase.expected, quota)
+		}
+	}
+}
+
+func TestHDFSMountBucketDisks(t *testing.T) {
+	now := time.Now()
+	disks := newHDFSDiskCache(time.Minute)
+	disks.disks["bucket"] = hdfsCachedDisk{disk: madmin.Disk{Endpoint: "bucket", UsedSpace: 42}, expires: now.Add(time.Minute)}
+	// Without a client the layer can only answer from its cache.
+	mounts := &hdfsMounts{links: map[string]*hdfsMount{
+		"bucket": {name: "bucket", layer: &hdfsObjects{disks: disks}},
+	}}
+
+	got, errs := mounts.bucketDisks(context.Background(), []minio.BucketInfo{{Name: "bucket"}, {Name: "missing"}}, now)
+	if len(got) != 1 || got[0].UsedSpace != 42 {
+		t.Errorf("Expected the cached drive, got %v", got)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Expected an error for the bucket without mount, got %v", errs)
+	}
+	if _, ok := errs[0].(minio.BucketNotFound); !ok {
+		t.Errorf("Expected BucketNotFound, got %v", errs[0])
+	}
+}
@@ -0,0 +1,468 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2"
+	"github.com/gorilla/mux"
+	ming "github.com/minio/ming/cmd"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/sync/errgroup"
+)
+
+// Snapshots of bucket directories are the read-only versions of their
+// objects. The object as it is in the snapshot .snapshot/<name> of its
+// bucket is the version of the snapshot, the live object is the null
+// version. S3 requires version IDs to be UUIDs, the ID of the versions of
+// a snapshot holds a hash of its name and its creation time in
+// milliseconds.
+//
+// Versions are listed by merging the listings of the bucket and of each
+// of its snapshots, a version is only listed when the object changed
+// since the next newer snapshot, or since the snapshot if it is the live
+// object. Versions
+// cannot be deleted, snapshots are created and deleted with the admin API
+// of the gateway.
+
+// Directory of the snapshots of a snapshottable directory.
+const hdfsSnapshotDir = ".snapshot"
+
+// Version ID of the live objects.
+const hdfsNullVersionID = "null"
+
+// Java exception of the namenode for failed snapshot operations.
+const hdfsSnapshotException = "org.apache.hadoop.hdfs.protocol.SnapshotException"
+
+var errInvalidHDFSVersionID = errors.New("invalid snapshot version id")
+
+// hdfsSnapshot is a snapshot of a bucket directory.
+type hdfsSnapshot struct {
+	Name      string    `json:"name"`
+	Created   time.Time `json:"created"`
+	VersionID string    `json:"versionId"`
+}
+
+// hdfsSnapshotVersionID returns the version ID of the objects of the
+// snapshot.
+func hdfsSnapshotVersionID(name string, created time.Time) string {
+	var b [16]byte
+	sum := sha256.Sum256([]byte(name))
+	copy(b[:8], sum[:8])
+	binary.BigEndian.PutUint64(b[8:], uint64(created.UnixNano()/int64(time.Millisecond)))
+	s := hex.EncodeToString(b[:])
+	return fmt.Sprintf("%s-%s-%s-%s-%s", s[:8], s[8:12], s[12:16], s[16:20], s[20:])
+}
+
+// isHDFSVersioned returns true if the version ID names a snapshot rather
+// than the live object.
+func isHDFSVersioned(versionID string) bool {
+	return versionID != "" && versionID != hdfsNullVersionID
+}
+
+// bucketSnapshots returns the snapshots of the bucket, newest first.
+func (n *hdfsObjects) bucketSnapshots(bucket string) ([]hdfsSnapshot, error) {
+	fis, err := n.clnt.ReadDir(n.hdfsPathJoin(bucket, hdfsSnapshotDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The bucket is not snapshottable.
+			return nil, nil
+		}
+		return nil, err
+	}
+	snapshots := make([]hdfsSnapshot, 0, len(fis))
+	for _, fi := range fis {
+		snapshots = append(snapshots, hdfsSnapshot{
+			Name:      fi.Name(),
+			Created:   fi.ModTime().UTC(),
+			VersionID: hdfsSnapshotVersionID(fi.Name(), fi.ModTime()),
+		})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.After(snapshots[j].Created)
+	})
+	return snapshots, nil
+}
+
+// snapshotObjectPath returns the path of the object in the snapshot of
+// the version ID.
+func (n *hdfsObjects) snapshotObjectPath(bucket, object, versionID string) (string, error) {
+	snapshots, err := n.bucketSnapshots(bucket)
+	if err != nil {
+		return "", err
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.VersionID == versionID {
+			return n.hdfsPathJoin(bucket, hdfsSnapshotDir, snapshot.Name, object), nil
+		}
+	}
+	return "", errInvalidHDFSVersionID
+}
+
+// getSnapshotObjectInfo returns the info of the version of the object in
+// a snapshot.
+func (n *hdfsObjects) getSnapshotObjectInfo(ctx context.Context, bucket, object, versionID string) (minio.ObjectInfo, error) {
+	name, err := n.snapshotObjectPath(bucket, object, versionID)
+	if err == nil {
+		var fi os.FileInfo
+		if fi, err = n.clnt.Stat(name); err == nil && !fi.IsDir() {
+			objInfo, err := n.objectInfoAt(ctx, bucket, object, name, fi)
+			objInfo.VersionID = versionID
+			return objInfo, n.hdfsToObjectBucketErr(ctx, err, bucket, object)
+		}
+	}
+	if err == nil || err == errInvalidHDFSVersionID || os.IsNotExist(err) {
+		return minio.ObjectInfo{}, minio.VersionNotFound{Bucket: bucket, Object: object, VersionID: versionID}
+	}
+	return minio.ObjectInfo{}, n.hdfsToObjectBucketErr(ctx, err, bucket, object)
+}
+
+// hdfsVersionTree is the bucket directory or one of its snapshots.
+type hdfsVersionTree struct {
+	root      string
+	versionID string
+}
+
+// versionTrees returns the bucket directory followed by its snapshots,
+// newest first.
+func (n *hdfsObjects) versionTrees(bucket string) ([]hdfsVersionTree, error) {
+	snapshots, err := n.bucketSnapshots(bucket)
+	if err != nil {
+		return nil, err
+	}
+	trees := []hdfsVersionTree{{root: n.hdfsPathJoin(bucket), versionID: hdfsNullVersionID}}
+	for _, snapshot := range snapshots {
+		trees = append(trees, hdfsVersionTree{
+			root:      n.hdfsPathJoin(bucket, hdfsSnapshotDir, snapshot.Name),
+			versionID: snapshot.VersionID,
+		})
+	}
+	return trees, nil
+}
+
+// hdfsVersionEntry is a version of an object or a common prefix of a
+// version listing.
+type hdfsVersionEntry struct {
+	key       string
+	name      string      // path of the file of the version
+	fi        os.FileInfo // nil for common prefixes
+	versionID string
+}
+
+// appendHDFSVersion appends the version to the versions of its object,
+// newest first, unless the object is unchanged since the newer version.
+func appendHDFSVersion(versions []hdfsVersionEntry, v hdfsVersionEntry) []hdfsVersionEntry {
+	if len(versions) > 0 {
+		newer := versions[len(versions)-1].fi
+		if newer.ModTime().Equal(v.fi.ModTime()) && newer.Size() == v.fi.Size() {
+			return versions
+		}
+	}
+	return append(versions, v)
+}
+
+// objectVersions returns the versions of the object in the trees.
+func (n *hdfsObjects) objectVersions(trees []hdfsVersionTree, object string) ([]hdfsVersionEntry, error) {
+	var versions []hdfsVersionEntry
+	for _, tree := range trees {
+		name := minio.PathJoin(tree.root, object)
+		fi, err := n.clnt.Stat(name)
+		if hdfsIsNotDir(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			continue
+		}
+		versions = appendHDFSVersion(versions, hdfsVersionEntry{key: object, name: name, fi: fi, versionID: tree.versionID})
+	}
+	return versions, nil
+}
+
+// hdfsVersionLister merges the listings of the bucket directory and of
+// its snapshots, see hdfsLister.
+type hdfsVersionLister struct {
+	trees   []hdfsVersionTree
+	listers []*hdfsLister
+}
+
+// newHDFSVersionLister returns the lister of the versions of the keys of
+// the prefix after the marker, in the trees.
+func newHDFSVersionLister(open hdfsDirOpener, trees []hdfsVersionTree, prefix, delimiter, marker string) *hdfsVersionLister {
+	l := &hdfsVersionLister{trees: trees}
+	for _, tree := range trees {
+		l.listers = append(l.listers, newHDFSLister(open, tree.root, prefix, delimiter, marker))
+	}
+	return l
+}
+
+// next returns the versions of the next object, newest first, or the
+// next common prefix, empty at the end of the listing.
+func (l *hdfsVersionLister) next() ([]hdfsVersionEntry, error) {
+	for {
+		var key string
+		var found bool
+		for _, lister := range l.listers {
+			e, ok, err := lister.peek()
+			if err != nil {
+				return nil, err
+			}
+			if ok && (!found || e.key < key) {
+				key, found = e.key, true
+			}
+		}
+		if !found {
+			return nil, nil
+		}
+
+		var versions []hdfsVersionEntry
+		var isPrefix bool
+		for i, lister := range l.listers {
+			if e, ok, _ := lister.peek(); !ok || e.key != key {
+				continue
+			}
+			e, _, _ := lister.next()
+			switch {
+			case e.fi == nil:
+				isPrefix = true
+			case e.fi.IsDir():
+				// Empty directories have no versions.
+			default:
+				versions = appendHDFSVersion(versions, hdfsVersionEntry{
+					key:       key,
+					name:      minio.PathJoin(l.trees[i].root, key),
+					fi:        e.fi,
+					versionID: l.trees[i].versionID,
+				})
+			}
+		}
+		if isPrefix {
+			return []hdfsVersionEntry{{key: key}}, nil
+		}
+		if len(versions) > 0 {
+			return versions, nil
+		}
+	}
+}
+
+// ListObjectVersions lists the live objects and their versions in the
+// snapshots of the bucket. The bucket and its snapshots are listed from
+// the marker on, and the attributes of the versions are only read for
+// the versions of the page.
+func (n *hdfsObjects) ListObjectVersions(ctx context.Context, bucket, prefix, marker, versionMarker, delimiter string, maxKeys int) (loi minio.ListObjectVersionsInfo, err error) {
+	if n, err = n.forRequest(ctx, bucket); err != nil {
+		return loi, err
+	}
+
+	if _, err = n.clnt.Stat(n.hdfsPathJoin(bucket)); err != nil {
+		return loi, hdfsToObjectErr(ctx, err, bucket)
+	}
+	if !minio.IsValidObjectPrefix(prefix) {
+		return loi, minio.ObjectNameInvalid{Bucket: bucket, Object: prefix}
+	}
+	// Keys never start with a slash.
+	if maxKeys == 0 || strings.HasPrefix(prefix, hdfsSeparator) {
+		return loi, nil
+	}
+	if maxKeys < 0 || maxKeys > hdfsMaxObjectList {
+		maxKeys = hdfsMaxObjectList
+	}
+	trees, err := n.versionTrees(bucket)
+	if err != nil {
+		return loi, hdfsToObjectErr(ctx, err, bucket)
+	}
+
+	var page []hdfsVersionEntry
+	// add adds the entries to the page, the listing is truncated once
+	// the page is full.
+	add := func(entries []hdfsVersionEntry) {
+		for _, e := range entries {
+			if len(page) == maxKeys {
+				loi.IsTruncated = true
+				return
+			}
+			page = append(page, e)
+		}
+	}
+
+	// The versions of the marker object after the version marker.
+	if marker != "" && versionMarker != "" {
+		versions, err := n.objectVersions(trees, marker)
+		if err != nil {
+			return loi, n.hdfsToObjectBucketErr(ctx, err, bucket)
+		}
+		for i, v := range versions {
+			if v.versionID == versionMarker {
+				versions = versions[i+1:]
+				break
+			}
+		}
+		add(versions)
+	}
+	l := newHDFSVersionLister(n.openDir, trees, prefix, delimiter, marker)
+	for !loi.IsTruncated {
+		entries, err := l.next()
+		if err != nil {
+			return loi, n.hdfsToObjectBucketErr(ctx, err, bucket)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		add(entries)
+	}
+	if len(page) > 0 {
+		loi.NextMarker = page[len(page)-1].key
+		loi.NextVersionIDMarker = page[len(page)-1].versionID
+	}
+
+	// The attributes of the versions are read concurrently, objects
+	// removed meanwhile are skipped.
+	infos := make([]*minio.ObjectInfo, len(page))
+	g := errgroup.WithNErrs(len(page)).WithConcurrency(hdfsListInfoConcurrency)
+	for i := range page {
+		if page[i].fi == nil {
+			continue
+		}
+		i := i
+		g.Go(func() error {
+			v := page[i]
+			objInfo, err := n.objectInfoAt(ctx, bucket, v.key, v.name, v.fi)
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return n.hdfsToObjectBucketErr(ctx, err, bucket)
+			}
+			objInfo.VersionID = v.versionID
+			objInfo.IsLatest = v.versionID == hdfsNullVersionID
+			infos[i] = &objInfo
+			return nil
+		}, i)
+	}
+	if err = g.WaitErr(); err != nil {
+		return minio.ListObjectVersionsInfo{}, err
+	}
+	for i, objInfo := range infos {
+		if page[i].fi == nil {
+			loi.Prefixes = append(loi.Prefixes, page[i].key)
+		} else if objInfo != nil {
+			loi.Objects = append(loi.Objects, *objInfo)
+		}
+	}
+	return loi, nil
+}
+
+// createSnapshot creates a snapshot of the bucket, named by the namenode
+// if name is empty. Buckets are made snapshottable on their first
+// snapshot, which requires the HDFS superuser.
+func (n *hdfsObjects) createSnapshot(ctx context.Context, bucket, name string) (hdfsSnapshot, error) {
+	dir := n.hdfsPathJoin(bucket)
+	snapshotPath, err := n.clnt.CreateSnapshot(dir, name)
+	var remoteErr hdfs.Error
+	if errors.As(err, &remoteErr) && remoteErr.Exception() == hdfsSnapshotException {
+		if n.clnt.AllowSnapshots(dir) == nil {
+			snapshotPath, err = n.clnt.CreateSnapshot(dir, name)
+		}
+	}
+	if err != nil {
+		return hdfsSnapshot{}, hdfsToObjectErr(ctx, err, bucket)
+	}
+	fi, err := n.clnt.Stat(snapshotPath)
+	if err != nil {
+		return hdfsSnapshot{}, hdfsToObjectErr(ctx, err, bucket)
+	}
+	return hdfsSnapshot{
+		Name:      fi.Name(),
+		Created:   fi.ModTime().UTC(),
+		VersionID: hdfsSnapshotVersionID(fi.Name(), fi.ModTime()),
+	}, nil
+}
+
+// deleteSnapshot deletes the snapshot of the bucket.
+func (n *hdfsObjects) deleteSnapshot(ctx context.Context, bucket, name string) error {
+	if name == "" {
+		return minio.InvalidArgument{Bucket: bucket, Err: errors.New("missing snapshot name")}
+	}
+	return hdfsToObjectErr(ctx, n.clnt.DeleteSnapshot(n.hdfsPathJoin(bucket), name), bucket)
+}
+
+// registerSnapshotRouter registers the snapshot admin API, which lists,
+// creates and deletes the snapshots of a bucket:
+//
+//	GET    /minio/admin/v3/hdfs/snapshots?bucket=<bucket>
+//	PUT    /minio/admin/v3/hdfs/snapshots?bucket=<bucket>[&name=<name>]
+//	DELETE /minio/admin/v3/hdfs/snapshots?bucket=<bucket>&name=<name>
+func (g *HDFS) registerSnapshotRouter(router *mux.Router) {
+	// handler returns the handler of the snapshots of the bucket of the
+	// request, acting as the gateway user.
+	handler := func(f func(ctx context.Context, n *hdfsObjects, bucket, name string) (interface{}, error)) http.HandlerFunc {
+		return ming.AdminHandler(func(r *http.Request) (interface{}, error) {
+			n, _ := g.layer.Load().(*hdfsObjects)
+			if n == nil {
+				return nil, errors.New("hdfs gateway not initialized")
+			}
+			bucket := r.URL.Query().Get("bucket")
+			if !hdfsIsValidBucketName(bucket) {
+				return nil, minio.BucketNameInvalid{Bucket: bucket}
+			}
+			n, err := n.mount(bucket)
+			if err != nil {
+				return nil, err
+			}
+			if n.users != nil {
+				n = n.users.service
+			}
+			return f(r.Context(), n, bucket, r.URL.Query().Get("name"))
+		})
+	}
+
+	router.Methods(http.MethodGet).Path("/snapshots").HandlerFunc(handler(
+		func(ctx context.Context, n *hdfsObjects, bucket, name string) (interface{}, error) {
+			if _, err := n.clnt.Stat(n.hdfsPathJoin(bucket)); err != nil {
+				return nil, hdfsToObjectErr(ctx, err, bucket)
+			}
+			snapshots, err := n.bucketSnapshots(bucket)
+			if err != nil {
+				return nil, hdfsToObjectErr(ctx, err, bucket)
+			}
+			if snapshots == nil {
+				snapshots = []hdfsSnapshot{}
+			}
+			return snapshots, nil
+		}))
+	router.Methods(http.MethodPut).Path("/snapshots").HandlerFunc(handler(
+		func(ctx context.Context, n *hdfsObjects, bucket, name string) (interface{}, error) {
+			return n.createSnapshot(ctx, bucket, name)
+		}))
+	router.Methods(http.MethodDelete).Path("/snapshots").HandlerFunc(handler(
+		func(ctx context.Context, n *hdfsObjects, bucket, name string) (interface{}, error) {
+			return nil, n.deleteSnapshot(ctx, bucket, name)
+		}))
+}
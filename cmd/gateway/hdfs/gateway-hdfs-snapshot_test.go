@@ -0,0 +1,107 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// resizeFakeFile changes the size of the file of the key, to tell its
+// versions apart.
+func resizeFakeFile(nn *fakeNamenode, key string, size int64) {
+	name := path.Join("/bucket", key)
+	for _, fi := range nn.dirs[path.Dir(name)] {
+		if fi.Name() == path.Base(name) {
+			fi.(*fakeFileInfo).size = size
+		}
+	}
+}
+
+func TestHDFSVersionLister(t *testing.T) {
+	live := newFakeNamenode([]string{"a", "b/c", "d", "f/"})
+	s1 := newFakeNamenode([]string{"a", "b/c", "e"})
+	resizeFakeFile(s1, "a", 10)
+	s2 := newFakeNamenode([]string{"a", "e"})
+	resizeFakeFile(s2, "e", 10)
+
+	trees := []hdfsVersionTree{
+		{root: "/bucket", versionID: hdfsNullVersionID},
+		{root: "/bucket/.snapshot/s1", versionID: "s1"},
+		{root: "/bucket/.snapshot/s2", versionID: "s2"},
+	}
+	// open opens the directory in the namenode of its tree.
+	open := func(name string) (hdfsDirReader, error) {
+		for _, tree := range []struct {
+			root string
+			nn   *fakeNamenode
+		}{{"/bucket/.snapshot/s1", s1}, {"/bucket/.snapshot/s2", s2}, {"/bucket", live}} {
+			if strings.HasPrefix(name, tree.root) {
+				return tree.nn.open("/bucket" + strings.TrimPrefix(name, tree.root))
+			}
+		}
+		t.Fatalf("Unexpected directory %s", name)
+		return nil, nil
+	}
+
+	testCases := []struct {
+		prefix, delimiter, marker string
+		versions                  []string
+	}{
+		// "a" changed in s1 and again since s2, "b/c" never changed and
+		// "e" was deleted after s1. The empty directory "f" has no
+		// versions.
+		{"", "", "", []string{"a@null", "a@s1", "a@s2", "b/c@null", "d@null", "e@s1", "e@s2"}},
+		{"", "/", "", []string{"a@null", "a@s1", "a@s2", "b/", "d@null", "e@s1", "e@s2", "f/"}},
+		{"", "", "b/c", []string{"d@null", "e@s1", "e@s2"}},
+		{"", "/", "b/", []string{"d@null", "e@s1", "e@s2", "f/"}},
+		{"b/", "", "", []string{"b/c@null"}},
+		{"e", "", "", []string{"e@s1", "e@s2"}},
+	}
+	for i, testCase := range testCases {
+		l := newHDFSVersionLister(open, trees, testCase.prefix, testCase.delimiter, testCase.marker)
+		var versions []string
+		for {
+			entries, err := l.next()
+			if err != nil {
+				t.Fatalf("Test %d: %v", i+1, err)
+			}
+			if len(entries) == 0 {
+				break
+			}
+			for _, e := range entries {
+				if e.fi == nil {
+					versions = append(versions, e.key)
+					continue
+				}
+				name := path.Join("/bucket", hdfsSnapshotDir, e.versionID, e.key)
+				if e.versionID == hdfsNullVersionID {
+					name = path.Join("/bucket", e.key)
+				}
+				if e.name != name {
+					t.Errorf("Test %d: unexpected path %s of %s@%s", i+1, e.name, e.key, e.versionID)
+				}
+				versions = append(versions, e.key+"@"+e.versionID)
+			}
+		}
+		if !reflect.DeepEqual(versions, testCase.versions) {
+			t.Errorf("Test %d: Expected %v, got %v", i+1, testCase.versions, versions)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/colinmarc/hdfs/v2"
+	"github.com/gorilla/mux"
+	ming "github.com/minio/ming/cmd"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/config/storageclass"
+)
+
+// S3 storage classes are mapped to HDFS storage policies, which choose the
+// storage types of the replicas of the blocks of a file, or to erasure
+// coding policies. The STANDARD class is the policy of the bucket
+// directory, set when the bucket is made, and objects written without a
+// class or with STANDARD inherit it. Objects of other classes have their
+// policy set on their file. The namenode reports the effective policy of
+// each file, inherited or its own, which is mapped back to a class.
+// Buckets may have a default class, kept in an attribute of the bucket
+// directory, which objects written without a class are written with.
+//
+// The HDFS client can only read and write replicated files, files of the
+// classes mapped to an erasure coding policy are written in a directory
+// of the policy and read through WebHDFS, and so are storage policies set.
+// Multipart uploads concatenate replicated parts and cannot be erasure
+// coded.
+
+// Storage policies of HDFS by their ids.
+var hdfsStoragePolicies = map[uint32]string{
+	1:  "PROVIDED",
+	2:  "COLD",
+	5:  "WARM",
+	7:  "HOT",
+	10: "ONE_SSD",
+	12: "ALL_SSD",
+	15: "LAZY_PERSIST",
+}
+
+// Attribute of the bucket directory holding the default storage class of
+// the bucket.
+const hdfsStorageClassXAttr = hdfsXAttrPrefix + "storageclass"
+
+// Directory of the tmp directory holding a directory for each erasure
+// coding policy, where the files of its classes are written.
+const hdfsECTmpDir = "ec"
+
+// hdfsIsStoragePolicy returns whether the policy is a storage policy,
+// any other policy is an erasure coding policy.
+func hdfsIsStoragePolicy(policy string) bool {
+	for _, p := range hdfsStoragePolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// hdfsIsECPolicy returns whether the policy is an erasure coding policy.
+func hdfsIsECPolicy(policy string) bool {
+	return policy != "" && !hdfsIsStoragePolicy(policy)
+}
+
+// parseHDFSStorageClasses parses the storage class to HDFS policy table,
+// given as comma separated "class=policy" pairs.
+func parseHDFSStorageClasses(s string) (map[string]string, error) {
+	classes := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i <= 0 || i == len(kv)-1 {
+			return nil, fmt.Errorf("invalid storage class mapping %q, expected class=policy", kv)
+		}
+		class, policy := kv[:i], kv[i+1:]
+		if !storageclass.IsValid(class) {
+			return nil, fmt.Errorf("invalid storage class %q, expected %s or %s", class, storageclass.STANDARD, storageclass.RRS)
+		}
+		if hdfsIsStoragePolicy(strings.ToUpper(policy)) {
+			policy = strings.ToUpper(policy)
+		} else if class == storageclass.STANDARD {
+			// Buckets of an erasure coding policy could not be written to.
+			return nil, fmt.Errorf("invalid storage class mapping %q, %s must map to a storage policy", kv, class)
+		}
+		classes[class] = policy
+	}
+	return classes, nil
+}
+
+// hdfsFilePolicy returns the effective policy of the file, its erasure
+// coding policy if it has one, empty if the namenode reports none.
+func hdfsFilePolicy(fi os.FileInfo) string {
+	status, ok := fi.Sys().(*hdfs.FileStatus)
+	if !ok {
+		return ""
+	}
+	if name := status.GetEcPolicy().GetName(); name != "" {
+		return name
+	}
+	return hdfsStoragePolicies[status.GetStoragePolicy()]
+}
+
+// storageClass returns the storage class of the file, STANDARD if no
+// class maps to its policy.
+func (n *hdfsObjects) storageClass(fi os.FileInfo) string {
+	policy := hdfsFilePolicy(fi)
+	if policy != "" && n.storageClasses[storageclass.RRS] == policy {
+		return storageclass.RRS
+	}
+	return storageclass.STANDARD
+}
+
+// hdfsRewritesPolicy returns whether a file of the current policy has to
+// be written again to have the policy, the erasure coding of a file being
+// fixed when it is written.
+func hdfsRewritesPolicy(current, policy string) bool {
+	return current != policy && (hdfsIsECPolicy(current) || hdfsIsECPolicy(policy))
+}
+
+// storagePolicy returns the storage or erasure coding policy objects of
+// the class are written with, empty to inherit the policy of the bucket.
+func (n *hdfsObjects) storagePolicy(class string) (string, error) {
+	if class == "" || class == storageclass.STANDARD {
+		return "", nil
+	}
+	policy := n.storageClasses[class]
+	if hdfsIsECPolicy(policy) && n.web == nil {
+		return "", minio.NotImplemented{API: "erasure coded storage class " + class + " without WebHDFS"}
+	}
+	return policy, nil
+}
+
+// multipartStoragePolicy returns the storage policy multipart uploads of
+// the class are completed with, their parts cannot be concatenated into
+// an erasure coded file.
+func (n *hdfsObjects) multipartStoragePolicy(class string) (string, error) {
+	policy, err := n.storagePolicy(class)
+	if err == nil && hdfsIsECPolicy(policy) {
+		return "", minio.NotImplemented{API: "multipart upload with erasure coded storage class " + class}
+	}
+	return policy, err
+}
+
+// ecTmpDir returns the tmp directory of files of the erasure coding
+// policy, setting the policy of the directory on its first use.
+func (n *hdfsObjects) ecTmpDir(ctx context.Context, policy string) (string, error) {
+	dir := n.hdfsPathJoin(minioMetaTmpBucket, hdfsECTmpDir, policy)
+	if fi, err := n.clnt.Stat(dir); err == nil && hdfsFilePolicy(fi) == policy {
+		return dir, nil
+	}
+	if err := n.clnt.MkdirAll(dir, n.metaDirMode()); err != nil {
+		return "", err
+	}
+	return dir, n.web.setECPolicy(ctx, dir, policy)
+}
+
+// objectStorageClass returns the class objects written as class are
+// written with, the default class of the bucket if class is empty.
+func (n *hdfsObjects) objectStorageClass(bucket, class string) (string, error) {
+	if class != "" {
+		return class, nil
+	}
+	return n.bucketStorageClass(bucket)
+}
+
+// bucketStorageClass returns the default storage class of the bucket,
+// empty if it has none.
+func (n *hdfsObjects) bucketStorageClass(bucket string) (string, error) {
+	xattrs, err := n.clnt.GetXAttrs(n.hdfsPathJoin(bucket), hdfsStorageClassXAttr)
+	if hdfsIsXAttrNotFound(err) {
+		return "", nil
+	}
+	return xattrs[hdfsStorageClassXAttr], err
+}
+
+// setBucketStorageClass sets the default storage class of the bucket,
+// removes it if class is empty or STANDARD.
+func (n *hdfsObjects) setBucketStorageClass(ctx context.Context, bucket, class string) error {
+	name := n.hdfsPathJoin(bucket)
+	if _, err := n.clnt.Stat(name); err != nil {
+		return hdfsToObjectErr(ctx, err, bucket)
+	}
+	if class == "" || class == storageclass.STANDARD {
+		if err := n.clnt.RemoveXAttr(name, hdfsStorageClassXAttr); err != nil && !hdfsIsXAttrNotFound(err) {
+			return hdfsToObjectErr(ctx, err, bucket)
+		}
+		return nil
+	}
+	if err := n.checkBucketStorageClass(bucket, class); err != nil {
+		return err
+	}
+	return hdfsToObjectErr(ctx, n.clnt.SetXAttr(name, hdfsStorageClassXAttr, class), bucket)
+}
+
+// checkBucketStorageClass returns an error if the class cannot be the
+// default class of the bucket, it must be mapped to a policy objects can
+// be written with.
+func (n *hdfsObjects) checkBucketStorageClass(bucket, class string) error {
+	if !storageclass.IsValid(class) || n.storageClasses[class] == "" {
+		return minio.InvalidArgument{Bucket: bucket, Err: fmt.Errorf("storage class %q is not mapped to an HDFS policy", class)}
+	}
+	_, err := n.storagePolicy(class)
+	return err
+}
+
+// hdfsBucketStorageClass is the default storage class of a bucket
+// returned by the admin API.
+type hdfsBucketStorageClass struct {
+	StorageClass string `json:"storageClass"`
+}
+
+// registerStorageClassRouter registers the storage class admin API, which
+// reads, sets and removes the default storage class of a bucket:
+//
+//	GET    /minio/admin/v3/hdfs/storageclass?bucket=<bucket>
+//	PUT    /minio/admin/v3/hdfs/storageclass?bucket=<bucket>&class=<class>
+//	DELETE /minio/admin/v3/hdfs/storageclass?bucket=<bucket>
+func (g *HDFS) registerStorageClassRouter(router *mux.Router) {
+	// handler returns the handler of the storage class of the bucket of
+	// the request, acting as the gateway user.
+	handler := func(f func(ctx context.Context, n *hdfsObjects, bucket string, query url.Values) (interface{}, error)) http.HandlerFunc {
+		return ming.AdminHandler(func(r *http.Request) (interface{}, error) {
+			n, _ := g.layer.Load().(*hdfsObjects)
+			if n == nil {
+				return nil, errors.New("hdfs gateway not initialized")
+			}
+			query := r.URL.Query()
+			bucket := query.Get("bucket")
+			if !hdfsIsValidBucketName(bucket) {
+				return nil, minio.BucketNameInvalid{Bucket: bucket}
+			}
+			n, err := n.mount(bucket)
+			if err != nil {
+				return nil, err
+			}
+			if n.users != nil {
+				n = n.users.service
+			}
+			return f(r.Context(), n, bucket, query)
+		})
+	}
+
+	router.Methods(http.MethodGet).Path("/storageclass").HandlerFunc(handler(
+		func(ctx context.Context, n *hdfsObjects, bucket string, query url.Values) (interface{}, error) {
+			if _, err := n.clnt.Stat(n.hdfsPathJoin(bucket)); err != nil {
+				return nil, hdfsToObjectErr(ctx, err, bucket)
+			}
+			class, err := n.bucketStorageClass(bucket)
+			if err != nil {
+				return nil, hdfsToObjectErr(ctx, err, bucket)
+			}
+			if class == "" {
+				class = storageclass.STANDARD
+			}
+			return hdfsBucketStorageClass{StorageClass: class}, nil
+		}))
+	router.Methods(http.MethodPut).Path("/storageclass").HandlerFunc(handler(
+		func(ctx context.Context, n *hdfsObjects, bucket string, query url.Values) (interface{}, error) {
+			return nil, n.setBucketStorageClass(ctx, bucket, query.Get("class"))
+		}))
+	router.Methods(http.MethodDelete).Path("/storageclass").HandlerFunc(handler(
+		func(ctx context.Context, n *hdfsObjects, bucket string, query url.Values) (interface{}, error) {
+			return nil, n.setBucketStorageClass(ctx, bucket, "")
+		}))
+}
+
+// setStoragePolicy sets the storage policy of the file or directory,
+// removes it to inherit the policy of its parent if policy is empty.
+// Blocks written before are moved by the HDFS mover. Erasure coding
+// policies cannot be set on files.
+func (n *hdfsObjects) setStoragePolicy(ctx context.Context, name, policy string) error {
+	if n.web == nil {
+		if policy == "" {
+			return nil
+		}
+		return minio.NotImplemented{API: "SetStoragePolicy without WebHDFS"}
+	}
+	if policy == "" {
+		return n.web.call(ctx, http.MethodPost, "UNSETSTORAGEPOLICY", name, nil)
+	}
+	if hdfsIsECPolicy(policy) {
+		return minio.NotImplemented{API: "SetStoragePolicy with erasure coding policy " + policy}
+	}
+	return n.web.call(ctx, http.MethodPut, "SETSTORAGEPOLICY", name, url.Values{"storagepolicy": {policy}})
+}
@@ -0,0 +1,161 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHDFSStorageClasses(t *testing.T) {
+	testCases := []struct {
+		s          string
+		classes    map[string]string
+		shouldPass bool
+	}{
+		{"", map[string]string{}, true},
+		{"STANDARD=hot, REDUCED_REDUNDANCY=cold", map[string]string{"STANDARD": "HOT", "REDUCED_REDUNDANCY": "COLD"}, true},
+		// Erasure coding policies keep their case.
+		{"REDUCED_REDUNDANCY=RS-6-3-1024k", map[string]string{"REDUCED_REDUNDANCY": "RS-6-3-1024k"}, true},
+		{"STANDARD=RS-6-3-1024k", nil, false},
+		{"GLACIER=COLD", nil, false},
+		{"standard=HOT", nil, false},
+		{"STANDARD", nil, false},
+		{"=HOT", nil, false},
+		{"STANDARD=", nil, false},
+	}
+	for i, testCase := range testCases {
+		classes, err := parseHDFSStorageClasses(testCase.s)
+		if err != nil && testCase.shouldPass {
+			t.Errorf("Test %d: Expected to pass, but failed with: <ERROR> %s", i+1, err)
+		}
+		if err == nil && !testCase.shouldPass {
+			t.Errorf("Test %d: Expected to fail, but passed", i+1)
+		}
+		if !reflect.DeepEqual(classes, testCase.classes) {
+			t.Errorf("Test %d: Expected %v, got %v", i+1, testCase.classes, classes)
+		}
+	}
+}
+
+func TestHDFSStoragePolicy(t *testing.T) {
+	web := newHDFSWebClient("namenode:9870", "minio", nil)
+	testCases := []struct {
+		classes    map[string]string
+		web        *hdfsWebClient
+		class      string
+		policy     string
+		multipart  bool
+		shouldPass bool
+	}{
+		// STANDARD objects inherit the policy of the bucket.
+		{map[string]string{"STANDARD": "HOT", "REDUCED_REDUNDANCY": "COLD"}, web, "", "", true, true},
+		{map[string]string{"STANDARD": "HOT", "REDUCED_REDUNDANCY": "COLD"}, web, "STANDARD", "", true, true},
+		{map[string]string{"STANDARD": "HOT", "REDUCED_REDUNDANCY": "COLD"}, web, "REDUCED_REDUNDANCY", "COLD", true, true},
+		// Unmapped classes inherit it too.
+		{map[string]string{}, nil, "REDUCED_REDUNDANCY", "", true, true},
+		// Erasure coded files are written through WebHDFS, and multipart
+		// uploads cannot be erasure coded.
+		{map[string]string{"REDUCED_REDUNDANCY": "RS-6-3-1024k"}, web, "REDUCED_REDUNDANCY", "RS-6-3-1024k", false, true},
+		{map[string]string{"REDUCED_REDUNDANCY": "RS-6-3-1024k"}, web, "REDUCED_REDUNDANCY", "", true, false},
+		{map[string]string{"REDUCED_REDUNDANCY": "RS-6-3-1024k"}, nil, "REDUCED_REDUNDANCY", "", false, false},
+	}
+	for i, testCase := range testCases {
+		n := &hdfsObjects{storageClasses: testCase.classes, web: testCase.web}
+		policy, err := n.storagePolicy(testCase.class)
+		if testCase.multipart {
+			policy, err = n.multipartStoragePolicy(testCase.class)
+		}
+		if err != nil && testCase.shouldPass {
+			t.Errorf("Test %d: Expected to pass, but failed with: <ERROR> %s", i+1, err)
+		}
+		if err == nil && !testCase.shouldPass {
+			t.Errorf("Test %d: Expected to fail, but passed", i+1)
+		}
+		if policy != testCase.policy {
+			t.Errorf("Test %d: Expected policy %q, got %q", i+1, testCase.policy, policy)
+		}
+	}
+}
+
+func TestHDFSStorageClass(t *testing.T) {
+	n := &hdfsObjects{storageClasses: map[string]string{"STANDARD": "HOT", "REDUCED_REDUNDANCY": "COLD"}}
+	testCases := []struct {
+		policy uint32
+		class  string
+	}{
+		{7, "STANDARD"},
+		{2, "REDUCED_REDUNDANCY"},
+		// Policies without a class are STANDARD.
+		{12, "STANDARD"},
+		// Namenodes without storage policies report none.
+		{0, "STANDARD"},
+	}
+	for i, testCase := range testCases {
+		fi := &fakeFileInfo{name: "object"}
+		fi.status.StoragePolicy = &testCase.policy
+		if class := n.storageClass(fi); class != testCase.class {
+			t.Errorf("Test %d: Expected %q, got %q", i+1, testCase.class, class)
+		}
+	}
+}
+
+func TestHDFSCheckBucketStorageClass(t *testing.T) {
+	testCases := []struct {
+		classes    map[string]string
+		class      string
+		shouldPass bool
+	}{
+		{map[string]string{"STANDARD": "HOT", "REDUCED_REDUNDANCY": "COLD"}, "REDUCED_REDUNDANCY", true},
+		{map[string]string{"REDUCED_REDUNDANCY": "ALL_SSD"}, "REDUCED_REDUNDANCY", true},
+		{map[string]string{"REDUCED_REDUNDANCY": "RS-6-3-1024k"}, "REDUCED_REDUNDANCY", true},
+		// Unmapped classes would be written as STANDARD.
+		{map[string]string{"STANDARD": "HOT"}, "REDUCED_REDUNDANCY", false},
+		{map[string]string{"STANDARD": "HOT", "REDUCED_REDUNDANCY": "COLD"}, "reduced_redundancy", false},
+		{map[string]string{"STANDARD": "HOT", "REDUCED_REDUNDANCY": "COLD"}, "GLACIER", false},
+	}
+	for i, testCase := range testCases {
+		n := &hdfsObjects{storageClasses: testCase.classes, web: newHDFSWebClient("namenode:9870", "minio", nil)}
+		err := n.checkBucketStorageClass("bucket", testCase.class)
+		if err != nil && testCase.shouldPass {
+			t.Errorf("Test %d: Expected to pass, but failed with: <ERROR> %s", i+1, err)
+		}
+		if err == nil && !testCase.shouldPass {
+			t.Errorf("Test %d: Expected to fail, but passed", i+1)
+		}
+	}
+}
+
+func TestHDFSRewritesPolicy(t *testing.T) {
+	testCases := []struct {
+		current, policy string
+		rewrites        bool
+	}{
+		{"HOT", "COLD", false},
+		{"HOT", "", false},
+		{"RS-6-3-1024k", "RS-6-3-1024k", false},
+		{"HOT", "RS-6-3-1024k", true},
+		{"RS-6-3-1024k", "COLD", true},
+		{"RS-6-3-1024k", "", true},
+		{"RS-6-3-1024k", "RS-3-2-1024k", true},
+	}
+	for i, testCase := range testCases {
+		if rewrites := hdfsRewritesPolicy(testCase.current, testCase.policy); rewrites != testCase.rewrites {
+			t.Errorf("Test %d: Expected %t, got %t", i+1, testCase.rewrites, rewrites)
+		}
+	}
+}
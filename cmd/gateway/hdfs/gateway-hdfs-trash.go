@@ -0,0 +1,403 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	ming "github.com/minio/ming/cmd"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+)
+
+// With the trash enabled, deleted objects are moved to a trash directory
+// instead of being removed. In user mode this is the HDFS trash of the
+// user deleting the object, /user/<user>/.Trash/Current/<path of the
+// object>, emptied by the trash emptier of the namenode after
+// fs.trash.interval minutes, which also moves Current to checkpoint
+// directories next to it. In bucket mode this is the directory
+// .minio.sys/trash/<bucket>/Current/<object>, emptied by the gateway once
+// the retention elapsed.
+//
+// The bucket, the object and the deletion time are kept in an attribute
+// of trashed files, files trashed outside the gateway are restored to the
+// object of their path.
+
+const (
+	hdfsTrashOff    = "off"
+	hdfsTrashUser   = "user"
+	hdfsTrashBucket = "bucket"
+
+	// Directory of the trash holding the files being trashed.
+	hdfsTrashCurrent = "Current"
+
+	// Attribute holding the bucket, object and deletion time of a trashed
+	// file, JSON encoded.
+	hdfsTrashedXAttr = hdfsXAttrPrefix + "trashed"
+
+	// Default retention of trashed objects in bucket mode.
+	hdfsTrashDefaultRetention = 24 * time.Hour
+
+	// Maximum interval between two purges of the bucket trashes.
+	hdfsTrashPurgeInterval = time.Hour
+)
+
+// Mode of the directories of the HDFS trash, as created by HDFS.
+const hdfsTrashDirMode = os.FileMode(0700)
+
+// hdfsTrashEntry is a trashed object.
+type hdfsTrashEntry struct {
+	ID      string    `json:"id"` // path below the trash directory
+	Bucket  string    `json:"bucket"`
+	Object  string    `json:"object"`
+	Deleted time.Time `json:"deleted"`
+	Size    int64     `json:"size"`
+}
+
+// parseHDFSTrash parses the trash mode.
+func parseHDFSTrash(s string) (string, error) {
+	switch s = strings.ToLower(s); s {
+	case hdfsTrashOff, hdfsTrashUser, hdfsTrashBucket:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid trash mode %q, expected %s, %s or %s", s, hdfsTrashOff, hdfsTrashUser, hdfsTrashBucket)
+}
+
+// hdfsShortName returns the short name of the user, the first component
+// of Kerberos principals as the default hadoop.security.auth_to_local
+// rule maps them, which names the home directory of the user.
+func hdfsShortName(user string) string {
+	if i := strings.IndexAny(user, "/@"); i >= 0 {
+		return user[:i]
+	}
+	return user
+}
+
+// trashRoot returns the trash directory of the bucket.
+func (n *hdfsObjects) trashRoot(bucket string) string {
+	if n.trash == hdfsTrashUser {
+		return path.Join("/user", hdfsShortName(n.clnt.User()), ".Trash")
+	}
+	return n.hdfsPathJoin(minioMetaTrashBucket, bucket)
+}
+
+// trashPrefix returns the path of the bucket in the checkpoints of its
+// trash directory.
+func (n *hdfsObjects) trashPrefix(bucket string) string {
+	if n.trash == hdfsTrashUser {
+		return n.hdfsPathJoin(bucket)
+	}
+	return ""
+}
+
+// trashObject moves the object to the trash, pruning its empty parent
+// directories. Directory objects are removed.
+func (n *hdfsObjects) trashObject(ctx context.Context, bucket, object string) error {
+	bucketPath := n.hdfsPathJoin(bucket)
+	name := n.hdfsPathJoin(bucket, object)
+	fi, err := n.clnt.Stat(name)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return n.deleteObject(bucketPath, name)
+	}
+
+	dst := path.Join(n.trashRoot(bucket), hdfsTrashCurrent, n.trashPrefix(bucket), object)
+	mode := hdfsTrashDirMode
+	if n.trash == hdfsTrashBucket {
+		// Bucket trashes are shared by all users.
+		mode = n.metaDirMode()
+	}
+	if err = n.clnt.MkdirAll(path.Dir(dst), mode); err != nil {
+		return err
+	}
+	// Renames replace their target, objects deleted again are suffixed
+	// with the time like HDFS does.
+	if _, err = n.clnt.Stat(dst); err == nil {
+		dst += strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	}
+
+	data, err := json.Marshal(hdfsTrashEntry{Bucket: bucket, Object: object, Deleted: minio.UTCNow()})
+	if err != nil {
+		return err
+	}
+	if err = n.clnt.SetXAttr(name, hdfsTrashedXAttr, string(data)); err != nil {
+		return err
+	}
+	if err = n.clnt.Rename(name, dst); err != nil {
+		n.clnt.RemoveXAttr(name, hdfsTrashedXAttr)
+		return err
+	}
+	return n.deleteObject(bucketPath, path.Dir(name))
+}
+
+// trashEntry returns the trashed object of the file at id below the trash
+// directory, and whether it belongs to the bucket.
+func (n *hdfsObjects) trashEntry(bucket, id string, fi os.FileInfo) (hdfsTrashEntry, bool) {
+	xattrs, err := n.clnt.GetXAttrs(path.Join(n.trashRoot(bucket), id), hdfsTrashedXAttr)
+	if err != nil {
+		xattrs = nil
+	}
+	return newHDFSTrashEntry(bucket, id, n.trashPrefix(bucket), fi, xattrs[hdfsTrashedXAttr])
+}
+
+// newHDFSTrashEntry returns the trashed object of the file at id below
+// the trash directory, given its trashed attribute, and whether it
+// belongs to the bucket. Files trashed outside the gateway are the
+// object of their path below the checkpoint and the prefix of the bucket.
+func newHDFSTrashEntry(bucket, id, prefix string, fi os.FileInfo, trashed string) (hdfsTrashEntry, bool) {
+	entry := hdfsTrashEntry{ID: id, Deleted: fi.ModTime().UTC(), Size: fi.Size()}
+	if trashed != "" && json.Unmarshal([]byte(trashed), &entry) == nil {
+		entry.ID, entry.Size = id, fi.Size()
+		return entry, entry.Bucket == bucket
+	}
+	i := strings.Index(id, hdfsSeparator)
+	if i < 0 {
+		return entry, false
+	}
+	prefix = strings.TrimPrefix(prefix, hdfsSeparator)
+	object := id[i+1:]
+	if prefix != "" {
+		if !strings.HasPrefix(object, prefix+hdfsSeparator) {
+			return entry, false
+		}
+		object = strings.TrimPrefix(object, prefix+hdfsSeparator)
+	}
+	entry.Bucket, entry.Object = bucket, object
+	return entry, true
+}
+
+// cleanHDFSTrashID returns the id of a trashed file given by a request,
+// which cannot leave the trash directory.
+func cleanHDFSTrashID(id string) string {
+	return strings.TrimPrefix(path.Clean(hdfsSeparator+id), hdfsSeparator)
+}
+
+// listTrash returns the trashed objects of the bucket below the prefix,
+// most recently deleted first for each object.
+func (n *hdfsObjects) listTrash(ctx context.Context, bucket, prefix string) ([]hdfsTrashEntry, error) {
+	root := n.trashRoot(bucket)
+	checkpoints, err := n.clnt.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []hdfsTrashEntry{}, nil
+		}
+		return nil, err
+	}
+
+	entries := []hdfsTrashEntry{}
+	for _, checkpoint := range checkpoints {
+		if !checkpoint.IsDir() {
+			continue
+		}
+		start := path.Join(root, checkpoint.Name(), n.trashPrefix(bucket))
+		err = n.clnt.Walk(start, func(name string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			entry, ok := n.trashEntry(bucket, strings.TrimPrefix(name, root+hdfsSeparator), fi)
+			if ok && strings.HasPrefix(entry.Object, prefix) {
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Object != entries[j].Object {
+			return entries[i].Object < entries[j].Object
+		}
+		return entries[i].Deleted.After(entries[j].Deleted)
+	})
+	return entries, nil
+}
+
+// restoreTrash moves the trashed file at id back to its object, or to
+// object if not empty. Existing objects are not replaced.
+func (n *hdfsObjects) restoreTrash(ctx context.Context, bucket, id, object string) (minio.ObjectInfo, error) {
+	if object != "" && !minio.IsValidObjectName(object) {
+		return minio.ObjectInfo{}, minio.ObjectNameInvalid{Bucket: bucket, Object: object}
+	}
+	id = cleanHDFSTrashID(id)
+	name := path.Join(n.trashRoot(bucket), id)
+	fi, err := n.clnt.Stat(name)
+	if err != nil {
+		return minio.ObjectInfo{}, hdfsToObjectErr(ctx, err, bucket, id)
+	}
+	entry, ok := n.trashEntry(bucket, id, fi)
+	if fi.IsDir() || !ok {
+		return minio.ObjectInfo{}, minio.ObjectNotFound{Bucket: bucket, Object: id}
+	}
+	if object == "" {
+		object = entry.Object
+		if !minio.IsValidObjectName(object) {
+			return minio.ObjectInfo{}, minio.ObjectNameInvalid{Bucket: bucket, Object: object}
+		}
+	}
+
+	dst := n.hdfsPathJoin(bucket, object)
+	if _, err = n.clnt.Stat(dst); err == nil {
+		return minio.ObjectInfo{}, minio.ObjectAlreadyExists{Bucket: bucket, Object: object}
+	}
+	if err = n.renameToObject(bucket, name, dst); err != nil {
+		return minio.ObjectInfo{}, n.hdfsToObjectBucketErr(ctx, err, bucket, object)
+	}
+	if err = n.clnt.RemoveXAttr(dst, hdfsTrashedXAttr); err != nil && !hdfsIsXAttrNotFound(err) {
+		logger.LogIf(ctx, err)
+	}
+	if fi, err = n.clnt.Stat(dst); err != nil {
+		return minio.ObjectInfo{}, hdfsToObjectErr(ctx, err, bucket, object)
+	}
+	objInfo, err := n.objectInfo(ctx, bucket, object, fi)
+	if err != nil {
+		return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
+	}
+	return objInfo, nil
+}
+
+// purgeTrash removes the objects trashed longer than the retention from
+// the bucket trashes, and their empty directories.
+func (n *hdfsObjects) purgeTrash(ctx context.Context) {
+	root := n.hdfsPathJoin(minioMetaTrashBucket)
+	buckets, err := n.clnt.ReadDir(root)
+	if err != nil {
+		logger.LogIf(ctx, err)
+		return
+	}
+	expiry := time.Now().Add(-n.trashRetention)
+	for _, bucket := range buckets {
+		var dirs []string
+		start := path.Join(root, bucket.Name())
+		err = n.clnt.Walk(start, func(name string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				dirs = append(dirs, name)
+				return nil
+			}
+			id := strings.TrimPrefix(name, start+hdfsSeparator)
+			if entry, _ := n.trashEntry(bucket.Name(), id, fi); entry.Deleted.Before(expiry) {
+				return n.clnt.Remove(name)
+			}
+			return nil
+		})
+		logger.LogIf(ctx, err)
+		// Deepest directories first, only empty ones are removed.
+		for i := len(dirs) - 1; i >= 0; i-- {
+			if dirs[i] != start {
+				n.deleteObject(start, dirs[i])
+			}
+		}
+	}
+}
+
+// purgeTrashes purges the bucket trashes periodically, until the context
+// is canceled.
+func (n *hdfsObjects) purgeTrashes(ctx context.Context) {
+	interval := n.trashRetention
+	if interval > hdfsTrashPurgeInterval {
+		interval = hdfsTrashPurgeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n.purgeTrash(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// registerTrashRouter registers the trash admin API, which lists and
+// restores the trashed objects of a bucket. Requests act as the gateway
+// user, or as the HDFS user given by the user parameter when
+// impersonating:
+//
+//	GET  /minio/admin/v3/hdfs/trash?bucket=<bucket>[&prefix=<prefix>][&user=<user>]
+//	POST /minio/admin/v3/hdfs/trash?bucket=<bucket>&id=<id>[&object=<object>][&user=<user>]
+func (g *HDFS) registerTrashRouter(router *mux.Router) {
+	handler := func(f func(ctx context.Context, n *hdfsObjects, bucket string, r *http.Request) (interface{}, error)) http.HandlerFunc {
+		return ming.AdminHandler(func(r *http.Request) (interface{}, error) {
+			n, _ := g.layer.Load().(*hdfsObjects)
+			if n == nil {
+				return nil, errors.New("hdfs gateway not initialized")
+			}
+			if n.trash == hdfsTrashOff {
+				return nil, minio.NotImplemented{API: "HDFS trash"}
+			}
+			bucket := r.URL.Query().Get("bucket")
+			if !hdfsIsValidBucketName(bucket) {
+				return nil, minio.BucketNameInvalid{Bucket: bucket}
+			}
+			n, err := n.mount(bucket)
+			if err != nil {
+				return nil, err
+			}
+			if n.users != nil {
+				if user := r.URL.Query().Get("user"); user != "" {
+					n, err = n.users.get(user, r.Context().Done())
+				} else {
+					n = n.users.service
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			return f(r.Context(), n, bucket, r)
+		})
+	}
+
+	router.Methods(http.MethodGet).Path("/trash").HandlerFunc(handler(
+		func(ctx context.Context, n *hdfsObjects, bucket string, r *http.Request) (interface{}, error) {
+			entries, err := n.listTrash(ctx, bucket, r.URL.Query().Get("prefix"))
+			if err != nil {
+				return nil, hdfsToObjectErr(ctx, err, bucket)
+			}
+			return entries, nil
+		}))
+	router.Methods(http.MethodPost).Path("/trash").HandlerFunc(handler(
+		func(ctx context.Context, n *hdfsObjects, bucket string, r *http.Request) (interface{}, error) {
+			id := r.URL.Query().Get("id")
+			if id == "" {
+				return nil, minio.InvalidArgument{Bucket: bucket, Err: errors.New("missing trash id")}
+			}
+			return n.restoreTrash(ctx, bucket, id, r.URL.Query().Get("object"))
+		}))
+}
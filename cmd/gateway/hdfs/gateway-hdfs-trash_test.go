@@ -0,0 +1,139 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	minio "github.com/minio/minio/cmd"
+)
+
+func TestParseHDFSTrash(t *testing.T) {
+	testCases := []struct {
+		s          string
+		mode       string
+		shouldPass bool
+	}{
+		{"off", hdfsTrashOff, true},
+		{"user", hdfsTrashUser, true},
+		{"Bucket", hdfsTrashBucket, true},
+		{"", "", false},
+		{"on", "", false},
+	}
+	for i, testCase := range testCases {
+		mode, err := parseHDFSTrash(testCase.s)
+		if err != nil && testCase.shouldPass {
+			t.Errorf("Test %d: Expected to pass, but failed with: <ERROR> %s", i+1, err)
+		}
+		if err == nil && !testCase.shouldPass {
+			t.Errorf("Test %d: Expected to fail, but passed", i+1)
+		}
+		if mode != testCase.mode {
+			t.Errorf("Test %d: Expected mode %q, got %q", i+1, testCase.mode, mode)
+		}
+	}
+}
+
+func TestHDFSShortName(t *testing.T) {
+	testCases := []struct {
+		user, name string
+	}{
+		{"minio", "minio"},
+		{"minio/gateway.example.com", "minio"},
+		{"minio@EXAMPLE.COM", "minio"},
+		{"minio/gateway.example.com@EXAMPLE.COM", "minio"},
+	}
+	for i, testCase := range testCases {
+		if name := hdfsShortName(testCase.user); name != testCase.name {
+			t.Errorf("Test %d: Expected %q, got %q", i+1, testCase.name, name)
+		}
+	}
+}
+
+func TestNewHDFSTrashEntry(t *testing.T) {
+	deleted := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	trashed := `{"id":"other","bucket":"bucket","object":"a/b","deleted":"2021-03-01T12:00:00Z","size":1}`
+	testCases := []struct {
+		id, prefix, trashed string
+		object              string
+		ok                  bool
+	}{
+		// Trashed by the gateway, the attribute tells the object.
+		{"Current/data/bucket/a/b", "/data/bucket", trashed, "a/b", true},
+		{"Current/data/bucket/a/b", "/data/bucket", `{"bucket":"other","object":"a/b"}`, "a/b", false},
+		// Trashed outside the gateway, the path tells the object.
+		{"Current/data/bucket/a/b", "/data/bucket", "", "a/b", true},
+		{"210301120000/data/bucket/c", "/data/bucket", "", "c", true},
+		{"Current/data/other/a/b", "/data/bucket", "", "", false},
+		{"Current/data/bucket2/a/b", "/data/bucket", "", "", false},
+		{"Current", "/data/bucket", "", "", false},
+		// Malformed attributes fall back to the path.
+		{"Current/data/bucket/a/b", "/data/bucket", "{", "a/b", true},
+		// Bucket trashes have no prefix.
+		{"Current/a/b", "", "", "a/b", true},
+	}
+	for i, testCase := range testCases {
+		fi := &fakeFileInfo{name: "b", size: 3}
+		entry, ok := newHDFSTrashEntry("bucket", testCase.id, testCase.prefix, fi, testCase.trashed)
+		if ok != testCase.ok {
+			t.Errorf("Test %d: Expected ok %t, got %t", i+1, testCase.ok, ok)
+		}
+		if !ok {
+			continue
+		}
+		if entry.Object != testCase.object || entry.Bucket != "bucket" {
+			t.Errorf("Test %d: Expected object bucket/%s, got %s/%s", i+1, testCase.object, entry.Bucket, entry.Object)
+		}
+		if entry.ID != testCase.id || entry.Size != fi.size {
+			t.Errorf("Test %d: Expected id %s and size %d, got %s and %d", i+1, testCase.id, fi.size, entry.ID, entry.Size)
+		}
+		if testCase.trashed == trashed && !entry.Deleted.Equal(deleted) {
+			t.Errorf("Test %d: Expected deletion at %s, got %s", i+1, deleted, entry.Deleted)
+		}
+	}
+}
+
+func TestCleanHDFSTrashID(t *testing.T) {
+	testCases := []struct {
+		id, clean string
+	}{
+		{"Current/data/bucket/a", "Current/data/bucket/a"},
+		{"/Current/data/bucket/a", "Current/data/bucket/a"},
+		{"Current/../../etc/passwd", "etc/passwd"},
+		{"../../../user/other/.Trash", "user/other/.Trash"},
+		{"a//b/./c", "a/b/c"},
+		{"", ""},
+	}
+	for i, testCase := range testCases {
+		if clean := cleanHDFSTrashID(testCase.id); clean != testCase.clean {
+			t.Errorf("Test %d: Expected %q, got %q", i+1, testCase.clean, clean)
+		}
+	}
+}
+
+func TestRestoreHDFSTrashObjectName(t *testing.T) {
+	// Invalid names are rejected before the trash is looked up.
+	n := &hdfsObjects{}
+	for _, object := range []string{"..", "../other/object", "a/../../other", "a/./b", "a//b", "a/"} {
+		_, err := n.restoreTrash(context.Background(), "bucket", "Current/a", object)
+		if _, ok := err.(minio.ObjectNameInvalid); !ok {
+			t.Errorf("%q: Expected ObjectNameInvalid, got %v", object, err)
+		}
+	}
+}
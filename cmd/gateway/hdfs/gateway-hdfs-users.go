@@ -0,0 +1,292 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/colinmarc/hdfs/v2"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+)
+
+// With impersonation enabled, requests act on HDFS as the user of the
+// access key that signed them, or the HDFS user the access key is mapped
+// to. The gateway connects with its own user or Kerberos principal and
+// names the user of the request as the effective user of the connection,
+// so on Kerberos clusters the gateway principal must be allowed to proxy
+// users through the hadoop.proxyuser.* settings of the namenode. Requests
+// of the root credentials act as the gateway user, anonymous requests as
+// the configured anonymous user and are denied without one. POST policy
+// uploads and requests of the browser are refused, MinIO server verifies
+// their credentials without passing the access key on to the gateway.
+//
+// Each user has its own client and lister pool, created on its first
+// request. The clients of the least recently used users are closed once
+// more users than MINIO_HDFS_USER_CLIENTS made requests, after their
+// requests in progress are done.
+
+// Mode of the directories of .minio.sys all users write to when
+// impersonating, world writable with the sticky bit set.
+const hdfsSharedDirMode = os.FileMode(01777)
+
+// Default number of users whose clients are kept connected.
+const hdfsDefaultUserClients = 64
+
+// parseHDFSUsers parses the access key to HDFS user table, given as comma
+// separated "accesskey=user" pairs.
+func parseHDFSUsers(s string) (map[string]string, error) {
+	users := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i <= 0 || i == len(kv)-1 {
+			return nil, fmt.Errorf("invalid user mapping %q, expected accesskey=user", kv)
+		}
+		users[kv[:i]] = kv[i+1:]
+	}
+	return users, nil
+}
+
+// hdfsUserView is the layer acting as an HDFS user.
+type hdfsUserView struct {
+	user    string
+	ready   chan struct{} // closed once the client is connected or failed to
+	objects *hdfsObjects  // set before ready is closed, nil on error
+	err     error         // set before ready is closed
+	active  int           // requests using the client
+	evicted bool          // closed once no request uses it
+}
+
+// hdfsUsers holds the clients of the users impersonated by the gateway.
+type hdfsUsers struct {
+	opts          hdfs.ClientOptions // options of the gateway client
+	dial          func(hdfs.ClientOptions) (*hdfs.Client, error)
+	rootAccessKey string
+	users         map[string]string // access key to HDFS user
+	anonymous     string            // HDFS user of anonymous requests
+	service       *hdfsObjects      // layer acting as the gateway user
+	maxViews      int
+
+	mu    sync.Mutex
+	views map[string]*list.Element // HDFS user to its element of lru
+	lru   *list.List               // views, most recently used first
+}
+
+// newHDFSUsers returns the users impersonated by the gateway layer with
+// the client options of the gateway.
+func newHDFSUsers(opts hdfs.ClientOptions, cfg hdfsConfig, service *hdfsObjects) *hdfsUsers {
+	return &hdfsUsers{
+		opts:          opts,
+		dial:          hdfs.NewClient,
+		rootAccessKey: cfg.rootAccessKey,
+		users:         cfg.users,
+		anonymous:     cfg.anonymousUser,
+		service:       service,
+		maxViews:      cfg.userClients,
+		views:         make(map[string]*list.Element),
+		lru:           list.New(),
+	}
+}
+
+// isHDFSUnattributedAPI returns whether the handlers of the API verify
+// the credentials of their requests without recording the access key in
+// the request info: POST policy uploads are signed in their form, and the
+// requests of the browser carry its token.
+func isHDFSUnattributedAPI(api string) bool {
+	return api == "PostPolicyBucket" || strings.HasPrefix(api, "Web")
+}
+
+// hdfsUser returns the HDFS user the request on the bucket acts as, empty
+// for the gateway user. Anonymous requests, made by S3 clients without an
+// access key, are denied when no anonymous user is configured, calls of
+// the gateway itself carry no request and act as the gateway user.
+// Requests whose access key is unknown to the gateway are refused rather
+// than acting as the anonymous user.
+func (u *hdfsUsers) hdfsUser(reqInfo *logger.ReqInfo, bucket string) (string, error) {
+	switch {
+	case reqInfo.AccessKey == "" && isHDFSUnattributedAPI(reqInfo.API):
+		return "", minio.NotImplemented{API: reqInfo.API}
+	case reqInfo.AccessKey == "" && reqInfo.API != "":
+		if u.anonymous == "" {
+			return "", minio.PrefixAccessDenied{Bucket: bucket}
+		}
+		return u.anonymous, nil
+	case reqInfo.AccessKey == "" || reqInfo.AccessKey == u.rootAccessKey:
+		return "", nil
+	}
+	if user, ok := u.users[reqInfo.AccessKey]; ok {
+		return user, nil
+	}
+	return reqInfo.AccessKey, nil
+}
+
+// get returns the layer acting as the HDFS user, connecting a client for
+// it on its first request. The client is kept open until done is closed,
+// evicted clients of other users are closed. The client is connected
+// without holding the lock of the users, requests of the same user wait
+// for it, requests of other users do not.
+func (u *hdfsUsers) get(user string, done <-chan struct{}) (*hdfsObjects, error) {
+	u.mu.Lock()
+	var view *hdfsUserView
+	connect := false
+	if e, ok := u.views[user]; ok {
+		u.lru.MoveToFront(e)
+		view = e.Value.(*hdfsUserView)
+	} else {
+		view = &hdfsUserView{user: user, ready: make(chan struct{})}
+		u.views[user] = u.lru.PushFront(view)
+		for u.lru.Len() > u.maxViews {
+			u.evict(u.lru.Back())
+		}
+		connect = true
+	}
+	// Calls without a request to wait for may see the client closed.
+	if done != nil {
+		view.active++
+	}
+	u.mu.Unlock()
+
+	if connect {
+		objects, err := u.connect(user)
+		u.mu.Lock()
+		view.objects, view.err = objects, err
+		if err != nil {
+			// Drop the view, unless evicted already, for the next request
+			// to connect again.
+			if e, ok := u.views[user]; ok && e.Value.(*hdfsUserView) == view {
+				u.lru.Remove(e)
+				delete(u.views, user)
+			}
+		} else if view.evicted && view.active == 0 {
+			objects.clnt.Close()
+		}
+		close(view.ready)
+		u.mu.Unlock()
+	} else {
+		select {
+		case <-view.ready:
+		case <-done:
+			u.release(view)
+			return nil, context.Canceled
+		}
+	}
+
+	if view.err != nil {
+		if done != nil {
+			u.release(view)
+		}
+		return nil, view.err
+	}
+	if done != nil {
+		go func() {
+			<-done
+			u.release(view)
+		}()
+	}
+	return view.objects, nil
+}
+
+// connect returns the layer acting as the HDFS user with a new client.
+func (u *hdfsUsers) connect(user string) (*hdfsObjects, error) {
+	opts := u.opts
+	opts.User = user
+	clnt, err := u.dial(opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize hdfsClient for user %s: %v", user, err)
+	}
+	objects := *u.service
+	objects.clnt = clnt
+	objects.listPool = newHDFSListPool(hdfsListTimeout)
+	if objects.web != nil {
+		objects.web = objects.web.as(user)
+	}
+	return &objects, nil
+}
+
+// release ends a request using the client of the view, closing it if it
+// was evicted meanwhile.
+func (u *hdfsUsers) release(view *hdfsUserView) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	view.active--
+	if view.evicted && view.active == 0 && view.objects != nil {
+		view.objects.clnt.Close()
+	}
+}
+
+// evict removes the view of the element, closing its client unless
+// requests still use it. Clients still connecting are closed by get.
+func (u *hdfsUsers) evict(e *list.Element) {
+	view := u.lru.Remove(e).(*hdfsUserView)
+	delete(u.views, view.user)
+	view.evicted = true
+	if view.active == 0 && view.objects != nil {
+		view.objects.clnt.Close()
+	}
+}
+
+// close closes the clients of all users.
+func (u *hdfsUsers) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for u.lru.Len() > 0 {
+		u.evict(u.lru.Back())
+	}
+}
+
+// forRequest returns the layer acting on the namespace of the bucket as
+// the HDFS user of the request.
+func (n *hdfsObjects) forRequest(ctx context.Context, bucket string) (*hdfsObjects, error) {
+	n, err := n.mount(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if n.users == nil {
+		return n, nil
+	}
+	user, err := n.users.hdfsUser(logger.GetReqInfo(ctx), bucket)
+	if err != nil {
+		return nil, err
+	}
+	if user == "" {
+		return n.users.service, nil
+	}
+	view, err := n.users.get(user, ctx.Done())
+	if err != nil {
+		logger.LogIf(ctx, err)
+		return nil, err
+	}
+	return view, nil
+}
+
+// metaDirMode returns the mode of the directories of .minio.sys holding
+// the files of all users.
+func (n *hdfsObjects) metaDirMode() os.FileMode {
+	if n.users != nil {
+		return hdfsSharedDirMode
+	}
+	return os.FileMode(0755)
+}
@@ -0,0 +1,239 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2"
+	minio "github.com/minio/minio/cmd"
+	"github.com/minio/minio/cmd/logger"
+)
+
+func TestParseHDFSUsers(t *testing.T) {
+	testCases := []struct {
+		s          string
+		users      map[string]string
+		shouldPass bool
+	}{
+		{"", map[string]string{}, true},
+		{"alice=alice", map[string]string{"alice": "alice"}, true},
+		{" AKIA1=alice , AKIA2=bob,", map[string]string{"AKIA1": "alice", "AKIA2": "bob"}, true},
+		// Users may contain the separator, access keys cannot.
+		{"AKIA1=etl=prod", map[string]string{"AKIA1": "etl=prod"}, true},
+		{"AKIA1=alice,AKIA1=bob", map[string]string{"AKIA1": "bob"}, true},
+		{"alice", nil, false},
+		{"=alice", nil, false},
+		{"AKIA1=", nil, false},
+		{"AKIA1=alice,bob", nil, false},
+	}
+	for i, testCase := range testCases {
+		users, err := parseHDFSUsers(testCase.s)
+		if err != nil && testCase.shouldPass {
+			t.Errorf("Test %d: Expected to pass, but failed with: <ERROR> %s", i+1, err)
+		}
+		if err == nil && !testCase.shouldPass {
+			t.Errorf("Test %d: Expected to fail, but passed", i+1)
+		}
+		if !reflect.DeepEqual(users, testCase.users) {
+			t.Errorf("Test %d: Expected %v, got %v", i+1, testCase.users, users)
+		}
+	}
+}
+
+func TestHDFSUser(t *testing.T) {
+	u := &hdfsUsers{
+		rootAccessKey: "minio",
+		users:         map[string]string{"AKIA1": "alice", "bob": "robert"},
+	}
+	testCases := []struct {
+		accessKey, api string
+		anonymous      string
+		user           string
+		err            error
+	}{
+		// Requests of the root user and calls of the gateway act as the
+		// gateway.
+		{"", "", "", "", nil},
+		{"minio", "GetObject", "", "", nil},
+		{"AKIA1", "GetObject", "", "alice", nil},
+		{"bob", "GetObject", "", "robert", nil},
+		// Unmapped access keys are HDFS users.
+		{"carol", "GetObject", "", "carol", nil},
+		{"alice", "GetObject", "", "alice", nil},
+		// Anonymous requests act as the anonymous user, if any.
+		{"", "GetObject", "", "", minio.PrefixAccessDenied{Bucket: "bucket"}},
+		{"", "GetObject", "nobody", "nobody", nil},
+		// Signed requests without an access key never act as the
+		// anonymous user.
+		{"", "PostPolicyBucket", "nobody", "", minio.NotImplemented{API: "PostPolicyBucket"}},
+		{"", "WebUpload", "nobody", "", minio.NotImplemented{API: "WebUpload"}},
+	}
+	for i, testCase := range testCases {
+		u.anonymous = testCase.anonymous
+		user, err := u.hdfsUser(&logger.ReqInfo{AccessKey: testCase.accessKey, API: testCase.api}, "bucket")
+		if user != testCase.user || err != testCase.err {
+			t.Errorf("Test %d: Expected %q and %v, got %q and %v", i+1, testCase.user, testCase.err, user, err)
+		}
+	}
+}
+
+// newTestHDFSClient returns a client connected to a namenode which never
+// answers, and a channel closed when the client closes its connection.
+func newTestHDFSClient(t *testing.T) (*hdfs.Client, <-chan struct{}) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, conn)
+		conn.Close()
+		close(closed)
+	}()
+	clnt, err := hdfs.NewClient(hdfs.ClientOptions{Addresses: []string{l.Addr().String()}, User: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clnt, closed
+}
+
+func isClosed(closed <-chan struct{}) bool {
+	select {
+	case <-closed:
+		return true
+	case <-time.After(5 * time.Second):
+		return false
+	}
+}
+
+func TestHDFSUsersEvict(t *testing.T) {
+	u := &hdfsUsers{
+		maxViews: 1,
+		views:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+	aliceClnt, aliceClosed := newTestHDFSClient(t)
+	bobClnt, bobClosed := newTestHDFSClient(t)
+	alice := &hdfsUserView{user: "alice", objects: &hdfsObjects{clnt: aliceClnt}, active: 1}
+	u.views["alice"] = u.lru.PushFront(alice)
+	u.views["bob"] = u.lru.PushFront(&hdfsUserView{user: "bob", objects: &hdfsObjects{clnt: bobClnt}})
+
+	// The least recently used client is evicted, and closed once its
+	// request is done.
+	u.evict(u.lru.Back())
+	if _, ok := u.views["alice"]; ok || u.lru.Len() != 1 {
+		t.Fatalf("Expected alice to be evicted, got %d views", u.lru.Len())
+	}
+	select {
+	case <-aliceClosed:
+		t.Fatal("Expected the client of alice to be kept open for its request")
+	default:
+	}
+	u.release(alice)
+	if !isClosed(aliceClosed) {
+		t.Error("Expected the client of alice to be closed")
+	}
+
+	u.close()
+	if len(u.views) != 0 || u.lru.Len() != 0 {
+		t.Errorf("Expected no views after close, got %d", u.lru.Len())
+	}
+	if !isClosed(bobClosed) {
+		t.Error("Expected the client of bob to be closed")
+	}
+}
+
+func TestHDFSUsersConnect(t *testing.T) {
+	slowClnt, _ := newTestHDFSClient(t)
+	aliceClnt, _ := newTestHDFSClient(t)
+	dialing := make(chan struct{})
+	dialed := make(chan struct{})
+	fail := true
+	u := &hdfsUsers{
+		dial: func(opts hdfs.ClientOptions) (*hdfs.Client, error) {
+			if opts.User == "slow" {
+				close(dialing)
+				<-dialed
+				return slowClnt, nil
+			}
+			if fail {
+				return nil, errors.New("connection refused")
+			}
+			return aliceClnt, nil
+		},
+		service:  &hdfsObjects{},
+		maxViews: 2,
+		views:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+	done := make(chan struct{})
+	defer close(done)
+
+	// A failed connection is not kept, the next request connects again.
+	if _, err := u.get("alice", done); err == nil {
+		t.Fatal("Expected the connection of alice to fail")
+	}
+	if _, ok := u.views["alice"]; ok {
+		t.Fatal("Expected the failed view of alice to be dropped")
+	}
+	fail = false
+	alice, err := u.get("alice", done)
+	if err != nil || alice.clnt != aliceClnt {
+		t.Fatalf("Expected the client of alice, got %v", err)
+	}
+
+	// A user connecting does not hold up requests of connected users.
+	slow := make(chan *hdfsObjects, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			objects, _ := u.get("slow", done)
+			slow <- objects
+		}()
+	}
+	<-dialing
+	got := make(chan *hdfsObjects)
+	go func() {
+		objects, _ := u.get("alice", done)
+		got <- objects
+	}()
+	select {
+	case objects := <-got:
+		if objects != alice {
+			t.Error("Expected the connected client of alice")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected alice not to wait for the connection of another user")
+	}
+	close(dialed)
+	first, second := <-slow, <-slow
+	if first == nil || first != second || first.clnt != slowClnt {
+		t.Error("Expected the requests of a user to share its client")
+	}
+}
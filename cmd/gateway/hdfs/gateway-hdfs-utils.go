@@ -30,6 +30,12 @@ const (
 	// Minio Tmp meta prefix.
 	minioMetaTmpBucket = minioMetaBucket + "/tmp"
 
+	// Minio multipart meta prefix.
+	minioMetaMultipartBucket = minioMetaBucket + "/multipart"
+
+	// Minio trash meta prefix.
+	minioMetaTrashBucket = minioMetaBucket + "/trash"
+
 	// Minio reserved bucket name.
 	minioReservedBucket = "minio"
 )
@@ -0,0 +1,255 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	krb "github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	minio "github.com/minio/minio/cmd"
+)
+
+// The HDFS client only speaks the namenode RPCs it implements, the few
+// operations the gateway needs besides them are sent to the WebHDFS REST
+// API of the namenode. Requests authenticate with SPNEGO when the gateway
+// uses Kerberos, and name the gateway user otherwise. Files of erasure
+// coding policies, which the HDFS client cannot read nor write, are read
+// and written through WebHDFS too, by the datanodes the namenode names.
+
+// Timeout of the requests to the namenode, which transfer no data.
+const hdfsWebTimeout = time.Minute
+
+// hdfsWebClient sends requests to the WebHDFS API of the namenode.
+type hdfsWebClient struct {
+	endpoint string // scheme and address of the namenode HTTP server
+	user     string // user of simple authentication, empty with Kerberos
+	doAs     string // impersonated user, empty for the gateway user
+	do       func(req *http.Request) (*http.Response, error)
+	transfer func(req *http.Request) (*http.Response, error) // data requests to the datanodes
+}
+
+// newHDFSWebClient returns a client of the WebHDFS API at endpoint,
+// authenticating with kerberos when it is not nil. Requests use the TLS
+// settings of the gateway.
+func newHDFSWebClient(endpoint, user string, kerberos *krb.Client) *hdfsWebClient {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	transport := minio.NewGatewayHTTPTransport()
+	client := &http.Client{Transport: transport, Timeout: hdfsWebTimeout}
+	c := &hdfsWebClient{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		user:     user,
+		do:       client.Do,
+		// Transfers last as long as their data, the transport bounds
+		// the wait for the datanodes to respond.
+		transfer: (&http.Client{Transport: transport}).Do,
+	}
+	if kerberos != nil {
+		c.user = ""
+		c.do = spnego.NewClient(kerberos, client, "").Do
+	}
+	return c
+}
+
+// as returns a client acting as the user.
+func (c *hdfsWebClient) as(user string) *hdfsWebClient {
+	view := *c
+	view.doAs = user
+	return &view
+}
+
+// hdfsWebError is a Java exception returned by WebHDFS.
+type hdfsWebError struct {
+	method        string
+	JavaClassName string `json:"javaClassName"`
+	Msg           string `json:"message"`
+}
+
+// hdfsWebError implements the hdfs.Error interface of the errors of the
+// RPCs, so both kinds of errors are handled alike.
+func (e *hdfsWebError) Method() string    { return e.method }
+func (e *hdfsWebError) Desc() string      { return e.Msg }
+func (e *hdfsWebError) Exception() string { return e.JavaClassName }
+func (e *hdfsWebError) Message() string   { return e.Msg }
+func (e *hdfsWebError) Error() string     { return fmt.Sprintf("%s: %s", e.JavaClassName, e.Msg) }
+
+// hdfsWebErr returns the error of the response, wrapped as the errors of
+// the HDFS client.
+func hdfsWebErr(op, name string, resp *http.Response) error {
+	var body struct {
+		RemoteException *hdfsWebError `json:"RemoteException"`
+	}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(data, &body); err != nil || body.RemoteException == nil {
+		return &os.PathError{Op: op, Path: name, Err: fmt.Errorf("webhdfs: %s", resp.Status)}
+	}
+	remoteErr := body.RemoteException
+	remoteErr.method = op
+	var err error = remoteErr
+	switch remoteErr.JavaClassName {
+	case "java.io.FileNotFoundException":
+		err = os.ErrNotExist
+	case "org.apache.hadoop.security.AccessControlException":
+		err = os.ErrPermission
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// request sends the operation on the path and returns the response, whose
+// body the caller closes.
+func (c *hdfsWebClient) request(ctx context.Context, method, op, name string, params url.Values) (*http.Response, error) {
+	query := url.Values{}
+	for k, v := range params {
+		query[k] = v
+	}
+	query.Set("op", op)
+	if c.user != "" {
+		query.Set("user.name", c.user)
+	}
+	if c.doAs != "" {
+		query.Set("doas", c.doAs)
+	}
+	u := c.endpoint + "/webhdfs/v1" + (&url.URL{Path: name}).EscapedPath() + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, hdfsWebErr(op, name, resp)
+	}
+	return resp, nil
+}
+
+// call sends the operation on the path.
+func (c *hdfsWebClient) call(ctx context.Context, method, op, name string, params url.Values) error {
+	resp, err := c.request(ctx, method, op, name, params)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// location returns the URL of the datanode the data of the operation on
+// the path is sent to or read from.
+func (c *hdfsWebClient) location(ctx context.Context, method, op, name string, params url.Values) (string, error) {
+	query := url.Values{"noredirect": {"true"}}
+	for k, v := range params {
+		query[k] = v
+	}
+	resp, err := c.request(ctx, method, op, name, query)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Location string `json:"Location"`
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil || body.Location == "" {
+		return "", &os.PathError{Op: op, Path: name, Err: errors.New("webhdfs: no datanode location")}
+	}
+	return body.Location, nil
+}
+
+// create writes the file from r, of size bytes or -1 if unknown. The
+// datanodes write it with the erasure coding policy of its directory.
+func (c *hdfsWebClient) create(ctx context.Context, name string, r io.Reader, size int64) error {
+	loc, err := c.location(ctx, http.MethodPut, "CREATE", name, url.Values{"overwrite": {"false"}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, loc, r)
+	if err != nil {
+		return err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.transfer(req)
+	if err != nil {
+		return &os.PathError{Op: "CREATE", Path: name, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return hdfsWebErr("CREATE", name, resp)
+	}
+	return nil
+}
+
+// open returns a reader of length bytes of the file from offset.
+func (c *hdfsWebClient) open(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	loc, err := c.location(ctx, http.MethodGet, "OPEN", name, url.Values{
+		"offset": {strconv.FormatInt(offset, 10)},
+		"length": {strconv.FormatInt(length, 10)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.transfer(req)
+	if err != nil {
+		return nil, &os.PathError{Op: "OPEN", Path: name, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, hdfsWebErr("OPEN", name, resp)
+	}
+	return resp.Body, nil
+}
+
+// setECPolicy sets the erasure coding policy of the directory, which the
+// files created in it are written with.
+func (c *hdfsWebClient) setECPolicy(ctx context.Context, name, policy string) error {
+	return c.call(ctx, http.MethodPut, "SETECPOLICY", name, url.Values{"ecpolicy": {policy}})
+}
+
+// concat moves the blocks of the sources to the end of the target and
+// removes the sources, without copying their data.
+func (c *hdfsWebClient) concat(ctx context.Context, target string, sources []string) error {
+	return c.call(ctx, http.MethodPost, "CONCAT", target, url.Values{"sources": {strings.Join(sources, ",")}})
+}
+
+// setSpaceQuota sets the space quota of the directory, clears it if quota
+// is negative. Its namespace quota is left unchanged.
+func (c *hdfsWebClient) setSpaceQuota(ctx context.Context, name string, quota int64) error {
+	if quota < 0 {
+		quota = -1
+	}
+	return c.call(ctx, http.MethodPut, "SETQUOTA", name, url.Values{"storagespacequota": {strconv.FormatInt(quota, 10)}})
+}
@@ -0,0 +1,159 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHDFSWebConcat(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.URL.Query().Get("op")+" "+
+			r.URL.Query().Get("sources")+" "+r.URL.Query().Get("user.name"))
+		if r.URL.Path == "/webhdfs/v1/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"RemoteException":{"exception":"FileNotFoundException",`+
+				`"javaClassName":"java.io.FileNotFoundException","message":"File does not exist: /missing"}}`)
+		}
+	}))
+	defer server.Close()
+
+	c := newHDFSWebClient(server.URL, "minio", nil)
+	ctx := context.Background()
+	if err := c.concat(ctx, "/.minio.sys/multipart/b/u/00001", []string{"/.minio.sys/multipart/b/u/00002", "/.minio.sys/multipart/b/u/00003"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "POST /webhdfs/v1/.minio.sys/multipart/b/u/00001 CONCAT /.minio.sys/multipart/b/u/00002,/.minio.sys/multipart/b/u/00003 minio"
+	if len(requests) != 1 || requests[0] != expected {
+		t.Fatalf("Expected %q, got %q", expected, requests)
+	}
+
+	if err := c.concat(ctx, "/missing", []string{"/other"}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing file, got %v", err)
+	}
+}
+
+func TestHDFSWebSetSpaceQuota(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if _, ok := query["namespacequota"]; ok {
+			t.Errorf("Unexpected namespace quota %q", query.Get("namespacequota"))
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+query.Get("op")+" "+query.Get("storagespacequota"))
+	}))
+	defer server.Close()
+
+	c := newHDFSWebClient(server.URL, "minio", nil)
+	ctx := context.Background()
+	for _, quota := range []int64{1 << 30, -1, -5} {
+		if err := c.setSpaceQuota(ctx, "/bucket", quota); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{
+		"PUT /webhdfs/v1/bucket SETQUOTA 1073741824",
+		"PUT /webhdfs/v1/bucket SETQUOTA -1",
+		"PUT /webhdfs/v1/bucket SETQUOTA -1",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected %q, got %q", expected, requests)
+	}
+}
+
+func TestHDFSWebCreateOpen(t *testing.T) {
+	var requests []string
+	files := make(map[string][]byte)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if strings.HasPrefix(r.URL.Path, "/datanode") {
+			// The datanodes transfer the data.
+			name := strings.TrimPrefix(r.URL.Path, "/datanode")
+			requests = append(requests, r.Method+" datanode "+name+" "+query.Get("op"))
+			switch r.Method {
+			case http.MethodPut:
+				data, _ := ioutil.ReadAll(r.Body)
+				files[name] = data
+				w.WriteHeader(http.StatusCreated)
+			case http.MethodGet:
+				data, ok := files[name]
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprint(w, `{"RemoteException":{"javaClassName":"java.io.FileNotFoundException","message":"File does not exist"}}`)
+					return
+				}
+				offset, _ := strconv.Atoi(query.Get("offset"))
+				length, _ := strconv.Atoi(query.Get("length"))
+				w.Write(data[offset : offset+length])
+			}
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+query.Get("op")+" "+query.Get("noredirect")+" "+query.Get("ecpolicy"))
+		if query.Get("noredirect") == "true" {
+			// The namenode names the datanode, keeping the parameters.
+			fmt.Fprintf(w, `{"Location":"%s/datanode%s?%s"}`, server.URL, strings.TrimPrefix(r.URL.Path, "/webhdfs/v1"), r.URL.RawQuery)
+		}
+	}))
+	defer server.Close()
+
+	c := newHDFSWebClient(server.URL, "minio", nil)
+	ctx := context.Background()
+	if err := c.setECPolicy(ctx, "/.minio.sys/tmp/ec/RS-6-3-1024k", "RS-6-3-1024k"); err != nil {
+		t.Fatal(err)
+	}
+	data := "erasure coded object"
+	if err := c.create(ctx, "/.minio.sys/tmp/ec/RS-6-3-1024k/f", strings.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := c.open(ctx, "/.minio.sys/tmp/ec/RS-6-3-1024k/f", 8, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(got) != "coded" {
+		t.Errorf("Expected %q, got %q and %v", "coded", got, err)
+	}
+	if _, err = c.open(ctx, "/missing", 0, 1); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing file, got %v", err)
+	}
+
+	expected := []string{
+		"PUT /webhdfs/v1/.minio.sys/tmp/ec/RS-6-3-1024k SETECPOLICY  RS-6-3-1024k",
+		"PUT /webhdfs/v1/.minio.sys/tmp/ec/RS-6-3-1024k/f CREATE true ",
+		"PUT datanode /.minio.sys/tmp/ec/RS-6-3-1024k/f CREATE",
+		"GET /webhdfs/v1/.minio.sys/tmp/ec/RS-6-3-1024k/f OPEN true ",
+		"GET datanode /.minio.sys/tmp/ec/RS-6-3-1024k/f OPEN",
+		"GET /webhdfs/v1/missing OPEN true ",
+		"GET datanode /missing OPEN",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected %q, got %q", expected, requests)
+	}
+}
@@ -0,0 +1,307 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	ming "github.com/minio/ming/cmd"
+	"github.com/minio/minio-go/v7/pkg/tags"
+	minio "github.com/minio/minio/cmd"
+	xhttp "github.com/minio/minio/cmd/http"
+	"github.com/minio/minio/cmd/logger"
+)
+
+// The ETag, tags and metadata of objects are kept in extended attributes
+// of their files, in the user namespace so any HDFS user may read them.
+// User metadata and content headers are stored together in one attribute
+// as HDFS limits the number of attributes of a file, and so is the internal
+// metadata of encrypted and compressed objects. The gateway sets all of
+// them on the files it writes, empty when unused, so they are read back in
+// one call. Files written outside the gateway have none of them.
+
+const (
+	// Prefix of all the attributes set by the gateway.
+	hdfsXAttrPrefix = "user.minio."
+
+	// Attribute holding the ETag of the object.
+	hdfsETagXAttr = hdfsXAttrPrefix + "etag"
+
+	// Attribute holding the tags of the object, URL query encoded as in
+	// x-amz-tagging.
+	hdfsTagsXAttr = hdfsXAttrPrefix + "tags"
+
+	// Attribute holding the user metadata and content headers of the
+	// object, JSON encoded.
+	hdfsMetadataXAttr = hdfsXAttrPrefix + "metadata"
+
+	// Attribute holding the internal metadata of encrypted and compressed
+	// objects, JSON encoded.
+	hdfsInternalXAttr = hdfsXAttrPrefix + "internal"
+
+	// Attribute holding the layout of the parts of encrypted and
+	// compressed multipart objects, see ming.EncodePartLayout.
+	hdfsPartsXAttr = hdfsXAttrPrefix + "parts"
+)
+
+// hdfsObjectXAttrKeys are the attributes set on the files of objects.
+var hdfsObjectXAttrKeys = []string{hdfsETagXAttr, hdfsTagsXAttr, hdfsMetadataXAttr, hdfsInternalXAttr, hdfsPartsXAttr}
+
+// Maximum number of times the attributes of a file are listed again when
+// they change while being read.
+const hdfsXAttrRetries = 3
+
+// hdfsContentHeaders are the standard headers stored with an object.
+var hdfsContentHeaders = []string{
+	"Content-Type",
+	"Content-Encoding",
+	"Content-Disposition",
+	"Content-Language",
+	"Cache-Control",
+	"Expires",
+}
+
+// hdfsObjectXAttrs returns the attributes of an object written with the
+// metadata and the ETag.
+func hdfsObjectXAttrs(metadata map[string]string, etag string) (map[string]string, error) {
+	xattrs := make(map[string]string)
+	if etag != "" {
+		xattrs[hdfsETagXAttr] = etag
+	}
+
+	meta := make(map[string]string)
+	internal := make(map[string]string)
+	for k, v := range metadata {
+		if hdfsIsInternalMetadata(k) {
+			// The handlers look the internal keys up as they wrote them.
+			internal[k] = v
+			continue
+		}
+		k = http.CanonicalHeaderKey(k)
+		switch {
+		case strings.HasPrefix(k, "X-Amz-Meta-"):
+			meta[k] = v
+		case k == http.CanonicalHeaderKey(xhttp.AmzObjectTagging):
+			if v != "" {
+				xattrs[hdfsTagsXAttr] = v
+			}
+		default:
+			for _, h := range hdfsContentHeaders {
+				if k == h {
+					meta[k] = v
+				}
+			}
+		}
+	}
+	if len(meta) > 0 {
+		data, err := json.Marshal(meta)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[hdfsMetadataXAttr] = string(data)
+	}
+	if len(internal) > 0 {
+		data, err := json.Marshal(internal)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[hdfsInternalXAttr] = string(data)
+	}
+	return xattrs, nil
+}
+
+// setObjectXAttrs sets the attributes of the file, clearing the attributes
+// of the gateway it does not have.
+func (n *hdfsObjects) setObjectXAttrs(name string, xattrs map[string]string) error {
+	for _, k := range hdfsObjectXAttrKeys {
+		if err := n.clnt.SetXAttr(name, k, xattrs[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hdfsIsXAttrNotFound returns whether the error reports a missing
+// attribute, which the HDFS client does not export.
+func hdfsIsXAttrNotFound(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	return ok && pathErr.Err.Error() == "one or more keys not found"
+}
+
+// getObjectXAttrs returns the attributes of the gateway set on the file.
+// The attributes of the files written by the gateway are read at once,
+// otherwise listing the attributes returns their names and the values of
+// those of the gateway are read when there are any.
+func (n *hdfsObjects) getObjectXAttrs(name string) (map[string]string, error) {
+	keys := hdfsObjectXAttrKeys
+	for i := 0; ; i++ {
+		xattrs, err := n.clnt.GetXAttrs(name, keys...)
+		if err == nil {
+			return hdfsNonEmptyXAttrs(xattrs), nil
+		}
+		// Written outside the gateway or attributes removed meanwhile.
+		if !hdfsIsXAttrNotFound(err) || i == hdfsXAttrRetries {
+			return nil, err
+		}
+		all, err := n.clnt.ListXAttrs(name)
+		if err != nil {
+			return nil, err
+		}
+		if keys = hdfsGatewayXAttrKeys(all); len(keys) == 0 {
+			return nil, nil
+		}
+	}
+}
+
+// hdfsGatewayXAttrKeys returns the sorted names of the attributes of the
+// gateway among the attributes of a file.
+func hdfsGatewayXAttrKeys(xattrs map[string]string) []string {
+	var keys []string
+	for k := range xattrs {
+		if strings.HasPrefix(k, hdfsXAttrPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hdfsNonEmptyXAttrs returns the attributes without the empty ones, which
+// the gateway sets in place of missing attributes.
+func hdfsNonEmptyXAttrs(xattrs map[string]string) map[string]string {
+	for k, v := range xattrs {
+		if v == "" {
+			delete(xattrs, k)
+		}
+	}
+	return xattrs
+}
+
+// applyXAttrsToObjectInfo fills the ETag, tags, metadata and parts of the
+// object from the attributes of its file.
+func applyXAttrsToObjectInfo(ctx context.Context, xattrs map[string]string, objInfo *minio.ObjectInfo) {
+	objInfo.ETag = xattrs[hdfsETagXAttr]
+	objInfo.UserTags = xattrs[hdfsTagsXAttr]
+
+	if data, ok := xattrs[hdfsPartsXAttr]; ok {
+		parts, err := ming.DecodePartLayout(data, hdfsMaxPartID)
+		if err != nil {
+			logger.LogIf(ctx, err)
+		}
+		objInfo.Parts = parts
+	}
+
+	meta := make(map[string]string)
+	for _, k := range []string{hdfsMetadataXAttr, hdfsInternalXAttr} {
+		if data, ok := xattrs[k]; ok {
+			if err := json.Unmarshal([]byte(data), &meta); err != nil {
+				logger.LogIf(ctx, err)
+			}
+		}
+	}
+	if len(meta) == 0 {
+		return
+	}
+	if v, ok := meta["Expires"]; ok {
+		if expiry, err := time.Parse(http.TimeFormat, v); err == nil {
+			objInfo.Expires = expiry.UTC()
+		}
+		delete(meta, "Expires")
+	}
+	objInfo.ContentType = meta["Content-Type"]
+	objInfo.ContentEncoding = meta["Content-Encoding"]
+	objInfo.UserDefined = meta
+}
+
+// objectInfo returns the info of the object from the file info and the
+// attributes of its file.
+func (n *hdfsObjects) objectInfo(ctx context.Context, bucket, object string, fi os.FileInfo) (minio.ObjectInfo, error) {
+	return n.objectInfoAt(ctx, bucket, object, n.hdfsPathJoin(bucket, object), fi)
+}
+
+// objectInfoAt returns the info of the object from the info and the
+// attributes of the file at name, which may be a snapshot of the object.
+func (n *hdfsObjects) objectInfoAt(ctx context.Context, bucket, object, name string, fi os.FileInfo) (minio.ObjectInfo, error) {
+	objInfo := fileInfoToObjectInfo(bucket, object, fi)
+	objInfo.StorageClass = n.storageClass(fi)
+	xattrs, err := n.getObjectXAttrs(name)
+	if err != nil {
+		return objInfo, err
+	}
+	applyXAttrsToObjectInfo(ctx, xattrs, &objInfo)
+	return objInfo, nil
+}
+
+// updateObjectTags sets the tags of the object, clears them if tagStr is
+// empty.
+func (n *hdfsObjects) updateObjectTags(ctx context.Context, bucket, object, tagStr string) error {
+	name := n.hdfsPathJoin(bucket, object)
+	if err := n.clnt.SetXAttr(name, hdfsTagsXAttr, tagStr); err != nil {
+		return n.hdfsToObjectBucketErr(ctx, err, bucket, object)
+	}
+	return nil
+}
+
+// GetObjectTags returns the tags of the object.
+func (n *hdfsObjects) GetObjectTags(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (*tags.Tags, error) {
+	objInfo, err := n.GetObjectInfo(ctx, bucket, object, opts)
+	if err != nil {
+		return nil, err
+	}
+	return tags.ParseObjectTags(objInfo.UserTags)
+}
+
+// PutObjectTags replaces the tags of the object.
+func (n *hdfsObjects) PutObjectTags(ctx context.Context, bucket, object string, tagStr string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	n, err := n.forRequest(ctx, bucket)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	tagObj, err := tags.ParseObjectTags(tagStr)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	if err = n.updateObjectTags(ctx, bucket, object, tagObj.String()); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	return n.GetObjectInfo(ctx, bucket, object, opts)
+}
+
+// DeleteObjectTags removes the tags of the object.
+func (n *hdfsObjects) DeleteObjectTags(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	n, err := n.forRequest(ctx, bucket)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	if err = n.updateObjectTags(ctx, bucket, object, ""); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	return n.GetObjectInfo(ctx, bucket, object, opts)
+}
+
+// IsTaggingSupported returns whether object tagging is implemented for this layer.
+func (n *hdfsObjects) IsTaggingSupported() bool {
+	return true
+}
@@ -0,0 +1,137 @@
+// This file is part of MinIO Gateway
+// Copyright (c) 2021 MinIO, Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package hdfs
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	ming "github.com/minio/ming/cmd"
+	minio "github.com/minio/minio/cmd"
+)
+
+func TestHDFSObjectXAttrs(t *testing.T) {
+	expires := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		metadata    map[string]string
+		etag        string
+		keys        []string
+		tags        string
+		contentType string
+		expires     time.Time
+		userDefined map[string]string
+	}{
+		// Files without metadata have their ETag only.
+		{nil, "abc", []string{hdfsETagXAttr}, "", "", time.Time{}, nil},
+		{nil, "", nil, "", "", time.Time{}, nil},
+		// User metadata and content headers are stored together, other
+		// headers are dropped.
+		{
+			map[string]string{"content-type": "text/plain", "x-amz-meta-foo": "bar", "X-Amz-Storage-Class": "COLD", "Expires": expires.Format("Mon, 02 Jan 2006 15:04:05 GMT")},
+			"abc", []string{hdfsETagXAttr, hdfsMetadataXAttr}, "", "text/plain", expires,
+			map[string]string{"Content-Type": "text/plain", "X-Amz-Meta-Foo": "bar"},
+		},
+		// Tags have their own attribute, empty tags are not stored.
+		{map[string]string{"X-Amz-Tagging": "a=b&c=d"}, "abc", []string{hdfsETagXAttr, hdfsTagsXAttr}, "a=b&c=d", "", time.Time{}, nil},
+		{map[string]string{"X-Amz-Tagging": ""}, "abc", []string{hdfsETagXAttr}, "", "", time.Time{}, nil},
+		// Internal metadata keeps its keys as the handlers wrote them.
+		{
+			map[string]string{minio.ReservedMetadataPrefix + "compression": "klauspost/compress/s2", "x-amz-meta-foo": "bar"},
+			"abc", []string{hdfsETagXAttr, hdfsInternalXAttr, hdfsMetadataXAttr}, "", "", time.Time{},
+			map[string]string{minio.ReservedMetadataPrefix + "compression": "klauspost/compress/s2", "X-Amz-Meta-Foo": "bar"},
+		},
+	}
+	for i, testCase := range testCases {
+		xattrs, err := hdfsObjectXAttrs(testCase.metadata, testCase.etag)
+		if err != nil {
+			t.Fatalf("Test %d: %v", i+1, err)
+		}
+		if keys := hdfsGatewayXAttrKeys(xattrs); !reflect.DeepEqual(keys, testCase.keys) {
+			t.Errorf("Test %d: Expected attributes %v, got %v", i+1, testCase.keys, keys)
+		}
+
+		var objInfo minio.ObjectInfo
+		applyXAttrsToObjectInfo(context.Background(), xattrs, &objInfo)
+		if objInfo.ETag != testCase.etag || objInfo.UserTags != testCase.tags {
+			t.Errorf("Test %d: Expected ETag %q and tags %q, got %q and %q", i+1, testCase.etag, testCase.tags, objInfo.ETag, objInfo.UserTags)
+		}
+		if objInfo.ContentType != testCase.contentType || !objInfo.Expires.Equal(testCase.expires) {
+			t.Errorf("Test %d: Expected content type %q and expiry %s, got %q and %s", i+1,
+				testCase.contentType, testCase.expires, objInfo.ContentType, objInfo.Expires)
+		}
+		if !reflect.DeepEqual(objInfo.UserDefined, testCase.userDefined) {
+			t.Errorf("Test %d: Expected metadata %v, got %v", i+1, testCase.userDefined, objInfo.UserDefined)
+		}
+	}
+}
+
+func TestHDFSObjectXAttrsParts(t *testing.T) {
+	parts := []minio.ObjectPartInfo{
+		{Number: 1, Size: 10, ActualSize: 8},
+		{Number: 2, Size: 10, ActualSize: 8},
+		{Number: 3, Size: 4, ActualSize: 2},
+	}
+	xattrs, err := hdfsObjectXAttrs(nil, "abc-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xattrs[hdfsPartsXAttr] = ming.EncodePartLayout(parts)
+
+	var objInfo minio.ObjectInfo
+	applyXAttrsToObjectInfo(context.Background(), xattrs, &objInfo)
+	if len(objInfo.Parts) != len(parts) {
+		t.Fatalf("Expected %d parts, got %v", len(parts), objInfo.Parts)
+	}
+	for i, part := range objInfo.Parts {
+		if part.Number != parts[i].Number || part.Size != parts[i].Size || part.ActualSize != parts[i].ActualSize {
+			t.Errorf("Part %d: Expected %+v, got %+v", i+1, parts[i], part)
+		}
+	}
+}
+
+func TestHDFSNonEmptyXAttrs(t *testing.T) {
+	// Attributes as the gateway sets them on the files it writes.
+	xattrs := make(map[string]string)
+	for _, k := range hdfsObjectXAttrKeys {
+		xattrs[k] = ""
+	}
+	xattrs[hdfsETagXAttr] = "abc"
+	xattrs[hdfsTagsXAttr] = "a=b"
+
+	expected := map[string]string{hdfsETagXAttr: "abc", hdfsTagsXAttr: "a=b"}
+	if got := hdfsNonEmptyXAttrs(xattrs); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestHDFSGatewayXAttrKeys(t *testing.T) {
+	xattrs := map[string]string{
+		hdfsTagsXAttr:         "",
+		hdfsETagXAttr:         "",
+		"user.other":          "",
+		"trusted.minio.etag":  "",
+		"user.minio":          "",
+		hdfsTrashedXAttr:      "",
+		"system.hdfs.erasure": "",
+	}
+	expected := []string{hdfsETagXAttr, hdfsTagsXAttr, hdfsTrashedXAttr}
+	if keys := hdfsGatewayXAttrKeys(xattrs); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+}
@@ -28,11 +28,13 @@ import (
 	"path"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/colinmarc/hdfs/v2"
 	"github.com/colinmarc/hdfs/v2/hadoopconf"
+	"github.com/gorilla/mux"
 	krb "github.com/jcmturner/gokrb5/v8/client"
 	"github.com/jcmturner/gokrb5/v8/config"
 	"github.com/jcmturner/gokrb5/v8/credentials"
@@ -41,6 +43,9 @@ import (
 	ming "github.com/minio/ming/cmd"
 	"github.com/minio/minio-go/v7/pkg/s3utils"
 	minio "github.com/minio/minio/cmd"
+	xconfig "github.com/minio/minio/cmd/config"
+	"github.com/minio/minio/cmd/config/storageclass"
+	xhttp "github.com/minio/minio/cmd/http"
 	"github.com/minio/minio/cmd/logger"
 	"github.com/minio/minio/pkg/auth"
 	"github.com/minio/minio/pkg/env"
@@ -63,7 +68,7 @@ FLAGS:
   {{range .VisibleFlags}}{{.}}
   {{end}}{{end}}
 HDFS-NAMENODE:
-  HDFS namenode URI
+  HDFS namenode or nameservice URI, or viewfs://<table>/ to serve the buckets of a ViewFS mount table
 
 EXAMPLES:
   1. Start ming server for HDFS backend
@@ -71,7 +76,12 @@ EXAMPLES:
      {{.Prompt}} {{.EnvVarSetCommand}} MINIO_ROOT_PASSWORD{{.AssignmentOperator}}secretkey
      {{.Prompt}} {{.HelpName}} hdfs://namenode:8200
 
-  2. Start ming server for HDFS with edge caching enabled
+  2. Start ming server for the buckets of the default ViewFS mount table
+     {{.Prompt}} {{.EnvVarSetCommand}} MINIO_ROOT_USER{{.AssignmentOperator}}accesskey
+     {{.Prompt}} {{.EnvVarSetCommand}} MINIO_ROOT_PASSWORD{{.AssignmentOperator}}secretkey
+     {{.Prompt}} {{.HelpName}} viewfs:///
+
+  3. Start ming server for HDFS with edge caching enabled
      {{.Prompt}} {{.EnvVarSetCommand}} MINIO_ROOT_USER{{.AssignmentOperator}}accesskey
      {{.Prompt}} {{.EnvVarSetCommand}} MINIO_ROOT_PASSWORD{{.AssignmentOperator}}secretkey
      {{.Prompt}} {{.EnvVarSetCommand}} MINIO_CACHE_DRIVES{{.AssignmentOperator}}"/mnt/drive1,/mnt/drive2,/mnt/drive3,/mnt/drive4"
@@ -104,7 +114,8 @@ func hdfsGatewayMain(ctx *cli.Context) {
 
 // HDFS implements Gateway.
 type HDFS struct {
-	args []string
+	args  []string
+	layer atomic.Value // *hdfsObjects, set once the gateway layer is initialized
 }
 
 // Name implements Gateway interface.
@@ -112,7 +123,10 @@ func (g *HDFS) Name() string {
 	return ming.HDFSBackendGateway
 }
 
-func getKerberosClient() (*krb.Client, error) {
+// getKerberosClient returns the Kerberos client of the principal, logged
+// in with KRB5KEYTAB, or of KRB5USERNAME and KRB5REALM or the credentials
+// cache if no principal is given.
+func getKerberosClient(principal string) (*krb.Client, error) {
 	cfg, err := config.Load(env.Get("KRB5_CONFIG", "/etc/krb5.conf"))
 	if err != nil {
 		return nil, err
@@ -124,6 +138,9 @@ func getKerberosClient() (*krb.Client, error) {
 	}
 
 	keytabPath := env.Get("KRB5KEYTAB", "")
+	if principal != "" && keytabPath == "" {
+		return nil, fmt.Errorf("kerberos principal %s requires KRB5KEYTAB", principal)
+	}
 	if keytabPath != "" {
 		kt, err := keytab.Load(keytabPath)
 		if err != nil {
@@ -132,6 +149,13 @@ func getKerberosClient() (*krb.Client, error) {
 
 		username := env.Get("KRB5USERNAME", "")
 		realm := env.Get("KRB5REALM", "")
+		if principal != "" {
+			i := strings.LastIndex(principal, "@")
+			if i <= 0 || i == len(principal)-1 {
+				return nil, fmt.Errorf("invalid kerberos principal %q, expected user@REALM", principal)
+			}
+			username, realm = principal[:i], principal[i+1:]
+		}
 		if username == "" || realm == "" {
 			return nil, errors.New("empty KRB5USERNAME or KRB5REALM")
 
@@ -158,70 +182,236 @@ func getKerberosClient() (*krb.Client, error) {
 	return krb.NewFromCCache(ccache, cfg)
 }
 
+// hdfsConfig is the configuration shared by the namespaces of the gateway.
+type hdfsConfig struct {
+	rootAccessKey  string
+	storageClasses map[string]string // storage class to HDFS policy
+	impersonate    bool
+	users          map[string]string // access key to HDFS user
+	anonymousUser  string            // HDFS user of anonymous requests
+	userClients    int               // maximum number of clients of users
+	trash          string
+	trashRetention time.Duration
+}
+
+// loadHDFSConfig loads the configuration shared by the namespaces of the
+// gateway from the environment.
+func loadHDFSConfig(creds auth.Credentials) (cfg hdfsConfig, err error) {
+	cfg.rootAccessKey = creds.AccessKey
+	cfg.storageClasses, err = parseHDFSStorageClasses(env.Get("MINIO_HDFS_STORAGE_CLASSES", ""))
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.impersonate, err = xconfig.ParseBool(env.Get("MINIO_HDFS_IMPERSONATION", xconfig.EnableOff))
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.impersonate {
+		if cfg.users, err = parseHDFSUsers(env.Get("MINIO_HDFS_USERS", "")); err != nil {
+			return cfg, err
+		}
+		cfg.anonymousUser = env.Get("MINIO_HDFS_ANONYMOUS_USER", "")
+		cfg.userClients, err = env.GetInt("MINIO_HDFS_USER_CLIENTS", hdfsDefaultUserClients)
+		if err != nil || cfg.userClients < 1 {
+			return cfg, fmt.Errorf("invalid number of user clients %q", env.Get("MINIO_HDFS_USER_CLIENTS", ""))
+		}
+	}
+
+	if cfg.trash, err = parseHDFSTrash(env.Get("MINIO_HDFS_TRASH", hdfsTrashOff)); err != nil {
+		return cfg, err
+	}
+	cfg.trashRetention = hdfsTrashDefaultRetention
+	if v := env.Get("MINIO_HDFS_TRASH_RETENTION", ""); v != "" {
+		if cfg.trashRetention, err = time.ParseDuration(v); err != nil || cfg.trashRetention <= 0 {
+			return cfg, fmt.Errorf("invalid trash retention %q", v)
+		}
+	}
+	return cfg, nil
+}
+
+// hdfsDialFunc dials namenodes and datanodes.
+var hdfsDialFunc = (&net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+	DualStack: true,
+}).DialContext
+
 // NewGatewayLayer returns hdfs gatewaylayer.
 func (g *HDFS) NewGatewayLayer(creds auth.Credentials) (minio.ObjectLayer, error) {
-	dialFunc := (&net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
-		DualStack: true,
-	}).DialContext
-
 	hconfig, err := hadoopconf.LoadFromEnvironment()
 	if err != nil {
 		return nil, err
 	}
+	cfg, err := loadHDFSConfig(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without namenodes a ViewFS default filesystem is served as such.
+	args := g.args
+	if defaultFS := hconfig["fs.defaultFS"]; len(args) == 0 && strings.HasPrefix(defaultFS, "viewfs://") {
+		args = []string{defaultFS}
+	}
+	table, err := hdfsViewFSTable(args)
+	if err != nil {
+		return nil, err
+	}
+	if table != "" {
+		n, err := newHDFSMounts(hconfig, table, cfg)
+		if err != nil {
+			return nil, err
+		}
+		g.layer.Store(n)
+		return n, nil
+	}
 
 	opts := hdfs.ClientOptionsFromConf(hconfig)
-	opts.NamenodeDialFunc = dialFunc
-	opts.DatanodeDialFunc = dialFunc
-
-	// Not addresses found, load it from command line.
-	var commonPath string
-	if len(opts.Addresses) == 0 {
-		var addresses []string
-		for _, s := range g.args {
-			u, err := xnet.ParseURL(s)
-			if err != nil {
-				return nil, err
-			}
-			if u.Scheme != "hdfs" {
-				return nil, fmt.Errorf("unsupported scheme %s, only supports hdfs://", u)
-			}
-			if commonPath != "" && commonPath != u.Path {
-				return nil, fmt.Errorf("all namenode paths should be same %s", g.args)
-			}
-			if commonPath == "" {
-				commonPath = u.Path
+	opts.NamenodeDialFunc = hdfsDialFunc
+	opts.DatanodeDialFunc = hdfsDialFunc
+
+	// Namenodes of a nameservice of the configuration given by its ID,
+	// else those of the configuration, else those given on the command
+	// line with their common path.
+	var commonPath, subPath string
+	var addresses []string
+	var nameservice bool
+	for _, s := range g.args {
+		u, err := xnet.ParseURL(s)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "hdfs" {
+			return nil, fmt.Errorf("unsupported scheme %s, only supports hdfs:// and viewfs://", u)
+		}
+		if commonPath != "" && commonPath != u.Path {
+			return nil, fmt.Errorf("all namenode paths should be same %s", g.args)
+		}
+		if commonPath == "" {
+			commonPath = u.Path
+		}
+		if nns := hdfsNameserviceNamenodes(hconfig, u.Host); nns != nil {
+			addresses = append(addresses, nns...)
+			nameservice = true
+			if spn := hdfsNamenodePrincipal(hconfig, u.Host); spn != "" {
+				opts.KerberosServicePrincipleName = spn
 			}
+		} else {
 			addresses = append(addresses, u.Host)
 		}
+	}
+	if nameservice || len(opts.Addresses) == 0 {
 		opts.Addresses = addresses
+		subPath = commonPath
+	}
+
+	opts, err = hdfsClientUser(opts, "")
+	if err != nil {
+		return nil, err
 	}
 
+	endpoint := hconfig["dfs.namenode.http-address"]
+	if len(g.args) > 0 {
+		if u, err := xnet.ParseURL(g.args[0]); err == nil {
+			if e := hdfsNameserviceWebEndpoint(hconfig, u.Host); e != "" {
+				endpoint = e
+			}
+		}
+	}
+	n, err := newHDFSObjects(opts, subPath, "", env.Get("MINIO_HDFS_WEBHDFS_ENDPOINT", endpoint), cfg)
+	if err != nil {
+		return nil, err
+	}
+	g.layer.Store(n)
+	return n, nil
+}
+
+// hdfsClientUser sets the user of the client options, the Kerberos
+// principal if Kerberos is enabled, else HADOOP_USER_NAME or the local
+// user. The principal is that of the Kerberos credentials cache or
+// KRB5USERNAME, unless given.
+func hdfsClientUser(opts hdfs.ClientOptions, principal string) (hdfs.ClientOptions, error) {
 	u, err := user.Current()
 	if err != nil {
-		return nil, fmt.Errorf("unable to lookup local user: %s", err)
+		return opts, fmt.Errorf("unable to lookup local user: %s", err)
 	}
 
 	if opts.KerberosClient != nil {
-		opts.KerberosClient, err = getKerberosClient()
+		opts.KerberosClient, err = getKerberosClient(principal)
 		if err != nil {
-			return nil, fmt.Errorf("unable to initialize kerberos client: %s", err)
+			return opts, fmt.Errorf("unable to initialize kerberos client: %s", err)
 		}
 	} else {
 		opts.User = env.Get("HADOOP_USER_NAME", u.Username)
 	}
+	return opts, nil
+}
 
+// newHDFSObjects returns the layer of the namespace below root of the
+// namenodes of the client options, the directories of buckets. Given a
+// link, root is the directory of the bucket of that name.
+func newHDFSObjects(opts hdfs.ClientOptions, root, link, webEndpoint string, cfg hdfsConfig) (_ *hdfsObjects, err error) {
 	clnt, err := hdfs.NewClient(opts)
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize hdfsClient: %v", err)
 	}
+	defer func() {
+		if err != nil {
+			clnt.Close()
+		}
+	}()
 
-	if err = clnt.MkdirAll(minio.PathJoin(commonPath, hdfsSeparator, minioMetaTmpBucket), os.FileMode(0755)); err != nil {
-		return nil, err
+	n := &hdfsObjects{
+		clnt:           clnt,
+		subPath:        root,
+		listPool:       newHDFSListPool(hdfsListTimeout),
+		disks:          newHDFSDiskCache(hdfsBucketDiskTTL),
+		storageClasses: cfg.storageClasses,
+		trash:          cfg.trash,
+		trashRetention: cfg.trashRetention,
+	}
+	if link != "" {
+		n.bucket = link
+		n.subPath = path.Dir(root)
+		n.bucketDir = path.Base(root)
+	}
+
+	if webEndpoint != "" {
+		n.web = newHDFSWebClient(webEndpoint, opts.User, opts.KerberosClient)
+	}
+	for _, policy := range n.storageClasses {
+		if hdfsIsStoragePolicy(policy) && n.web == nil {
+			return nil, errors.New("storage policies are set through WebHDFS, MINIO_HDFS_WEBHDFS_ENDPOINT is required")
+		}
 	}
 
-	return &hdfsObjects{clnt: clnt, subPath: commonPath, listPool: minio.NewTreeWalkPool(time.Minute * 30)}, nil
+	if cfg.impersonate {
+		n.users = newHDFSUsers(opts, cfg, n)
+	}
+
+	// Files of all users are written to the tmp and multipart directories.
+	metaDirs := []string{minioMetaTmpBucket, minioMetaMultipartBucket}
+	if n.trash == hdfsTrashBucket {
+		metaDirs = append(metaDirs, minioMetaTrashBucket)
+	}
+	for _, dir := range metaDirs {
+		if err = clnt.MkdirAll(n.hdfsPathJoin(dir), n.metaDirMode()); err != nil {
+			return nil, err
+		}
+		if n.users == nil {
+			continue
+		}
+		if err = clnt.Chmod(n.hdfsPathJoin(dir), hdfsSharedDirMode); err != nil {
+			return nil, err
+		}
+	}
+
+	if n.trash == hdfsTrashBucket {
+		var ctx context.Context
+		ctx, n.cancel = context.WithCancel(minio.GlobalContext)
+		go n.purgeTrashes(ctx)
+	}
+	return n, nil
 }
 
 // Production - hdfs gateway is production ready.
@@ -229,7 +419,26 @@ func (g *HDFS) Production() bool {
 	return true
 }
 
+// RegisterAdminRouter registers the snapshot, quota, storage class, trash
+// and mount admin APIs.
+func (g *HDFS) RegisterAdminRouter(router *mux.Router) {
+	g.registerSnapshotRouter(router)
+	g.registerQuotaRouter(router)
+	g.registerStorageClassRouter(router)
+	g.registerTrashRouter(router)
+	g.registerMountRouter(router)
+}
+
 func (n *hdfsObjects) Shutdown(ctx context.Context) error {
+	if n.mounts != nil {
+		return n.mounts.close(ctx)
+	}
+	if n.cancel != nil {
+		n.cancel()
+	}
+	if n.users != nil {
+		n.users.close()
+	}
 	return n.clnt.Close()
 }
 
@@ -238,16 +447,37 @@ func (n *hdfsObjects) LocalStorageInfo(ctx context.Context) (si minio.StorageInf
 }
 
 func (n *hdfsObjects) StorageInfo(ctx context.Context) (si minio.StorageInfo, errs []error) {
+	if n.mounts != nil {
+		return n.mounts.storageInfo(ctx)
+	}
 	fsInfo, err := n.clnt.StatFs()
 	if err != nil {
 		return minio.StorageInfo{}, []error{err}
 	}
 	si.Disks = []madmin.Disk{{
-		UsedSpace: fsInfo.Used,
+		DrivePath:      n.hdfsPathJoin(),
+		State:          madmin.DriveStateOk,
+		TotalSpace:     fsInfo.Capacity,
+		UsedSpace:      fsInfo.Used,
+		AvailableSpace: fsInfo.Remaining,
 	}}
+
+	// Buckets follow the filesystem, with their usage and quotas.
+	buckets, err := n.ListBuckets(ctx)
+	if err != nil {
+		return si, []error{err}
+	}
+	for _, bucket := range buckets {
+		disk, err := n.disks.get(bucket.Name, time.Now(), n.bucketDisk)
+		if err != nil {
+			errs = append(errs, hdfsToObjectErr(ctx, err, bucket.Name))
+			continue
+		}
+		si.Disks = append(si.Disks, disk)
+	}
 	si.Backend.Type = madmin.Gateway
 	si.Backend.GatewayOnline = true
-	return si, nil
+	return si, errs
 }
 
 // hdfsObjects implements gateway for Minio and S3 compatible object storage servers.
@@ -255,7 +485,21 @@ type hdfsObjects struct {
 	minio.ObjectLayerUnsupported
 	clnt     *hdfs.Client
 	subPath  string
-	listPool *minio.TreeWalkPool
+	listPool *hdfsListPool
+	disks    *hdfsDiskCache // drives of the buckets reported by StorageInfo
+	users    *hdfsUsers     // nil unless impersonating users
+
+	mounts    *hdfsMounts // nil unless serving a mount table
+	bucket    string      // bucket of a link mount, empty for a namespace of buckets
+	bucketDir string      // directory of the bucket of a link mount below subPath
+
+	web            *hdfsWebClient    // nil without a WebHDFS endpoint
+	storageClasses map[string]string // storage class to HDFS policy
+
+	trash          string // trash mode, off, user or bucket
+	trashRetention time.Duration
+
+	cancel context.CancelFunc // stops the trash purge, nil without one
 }
 
 func hdfsToObjectErr(ctx context.Context, err error, params ...string) error {
@@ -292,6 +536,10 @@ func hdfsToObjectErr(ctx context.Context, err error, params ...string) error {
 			return minio.PrefixAccessDenied{Bucket: bucket, Object: object}
 		}
 		return minio.BucketAlreadyOwnedByYou{Bucket: bucket}
+	case os.IsPermission(err):
+		return minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	case hdfsIsQuotaExceeded(err):
+		return minio.BucketQuotaExceeded{Bucket: bucket}
 	case errors.Is(err, syscall.ENOTEMPTY):
 		if object != "" {
 			return minio.PrefixAccessDenied{Bucket: bucket, Object: object}
@@ -303,26 +551,79 @@ func hdfsToObjectErr(ctx context.Context, err error, params ...string) error {
 	}
 }
 
+// hdfsToObjectBucketErr converts the error of a call on an object or an
+// upload of the bucket like hdfsToObjectErr. The bucket is only looked up
+// when the call failed on a missing path, which saves a Stat of the bucket
+// on every successful call.
+func (n *hdfsObjects) hdfsToObjectBucketErr(ctx context.Context, err error, bucket string, params ...string) error {
+	if os.IsNotExist(err) {
+		if _, serr := n.clnt.Stat(n.hdfsPathJoin(bucket)); os.IsNotExist(serr) {
+			return minio.BucketNotFound{Bucket: bucket}
+		}
+	}
+	return hdfsToObjectErr(ctx, err, append([]string{bucket}, params...)...)
+}
+
+// renameToObject renames src to the path of an object of the bucket. The
+// parent directories of the object are created when the rename fails for
+// their lack, only inside an existing bucket.
+func (n *hdfsObjects) renameToObject(bucket, src, dst string) error {
+	err := n.clnt.Rename(src, dst)
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if _, err = n.clnt.Stat(n.hdfsPathJoin(bucket)); err != nil {
+		return err
+	}
+	if err = n.clnt.MkdirAll(path.Dir(dst), os.FileMode(0755)); err != nil {
+		return err
+	}
+	return n.clnt.Rename(src, dst)
+}
+
 // hdfsIsValidBucketName verifies whether a bucket name is valid.
 func hdfsIsValidBucketName(bucket string) bool {
 	return s3utils.CheckValidBucketNameStrict(bucket) == nil
 }
 
 func (n *hdfsObjects) hdfsPathJoin(args ...string) string {
+	if n.bucket != "" && len(args) > 0 && args[0] == n.bucket {
+		args = append([]string{n.bucketDir}, args[1:]...)
+	}
 	return minio.PathJoin(append([]string{n.subPath, hdfsSeparator}, args...)...)
 }
 
 func (n *hdfsObjects) DeleteBucket(ctx context.Context, bucket string, forceDelete bool) error {
+	n, err := n.forRequest(ctx, bucket)
+	if err != nil {
+		return err
+	}
+
 	if !hdfsIsValidBucketName(bucket) {
 		return minio.BucketNameInvalid{Bucket: bucket}
 	}
 	if forceDelete {
-		return hdfsToObjectErr(ctx, n.clnt.RemoveAll(n.hdfsPathJoin(bucket)), bucket)
+		err = n.clnt.RemoveAll(n.hdfsPathJoin(bucket))
+	} else {
+		err = n.clnt.Remove(n.hdfsPathJoin(bucket))
 	}
-	return hdfsToObjectErr(ctx, n.clnt.Remove(n.hdfsPathJoin(bucket)), bucket)
+	if err != nil {
+		return hdfsToObjectErr(ctx, err, bucket)
+	}
+	n.disks.invalidate(bucket)
+	// Uploads of the bucket must not outlive it.
+	if err = n.clnt.RemoveAll(n.hdfsPathJoin(minioMetaMultipartBucket, bucket)); err != nil && !os.IsNotExist(err) {
+		logger.LogIf(ctx, err)
+	}
+	return nil
 }
 
 func (n *hdfsObjects) MakeBucketWithLocation(ctx context.Context, bucket string, opts minio.BucketOptions) error {
+	n, err := n.forRequest(ctx, bucket)
+	if err != nil {
+		return err
+	}
+
 	if opts.LockEnabled || opts.VersioningEnabled {
 		return minio.NotImplemented{}
 	}
@@ -330,10 +631,23 @@ func (n *hdfsObjects) MakeBucketWithLocation(ctx context.Context, bucket string,
 	if !hdfsIsValidBucketName(bucket) {
 		return minio.BucketNameInvalid{Bucket: bucket}
 	}
-	return hdfsToObjectErr(ctx, n.clnt.Mkdir(n.hdfsPathJoin(bucket), os.FileMode(0755)), bucket)
+	if err = n.clnt.Mkdir(n.hdfsPathJoin(bucket), os.FileMode(0755)); err != nil {
+		return hdfsToObjectErr(ctx, err, bucket)
+	}
+	// Objects of the STANDARD class inherit the policy of the bucket.
+	if policy := n.storageClasses[storageclass.STANDARD]; policy != "" {
+		if err = n.setStoragePolicy(ctx, n.hdfsPathJoin(bucket), policy); err != nil {
+			return hdfsToObjectErr(ctx, err, bucket)
+		}
+	}
+	return nil
 }
 
 func (n *hdfsObjects) GetBucketInfo(ctx context.Context, bucket string) (bi minio.BucketInfo, err error) {
+	if n, err = n.forRequest(ctx, bucket); err != nil {
+		return bi, err
+	}
+
 	fi, err := n.clnt.Stat(n.hdfsPathJoin(bucket))
 	if err != nil {
 		return bi, hdfsToObjectErr(ctx, err, bucket)
@@ -346,6 +660,25 @@ func (n *hdfsObjects) GetBucketInfo(ctx context.Context, bucket string) (bi mini
 }
 
 func (n *hdfsObjects) ListBuckets(ctx context.Context) (buckets []minio.BucketInfo, err error) {
+	if n.mounts != nil {
+		return n.mounts.listBuckets(ctx)
+	}
+	if n, err = n.forRequest(ctx, ""); err != nil {
+		return nil, err
+	}
+
+	// The directory of a link mount is its only bucket.
+	if n.bucket != "" {
+		fi, err := n.clnt.Stat(n.hdfsPathJoin(n.bucket))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, hdfsToObjectErr(ctx, err, n.bucket)
+		}
+		return []minio.BucketInfo{{Name: n.bucket, Created: fi.ModTime()}}, nil
+	}
+
 	entries, err := n.clnt.ReadDir(n.hdfsPathJoin())
 	if err != nil {
 		logger.LogIf(ctx, err)
@@ -369,104 +702,6 @@ func (n *hdfsObjects) ListBuckets(ctx context.Context) (buckets []minio.BucketIn
 	return buckets, nil
 }
 
-func (n *hdfsObjects) isLeafDir(bucket, leafPath string) bool {
-	return n.isObjectDir(context.Background(), bucket, leafPath)
-}
-
-func (n *hdfsObjects) isLeaf(bucket, leafPath string) bool {
-	return !strings.HasSuffix(leafPath, hdfsSeparator)
-}
-
-func (n *hdfsObjects) listDirFactory() minio.ListDirFunc {
-	// listDir - lists all the entries at a given prefix and given entry in the prefix.
-	listDir := func(bucket, prefixDir, prefixEntry string) (emptyDir bool, entries []string, delayIsLeaf bool) {
-		f, err := n.clnt.Open(n.hdfsPathJoin(bucket, prefixDir))
-		if err != nil {
-			if os.IsNotExist(err) {
-				err = nil
-			}
-			logger.LogIf(minio.GlobalContext, err)
-			return
-		}
-		defer f.Close()
-		fis, err := f.Readdir(0)
-		if err != nil {
-			logger.LogIf(minio.GlobalContext, err)
-			return
-		}
-		if len(fis) == 0 {
-			return true, nil, false
-		}
-		for _, fi := range fis {
-			if fi.IsDir() {
-				entries = append(entries, fi.Name()+hdfsSeparator)
-			} else {
-				entries = append(entries, fi.Name())
-			}
-		}
-		entries, delayIsLeaf = minio.FilterListEntries(bucket, prefixDir, entries, prefixEntry, n.isLeaf)
-		return false, entries, delayIsLeaf
-	}
-
-	// Return list factory instance.
-	return listDir
-}
-
-// ListObjects lists all blobs in HDFS bucket filtered by prefix.
-func (n *hdfsObjects) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (loi minio.ListObjectsInfo, err error) {
-	fileInfos := make(map[string]os.FileInfo)
-	targetPath := n.hdfsPathJoin(bucket, prefix)
-
-	var targetFileInfo os.FileInfo
-
-	if targetFileInfo, err = n.populateDirectoryListing(targetPath, fileInfos); err != nil {
-		return loi, hdfsToObjectErr(ctx, err, bucket)
-	}
-
-	// If the user is trying to list a single file, bypass the entire directory-walking code below
-	// and just return the single file's information.
-	if !targetFileInfo.IsDir() {
-		return minio.ListObjectsInfo{
-			IsTruncated: false,
-			NextMarker:  "",
-			Objects: []minio.ObjectInfo{
-				fileInfoToObjectInfo(bucket, prefix, targetFileInfo),
-			},
-			Prefixes: []string{},
-		}, nil
-	}
-
-	getObjectInfo := func(ctx context.Context, bucket, entry string) (minio.ObjectInfo, error) {
-		filePath := path.Clean(n.hdfsPathJoin(bucket, entry))
-		fi, ok := fileInfos[filePath]
-
-		// If the file info is not known, this may be a recursive listing and filePath is a
-		// child of a sub-directory. In this case, obtain that sub-directory's listing.
-		if !ok {
-			parentPath := path.Dir(filePath)
-
-			if _, err := n.populateDirectoryListing(parentPath, fileInfos); err != nil {
-				return minio.ObjectInfo{}, hdfsToObjectErr(ctx, err, bucket)
-			}
-
-			fi, ok = fileInfos[filePath]
-
-			if !ok {
-				err = fmt.Errorf("could not get FileInfo for path '%s'", filePath)
-				return minio.ObjectInfo{}, hdfsToObjectErr(ctx, err, bucket, entry)
-			}
-		}
-
-		objectInfo := fileInfoToObjectInfo(bucket, entry, fi)
-
-		delete(fileInfos, filePath)
-
-		return objectInfo, nil
-	}
-
-	return minio.ListObjects(ctx, n, bucket, prefix, marker, delimiter, maxKeys, n.listPool, n.listDirFactory(), n.isLeaf, n.isLeafDir, getObjectInfo, getObjectInfo)
-}
-
 func fileInfoToObjectInfo(bucket string, entry string, fi os.FileInfo) minio.ObjectInfo {
 	return minio.ObjectInfo{
 		Bucket:  bucket,
@@ -478,37 +713,6 @@ func fileInfoToObjectInfo(bucket string, entry string, fi os.FileInfo) minio.Obj
 	}
 }
 
-// Lists a path's direct, first-level entries and populates them in the `fileInfos` cache which maps
-// a path entry to an `os.FileInfo`. It also saves the listed path's `os.FileInfo` in the cache.
-func (n *hdfsObjects) populateDirectoryListing(filePath string, fileInfos map[string]os.FileInfo) (os.FileInfo, error) {
-	dirReader, err := n.clnt.Open(filePath)
-
-	if err != nil {
-		return nil, err
-	}
-
-	dirStat := dirReader.Stat()
-	key := path.Clean(filePath)
-
-	if !dirStat.IsDir() {
-		return dirStat, nil
-	}
-
-	fileInfos[key] = dirStat
-	infos, err := dirReader.Readdir(0)
-
-	if err != nil {
-		return nil, err
-	}
-
-	for _, fileInfo := range infos {
-		filePath := minio.PathJoin(filePath, fileInfo.Name())
-		fileInfos[filePath] = fileInfo
-	}
-
-	return dirStat, nil
-}
-
 // deleteObject deletes a file path if its empty. If it's successfully deleted,
 // it will recursively move up the tree, deleting empty parent directories
 // until it finds one with files in it. Returns nil for a non-empty directory.
@@ -561,7 +765,22 @@ func (n *hdfsObjects) ListObjectsV2(ctx context.Context, bucket, prefix, continu
 }
 
 func (n *hdfsObjects) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
-	err := hdfsToObjectErr(ctx, n.deleteObject(n.hdfsPathJoin(bucket), n.hdfsPathJoin(bucket, object)), bucket, object)
+	n, err := n.forRequest(ctx, bucket)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	// Versions in snapshots are read-only.
+	if isHDFSVersioned(opts.VersionID) {
+		return minio.ObjectInfo{}, minio.PrefixAccessDenied{Bucket: bucket, Object: object}
+	}
+
+	if n.trash != hdfsTrashOff {
+		err = n.trashObject(ctx, bucket, object)
+	} else {
+		err = n.deleteObject(n.hdfsPathJoin(bucket), n.hdfsPathJoin(bucket, object))
+	}
+	err = hdfsToObjectErr(ctx, err, bucket, object)
 	return minio.ObjectInfo{
 		Bucket: bucket,
 		Name:   object,
@@ -572,10 +791,12 @@ func (n *hdfsObjects) DeleteObjects(ctx context.Context, bucket string, objects
 	errs := make([]error, len(objects))
 	dobjects := make([]minio.DeletedObject, len(objects))
 	for idx, object := range objects {
+		opts.VersionID = object.VersionID
 		_, errs[idx] = n.DeleteObject(ctx, bucket, object.ObjectName, opts)
 		if errs[idx] == nil {
 			dobjects[idx] = minio.DeletedObject{
 				ObjectName: object.ObjectName,
+				VersionID:  object.VersionID,
 			}
 		}
 	}
@@ -583,13 +804,18 @@ func (n *hdfsObjects) DeleteObjects(ctx context.Context, bucket string, objects
 }
 
 func (n *hdfsObjects) GetObjectNInfo(ctx context.Context, bucket, object string, rs *minio.HTTPRangeSpec, h http.Header, lockType minio.LockType, opts minio.ObjectOptions) (gr *minio.GetObjectReader, err error) {
+	if n, err = n.forRequest(ctx, bucket); err != nil {
+		return nil, err
+	}
+
 	objInfo, err := n.GetObjectInfo(ctx, bucket, object, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	var startOffset, length int64
-	startOffset, length, err = rs.GetOffsetLength(objInfo.Size)
+	// The range is translated to the range of the stored stream of
+	// encrypted and compressed objects, which fn decrypts and decompresses.
+	fn, startOffset, length, err := minio.NewGetObjectReader(rs, objInfo, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -603,13 +829,82 @@ func (n *hdfsObjects) GetObjectNInfo(ctx context.Context, bucket, object string,
 	// Setup cleanup function to cause the above go-routine to
 	// exit in case of partial read
 	pipeCloser := func() { pr.Close() }
-	return minio.NewGetObjectReaderFromReader(pr, objInfo, opts, pipeCloser)
-
+	return fn(pr, h, opts.CheckPrecondFn, pipeCloser)
 }
 
 func (n *hdfsObjects) CopyObject(ctx context.Context, srcBucket, srcObject, dstBucket, dstObject string, srcInfo minio.ObjectInfo, srcOpts, dstOpts minio.ObjectOptions) (minio.ObjectInfo, error) {
+	n, err := n.forRequest(ctx, dstBucket)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	// Copying a snapshot version onto its object restores it.
 	cpSrcDstSame := minio.IsStringEqual(n.hdfsPathJoin(srcBucket, srcObject), n.hdfsPathJoin(dstBucket, dstObject))
-	if cpSrcDstSame {
+	if cpSrcDstSame && !isHDFSVersioned(srcOpts.VersionID) {
+		stored, err := n.GetObjectInfo(ctx, srcBucket, srcObject, minio.ObjectOptions{})
+		if err != nil {
+			return minio.ObjectInfo{}, err
+		}
+		if ming.CopyRewrites(stored.UserDefined, srcInfo.UserDefined, true) {
+			return n.PutObject(ctx, dstBucket, dstObject, srcInfo.PutObjReader, minio.ObjectOptions{
+				ServerSideEncryption: dstOpts.ServerSideEncryption,
+				UserDefined:          srcInfo.UserDefined,
+			})
+		}
+
+		// Copying an object onto itself replaces its metadata, and its
+		// storage class when one is given. The stream is kept, and so is
+		// its internal metadata unless its key was sealed again.
+		name := n.hdfsPathJoin(srcBucket, srcObject)
+		var policy string
+		class, setClass := srcInfo.UserDefined[xhttp.AmzStorageClass]
+		if setClass {
+			if policy, err = n.storagePolicy(class); err != nil {
+				return minio.ObjectInfo{}, err
+			}
+			fi, err := n.clnt.Stat(name)
+			if err != nil {
+				return minio.ObjectInfo{}, n.hdfsToObjectBucketErr(ctx, err, srcBucket, srcObject)
+			}
+			if hdfsRewritesPolicy(hdfsFilePolicy(fi), policy) {
+				// The stream of encrypted and compressed objects is
+				// only available transformed back.
+				if hdfsIsTransformed(stored.UserDefined) {
+					return minio.ObjectInfo{}, minio.NotImplemented{API: "changing the erasure coding of an encrypted or compressed object with storage class " + class}
+				}
+				return n.PutObject(ctx, dstBucket, dstObject, srcInfo.PutObjReader, minio.ObjectOptions{
+					UserDefined: srcInfo.UserDefined,
+				})
+			}
+		}
+		metadata := make(map[string]string)
+		for k, v := range srcInfo.UserDefined {
+			if !hdfsIsInternalMetadata(k) {
+				metadata[k] = v
+			}
+		}
+		internal := stored.UserDefined
+		if ming.IsKeyRotation(stored.UserDefined, srcInfo.UserDefined) {
+			internal = srcInfo.UserDefined
+		}
+		for k, v := range hdfsInternalMetadata(internal) {
+			metadata[k] = v
+		}
+		xattrs, err := hdfsObjectXAttrs(metadata, srcInfo.ETag)
+		if err != nil {
+			return minio.ObjectInfo{}, err
+		}
+		if len(stored.Parts) > 0 {
+			xattrs[hdfsPartsXAttr] = ming.EncodePartLayout(stored.Parts)
+		}
+		if setClass {
+			if err = n.setStoragePolicy(ctx, name, policy); err != nil {
+				return minio.ObjectInfo{}, n.hdfsToObjectBucketErr(ctx, err, srcBucket, srcObject)
+			}
+		}
+		if err = n.setObjectXAttrs(name, xattrs); err != nil {
+			return minio.ObjectInfo{}, n.hdfsToObjectBucketErr(ctx, err, srcBucket, srcObject)
+		}
 		return n.GetObjectInfo(ctx, srcBucket, srcObject, minio.ObjectOptions{})
 	}
 
@@ -620,14 +915,21 @@ func (n *hdfsObjects) CopyObject(ctx context.Context, srcBucket, srcObject, dstB
 }
 
 func (n *hdfsObjects) getObject(ctx context.Context, bucket, key string, startOffset, length int64, writer io.Writer, etag string, opts minio.ObjectOptions) error {
-	if _, err := n.clnt.Stat(n.hdfsPathJoin(bucket)); err != nil {
-		return hdfsToObjectErr(ctx, err, bucket)
+	name := n.hdfsPathJoin(bucket, key)
+	if isHDFSVersioned(opts.VersionID) {
+		var err error
+		if name, err = n.snapshotObjectPath(bucket, key, opts.VersionID); err != nil {
+			return minio.VersionNotFound{Bucket: bucket, Object: key, VersionID: opts.VersionID}
+		}
 	}
-	rd, err := n.clnt.Open(n.hdfsPathJoin(bucket, key))
+	rd, err := n.clnt.Open(name)
 	if err != nil {
-		return hdfsToObjectErr(ctx, err, bucket, key)
+		return n.hdfsToObjectBucketErr(ctx, err, bucket, key)
 	}
 	defer rd.Close()
+	if hdfsIsECPolicy(hdfsFilePolicy(rd.Stat())) {
+		return n.getECObject(ctx, bucket, key, name, startOffset, length, writer)
+	}
 	_, err = io.Copy(writer, io.NewSectionReader(rd, startOffset, length))
 	if err == io.ErrClosedPipe {
 		// hdfs library doesn't send EOF correctly, so io.Copy attempts
@@ -638,6 +940,24 @@ func (n *hdfsObjects) getObject(ctx context.Context, bucket, key string, startOf
 	return hdfsToObjectErr(ctx, err, bucket, key)
 }
 
+// getECObject writes length bytes of the erasure coded file from
+// startOffset, which the HDFS client cannot read, read by the datanodes.
+func (n *hdfsObjects) getECObject(ctx context.Context, bucket, key, name string, startOffset, length int64, writer io.Writer) error {
+	if length == 0 {
+		return nil
+	}
+	if n.web == nil {
+		return minio.NotImplemented{API: "GetObject of erasure coded files without WebHDFS"}
+	}
+	rc, err := n.web.open(ctx, name, startOffset, length)
+	if err != nil {
+		return n.hdfsToObjectBucketErr(ctx, err, bucket, key)
+	}
+	defer rc.Close()
+	_, err = io.Copy(writer, rc)
+	return hdfsToObjectErr(ctx, err, bucket, key)
+}
+
 func (n *hdfsObjects) isObjectDir(ctx context.Context, bucket, object string) bool {
 	f, err := n.clnt.Open(n.hdfsPathJoin(bucket, object))
 	if err != nil {
@@ -659,233 +979,125 @@ func (n *hdfsObjects) isObjectDir(ctx context.Context, bucket, object string) bo
 
 // GetObjectInfo reads object info and replies back ObjectInfo.
 func (n *hdfsObjects) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
-	_, err = n.clnt.Stat(n.hdfsPathJoin(bucket))
-	if err != nil {
-		return objInfo, hdfsToObjectErr(ctx, err, bucket)
+	if n, err = n.forRequest(ctx, bucket); err != nil {
+		return objInfo, err
 	}
+
+	if isHDFSVersioned(opts.VersionID) {
+		return n.getSnapshotObjectInfo(ctx, bucket, object, opts.VersionID)
+	}
+
 	if strings.HasSuffix(object, hdfsSeparator) && !n.isObjectDir(ctx, bucket, object) {
-		return objInfo, hdfsToObjectErr(ctx, os.ErrNotExist, bucket, object)
+		return objInfo, n.hdfsToObjectBucketErr(ctx, os.ErrNotExist, bucket, object)
 	}
 
 	fi, err := n.clnt.Stat(n.hdfsPathJoin(bucket, object))
 	if err != nil {
-		return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
+		return objInfo, n.hdfsToObjectBucketErr(ctx, err, bucket, object)
 	}
-	return minio.ObjectInfo{
-		Bucket:  bucket,
-		Name:    object,
-		ModTime: fi.ModTime(),
-		Size:    fi.Size(),
-		IsDir:   fi.IsDir(),
-		AccTime: fi.(*hdfs.FileInfo).AccessTime(),
-	}, nil
-}
-
-func (n *hdfsObjects) PutObject(ctx context.Context, bucket string, object string, r *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
-	_, err = n.clnt.Stat(n.hdfsPathJoin(bucket))
+	objInfo, err = n.objectInfo(ctx, bucket, object, fi)
 	if err != nil {
-		return objInfo, hdfsToObjectErr(ctx, err, bucket)
+		return objInfo, n.hdfsToObjectBucketErr(ctx, err, bucket, object)
 	}
+	return objInfo, nil
+}
 
-	name := n.hdfsPathJoin(bucket, object)
-
-	// If its a directory create a prefix {
-	if strings.HasSuffix(object, hdfsSeparator) && r.Size() == 0 {
-		if err = n.clnt.MkdirAll(name, os.FileMode(0755)); err != nil {
-			n.deleteObject(n.hdfsPathJoin(bucket), name)
-			return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
-		}
-	} else {
-		tmpname := n.hdfsPathJoin(minioMetaTmpBucket, minio.MustGetUUID())
-		var w *hdfs.FileWriter
+// writeTmpFile writes the tmp file of an object of the policy from r.
+func (n *hdfsObjects) writeTmpFile(ctx context.Context, tmpname, policy string, r *minio.PutObjReader) error {
+	if hdfsIsECPolicy(policy) {
+		// The datanodes write the file erasure coded, with the policy
+		// of its directory.
+		return n.web.create(ctx, tmpname, r, r.Size())
+	}
+	var w *hdfs.FileWriter
+	var err error
+	if policy == "" {
 		w, err = n.clnt.Create(tmpname)
-		if err != nil {
-			return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
-		}
-		defer n.deleteObject(n.hdfsPathJoin(minioMetaTmpBucket), tmpname)
-		if _, err = io.Copy(w, r); err != nil {
-			w.Close()
-			return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
-		}
-		dir := path.Dir(name)
-		if dir != "" {
-			if err = n.clnt.MkdirAll(dir, os.FileMode(0755)); err != nil {
-				w.Close()
-				n.deleteObject(n.hdfsPathJoin(bucket), dir)
-				return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
+	} else {
+		// The policy is set before writing, so blocks are placed on
+		// the storage types of the class from the start.
+		if err = n.clnt.CreateEmptyFile(tmpname); err == nil {
+			if err = n.setStoragePolicy(ctx, tmpname, policy); err == nil {
+				w, err = n.clnt.Append(tmpname)
 			}
 		}
-		w.Close()
-		if err = n.clnt.Rename(tmpname, name); err != nil {
-			return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
-		}
-	}
-	fi, err := n.clnt.Stat(name)
-	if err != nil {
-		return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
-	}
-	return minio.ObjectInfo{
-		Bucket:  bucket,
-		Name:    object,
-		ETag:    r.MD5CurrentHexString(),
-		ModTime: fi.ModTime(),
-		Size:    fi.Size(),
-		IsDir:   fi.IsDir(),
-		AccTime: fi.(*hdfs.FileInfo).AccessTime(),
-	}, nil
-}
-
-func (n *hdfsObjects) NewMultipartUpload(ctx context.Context, bucket string, object string, opts minio.ObjectOptions) (uploadID string, err error) {
-	_, err = n.clnt.Stat(n.hdfsPathJoin(bucket))
-	if err != nil {
-		return uploadID, hdfsToObjectErr(ctx, err, bucket)
-	}
-
-	uploadID = minio.MustGetUUID()
-	if err = n.clnt.CreateEmptyFile(n.hdfsPathJoin(minioMetaTmpBucket, uploadID)); err != nil {
-		return uploadID, hdfsToObjectErr(ctx, err, bucket)
 	}
-
-	return uploadID, nil
-}
-
-func (n *hdfsObjects) ListMultipartUploads(ctx context.Context, bucket string, prefix string, keyMarker string, uploadIDMarker string, delimiter string, maxUploads int) (lmi minio.ListMultipartsInfo, err error) {
-	_, err = n.clnt.Stat(n.hdfsPathJoin(bucket))
 	if err != nil {
-		return lmi, hdfsToObjectErr(ctx, err, bucket)
+		return err
 	}
-
-	// It's decided not to support List Multipart Uploads, hence returning empty result.
-	return lmi, nil
-}
-
-func (n *hdfsObjects) checkUploadIDExists(ctx context.Context, bucket, object, uploadID string) (err error) {
-	_, err = n.clnt.Stat(n.hdfsPathJoin(minioMetaTmpBucket, uploadID))
-	if err != nil {
-		return hdfsToObjectErr(ctx, err, bucket, object, uploadID)
+	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+		return err
 	}
+	w.Close()
 	return nil
 }
 
-// GetMultipartInfo returns multipart info of the uploadId of the object
-func (n *hdfsObjects) GetMultipartInfo(ctx context.Context, bucket, object, uploadID string, opts minio.ObjectOptions) (result minio.MultipartInfo, err error) {
-	_, err = n.clnt.Stat(n.hdfsPathJoin(bucket))
-	if err != nil {
-		return result, hdfsToObjectErr(ctx, err, bucket)
-	}
-
-	if err = n.checkUploadIDExists(ctx, bucket, object, uploadID); err != nil {
-		return result, err
-	}
-
-	result.Bucket = bucket
-	result.Object = object
-	result.UploadID = uploadID
-	return result, nil
-}
-
-func (n *hdfsObjects) ListObjectParts(ctx context.Context, bucket, object, uploadID string, partNumberMarker int, maxParts int, opts minio.ObjectOptions) (result minio.ListPartsInfo, err error) {
-	_, err = n.clnt.Stat(n.hdfsPathJoin(bucket))
-	if err != nil {
-		return result, hdfsToObjectErr(ctx, err, bucket)
-	}
-
-	if err = n.checkUploadIDExists(ctx, bucket, object, uploadID); err != nil {
-		return result, err
-	}
-
-	// It's decided not to support List parts, hence returning empty result.
-	return result, nil
-}
-
-func (n *hdfsObjects) CopyObjectPart(ctx context.Context, srcBucket, srcObject, dstBucket, dstObject, uploadID string, partID int,
-	startOffset int64, length int64, srcInfo minio.ObjectInfo, srcOpts, dstOpts minio.ObjectOptions) (minio.PartInfo, error) {
-	return n.PutObjectPart(ctx, dstBucket, dstObject, uploadID, partID, srcInfo.PutObjReader, dstOpts)
-}
-
-func (n *hdfsObjects) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, r *minio.PutObjReader, opts minio.ObjectOptions) (info minio.PartInfo, err error) {
-	_, err = n.clnt.Stat(n.hdfsPathJoin(bucket))
-	if err != nil {
-		return info, hdfsToObjectErr(ctx, err, bucket)
-	}
-
-	var w *hdfs.FileWriter
-	w, err = n.clnt.Append(n.hdfsPathJoin(minioMetaTmpBucket, uploadID))
-	if err != nil {
-		return info, hdfsToObjectErr(ctx, err, bucket, object, uploadID)
-	}
-	defer w.Close()
-	_, err = io.Copy(w, r.Reader)
-	if err != nil {
-		return info, hdfsToObjectErr(ctx, err, bucket, object, uploadID)
+func (n *hdfsObjects) PutObject(ctx context.Context, bucket string, object string, r *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	if n, err = n.forRequest(ctx, bucket); err != nil {
+		return objInfo, err
 	}
 
-	info.PartNumber = partID
-	info.ETag = r.MD5CurrentHexString()
-	info.LastModified = minio.UTCNow()
-	info.Size = r.Reader.Size()
-
-	return info, nil
-}
-
-func (n *hdfsObjects) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, parts []minio.CompletePart, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
-	_, err = n.clnt.Stat(n.hdfsPathJoin(bucket))
+	name := n.hdfsPathJoin(bucket, object)
+	var xattrs map[string]string
+	class, err := n.objectStorageClass(bucket, opts.UserDefined[xhttp.AmzStorageClass])
 	if err != nil {
 		return objInfo, hdfsToObjectErr(ctx, err, bucket)
 	}
-
-	if err = n.checkUploadIDExists(ctx, bucket, object, uploadID); err != nil {
+	policy, err := n.storagePolicy(class)
+	if err != nil {
 		return objInfo, err
 	}
 
-	name := n.hdfsPathJoin(bucket, object)
-	dir := path.Dir(name)
-	if dir != "" {
-		if err = n.clnt.MkdirAll(dir, os.FileMode(0755)); err != nil {
+	// If its a directory create a prefix {
+	if strings.HasSuffix(object, hdfsSeparator) && r.Size() == 0 {
+		// MkdirAll would create a missing bucket as well.
+		if _, err = n.clnt.Stat(n.hdfsPathJoin(bucket)); err != nil {
+			return objInfo, hdfsToObjectErr(ctx, err, bucket)
+		}
+		if err = n.clnt.MkdirAll(name, os.FileMode(0755)); err != nil {
+			n.deleteObject(n.hdfsPathJoin(bucket), name)
 			return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
 		}
-	}
-
-	err = n.clnt.Rename(n.hdfsPathJoin(minioMetaTmpBucket, uploadID), name)
-	// Object already exists is an error on HDFS
-	// remove it and then create it again.
-	if os.IsExist(err) {
-		if err = n.clnt.Remove(name); err != nil {
-			if dir != "" {
-				n.deleteObject(n.hdfsPathJoin(bucket), dir)
-			}
+		xattrs, err = hdfsObjectXAttrs(opts.UserDefined, r.MD5CurrentHexString())
+		if err != nil {
+			return objInfo, err
+		}
+		if err = n.setObjectXAttrs(name, xattrs); err != nil {
 			return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
 		}
-		if err = n.clnt.Rename(n.hdfsPathJoin(minioMetaTmpBucket, uploadID), name); err != nil {
-			if dir != "" {
-				n.deleteObject(n.hdfsPathJoin(bucket), dir)
+	} else {
+		tmpdir := n.hdfsPathJoin(minioMetaTmpBucket)
+		if hdfsIsECPolicy(policy) {
+			if tmpdir, err = n.ecTmpDir(ctx, policy); err != nil {
+				return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
 			}
+		}
+		tmpname := path.Join(tmpdir, minio.MustGetUUID())
+		if err = n.writeTmpFile(ctx, tmpname, policy, r); err != nil {
+			n.deleteObject(tmpdir, tmpname)
+			return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
+		}
+		defer n.deleteObject(tmpdir, tmpname)
+		// The attributes are set before the rename, the object never
+		// appears without them.
+		xattrs, err = hdfsObjectXAttrs(opts.UserDefined, r.MD5CurrentHexString())
+		if err != nil {
+			return objInfo, err
+		}
+		if err = n.setObjectXAttrs(tmpname, xattrs); err != nil {
 			return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
 		}
+		if err = n.renameToObject(bucket, tmpname, name); err != nil {
+			return objInfo, n.hdfsToObjectBucketErr(ctx, err, bucket, object)
+		}
 	}
 	fi, err := n.clnt.Stat(name)
 	if err != nil {
 		return objInfo, hdfsToObjectErr(ctx, err, bucket, object)
 	}
-
-	// Calculate s3 compatible md5sum for complete multipart.
-	s3MD5 := minio.ComputeCompleteMultipartMD5(parts)
-
-	return minio.ObjectInfo{
-		Bucket:  bucket,
-		Name:    object,
-		ETag:    s3MD5,
-		ModTime: fi.ModTime(),
-		Size:    fi.Size(),
-		IsDir:   fi.IsDir(),
-		AccTime: fi.(*hdfs.FileInfo).AccessTime(),
-	}, nil
-}
-
-func (n *hdfsObjects) AbortMultipartUpload(ctx context.Context, bucket, object, uploadID string, opts minio.ObjectOptions) (err error) {
-	_, err = n.clnt.Stat(n.hdfsPathJoin(bucket))
-	if err != nil {
-		return hdfsToObjectErr(ctx, err, bucket)
-	}
-	return hdfsToObjectErr(ctx, n.clnt.Remove(n.hdfsPathJoin(minioMetaTmpBucket, uploadID)), bucket, object, uploadID)
+	objInfo = fileInfoToObjectInfo(bucket, object, fi)
+	objInfo.StorageClass = n.storageClass(fi)
+	applyXAttrsToObjectInfo(ctx, xattrs, &objInfo)
+	return objInfo, nil
 }